@@ -10,7 +10,9 @@ type Config struct {
 	ServerPort  string `mapstructure:"SERVER_PORT"`
 	DatabaseURL string `mapstructure:"DATABASE_URL"`
 	SecretKey   string `mapstructure:"SECRET_KEY"`
-	TokenExpiry int    `mapstructure:"TOKEN_EXPIRY_MINUTES"`
+	TokenExpiry int    `mapstructure:"TOKEN_EXPIRY_MINUTES"` // 访问令牌的有效分钟数，过期后用刷新令牌换取
+
+	RefreshTokenExpiry int `mapstructure:"REFRESH_TOKEN_EXPIRY_DAYS"` // 刷新令牌的有效天数，每次刷新时顺延
 
 	RedisHost     string `mapstructure:"REDIS_HOST"`
 	RedisPort     string `mapstructure:"REDIS_PORT"`
@@ -20,6 +22,36 @@ type Config struct {
 	AdminUsername string `mapstructure:"ADMIN_USERNAME"`
 	AdminPassword string `mapstructure:"ADMIN_PASSWORD"`
 	AdminEmail    string `mapstructure:"ADMIN_EMAIL"`
+
+	// 笔记检索使用的向量模型（OpenAI 兼容接口），未配置 API Key 时使用本地哈希向量
+	EmbeddingAPIKey  string `mapstructure:"EMBEDDING_API_KEY"`
+	EmbeddingBaseURL string `mapstructure:"EMBEDDING_BASE_URL"`
+	EmbeddingModel   string `mapstructure:"EMBEDDING_MODEL"`
+
+	// 上传文件的存储后端：local（保存在 file_upload.upload_dir 下）或 s3（S3 兼容存储，如 MinIO）
+	BlobStore      string `mapstructure:"BLOB_STORE"`
+	S3Endpoint     string `mapstructure:"S3_ENDPOINT"`
+	S3Region       string `mapstructure:"S3_REGION"`
+	S3Bucket       string `mapstructure:"S3_BUCKET"`
+	S3AccessKey    string `mapstructure:"S3_ACCESS_KEY"`
+	S3SecretKey    string `mapstructure:"S3_SECRET_KEY"`
+	S3Prefix       string `mapstructure:"S3_PREFIX"`
+	S3UsePathStyle bool   `mapstructure:"S3_USE_PATH_STYLE"`
+
+	// 用户 API 密钥的主密钥，格式为 "v1:<base64 32字节>,v2:<base64>"；未配置时由 SECRET_KEY 派生。
+	// 轮换时添加新主密钥并设为当前主密钥，执行 rotate-api-keys 后再移除旧主密钥
+	APIKeyMasterKeys  string `mapstructure:"API_KEY_MASTER_KEYS"`
+	APIKeyActiveKeyID string `mapstructure:"API_KEY_ACTIVE_KEY_ID"`
+
+	// 验证码邮件的发送方式：console（打印到控制台）、file（保存为 MAIL_DIR 下的 .eml 文件）或 smtp
+	Mailer       string `mapstructure:"MAILER"`
+	MailDir      string `mapstructure:"MAIL_DIR"`
+	MailFrom     string `mapstructure:"MAIL_FROM"`
+	SMTPHost     string `mapstructure:"SMTP_HOST"`
+	SMTPPort     string `mapstructure:"SMTP_PORT"`
+	SMTPUsername string `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword string `mapstructure:"SMTP_PASSWORD"`
+	SMTPSecurity string `mapstructure:"SMTP_SECURITY"` // starttls / tls / none
 }
 
 var Cfg Config
@@ -33,13 +65,27 @@ func InitConfig() error {
 	// 设置默认值
 	viper.SetDefault("SERVER_PORT", "8000")
 	viper.SetDefault("DATABASE_URL", "sqlite://k12_platform.db")
-	viper.SetDefault("TOKEN_EXPIRY_MINUTES", 1440) // 24小时
+	viper.SetDefault("TOKEN_EXPIRY_MINUTES", 15)
+	viper.SetDefault("REFRESH_TOKEN_EXPIRY_DAYS", 30)
 
 	viper.SetDefault("REDIS_HOST", "localhost")
 	viper.SetDefault("REDIS_PORT", "6379")
 	viper.SetDefault("REDIS_PASSWORD", "")
 	viper.SetDefault("REDIS_DB", 0)
 
+	viper.SetDefault("EMBEDDING_BASE_URL", "https://api.openai.com/v1")
+	viper.SetDefault("EMBEDDING_MODEL", "text-embedding-3-small")
+
+	viper.SetDefault("BLOB_STORE", "local")
+	viper.SetDefault("S3_REGION", "us-east-1")
+	viper.SetDefault("S3_USE_PATH_STYLE", true)
+
+	viper.SetDefault("MAILER", "console")
+	viper.SetDefault("MAIL_DIR", "./mails")
+	viper.SetDefault("MAIL_FROM", "no-reply@localhost")
+	viper.SetDefault("SMTP_PORT", "587")
+	viper.SetDefault("SMTP_SECURITY", "starttls")
+
 	if err := viper.ReadInConfig(); err != nil {
 		var configFileNotFoundError viper.ConfigFileNotFoundError
 		if errors.As(err, &configFileNotFoundError) {
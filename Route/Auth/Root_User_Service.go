@@ -130,8 +130,8 @@ func RootLogin(c *gin.Context) {
 		return
 	}
 
-	// 3. 生成 JWT Token（带上角色信息）
-	token, err := Auth.GenerateToken(user.ID, user.Username, string(user.Role))
+	// 3. 签发令牌（访问令牌带上角色信息）
+	pair, err := issueTokens(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "生成令牌失败"})
 		return
@@ -147,8 +147,10 @@ func RootLogin(c *gin.Context) {
 
 	// 5. 返回响应（标记为管理员）
 	c.JSON(http.StatusOK, gin.H{
-		"message": "管理员登录成功",
-		"token":   token,
+		"message":       "管理员登录成功",
+		"token":         pair.AccessToken,
+		"refresh_token": pair.RefreshToken,
+		"expires_in":    expiresIn(pair),
 		"user": database.AdminUserResponse{
 			ID:        user.ID,
 			Username:  user.Username,
@@ -0,0 +1,101 @@
+package Auth
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"platfrom/database"
+	"platfrom/service/Auth"
+	"strconv"
+	"time"
+)
+
+// APITokenResponse 令牌信息（不含明文）
+type APITokenResponse struct {
+	ID         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+func newAPITokenResponse(token *database.APIToken) APITokenResponse {
+	return APITokenResponse{
+		ID:         token.ID,
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+// CreateAPIToken 创建调用 /v1 接口的 API 令牌，明文只在此时返回
+func CreateAPIToken(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var req database.APITokenCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "参数错误: " + err.Error(),
+		})
+		return
+	}
+
+	record, token, err := Auth.GlobalAPITokenService.CreateToken(userID.(uint), req.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "创建令牌失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "令牌创建成功，请妥善保存，之后将无法再次查看",
+		"token":   token,
+		"data":    newAPITokenResponse(record),
+	})
+}
+
+// ListAPITokens 获取当前用户的 API 令牌列表
+func ListAPITokens(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	tokens, err := Auth.GlobalAPITokenService.ListTokens(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "获取令牌列表失败: " + err.Error(),
+		})
+		return
+	}
+
+	response := make([]APITokenResponse, 0, len(tokens))
+	for i := range tokens {
+		response = append(response, newAPITokenResponse(&tokens[i]))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": response,
+	})
+}
+
+// DeleteAPIToken 删除 API 令牌，删除后立即失效
+func DeleteAPIToken(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	tokenID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "无效的令牌ID",
+		})
+		return
+	}
+
+	if err := Auth.GlobalAPITokenService.DeleteToken(userID.(uint), uint(tokenID)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "令牌已删除",
+	})
+}
@@ -35,8 +35,8 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// 生成JWT令牌
-	token, err := Auth.GenerateToken(user.ID, user.Username, string(user.Role))
+	// 签发访问令牌和刷新令牌
+	pair, err := issueTokens(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "生成令牌失败",
@@ -44,11 +44,11 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	c.SetCookie("access_token", token, 3600*24*7, "/", "", false, true)
-
 	c.JSON(http.StatusOK, database.LoginResponse{
-		Message: "注册成功",
-		Token:   token,
+		Message:      "注册成功",
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    expiresIn(pair),
 		User: database.UserResponse{
 			ID:        user.ID,
 			Username:  user.Username,
@@ -93,8 +93,8 @@ func Login(c *gin.Context) {
 	user.LastLogin = now
 	// 这里可以保存到数据库
 
-	// 生成JWT令牌
-	token, err := Auth.GenerateToken(user.ID, user.Username, string(user.Role))
+	// 签发访问令牌和刷新令牌（同时写入Cookie）
+	pair, err := issueTokens(c, user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": "生成令牌失败",
@@ -102,13 +102,12 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// 设置Cookie
-	c.SetCookie("access_token", token, 3600*24*7, "/", "", false, true)
-
 	// 返回响应
 	c.JSON(http.StatusOK, database.LoginResponse{
-		Message: "登录成功",
-		Token:   token,
+		Message:      "登录成功",
+		Token:        pair.AccessToken,
+		RefreshToken: pair.RefreshToken,
+		ExpiresIn:    expiresIn(pair),
 		User: database.UserResponse{
 			ID:        user.ID,
 			Username:  user.Username,
@@ -118,10 +117,29 @@ func Login(c *gin.Context) {
 	})
 }
 
-// Logout 用户注销
+// Logout 用户注销：吊销当前登录会话的令牌（访问令牌过期时按刷新令牌查找）
 func Logout(c *gin.Context) {
+	accessToken := bearerToken(c)
+	if accessToken == "" {
+		accessToken, _ = c.Cookie("access_token")
+	}
+	refreshToken, _ := c.Cookie("refresh_token")
+	if refreshToken == "" {
+		var req database.RefreshTokenRequest
+		if c.Request.ContentLength > 0 && c.ShouldBindJSON(&req) == nil {
+			refreshToken = req.RefreshToken
+		}
+	}
+
+	if err := Auth.GlobalTokenService.Logout(accessToken, refreshToken); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "退出登录失败: " + err.Error(),
+		})
+		return
+	}
+
 	// 清除Cookie
-	c.SetCookie("access_token", "", -1, "/", "", false, true)
+	clearAuthCookies(c)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "已退出登录",
@@ -163,7 +181,11 @@ func SendVerificationCode(c *gin.Context) {
 
 	// 发送验证码
 	userService := getUserService()
-	_, err := userService.SendVerificationCode(req.Username, req.CodeType)
+	language := req.Language
+	if language == "" {
+		language = c.GetHeader("Accept-Language")
+	}
+	_, err := userService.SendVerificationCode(req.Username, req.CodeType, language)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": "发送验证码失败: " + err.Error(),
@@ -270,7 +292,9 @@ func UpdatePassword(c *gin.Context) {
 		return
 	}
 
+	// 所有设备（包括当前设备）的令牌已被吊销
+	clearAuthCookies(c)
 	c.JSON(http.StatusOK, gin.H{
-		"message": "密码修改成功",
+		"message": "密码修改成功，请重新登录",
 	})
 }
@@ -8,27 +8,24 @@ import (
 	"strings"
 )
 
-// AuthMiddleware 认证中间件
+// AuthMiddleware 认证中间件：依次尝试 Authorization 头和 Cookie 中的访问令牌（校验签名、有效期和吊销名单）。
+// 访问令牌都无效但 Cookie 中有刷新令牌时，自动刷新并写回 Cookie，浏览器端无需处理过期
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 从Header获取token
 		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			// 从Cookie获取token
-			token, err := c.Cookie("access_token")
-			if err != nil {
-				c.JSON(http.StatusUnauthorized, gin.H{
-					"error": "未提供认证令牌",
-				})
-				c.Abort()
-				return
-			}
-			authHeader = "Bearer " + token
+		cookieToken, _ := c.Cookie("access_token")
+		refreshToken, _ := c.Cookie("refresh_token")
+		if authHeader == "" && cookieToken == "" && refreshToken == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "未提供认证令牌",
+			})
+			c.Abort()
+			return
 		}
 
 		// 检查Bearer前缀
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
+		headerToken := bearerToken(c)
+		if authHeader != "" && headerToken == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "认证令牌格式错误",
 			})
@@ -36,9 +33,25 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 验证token
-		claims, err := Auth.ValidateToken(parts[1])
-		if err != nil {
+		var claims *Auth.Claims
+		for _, token := range []string{headerToken, cookieToken} {
+			if token == "" {
+				continue
+			}
+			if validated, err := Auth.GlobalTokenService.Authenticate(token); err == nil {
+				claims = validated
+				break
+			}
+		}
+
+		if claims == nil && refreshToken != "" {
+			if pair, err := Auth.GlobalTokenService.Refresh(refreshToken, c.Request.UserAgent(), c.ClientIP()); err == nil {
+				setAuthCookies(c, pair)
+				claims, _ = Auth.ValidateToken(pair.AccessToken)
+			}
+		}
+
+		if claims == nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": "认证令牌无效或已过期",
 			})
@@ -49,6 +62,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		// 将用户信息存入上下文
 		c.Set("user_id", claims.UserID)
 		c.Set("username", claims.Username)
+		c.Set("auth_session_id", claims.SessionID)
 
 		c.Next()
 	}
@@ -82,3 +96,31 @@ func AdminMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// APITokenMiddleware OpenAI 兼容接口（/v1）的认证中间件，使用平台签发的 API 令牌（Authorization: Bearer sk-plat-...）。
+// 错误按 OpenAI 的格式返回，便于现有客户端显示
+func APITokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+		if len(parts) != 2 || parts[0] != "Bearer" || parts[1] == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": gin.H{
+				"message": "未提供 API 令牌",
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+
+		token, err := Auth.GlobalAPITokenService.Authenticate(parts[1])
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": gin.H{
+				"message": "API 令牌无效",
+				"type":    "invalid_request_error",
+			}})
+			return
+		}
+
+		c.Set("user_id", token.UserID)
+		c.Set("api_token_id", token.ID)
+		c.Next()
+	}
+}
@@ -0,0 +1,177 @@
+package Auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"platfrom/database"
+	"platfrom/service/Auth"
+	"strings"
+	"time"
+)
+
+// setAuthCookies 写入访问令牌和刷新令牌的 Cookie。刷新令牌只发往 /api，宽限期内未轮换时保留原 Cookie
+func setAuthCookies(c *gin.Context, pair *Auth.TokenPair) {
+	c.SetCookie("access_token", pair.AccessToken, int(time.Until(pair.AccessExpiresAt).Seconds()), "/", "", false, true)
+	if pair.RefreshToken != "" {
+		c.SetCookie("refresh_token", pair.RefreshToken, int(time.Until(pair.RefreshExpiresAt).Seconds()), "/api", "", false, true)
+	}
+}
+
+// clearAuthCookies 清除认证 Cookie
+func clearAuthCookies(c *gin.Context) {
+	c.SetCookie("access_token", "", -1, "/", "", false, true)
+	c.SetCookie("refresh_token", "", -1, "/api", "", false, true)
+}
+
+// issueTokens 登录成功后创建会话、签发令牌并写入 Cookie
+func issueTokens(c *gin.Context, user *database.User) (*Auth.TokenPair, error) {
+	pair, err := Auth.GlobalTokenService.IssueTokens(user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		return nil, err
+	}
+	setAuthCookies(c, pair)
+	return pair, nil
+}
+
+// expiresIn 访问令牌剩余的有效秒数
+func expiresIn(pair *Auth.TokenPair) int64 {
+	return int64(time.Until(pair.AccessExpiresAt).Seconds())
+}
+
+// bearerToken 读取 Authorization 头中的令牌
+func bearerToken(c *gin.Context) string {
+	parts := strings.SplitN(c.GetHeader("Authorization"), " ", 2)
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return ""
+	}
+	return parts[1]
+}
+
+// RefreshToken 用刷新令牌换取新的令牌，刷新令牌从请求体或 Cookie 读取
+func RefreshToken(c *gin.Context) {
+	var req database.RefreshTokenRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "请求参数错误: " + err.Error(),
+			})
+			return
+		}
+	}
+	if req.RefreshToken == "" {
+		req.RefreshToken, _ = c.Cookie("refresh_token")
+	}
+	if req.RefreshToken == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "未提供刷新令牌",
+		})
+		return
+	}
+
+	pair, err := Auth.GlobalTokenService.Refresh(req.RefreshToken, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		if errors.Is(err, Auth.ErrInvalidRefreshToken) || errors.Is(err, Auth.ErrRefreshTokenReused) {
+			clearAuthCookies(c)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "刷新令牌失败: " + err.Error(),
+		})
+		return
+	}
+
+	setAuthCookies(c, pair)
+	c.JSON(http.StatusOK, gin.H{
+		"token":         pair.AccessToken,
+		"refresh_token": pair.RefreshToken, // 为空表示继续使用原来的刷新令牌
+		"expires_in":    expiresIn(pair),
+	})
+}
+
+// AuthSessionResponse 登录会话（设备）信息
+type AuthSessionResponse struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	Current    bool      `json:"current"` // 是否为发起本次请求的会话
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// ListAuthSessions 获取当前用户已登录的设备
+func ListAuthSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	current := c.GetString("auth_session_id")
+
+	sessions, err := Auth.GlobalTokenService.ListSessions(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "获取登录设备失败: " + err.Error(),
+		})
+		return
+	}
+
+	data := make([]AuthSessionResponse, len(sessions))
+	for i, session := range sessions {
+		data[i] = AuthSessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			Current:    session.ID == current,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			CreatedAt:  session.CreatedAt,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+	})
+}
+
+// RevokeAuthSession 注销一个已登录的设备
+func RevokeAuthSession(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	err := Auth.GlobalTokenService.RevokeSession(userID.(uint), c.Param("id"))
+	if err != nil {
+		if errors.Is(err, Auth.ErrAuthSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "注销设备失败: " + err.Error(),
+		})
+		return
+	}
+
+	if c.Param("id") == c.GetString("auth_session_id") {
+		clearAuthCookies(c)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "设备已注销",
+	})
+}
+
+// RevokeOtherAuthSessions 注销除当前设备外的所有设备
+func RevokeOtherAuthSessions(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	count, err := Auth.GlobalTokenService.RevokeUserSessions(userID.(uint), c.GetString("auth_session_id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "注销设备失败: " + err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "其他设备已注销",
+		"revoked": count,
+	})
+}
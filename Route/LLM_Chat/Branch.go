@@ -0,0 +1,236 @@
+package LLM_Chat
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"platfrom/database"
+	LLM_Chat_Service "platfrom/service/LLM_Chat"
+	"strconv"
+)
+
+// branchErrorStatus 分支操作错误对应的状态码：会话或消息不存在（包括属于其他用户）时返回 404
+func branchErrorStatus(err error) int {
+	if errors.Is(err, LLM_Chat_Service.ErrSessionNotFound) || errors.Is(err, LLM_Chat_Service.ErrMessageNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusBadRequest
+}
+
+// parseMessageID 读取路径中的 message_id
+func parseMessageID(c *gin.Context) (uint, bool) {
+	messageID, err := strconv.ParseUint(c.Param("message_id"), 10, 64)
+	if err != nil || messageID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的消息ID"})
+		return 0, false
+	}
+	return uint(messageID), true
+}
+
+// rejectRunning 会话正在生成时返回 409：生成的回复保存在当前分支末尾，期间不能移动当前分支
+func rejectRunning(c *gin.Context, sessionID string) bool {
+	if LLM_Chat_Service.GlobalGenerationRegistry.Running(sessionID) {
+		c.JSON(http.StatusConflict, gin.H{"error": LLM_Chat_Service.ErrGenerationInProgress.Error()})
+		return true
+	}
+	return false
+}
+
+// findSessionMessage 获取用户会话中的一条消息，不存在时写出错误响应
+func findSessionMessage(c *gin.Context, userID uint, sessionID string, messageID uint, action string) (*database.ChatMessage, bool) {
+	siblings, err := LLM_Chat_Service.GetSessionManager().GetChatService().GetSiblings(sessionID, userID, messageID)
+	if err != nil {
+		c.JSON(branchErrorStatus(err), gin.H{"error": action + "失败: " + err.Error()})
+		return nil, false
+	}
+	for i := range siblings {
+		if siblings[i].ID == messageID {
+			return &siblings[i], true
+		}
+	}
+	c.JSON(http.StatusNotFound, gin.H{"error": action + "失败: " + LLM_Chat_Service.ErrMessageNotFound.Error()})
+	return nil, false
+}
+
+// EditMessage 编辑一条用户消息：创建新的兄弟分支并重新请求回复，原分支保留。
+// 分支的创建和生成都由后台 worker 执行，本请求等待生成结束
+func EditMessage(c *gin.Context) {
+	var request struct {
+		Message  string `json:"message" binding:"required"`
+		UseTools bool   `json:"use_tools"`
+		// 本次消息的生成参数，覆盖会话级设置
+		database.GenerationParams
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	messageID, ok := parseMessageID(c)
+	if !ok {
+		return
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	if rejectRunning(c, sessionID) {
+		return
+	}
+	original, ok := findSessionMessage(c, userID.(uint), sessionID, messageID, "编辑消息")
+	if !ok {
+		return
+	}
+	if original.Role != "user" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "编辑消息失败: 只能编辑用户消息"})
+		return
+	}
+
+	job := LLM_Chat_Service.NewGenerationJob(LLM_Chat_Service.JobEdit, sessionID, userID.(uint))
+	job.MessageID = messageID
+	job.UserMessage = request.Message
+	job.UseTools = request.UseTools
+	job.Params = request.GenerationParams
+	if !submitJob(c, job) {
+		return
+	}
+
+	result, ok := awaitJob(c, sessionID)
+	if !ok {
+		return
+	}
+	if result.Err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error":      "发送消息失败: " + result.Err.Error(),
+			"message_id": result.MessageID,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message_id": result.MessageID,
+		"response":   result.Response,
+		"stopped":    result.Stopped,
+	})
+}
+
+// RegenerateMessage 重新生成一条模型回复，新回复作为原回复的兄弟分支。
+// 由后台 worker 执行，本请求等待生成结束
+func RegenerateMessage(c *gin.Context) {
+	var request regenerateRequest
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	messageID, ok := parseMessageID(c)
+	if !ok {
+		return
+	}
+	// 请求体可以为空
+	if !bindOptionalJSON(c, &request) {
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	if rejectRunning(c, sessionID) {
+		return
+	}
+	reply, ok := findSessionMessage(c, userID.(uint), sessionID, messageID, "重新生成")
+	if !ok {
+		return
+	}
+	if reply.Role != "assistant" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "重新生成失败: 只能重新生成模型的回复"})
+		return
+	}
+
+	job := LLM_Chat_Service.NewGenerationJob(LLM_Chat_Service.JobRegenerate, sessionID, userID.(uint))
+	job.MessageID = messageID
+	job.UseTools = request.UseTools
+	job.Params = request.GenerationParams
+	if !submitJob(c, job) {
+		return
+	}
+
+	result, ok := awaitJob(c, sessionID)
+	if !ok {
+		return
+	}
+	if result.Err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "重新生成失败: " + result.Err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"response": result.Response,
+		"stopped":  result.Stopped,
+	})
+}
+
+// GetMessageSiblings 获取与指定消息处于同一位置的所有版本（兄弟消息）
+func GetMessageSiblings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	messageID, ok := parseMessageID(c)
+	if !ok {
+		return
+	}
+
+	siblings, err := LLM_Chat_Service.GetSessionManager().GetChatService().GetSiblings(c.Param("session_id"), userID.(uint), messageID)
+	if err != nil {
+		c.JSON(branchErrorStatus(err), gin.H{"error": "获取消息失败: " + err.Error()})
+		return
+	}
+
+	index := 0
+	for i, sibling := range siblings {
+		if sibling.ID == messageID {
+			index = i
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"data":  newMessagesWithID(siblings),
+		"index": index,
+	})
+}
+
+// SwitchBranch 切换当前分支到包含指定消息的分支
+func SwitchBranch(c *gin.Context) {
+	var request struct {
+		MessageID uint `json:"message_id" binding:"required"`
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	if rejectRunning(c, sessionID) {
+		return
+	}
+	leafID, err := LLM_Chat_Service.GetSessionManager().GetChatService().SwitchBranch(sessionID, userID.(uint), request.MessageID)
+	if err != nil {
+		c.JSON(branchErrorStatus(err), gin.H{"error": "切换分支失败: " + err.Error()})
+		return
+	}
+	LLM_Chat_Service.GetSessionManager().InvalidateSession(sessionID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "切换分支成功",
+		"active_leaf_id": leafID,
+	})
+}
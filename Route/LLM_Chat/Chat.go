@@ -11,65 +11,158 @@ import (
 	"net/http"
 	"platfrom/database"
 	LLM_Chat_Service "platfrom/service/LLM_Chat"
+	"platfrom/service/RAG"
 	"strconv"
-	"strings"
 	"time"
 )
 
-// SetupChatRoutes 设置聊天相关路由
-func SetupChatRoutes(router *gin.Engine) {
-	chat := router.Group("/api/chat")
-	{
-		chat.POST("/message", SendMessage)
-		chat.POST("/message/stream", SendMessageStream) // 新增流式接口
-		chat.POST("/session", CreateSession)
-		chat.GET("/sessions", GetSessions)
-		chat.GET("/sessions/:session_id/messages", GetSessionMessages)
-		chat.DELETE("/sessions/:session_id", DeleteSession)
-	}
-}
+// fileChunkTopK 每条消息最多附带的文件片段数
+const fileChunkTopK = 8
 
-// fileService LLM_Chat_Service.FileServiceInterface,
-// 在处理消息前添加文件内容
-func processFilesWithMessage(sessionID string, message string, fileIDs []uint) (string, error) {
+// processFilesWithMessage 在消息后附上附件中与问题最相关的片段（而不是整个文件），返回使用的片段作为引用
+func processFilesWithMessage(ctx context.Context, userID uint, sessionID string, message string, fileIDs []uint) (string, []RAG.FileSearchResult, error) {
 	if len(fileIDs) == 0 {
-		return message, nil
+		return message, nil, nil
+	}
+	if RAG.GlobalRAGService == nil {
+		return "", nil, errors.New("文件检索服务未初始化")
 	}
 
-	var fileContents []string
 	for _, fileID := range fileIDs {
-		file, err := LLM_Chat_Service.GlobalFileService.GetFileByID(fileID)
+		file, err := LLM_Chat_Service.GlobalFileService.GetFileByID(fileID, userID)
 		if err != nil {
-			return "", fmt.Errorf("获取文件失败: %v", err)
+			return "", nil, fmt.Errorf("获取文件失败: %w", err)
+		}
+		if file.SessionID != sessionID {
+			return "", nil, errors.New("文件不属于当前会话")
 		}
 
-		content, err := LLM_Chat_Service.GlobalFileService.ProcessFileContent(file)
-		if err == nil && content != "" {
-			fileContents = append(fileContents, fmt.Sprintf("【文件：%s】\n%s\n", file.FileName, content))
+		// 上传后的后台索引尚未完成时同步建立
+		if !file.IsProcessed {
+			if err := LLM_Chat_Service.GlobalFileService.IndexFile(file); err != nil {
+				log.Printf("文件索引失败 (file: %d): %v", file.ID, err)
+			}
 		}
 	}
 
-	if len(fileContents) > 0 {
-		fileSection := strings.Join(fileContents, "\n")
-		if message != "" {
-			return message + "\n\n" + fileSection, nil
+	chunks, err := RAG.GlobalRAGService.SearchFiles(ctx, fileIDs, message, fileChunkTopK)
+	if err != nil {
+		return "", nil, fmt.Errorf("检索文件内容失败: %v", err)
+	}
+	if len(chunks) == 0 {
+		return message, nil, nil
+	}
+
+	fileSection := RAG.FormatFileChunks(chunks)
+	if message != "" {
+		return message + "\n\n" + fileSection, chunks, nil
+	}
+	return fileSection, chunks, nil
+}
+
+// errVisionUnsupported 消息带图片但所选模型未开启视觉能力
+var errVisionUnsupported = errors.New("当前模型不支持图片输入，请在 API 配置中开启视觉能力（supports_vision）或更换模型")
+
+// imageAttachments 消息附带的图片：parts 保存到数据库（只记录文件引用），images 发送给模型
+type imageAttachments struct {
+	parts  []database.MessagePart
+	images []openai.ChatMessagePart
+}
+
+// splitImageFiles 从附件中分离出图片，返回其余文档文件的ID
+// 有图片时检查模型是否支持视觉输入，不支持时返回 errVisionUnsupported
+func splitImageFiles(userID uint, sessionID, modelName, message string, fileIDs []uint) ([]uint, imageAttachments, error) {
+	var attachments imageAttachments
+	var documentIDs []uint
+	for _, fileID := range fileIDs {
+		file, err := LLM_Chat_Service.GlobalFileService.GetFileByID(fileID, userID)
+		if err != nil {
+			return nil, attachments, fmt.Errorf("获取文件失败: %w", err)
+		}
+		if file.SessionID != sessionID {
+			return nil, attachments, errors.New("文件不属于当前会话")
 		}
-		return fileSection, nil
+		if !LLM_Chat_Service.IsImageMIME(file.FileType) {
+			documentIDs = append(documentIDs, fileID)
+			continue
+		}
+
+		dataURL, err := LLM_Chat_Service.LoadImageDataURL(file)
+		if err != nil {
+			return nil, attachments, err
+		}
+		attachments.parts = append(attachments.parts, database.MessagePart{
+			Type:     database.MessagePartImage,
+			FileID:   file.ID,
+			MimeType: file.FileType,
+		})
+		attachments.images = append(attachments.images, LLM_Chat_Service.NewImagePart(dataURL))
+	}
+	if len(attachments.images) == 0 {
+		return documentIDs, attachments, nil
 	}
 
-	return message, nil
+	route, err := LLM_Chat_Service.GlobalUserAPIService.ResolveModelRoute(userID, modelName)
+	if err != nil {
+		return nil, attachments, fmt.Errorf("获取模型配置失败: %v", err)
+	}
+	if !route.Primary().SupportsVision {
+		return nil, attachments, errVisionUnsupported
+	}
+
+	// 文本放在图片之前，与发送给模型的顺序一致
+	if message != "" {
+		attachments.parts = append([]database.MessagePart{{Type: database.MessagePartText, Text: message}}, attachments.parts...)
+	}
+	return documentIDs, attachments, nil
 }
 
-// SendMessage 原有的同步消息发送（保持不变）
-func SendMessage(c *gin.Context) {
-	var request struct {
-		BaseUrl   string `json:"BaseUrl"`
-		SessionID string `json:"session_id" binding:"required"`
-		ModelName string `json:"model_name" binding:"required"`
-		Message   string `json:"message" binding:"required"`
-		Persona   string `json:"persona"`
-		FileIDs   []uint `json:"file_ids"`
+// attachmentErrorStatus 附件处理错误对应的状态码
+func attachmentErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errVisionUnsupported):
+		return http.StatusBadRequest
+	case errors.Is(err, LLM_Chat_Service.ErrFileNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// sessionErrorStatus 会话不存在或属于其他用户时返回 404
+func sessionErrorStatus(err error) int {
+	if errors.Is(err, LLM_Chat_Service.ErrSessionNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// retrieveNoteReferences 检索与本次消息相关的笔记切块
+func retrieveNoteReferences(ctx context.Context, userID uint, message string, topK int) ([]RAG.SearchResult, error) {
+	if RAG.GlobalRAGService == nil {
+		return nil, errors.New("笔记检索服务未初始化")
+	}
+	return RAG.GlobalRAGService.Search(ctx, userID, message, topK)
+}
+
+// referencesEvent 以独立的 SSE 事件推送本次引用的笔记和文件片段
+func referencesEvent(references []RAG.SearchResult, fileReferences []RAG.FileSearchResult) (LLM_Chat_Service.StreamEvent, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"type":            "references",
+		"references":      references,
+		"file_references": fileReferences,
+		"done":            false,
+	})
+	if err != nil {
+		return LLM_Chat_Service.StreamEvent{}, err
 	}
+	return LLM_Chat_Service.StreamEvent{Event: "references", Data: string(data)}, nil
+}
+
+// SendMessage 同步发送消息：与流式接口一样交给后台 worker 生成，等待生成结束后一次返回回复。
+// 客户端断开不影响生成，回复仍会保存
+func SendMessage(c *gin.Context) {
+	var request streamMessageRequest
 
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -86,64 +179,108 @@ func SendMessage(c *gin.Context) {
 		return
 	}
 
-	// 获取或创建会话
-	session, err := LLM_Chat_Service.GetSessionManager().GetOrCreateSession(userID.(uint), request.SessionID, request.ModelName, request.BaseUrl, request.Persona)
+	job, preamble, status, err := prepareSendJob(c.Request.Context(), userID.(uint), &request)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "获取会话失败: " + err.Error(),
+		c.JSON(status, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
-
-	// 处理文件内容
-	fullMessage, err := processFilesWithMessage(request.SessionID, request.Message, request.FileIDs)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "处理文件内容失败: " + err.Error(),
-		})
+	if !submitJob(c, job, preamble...) {
 		return
 	}
 
-	// 保存用户消息到数据库
-	if err := LLM_Chat_Service.GetSessionManager().SaveMessage(request.SessionID, "user", request.Message, userID.(uint)); err != nil {
+	result, ok := awaitJob(c, request.SessionID)
+	if !ok {
+		return
+	}
+	if result.Err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "保存用户消息失败: " + err.Error(),
+			"error": "发送消息失败: " + result.Err.Error(),
 		})
 		return
 	}
 
-	// 发送消息（使用包含文件内容的完整消息）
-	response, err := session.SendMessage(fullMessage)
+	c.JSON(http.StatusOK, gin.H{
+		"response":        result.Response,
+		"references":      result.References,
+		"file_references": result.FileReferences,
+		"stopped":         result.Stopped,
+	})
+}
+
+// streamMessageRequest 发送消息的参数，同步、流式接口和 WebSocket 共用
+type streamMessageRequest struct {
+	BaseUrl   string `json:"BaseUrl"`
+	SessionID string `json:"session_id" binding:"required"`
+	ModelName string `json:"model_name" binding:"required"`
+	Message   string `json:"message" binding:"required"`
+	Persona   string `json:"persona"`
+	FileIDs   []uint `json:"file_ids"`
+	UseTools  bool   `json:"use_tools"` // 是否允许模型调用工具（搜索笔记、读取文件等）
+	UseNotes  bool   `json:"use_notes"` // 是否检索用户笔记作为参考资料
+	NotesTopK int    `json:"notes_top_k" binding:"omitempty,min=1,max=20"`
+	// 本次消息的生成参数，覆盖会话级设置
+	database.GenerationParams
+}
+
+// prepareSendJob 检查会话、处理附件并检索笔记，生成发送消息的任务和需要先推送的引用事件。
+// 失败时返回对应的状态码
+func prepareSendJob(ctx context.Context, userID uint, request *streamMessageRequest) (*LLM_Chat_Service.GenerationJob, []LLM_Chat_Service.StreamEvent, int, error) {
+	// 获取或创建会话（检查会话归属，worker 执行时复用）
+	if _, err := LLM_Chat_Service.GetSessionManager().GetOrCreateSession(userID, request.SessionID, request.ModelName, request.BaseUrl, request.Persona); err != nil {
+		return nil, nil, sessionErrorStatus(err), errors.New("获取会话失败: " + err.Error())
+	}
+
+	// 分离图片附件，其余文件检索相关片段附加到消息
+	documentIDs, attachments, err := splitImageFiles(userID, request.SessionID, request.ModelName, request.Message, request.FileIDs)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "发送消息失败: " + err.Error(),
-		})
-		return
+		return nil, nil, attachmentErrorStatus(err), err
 	}
 
-	// 保存AI回复到数据库
-	if err := LLM_Chat_Service.GetSessionManager().SaveMessage(request.SessionID, "assistant", response, userID.(uint)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "保存AI回复失败: " + err.Error(),
-		})
-		return
+	// 处理文件内容
+	fullMessage, fileReferences, err := processFilesWithMessage(ctx, userID, request.SessionID, request.Message, documentIDs)
+	if err != nil {
+		return nil, nil, http.StatusInternalServerError, errors.New("处理文件内容失败: " + err.Error())
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"response": response,
-	})
+	// 检索相关笔记作为参考资料（在开始推送前完成，失败时仍可返回普通错误响应）
+	var references []RAG.SearchResult
+	if request.UseNotes {
+		references, err = retrieveNoteReferences(ctx, userID, request.Message, request.NotesTopK)
+		if err != nil {
+			return nil, nil, http.StatusInternalServerError, errors.New("检索笔记失败: " + err.Error())
+		}
+	}
+
+	// 后台 worker 保存用户消息、请求模型并保存回复
+	job := LLM_Chat_Service.NewGenerationJob(LLM_Chat_Service.JobSend, request.SessionID, userID)
+	job.ModelName = request.ModelName
+	job.BaseUrl = request.BaseUrl
+	job.Persona = request.Persona
+	job.UserMessage = request.Message
+	job.Parts = attachments.parts
+	job.Message = fullMessage
+	job.Images = attachments.images
+	job.References = RAG.FormatReferences(references)
+	job.UseTools = request.UseTools
+	job.Params = request.GenerationParams
+
+	var preamble []LLM_Chat_Service.StreamEvent
+	if len(references) > 0 || len(fileReferences) > 0 {
+		event, err := referencesEvent(references, fileReferences)
+		if err != nil {
+			log.Printf("推送引用笔记失败: %v", err)
+		} else {
+			preamble = append(preamble, event)
+		}
+	}
+	return job, preamble, http.StatusOK, nil
 }
 
 // SendMessageStream 新增：流式发送消息
 func SendMessageStream(c *gin.Context) {
-	var request struct {
-		BaseUrl   string `json:"BaseUrl"`
-		SessionID string `json:"session_id" binding:"required"`
-		ModelName string `json:"model_name" binding:"required"`
-		Message   string `json:"message" binding:"required"`
-		Persona   string `json:"persona"`
-		FileIDs   []uint `json:"file_ids"`
-	}
+	var request streamMessageRequest
 
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -160,157 +297,58 @@ func SendMessageStream(c *gin.Context) {
 		return
 	}
 
-	// 获取或创建会话
-	session, err := LLM_Chat_Service.GetSessionManager().GetOrCreateSession(userID.(uint), request.SessionID, request.ModelName, request.BaseUrl, request.Persona)
+	job, preamble, status, err := prepareSendJob(c.Request.Context(), userID.(uint), &request)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "获取会话失败: " + err.Error(),
+		c.JSON(status, gin.H{
+			"error": err.Error(),
 		})
 		return
 	}
 
-	// 处理文件内容
-	fullMessage, err := processFilesWithMessage(request.SessionID, request.Message, request.FileIDs)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "处理文件内容失败: " + err.Error(),
-		})
+	// 交给后台 worker 生成，本请求只订阅事件；客户端断开不影响生成
+	if !submitJob(c, job, preamble...) {
 		return
 	}
+	followStream(c, request.SessionID, "")
+}
 
-	// 保存用户消息到数据库
-	if err := LLM_Chat_Service.GetSessionManager().SaveMessage(request.SessionID, "user", request.Message, userID.(uint)); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": "保存用户消息失败: " + err.Error(),
-		})
+// RecoverStreamResponse :前端断连重连。按 Last-Event-ID（请求头或 last_event_id 参数）补发错过的事件，
+// 生成仍在进行时继续推送新事件，直到本次生成结束
+func RecoverStreamResponse(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(c.Request.Context(), 120*time.Second)
-	defer cancel()
-
-	// 设置响应头为流式传输
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
-	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-	c.Writer.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
-	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-	c.Writer.Flush()
-
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
-
-	go func() {
-		for {
-			select {
-			case <-ticker.C:
-				fmt.Fprintf(c.Writer, ": heartbeat\n\n")
-				c.Writer.Flush()
-			case <-ctx.Done():
-				return
-			}
-		}
-	}()
-
-	// 用于保存完整的AI回复
-	var fullResponse string
-
-	// 使用流式发送消息（使用包含文件内容的完整消息）
-	fullResponse, err = session.SendMessageStream(ctx, fullMessage, func(chunk string) error {
-
-		if err := LLM_Chat_Service.GlobalCacheService.AppendStreamResponse(request.SessionID, chunk); err != nil {
-			log.Printf("缓存流式响应失败: %v", err)
-		}
-
-		// 构建SSE格式的数据
-		data := map[string]interface{}{
-			"content": chunk,
-			"done":    false,
-		}
-
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			return err
-		}
-
-		// 发送SSE格式数据
-		fmt.Fprintf(c.Writer, "data: %s\n\n", jsonData)
-		c.Writer.Flush()
-
-		if c.Request.Context().Err() != nil {
-			// 客户端已断开，停止发送
-			return errors.New("client disconnected")
-		}
-
-		return nil
-	})
-
-	if err != nil {
-		// 发送错误信息
-		errorData := map[string]interface{}{
-			"error": err.Error(),
-			"done":  true,
-		}
-		jsonData, _ := json.Marshal(errorData)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", jsonData)
-		c.Writer.Flush()
+	sessionID := c.Query("session_id")
+	if _, err := LLM_Chat_Service.GetSessionManager().GetChatService().GetChatSession(sessionID, userID.(uint)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
 		return
 	}
 
-	cacheResponse, redisErr := LLM_Chat_Service.GlobalCacheService.GetStreamResponse(request.SessionID)
-	if redisErr == nil && cacheResponse != "" {
-		fullResponse = cacheResponse
-		log.Printf("从 Redis 缓存恢复完整响应，长度: %d", len(fullResponse))
-		log.Printf("缓冲统计 - SessionID: %s, Redis可用: %v, 缓冲长度: %d", request.SessionID, database.IsRedisAvailable(), len(fullResponse))
-	} else if redisErr != nil {
-		log.Printf("Redis 获取失败，使用内存变量: %v", redisErr)
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
 	}
 
-	// 保存AI回复到数据库（带重试）
-	if err := LLM_Chat_Service.GlobalCacheService.SaveWithRetry(request.SessionID, "assistant", fullResponse, userID.(uint), 3); err != nil {
-		errorData := map[string]interface{}{
-			"error": "保存AI回复失败: " + err.Error(),
-			"done":  true,
+	events, err := LLM_Chat_Service.GlobalCacheService.ReadStreamEvents(c.Request.Context(), sessionID, lastEventID, 0)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, LLM_Chat_Service.ErrInvalidEventID) {
+			status = http.StatusBadRequest
 		}
-		jsonData, _ := json.Marshal(errorData)
-		fmt.Fprintf(c.Writer, "data: %s\n\n", jsonData)
-		c.Writer.Flush()
-
-		// 👇 新增：清理 Redis 缓存
-		LLM_Chat_Service.GlobalCacheService.DeleteStreamResponse(request.SessionID)
+		c.JSON(status, gin.H{"error": "读取缓存的响应失败: " + err.Error()})
 		return
-
-		// 👇 新增：保存成功后清理 Redis 缓存
-		if err := LLM_Chat_Service.GlobalCacheService.DeleteStreamResponse(request.SessionID); err != nil {
-			log.Printf("清理 Redis 缓存失败: %v", err)
-		}
 	}
-
-	// 发送结束信号
-	endData := map[string]interface{}{
-		"content": "",
-		"done":    true,
-	}
-	jsonData, _ := json.Marshal(endData)
-	fmt.Fprintf(c.Writer, "data: %s\n\n", jsonData)
-	c.Writer.Flush()
-}
-
-// RecoverStreamResponse :前端断连重连
-func RecoverStreamResponse(c *gin.Context) {
-	sessionID := c.Query("session_id")
-
-	cached, err := LLM_Chat_Service.GlobalCacheService.GetStreamResponse(sessionID)
-	if err != nil || cached == "" {
+	running := LLM_Chat_Service.GlobalGenerationRegistry.Running(sessionID)
+	if len(events) == 0 && !running {
 		c.JSON(http.StatusNotFound, gin.H{"error": "无缓存的响应"})
 		return
 	}
-	log.Printf("恢复成功 - SessionID: %s, Redis可用: %v, 缓冲长度: %d", sessionID, database.IsRedisAvailable(), len(cached))
+	log.Printf("恢复成功 - SessionID: %s, Redis可用: %v, 补发事件数: %d", sessionID, database.IsRedisAvailable(), len(events))
 
-	c.JSON(http.StatusOK, gin.H{
-		"cached_response": cached,
-	})
+	followStream(c, sessionID, lastEventID)
 }
 
 // CreateSession 创建新会话
@@ -319,6 +357,10 @@ func CreateSession(c *gin.Context) {
 		BaseUrl   string `json:"BaseUrl"`
 		ModelName string `json:"model_name" binding:"required"`
 		Persona   string `json:"persona"` // 新增：人格选择
+		// 是否开启滚动摘要
+		SummaryEnabled bool `json:"summary_enabled"`
+		// 会话级生成参数
+		database.GenerationParams
 	}
 
 	userID, exists := c.Get("user_id")
@@ -348,11 +390,104 @@ func CreateSession(c *gin.Context) {
 		return
 	}
 
+	// 保存会话级生成参数
+	if _, err := LLM_Chat_Service.GetSessionManager().GetChatService().UpdateSessionGenerationParams(sessionID, userID.(uint), request.GenerationParams); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "保存生成参数失败: " + err.Error(),
+		})
+		return
+	}
+
+	if request.SummaryEnabled {
+		if err := LLM_Chat_Service.GetSessionManager().GetChatService().UpdateSessionSummaryEnabled(sessionID, userID.(uint), true); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": "保存摘要设置失败: " + err.Error(),
+			})
+			return
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"session_id": sessionID,
 	})
 }
 
+// SessionSettings 会话级设置：生成参数与滚动摘要开关
+type SessionSettings struct {
+	database.GenerationParams
+	SummaryEnabled *bool `json:"summary_enabled,omitempty"` // 为空时保持原设置
+}
+
+func newSessionSettings(chatSession *database.ChatSession) SessionSettings {
+	return SessionSettings{
+		GenerationParams: chatSession.GenerationParams,
+		SummaryEnabled:   &chatSession.SummaryEnabled,
+	}
+}
+
+// GetSessionSettings 获取会话级设置
+func GetSessionSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	chatSession, err := LLM_Chat_Service.GetSessionManager().GetChatService().GetChatSession(sessionID, userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "会话不存在"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data": newSessionSettings(chatSession),
+	})
+}
+
+// UpdateSessionSettings 更新会话级设置（生成参数整体替换）
+func UpdateSessionSettings(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
+	var settings SessionSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	chatService := LLM_Chat_Service.GetSessionManager().GetChatService()
+	if settings.SummaryEnabled != nil {
+		if err := chatService.UpdateSessionSummaryEnabled(sessionID, userID.(uint), *settings.SummaryEnabled); err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, LLM_Chat_Service.ErrSessionNotFound) {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": "更新摘要设置失败: " + err.Error()})
+			return
+		}
+	}
+
+	chatSession, err := chatService.UpdateSessionGenerationParams(sessionID, userID.(uint), settings.GenerationParams)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, LLM_Chat_Service.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(status, gin.H{"error": "更新生成参数失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "会话设置更新成功",
+		"data":    newSessionSettings(chatSession),
+	})
+}
+
 // GetSessions  获取会话列表
 func GetSessions(c *gin.Context) {
 	userID, exists := c.Get("user_id")
@@ -400,13 +535,55 @@ type PaginatedMessagesResponse struct {
 }
 
 type MessageWithID struct {
-	ID      uint   `json:"id"`
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	ID         uint            `json:"id"`
+	ParentID   uint            `json:"parent_id"` // 上一条消息，编辑和重新生成产生的兄弟消息共用同一个 parent_id
+	Role       string          `json:"role"`
+	Content    string          `json:"content"`
+	ToolCalls  json.RawMessage `json:"tool_calls,omitempty"`
+	ToolCallID string          `json:"tool_call_id,omitempty"`
+	// 多部分内容，图片只返回文件引用
+	Parts []database.MessagePart `json:"parts,omitempty"`
+	// 模型回复实际使用的 API 配置和模型（经过模型路由时可能与会话的模型名不同）
+	APIID       uint   `json:"api_id,omitempty"`
+	ServedModel string `json:"served_model,omitempty"`
+	// 模型回复的 token 用量和费用
+	PromptTokens     int     `json:"prompt_tokens,omitempty"`
+	CompletionTokens int     `json:"completion_tokens,omitempty"`
+	Cost             float64 `json:"cost,omitempty"`
+}
+
+// newMessagesWithID 把数据库消息转换为返回给前端的结构
+func newMessagesWithID(dbMessages []database.ChatMessage) []MessageWithID {
+	messages := make([]MessageWithID, len(dbMessages))
+	for i, msg := range dbMessages {
+		messages[i] = MessageWithID{
+			ID:               msg.ID,
+			ParentID:         msg.ParentID,
+			Role:             msg.Role,
+			Content:          msg.Content,
+			ToolCallID:       msg.ToolCallID,
+			Parts:            msg.Parts,
+			APIID:            msg.APIID,
+			ServedModel:      msg.ServedModel,
+			PromptTokens:     msg.PromptTokens,
+			CompletionTokens: msg.CompletionTokens,
+			Cost:             msg.Cost,
+		}
+		if msg.ToolCalls != "" {
+			messages[i].ToolCalls = json.RawMessage(msg.ToolCalls)
+		}
+	}
+	return messages
 }
 
-// GetSessionMessages 获取特定会话的消息
+// GetSessionMessages 获取特定会话当前分支上的消息
 func GetSessionMessages(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
 	sessionID := c.Param("session_id")
 
 	// 从查询参数获取分页信息
@@ -417,23 +594,16 @@ func GetSessionMessages(c *gin.Context) {
 	limit, _ := strconv.Atoi(limitStr)
 
 	// 调用 ChatService 获取数据库消息
-	dbMessages, nextCursor, hasMore, err := LLM_Chat_Service.GetSessionManager().GetChatService().GetChatMessages(sessionID, uint(cursor), limit)
+	dbMessages, nextCursor, hasMore, err := LLM_Chat_Service.GetSessionManager().GetChatService().GetChatMessages(sessionID, userID.(uint), uint(cursor), limit)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(sessionErrorStatus(err), gin.H{
 			"error": "获取消息失败: " + err.Error(),
 		})
 		return
 	}
 
 	// 转换为带 ID 的消息结构
-	messages := make([]MessageWithID, len(dbMessages))
-	for i, msg := range dbMessages {
-		messages[i] = MessageWithID{
-			ID:      msg.ID,
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
-	}
+	messages := newMessagesWithID(dbMessages)
 
 	c.JSON(http.StatusOK, gin.H{
 		"data":     messages,
@@ -444,12 +614,18 @@ func GetSessionMessages(c *gin.Context) {
 
 // DeleteSession 删除会话
 func DeleteSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
 	sessionID := c.Param("session_id")
 
 	// 从内存中移除并删除数据库记录
-	err := LLM_Chat_Service.GetSessionManager().DeleteSession(sessionID)
+	err := LLM_Chat_Service.GetSessionManager().DeleteSession(sessionID, userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
+		c.JSON(sessionErrorStatus(err), gin.H{
 			"error": "删除会话失败: " + err.Error(),
 		})
 		return
@@ -10,27 +10,65 @@ import (
 
 // APICreateRequest API管理相关的请求和响应结构体
 type APICreateRequest struct {
-	APIName   string `json:"api_name" binding:"required"`
-	APIKey    string `json:"api_key" binding:"required"`
-	ModelName string `json:"model_name" binding:"required"`
-	BaseURL   string `json:"base_url" binding:"omitempty,url"`
+	APIName        string `json:"api_name" binding:"required"`
+	APIKey         string `json:"api_key"` // 脱敏后的密钥，明文不会返回给前端
+	ModelName      string `json:"model_name" binding:"required"`
+	BaseURL        string `json:"base_url" binding:"omitempty,url"`
+	Provider       string `json:"provider" binding:"omitempty,oneof=openai anthropic ollama"`
+	ContextBudget  int    `json:"context_budget" binding:"omitempty,min=0"` // 上下文 token 预算，0 表示使用模型的上下文长度
+	SupportsVision bool   `json:"supports_vision"`                          // 模型是否支持图片输入
 }
 
 type APIUpdateRequest struct {
-	APIName   string `json:"api_name" binding:"omitempty"`
-	APIKey    string `json:"api_key" binding:"omitempty"`
-	ModelName string `json:"model_name" binding:"omitempty"`
-	BaseURL   string `json:"base_url" binding:"omitempty,url"`
+	APIName        string `json:"api_name" binding:"omitempty"`
+	APIKey         string `json:"api_key" binding:"omitempty"`
+	ModelName      string `json:"model_name" binding:"omitempty"`
+	BaseURL        string `json:"base_url" binding:"omitempty,url"`
+	Provider       string `json:"provider" binding:"omitempty,oneof=openai anthropic ollama"`
+	ContextBudget  *int   `json:"context_budget" binding:"omitempty,min=0"` // 指针用于区分"未提供"和"重置为 0"
+	SupportsVision *bool  `json:"supports_vision"`
 }
 
 type APIResponse struct {
-	ID        uint   `json:"id"`
-	APIName   string `json:"api_name"`
-	ModelName string `json:"model_name"`
-	BaseURL   string `json:"base_url"`
-	APIKey    string `json:"api_key"`
-	CreatedAt string `json:"created_at"`
-	UpdatedAt string `json:"updated_at"`
+	ID             uint   `json:"id"`
+	APIName        string `json:"api_name"`
+	ModelName      string `json:"model_name"`
+	BaseURL        string `json:"base_url"`
+	Provider       string `json:"provider"`
+	ContextBudget  int    `json:"context_budget"`
+	SupportsVision bool   `json:"supports_vision"`
+	APIKey         string `json:"api_key"` // 脱敏后的密钥，明文不会返回给前端
+	CreatedAt      string `json:"created_at"`
+	UpdatedAt      string `json:"updated_at"`
+
+	// 最近一次连通性检查结果
+	HealthStatus    string `json:"health_status"`
+	HealthLatencyMs int64  `json:"health_latency_ms"`
+	HealthError     string `json:"health_error,omitempty"`
+	HealthCheckedAt string `json:"health_checked_at,omitempty"`
+}
+
+// newAPIResponse 转换为响应格式
+func newAPIResponse(api *database.UserAPI) APIResponse {
+	response := APIResponse{
+		ID:              api.ID,
+		APIName:         api.APIName,
+		ModelName:       api.ModelName,
+		BaseURL:         api.BaseURL,
+		Provider:        api.Provider,
+		ContextBudget:   api.ContextBudget,
+		SupportsVision:  api.SupportsVision,
+		APIKey:          LLM_Chat.MaskAPIKey(api.APIKey),
+		CreatedAt:       api.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt:       api.UpdatedAt.Format("2006-01-02 15:04:05"),
+		HealthStatus:    api.HealthStatus,
+		HealthLatencyMs: api.HealthLatencyMs,
+		HealthError:     api.HealthError,
+	}
+	if api.HealthCheckedAt != nil {
+		response.HealthCheckedAt = api.HealthCheckedAt.Format("2006-01-02 15:04:05")
+	}
+	return response
 }
 
 // CreateUserAPI 创建新的API配置
@@ -54,11 +92,14 @@ func CreateUserAPI(c *gin.Context) {
 
 	// 创建API配置对象
 	apiConfig := &database.UserAPI{
-		UserID:    userID.(uint),
-		APIName:   req.APIName,
-		APIKey:    req.APIKey,
-		ModelName: req.ModelName,
-		BaseURL:   req.BaseURL,
+		UserID:         userID.(uint),
+		APIName:        req.APIName,
+		APIKey:         req.APIKey,
+		ModelName:      req.ModelName,
+		BaseURL:        req.BaseURL,
+		Provider:       req.Provider,
+		ContextBudget:  req.ContextBudget,
+		SupportsVision: req.SupportsVision,
 	}
 
 	// 调用服务创建API
@@ -73,12 +114,15 @@ func CreateUserAPI(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"message": "API创建成功",
 		"api": APIResponse{
-			ID:        createdAPI.ID,
-			APIName:   createdAPI.APIName,
-			ModelName: createdAPI.ModelName,
-			BaseURL:   createdAPI.BaseURL,
-			CreatedAt: createdAPI.CreatedAt.Format("2006-01-02 15:04:05"),
-			UpdatedAt: createdAPI.UpdatedAt.Format("2006-01-02 15:04:05"),
+			ID:             createdAPI.ID,
+			APIName:        createdAPI.APIName,
+			ModelName:      createdAPI.ModelName,
+			BaseURL:        createdAPI.BaseURL,
+			Provider:       createdAPI.Provider,
+			ContextBudget:  createdAPI.ContextBudget,
+			SupportsVision: createdAPI.SupportsVision,
+			CreatedAt:      createdAPI.CreatedAt.Format("2006-01-02 15:04:05"),
+			UpdatedAt:      createdAPI.UpdatedAt.Format("2006-01-02 15:04:05"),
 		},
 	})
 }
@@ -105,16 +149,8 @@ func GetUserAPIs(c *gin.Context) {
 
 	// 转换为响应格式
 	var apiResponses []APIResponse
-	for _, api := range apis {
-		apiResponses = append(apiResponses, APIResponse{
-			ID:        api.ID,
-			APIName:   api.APIName,
-			ModelName: api.ModelName,
-			BaseURL:   api.BaseURL,
-			APIKey:    api.APIKey,
-			CreatedAt: api.CreatedAt.Format("2006-01-02 15:04:05"),
-			UpdatedAt: api.UpdatedAt.Format("2006-01-02 15:04:05"),
-		})
+	for i := range apis {
+		apiResponses = append(apiResponses, newAPIResponse(&apis[i]))
 	}
 
 	c.JSON(http.StatusOK, gin.H{
@@ -152,15 +188,7 @@ func GetUserAPIByName(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"api": APIResponse{
-			ID:        api.ID,
-			APIName:   api.APIName,
-			ModelName: api.ModelName,
-			BaseURL:   api.BaseURL,
-			APIKey:    api.APIKey,
-			CreatedAt: api.CreatedAt.Format("2006-01-02 15:04:05"),
-			UpdatedAt: api.UpdatedAt.Format("2006-01-02 15:04:05"),
-		},
+		"api": newAPIResponse(api),
 	})
 }
 
@@ -206,6 +234,15 @@ func UpdateUserAPI(c *gin.Context) {
 	if req.BaseURL != "" {
 		updates["base_url"] = req.BaseURL
 	}
+	if req.Provider != "" {
+		updates["provider"] = req.Provider
+	}
+	if req.ContextBudget != nil {
+		updates["context_budget"] = *req.ContextBudget
+	}
+	if req.SupportsVision != nil {
+		updates["supports_vision"] = *req.SupportsVision
+	}
 
 	// 检查是否有更新字段
 	if len(updates) == 0 {
@@ -284,14 +321,48 @@ func GetFirstAvailableAPI(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"api": APIResponse{
-			ID:        api.ID,
-			APIName:   api.APIName,
-			ModelName: api.ModelName,
-			BaseURL:   api.BaseURL,
-			APIKey:    api.APIKey,
-			CreatedAt: api.CreatedAt.Format("2006-01-02 15:04:05"),
-			UpdatedAt: api.UpdatedAt.Format("2006-01-02 15:04:05"),
-		},
+		"api": newAPIResponse(api),
+	})
+}
+
+// TestUserAPI 立即检查API配置的连通性，返回检查结果
+func TestUserAPI(c *gin.Context) {
+	// 从上下文中获取用户ID
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "用户未认证",
+		})
+		return
+	}
+
+	apiID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "API ID格式错误",
+		})
+		return
+	}
+
+	// 只能检查自己的配置
+	api, err := LLM_Chat.GlobalUserAPIService.GetAPIByID(uint(apiID))
+	if err != nil || api.UserID != userID.(uint) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "API配置不存在",
+		})
+		return
+	}
+
+	api, err = LLM_Chat.GlobalUserAPIService.TestAPIConnection(c.Request.Context(), api.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "检查API连接失败: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"healthy": api.HealthStatus == database.APIHealthy,
+		"api":     newAPIResponse(api),
 	})
 }
@@ -1,121 +1,137 @@
 package LLM_Chat
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"platfrom/database"
 	LLM_Chat_Service "platfrom/service/LLM_Chat"
-	"strings"
 )
 
 func setupFileRoutes(router *gin.Engine) {
 	files := router.Group("/api/files")
 	{
 		files.POST("/upload", UploadFile())
+		files.GET("/config", GetUploadConfig())
 		files.GET("/session/:session_id", GetSessionFiles())
 		files.DELETE("/:file_id", DeleteFile())
 	}
 }
 
+// multipartOverhead 请求体中表单字段和分隔符占用的余量
+const multipartOverhead = 1 << 20
+
 func UploadFile() gin.HandlerFunc {
 	fileService := LLM_Chat_Service.GlobalFileService
 	return func(c *gin.Context) {
-		sessionID := c.PostForm("session_id")
-		if sessionID == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "session_id 不能为空"})
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+			return
+		}
+
+		// 限制请求体大小，超过上限时在解析表单阶段就中止读取
+		maxBodySize := fileService.UploadConfig().MaxFileSize + multipartOverhead
+		if c.Request.ContentLength > maxBodySize {
+			err := fileService.ValidateUpload("", c.Request.ContentLength)
+			c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBodySize)
 
 		file, err := c.FormFile("file")
 		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				err = fileService.ValidateUpload("", maxBytesErr.Limit)
+				c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+				return
+			}
 			c.JSON(http.StatusBadRequest, gin.H{"error": "文件上传失败: " + err.Error()})
 			return
 		}
 
-		// 创建上传目录
-		uploadDir := "./uploads"
-		if err := os.MkdirAll(uploadDir, 0755); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "创建上传目录失败: " + err.Error()})
+		sessionID := c.PostForm("session_id")
+		if sessionID == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "session_id 不能为空"})
 			return
 		}
 
-		// 生成唯一文件名
-		fileExt := filepath.Ext(file.Filename)
-		fileName := fmt.Sprintf("%s_%s%s", sessionID, generateRandomString(8), fileExt)
-		filePath := filepath.Join(uploadDir, fileName)
-
-		// 保存文件
-		if err := c.SaveUploadedFile(file, filePath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件失败: " + err.Error()})
+		// 先按文件头中的名称和大小校验，不通过时不落盘
+		if err := fileService.ValidateUpload(file.Filename, file.Size); err != nil {
+			c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
 			return
 		}
 
-		// 读取文件内容（如果是文本文件）
-		var fileContent string
-		textExtensions := []string{".txt", ".py", ".go", ".c", ".cpp", ".h", ".hpp", ".js", ".ts", ".java", ".html", ".css", ".md", ".json", ".xml", ".yaml", ".yml"}
-
-		ext := strings.ToLower(fileExt)
-		isTextFile := false
-		for _, textExt := range textExtensions {
-			if ext == textExt {
-				isTextFile = true
-				break
-			}
-		}
-
-		if isTextFile {
-			content, err := os.ReadFile(filePath)
-			if err == nil {
-				fileContent = string(content)
-			}
+		src, err := file.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "读取上传文件失败: " + err.Error()})
+			return
 		}
+		defer src.Close()
 
-		// 保存到数据库
-		uploadedFile := &database.UploadedFile{
-			SessionID:   sessionID,
-			FileName:    file.Filename,
-			FilePath:    filePath,
-			FileSize:    file.Size,
-			FileType:    filepath.Ext(file.Filename),
-			Content:     fileContent,
-			IsProcessed: false,
+		uploadedFile, err := fileService.StoreUpload(sessionID, file.Filename, src, userID.(uint))
+		if err != nil {
+			c.JSON(uploadErrorStatus(err), gin.H{"error": err.Error()})
+			return
 		}
 
-		if err := fileService.SaveFile(uploadedFile); err != nil {
-			// 删除已上传的文件
-			os.Remove(filePath)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "保存文件信息失败: " + err.Error()})
-			return
+		// 后台切块建立索引，完成后 IsProcessed 置为 true；对话时仍未完成的文件会同步建立索引
+		if !uploadedFile.IsProcessed {
+			go func(file database.UploadedFile) {
+				if err := fileService.IndexFile(&file); err != nil {
+					log.Printf("文件索引失败 (file: %d): %v", file.ID, err)
+				}
+			}(*uploadedFile)
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"message":   "文件上传成功",
-			"file_id":   uploadedFile.ID,
-			"file_name": uploadedFile.FileName,
-			"file_type": uploadedFile.FileType,
+			"message":      "文件上传成功",
+			"file_id":      uploadedFile.ID,
+			"file_name":    uploadedFile.FileName,
+			"file_type":    uploadedFile.FileType,
+			"is_processed": uploadedFile.IsProcessed,
 		})
 	}
 }
 
-func generateRandomString(length int) string {
-	// 简单的随机字符串生成，实际使用时可以改进
-	bytes := make([]byte, length)
-	for i := 0; i < length; i++ {
-		bytes[i] = byte(65 + (i % 26)) // A-Z
+// uploadErrorStatus 上传错误对应的状态码
+func uploadErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, LLM_Chat_Service.ErrFileTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, LLM_Chat_Service.ErrExtensionNotAllowed), errors.Is(err, LLM_Chat_Service.ErrInvalidFile):
+		return http.StatusBadRequest
+	case errors.Is(err, LLM_Chat_Service.ErrSessionNotFound), errors.Is(err, LLM_Chat_Service.ErrFileNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// GetUploadConfig 返回上传策略（大小上限和允许的扩展名），前端据此做预检查
+func GetUploadConfig() gin.HandlerFunc {
+	fileService := LLM_Chat_Service.GlobalFileService
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"data": fileService.UploadConfig()})
 	}
-	return string(bytes)
 }
 
 func GetSessionFiles() gin.HandlerFunc {
 	fileService := LLM_Chat_Service.GlobalFileService
 	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+			return
+		}
+
 		sessionID := c.Param("session_id")
-		files, err := fileService.GetFilesBySession(sessionID)
+		files, err := fileService.GetFilesBySession(sessionID, userID.(uint))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "获取文件列表失败: " + err.Error()})
+			c.JSON(uploadErrorStatus(err), gin.H{"error": "获取文件列表失败: " + err.Error()})
 			return
 		}
 
@@ -126,6 +142,12 @@ func GetSessionFiles() gin.HandlerFunc {
 func DeleteFile() gin.HandlerFunc {
 	fileService := LLM_Chat_Service.GlobalFileService
 	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+			return
+		}
+
 		fileID := c.Param("file_id")
 
 		// 这里需要将字符串fileID转换为uint
@@ -136,21 +158,9 @@ func DeleteFile() gin.HandlerFunc {
 			return
 		}
 
-		// 先获取文件信息以便删除物理文件
-		file, err := fileService.GetFileByID(id)
-		if err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "文件不存在"})
-			return
-		}
-
-		// 删除物理文件
-		if file.FilePath != "" {
-			os.Remove(file.FilePath)
-		}
-
-		// 删除数据库记录
-		if err := fileService.DeleteFile(id); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "删除文件失败: " + err.Error()})
+		// 删除数据库记录，最后一个引用被删除时同时删除存储的内容；其他用户的文件按不存在处理
+		if err := fileService.DeleteFile(id, userID.(uint)); err != nil {
+			c.JSON(uploadErrorStatus(err), gin.H{"error": "删除文件失败: " + err.Error()})
 			return
 		}
 
@@ -0,0 +1,307 @@
+package LLM_Chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"platfrom/database"
+	LLM_Chat_Service "platfrom/service/LLM_Chat"
+	"strings"
+	"time"
+)
+
+// streamIdleTimeout 生成不在本进程中进行（已中断或在其他实例上）且持续没有新事件时，结束推送的最长等待时间
+const streamIdleTimeout = 15 * time.Second
+
+// writeSSEHeaders 设置流式传输的响应头
+func writeSSEHeaders(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Cache-Control")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+	c.Writer.Flush()
+}
+
+// writeSSEEvent 按 SSE 格式写出一条事件
+func writeSSEEvent(c *gin.Context, event LLM_Chat_Service.StreamEvent) {
+	if event.ID != "" {
+		fmt.Fprintf(c.Writer, "id: %s\n", event.ID)
+	}
+	if event.Event != "" {
+		fmt.Fprintf(c.Writer, "event: %s\n", event.Event)
+	}
+	fmt.Fprintf(c.Writer, "data: %s\n\n", event.Data)
+	c.Writer.Flush()
+}
+
+// submitErrorStatus 提交生成任务失败时的状态码
+func submitErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, LLM_Chat_Service.ErrGenerationInProgress):
+		return http.StatusConflict
+	case errors.Is(err, LLM_Chat_Service.ErrTooManyGenerations), errors.Is(err, LLM_Chat_Service.ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, LLM_Chat_Service.ErrQueueFull):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// submitJob 把生成任务交给后台 worker，失败时返回错误响应
+func submitJob(c *gin.Context, job *LLM_Chat_Service.GenerationJob, preamble ...LLM_Chat_Service.StreamEvent) bool {
+	if err := LLM_Chat_Service.GetGenerationWorkerPool().Submit(job, preamble...); err != nil {
+		if abortRateLimited(c, err) {
+			return false
+		}
+		c.JSON(submitErrorStatus(err), gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// followEvents 从 lastEventID 之后依次交给 write 会话事件日志中的事件，直到本次生成结束或 ctx 结束。
+// 持续没有新事件时调用 heartbeat（可为 nil）
+func followEvents(ctx context.Context, sessionID, lastEventID string, write func(event LLM_Chat_Service.StreamEvent) error, heartbeat func() error) {
+	idleSince := time.Now()
+	for {
+		events, err := LLM_Chat_Service.GlobalCacheService.ReadStreamEvents(ctx, sessionID, lastEventID, 5*time.Second)
+		if err != nil {
+			return
+		}
+		for _, event := range events {
+			if err := write(event); err != nil {
+				return
+			}
+			lastEventID = event.ID
+			if event.Done {
+				return
+			}
+		}
+		if len(events) > 0 {
+			idleSince = time.Now()
+			continue
+		}
+
+		if heartbeat != nil {
+			if err := heartbeat(); err != nil {
+				return
+			}
+		}
+		if !LLM_Chat_Service.GlobalGenerationRegistry.Running(sessionID) && time.Since(idleSince) > streamIdleTimeout {
+			return
+		}
+	}
+}
+
+// followStream 以 SSE 推送会话从 lastEventID 之后的事件，直到本次生成结束。
+// 客户端断开只结束推送，后台生成继续进行并保存回复
+func followStream(c *gin.Context, sessionID, lastEventID string) {
+	writeSSEHeaders(c)
+	followEvents(c.Request.Context(), sessionID, lastEventID, func(event LLM_Chat_Service.StreamEvent) error {
+		writeSSEEvent(c, event)
+		return nil
+	}, func() error {
+		// 没有新事件时推送心跳注释，保持连接
+		fmt.Fprintf(c.Writer, ": heartbeat\n\n")
+		c.Writer.Flush()
+		return nil
+	})
+}
+
+// jobResult 同步接口等待的后台任务结果，由会话事件日志汇总
+type jobResult struct {
+	Response       string
+	References     json.RawMessage // 引用的笔记
+	FileReferences json.RawMessage // 引用的文件片段
+	MessageID      uint            // 任务创建的用户消息（编辑消息时）
+	Stopped        bool
+	Err            error
+}
+
+// awaitJob 等待已提交的后台任务结束，返回回复和引用。客户端断开时返回 false（生成在后台继续并保存回复）。
+// 同步接口与流式接口一样由 worker 生成，因此同样受并发上限约束、可以被 /stop 停止
+func awaitJob(c *gin.Context, sessionID string) (*jobResult, bool) {
+	result := &jobResult{}
+	var content strings.Builder
+	done := false
+	followEvents(c.Request.Context(), sessionID, "", func(event LLM_Chat_Service.StreamEvent) error {
+		switch event.Event {
+		case "references":
+			var payload struct {
+				References     json.RawMessage `json:"references"`
+				FileReferences json.RawMessage `json:"file_references"`
+			}
+			if err := json.Unmarshal([]byte(event.Data), &payload); err == nil {
+				result.References, result.FileReferences = payload.References, payload.FileReferences
+			}
+		case "message":
+			var payload struct {
+				MessageID uint `json:"message_id"`
+			}
+			if err := json.Unmarshal([]byte(event.Data), &payload); err == nil {
+				result.MessageID = payload.MessageID
+			}
+		case "":
+			var payload struct {
+				Content string `json:"content"`
+				Error   string `json:"error"`
+				Stopped bool   `json:"stopped"`
+			}
+			if err := json.Unmarshal([]byte(event.Data), &payload); err != nil {
+				return nil
+			}
+			content.WriteString(payload.Content)
+			if event.Done {
+				done = true
+				result.Stopped = payload.Stopped
+				if payload.Error != "" {
+					result.Err = errors.New(payload.Error)
+				}
+			}
+		}
+		return nil
+	}, nil)
+
+	if !done {
+		if c.Request.Context().Err() != nil {
+			return nil, false
+		}
+		result.Err = errors.New("生成未完成")
+	}
+	result.Response = content.String()
+	return result, true
+}
+
+// bindOptionalJSON 请求体非空时绑定 JSON
+func bindOptionalJSON(c *gin.Context, obj interface{}) bool {
+	if c.Request.ContentLength <= 0 {
+		return true
+	}
+	if err := c.ShouldBindJSON(obj); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "参数错误: " + err.Error()})
+		return false
+	}
+	return true
+}
+
+// StopGeneration 停止会话中正在进行的流式生成，已生成的部分保存为回复
+func StopGeneration(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
+	content, err := LLM_Chat_Service.GlobalGenerationRegistry.Stop(c.Param("session_id"), userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已停止生成",
+		"content": content,
+	})
+}
+
+// regenerateRequest 重新生成的参数
+type regenerateRequest struct {
+	UseTools bool `json:"use_tools"`
+	database.GenerationParams
+}
+
+// continueRequest 续写的参数
+type continueRequest struct {
+	database.GenerationParams
+}
+
+// prepareRegenerateJob 检查会话中有可以重新生成的消息，生成重新生成的任务（执行时才创建分支）
+func prepareRegenerateJob(userID uint, sessionID string, request *regenerateRequest) (*LLM_Chat_Service.GenerationJob, int, error) {
+	chatService := LLM_Chat_Service.GetSessionManager().GetChatService()
+	if _, err := chatService.GetChatSession(sessionID, userID); err != nil {
+		return nil, sessionErrorStatus(err), errors.New("获取会话失败: " + err.Error())
+	}
+	if _, err := chatService.GetLastMessage(sessionID, userID); err != nil {
+		return nil, branchErrorStatus(err), errors.New("没有可以重新生成的回复: " + err.Error())
+	}
+
+	job := LLM_Chat_Service.NewGenerationJob(LLM_Chat_Service.JobRegenerate, sessionID, userID)
+	job.UseTools = request.UseTools
+	job.Params = request.GenerationParams
+	return job, http.StatusOK, nil
+}
+
+// prepareContinueJob 检查当前分支最后一条是模型回复，生成续写的任务
+func prepareContinueJob(userID uint, sessionID string, request *continueRequest) (*LLM_Chat_Service.GenerationJob, int, error) {
+	chatService := LLM_Chat_Service.GetSessionManager().GetChatService()
+	if _, err := chatService.GetChatSession(sessionID, userID); err != nil {
+		return nil, sessionErrorStatus(err), errors.New("获取会话失败: " + err.Error())
+	}
+	last, err := chatService.GetLastMessage(sessionID, userID)
+	if err != nil {
+		return nil, branchErrorStatus(err), errors.New("没有可以续写的回复: " + err.Error())
+	}
+	if last.Role != "assistant" {
+		return nil, http.StatusBadRequest, errors.New("最后一条消息不是模型回复，无法续写")
+	}
+
+	job := LLM_Chat_Service.NewGenerationJob(LLM_Chat_Service.JobContinue, sessionID, userID)
+	job.Params = request.GenerationParams
+	return job, http.StatusOK, nil
+}
+
+// RegenerateStream 流式重新生成当前分支的最后一条回复，原回复保留为兄弟分支。
+// 最后一条是用户消息（上次生成失败）时直接为它生成回复
+func RegenerateStream(c *gin.Context) {
+	var request regenerateRequest
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	if !bindOptionalJSON(c, &request) {
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	job, status, err := prepareRegenerateJob(userID.(uint), sessionID, &request)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if !submitJob(c, job) {
+		return
+	}
+	followStream(c, sessionID, "")
+}
+
+// ContinueStream 流式续写当前分支最后一条被截断的回复，续写内容拼接到原回复
+func ContinueStream(c *gin.Context) {
+	var request continueRequest
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	if !bindOptionalJSON(c, &request) {
+		return
+	}
+
+	sessionID := c.Param("session_id")
+	job, status, err := prepareContinueJob(userID.(uint), sessionID, &request)
+	if err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if !submitJob(c, job) {
+		return
+	}
+	followStream(c, sessionID, "")
+}
@@ -0,0 +1,161 @@
+package LLM_Chat
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+	"strconv"
+)
+
+// ModelRouteRequest 创建和修改模型路由的请求
+type ModelRouteRequest struct {
+	Name   string `json:"name" binding:"required"`                                             // 聊天时作为模型名使用
+	Policy string `json:"policy" binding:"omitempty,oneof=fallback round_robin least_latency"` // 默认 fallback
+	APIIDs []uint `json:"api_ids" binding:"required,min=1"`                                    // 按优先级排列
+}
+
+type ModelRouteResponse struct {
+	ID        uint   `json:"id"`
+	Name      string `json:"name"`
+	Policy    string `json:"policy"`
+	APIIDs    []uint `json:"api_ids"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// newModelRouteResponse 转换为响应格式
+func newModelRouteResponse(route *database.ModelRoute) ModelRouteResponse {
+	return ModelRouteResponse{
+		ID:        route.ID,
+		Name:      route.Name,
+		Policy:    route.Policy,
+		APIIDs:    route.APIIDs,
+		CreatedAt: route.CreatedAt.Format("2006-01-02 15:04:05"),
+		UpdatedAt: route.UpdatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// parseRouteID 读取路径中的路由ID
+func parseRouteID(c *gin.Context) (uint, bool) {
+	routeID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || routeID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "路由ID格式错误"})
+		return 0, false
+	}
+	return uint(routeID), true
+}
+
+// GetModelRoutes 获取用户的模型路由
+func GetModelRoutes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
+	routes, err := LLM_Chat.GlobalUserAPIService.GetModelRoutes(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取模型路由失败: " + err.Error()})
+		return
+	}
+
+	response := make([]ModelRouteResponse, len(routes))
+	for i := range routes {
+		response[i] = newModelRouteResponse(&routes[i])
+	}
+	c.JSON(http.StatusOK, gin.H{"data": response})
+}
+
+// CreateModelRoute 创建模型路由
+func CreateModelRoute(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
+	var req ModelRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	route, err := LLM_Chat.GlobalUserAPIService.CreateModelRoute(userID.(uint), &database.ModelRoute{
+		Name:   req.Name,
+		Policy: req.Policy,
+		APIIDs: req.APIIDs,
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "创建模型路由失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "模型路由创建成功",
+		"data":    newModelRouteResponse(route),
+	})
+}
+
+// UpdateModelRoute 修改模型路由（整体替换名称、策略和配置列表）
+func UpdateModelRoute(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	routeID, ok := parseRouteID(c)
+	if !ok {
+		return
+	}
+
+	var req ModelRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	route, err := LLM_Chat.GlobalUserAPIService.UpdateModelRoute(userID.(uint), routeID, &database.ModelRoute{
+		Name:   req.Name,
+		Policy: req.Policy,
+		APIIDs: req.APIIDs,
+	})
+	if err != nil {
+		if errors.Is(err, LLM_Chat.ErrModelRouteNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "更新模型路由失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "模型路由更新成功",
+		"data":    newModelRouteResponse(route),
+	})
+}
+
+// DeleteModelRoute 删除模型路由，使用该路由名的会话之后按同名的 API 配置回退
+func DeleteModelRoute(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	routeID, ok := parseRouteID(c)
+	if !ok {
+		return
+	}
+
+	if err := LLM_Chat.GlobalUserAPIService.DeleteModelRoute(userID.(uint), routeID); err != nil {
+		if errors.Is(err, LLM_Chat.ErrModelRouteNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除模型路由失败: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "模型路由删除成功"})
+}
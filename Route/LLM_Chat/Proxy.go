@@ -0,0 +1,120 @@
+package LLM_Chat
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"platfrom/service/LLM_Chat"
+	"strconv"
+)
+
+// proxyMaxRequestSize /v1 请求体的最大长度（图片以 base64 内嵌时请求较大）
+const proxyMaxRequestSize = 32 << 20
+
+// openAIError 按 OpenAI 的错误格式返回，便于现有客户端显示
+func openAIError(c *gin.Context, status int, errType, message string) {
+	c.JSON(status, gin.H{"error": gin.H{
+		"message": message,
+		"type":    errType,
+	}})
+}
+
+// ProxyRateLimitMiddleware /v1/chat/completions 与聊天接口使用同一套限制（按令牌所属的用户计算）：
+// 检查每分钟请求数和每日 token 配额，请求期间占用一个并发名额。被限流时按 OpenAI 的格式返回 429
+func ProxyRateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			openAIError(c, http.StatusUnauthorized, "invalid_request_error", "未提供 API 令牌")
+			c.Abort()
+			return
+		}
+
+		status, err := checkRateLimit(userID.(uint))
+		writeRateLimitHeaders(c, status)
+		if abortProxyRateLimited(c, err) {
+			return
+		}
+		if LLM_Chat.GlobalRateLimiter == nil {
+			c.Next()
+			return
+		}
+
+		release, err := LLM_Chat.GlobalRateLimiter.AcquireStream(userID.(uint))
+		if err != nil {
+			if abortProxyRateLimited(c, err) {
+				return
+			}
+			log.Printf("检查并发限制失败，本次不限制 (user: %d): %v", userID, err)
+			c.Next()
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// abortProxyRateLimited 被限流时按 OpenAI 的格式返回 429，err 不是 RateLimitError 时返回 false
+func abortProxyRateLimited(c *gin.Context, err error) bool {
+	var limited *LLM_Chat.RateLimitError
+	if !errors.As(err, &limited) {
+		return false
+	}
+	c.Header("Retry-After", strconv.FormatInt(retryAfterSeconds(limited), 10))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": gin.H{
+		"message": limited.Error(),
+		"type":    "rate_limit_error",
+		"code":    limited.Scope,
+	}})
+	return true
+}
+
+// ProxyChatCompletions OpenAI 兼容的 /v1/chat/completions，按模型名使用用户保存的 API 配置转发（支持流式）
+func ProxyChatCompletions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		openAIError(c, http.StatusUnauthorized, "invalid_request_error", "未提供 API 令牌")
+		return
+	}
+	tokenID := c.GetUint("api_token_id")
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, proxyMaxRequestSize))
+	if err != nil {
+		openAIError(c, http.StatusRequestEntityTooLarge, "invalid_request_error", "请求体过大或读取失败: "+err.Error())
+		return
+	}
+
+	err = LLM_Chat.GlobalProxyService.ChatCompletions(c.Request.Context(), userID.(uint), tokenID, body, c.Writer)
+	switch {
+	case err == nil:
+	case errors.Is(err, LLM_Chat.ErrInvalidProxyRequest):
+		openAIError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+	case errors.Is(err, LLM_Chat.ErrModelNotConfigured):
+		openAIError(c, http.StatusNotFound, "invalid_request_error", err.Error())
+	default:
+		log.Printf("转发请求失败 (user: %d): %v", userID.(uint), err)
+		openAIError(c, http.StatusBadGateway, "api_error", err.Error())
+	}
+}
+
+// ProxyListModels OpenAI 兼容的 /v1/models，返回用户已配置的模型
+func ProxyListModels(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		openAIError(c, http.StatusUnauthorized, "invalid_request_error", "未提供 API 令牌")
+		return
+	}
+
+	models, err := LLM_Chat.GlobalProxyService.ListModels(userID.(uint))
+	if err != nil {
+		openAIError(c, http.StatusInternalServerError, "api_error", "获取模型列表失败: "+err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"object": "list",
+		"data":   models,
+	})
+}
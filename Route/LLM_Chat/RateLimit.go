@@ -0,0 +1,227 @@
+package LLM_Chat
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"log"
+	"math"
+	"net/http"
+	"platfrom/database"
+	LLM_Chat_Service "platfrom/service/LLM_Chat"
+	"strconv"
+)
+
+// 限流响应头（与 OpenAI 的命名一致，Reset 为 Unix 时间戳），只输出设置了上限的项；被限流时另有 Retry-After（秒）
+var RateLimitHeaders = []string{
+	"Retry-After",
+	"X-RateLimit-Limit-Requests", "X-RateLimit-Remaining-Requests", "X-RateLimit-Reset-Requests",
+	"X-RateLimit-Limit-Tokens", "X-RateLimit-Remaining-Tokens", "X-RateLimit-Reset-Tokens",
+}
+
+// writeRateLimitHeaders 输出每分钟请求数和每日 token 配额的剩余量
+func writeRateLimitHeaders(c *gin.Context, status *LLM_Chat_Service.RateLimitStatus) {
+	if status == nil {
+		return
+	}
+	header := c.Writer.Header()
+	if limit := int64(status.Limits.RequestsPerMinute); limit > 0 {
+		header.Set("X-RateLimit-Limit-Requests", strconv.FormatInt(limit, 10))
+		header.Set("X-RateLimit-Remaining-Requests", strconv.FormatInt(max(limit-status.Requests, 0), 10))
+		header.Set("X-RateLimit-Reset-Requests", strconv.FormatInt(status.RequestsReset.Unix(), 10))
+	}
+	if limit := status.Limits.DailyTokens; limit > 0 {
+		header.Set("X-RateLimit-Limit-Tokens", strconv.FormatInt(limit, 10))
+		header.Set("X-RateLimit-Remaining-Tokens", strconv.FormatInt(max(limit-status.TokensUsed, 0), 10))
+		header.Set("X-RateLimit-Reset-Tokens", strconv.FormatInt(status.TokensReset.Unix(), 10))
+	}
+}
+
+// retryAfterSeconds 向上取整的重试秒数
+func retryAfterSeconds(err *LLM_Chat_Service.RateLimitError) int64 {
+	return int64(math.Ceil(err.RetryAfter().Seconds()))
+}
+
+// abortRateLimited 被限流时返回 429，err 不是 RateLimitError 时返回 false
+func abortRateLimited(c *gin.Context, err error) bool {
+	var limited *LLM_Chat_Service.RateLimitError
+	if !errors.As(err, &limited) {
+		return false
+	}
+	retryAfter := retryAfterSeconds(limited)
+	c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"error":       limited.Error(),
+		"limit":       limited.Scope,
+		"retry_after": retryAfter,
+	})
+	return true
+}
+
+// checkRateLimit 检查并计入一次生成请求。未启用限流或检查出错时放行，避免计数服务故障导致无法聊天
+func checkRateLimit(userID uint) (*LLM_Chat_Service.RateLimitStatus, error) {
+	if LLM_Chat_Service.GlobalRateLimiter == nil {
+		return nil, nil
+	}
+	status, err := LLM_Chat_Service.GlobalRateLimiter.AllowRequest(userID)
+	if err != nil && !errors.Is(err, LLM_Chat_Service.ErrRateLimited) {
+		log.Printf("检查限流失败，本次不限制 (user: %d): %v", userID, err)
+		return nil, nil
+	}
+	return status, err
+}
+
+// RateLimitMiddleware 发起生成的接口：检查每日 token 配额和每分钟请求数
+func RateLimitMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+			return
+		}
+
+		status, err := checkRateLimit(userID.(uint))
+		writeRateLimitHeaders(c, status)
+		if abortRateLimited(c, err) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// ConcurrentStreamMiddleware 在请求中同步生成回复的接口：请求期间占用一个并发名额。
+// 后台生成的接口由 worker 池在提交任务时占用名额，直到生成结束
+func ConcurrentStreamMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists || LLM_Chat_Service.GlobalRateLimiter == nil {
+			c.Next()
+			return
+		}
+
+		release, err := LLM_Chat_Service.GlobalRateLimiter.AcquireStream(userID.(uint))
+		if err != nil {
+			if abortRateLimited(c, err) {
+				return
+			}
+			log.Printf("检查并发限制失败，本次不限制 (user: %d): %v", userID, err)
+			c.Next()
+			return
+		}
+		defer release()
+		c.Next()
+	}
+}
+
+// GetRateLimits 获取当前用户的限制和用量
+func GetRateLimits(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
+	status, err := LLM_Chat_Service.GlobalRateLimiter.Status(userID.(uint))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取限制失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": status})
+}
+
+// UserRateLimitRequest 管理员为用户设置的限制，字段为空时使用角色的限制，0 表示不限制
+type UserRateLimitRequest struct {
+	RequestsPerMinute *int   `json:"requests_per_minute" binding:"omitempty,min=0"`
+	ConcurrentStreams *int   `json:"concurrent_streams" binding:"omitempty,min=0"`
+	DailyTokens       *int64 `json:"daily_tokens" binding:"omitempty,min=0"`
+}
+
+// parseUserID 读取路径中的用户ID
+func parseUserID(c *gin.Context) (uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil || userID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "用户ID格式错误"})
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// rootRateLimitResponse 用户生效的限制、当前用量和管理员的覆盖设置
+func rootRateLimitResponse(c *gin.Context, userID uint) {
+	status, err := LLM_Chat_Service.GlobalRateLimiter.Status(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取限制失败: " + err.Error()})
+		return
+	}
+	override, err := LLM_Chat_Service.GlobalRateLimiter.GetUserOverride(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取限制失败: " + err.Error()})
+		return
+	}
+
+	var overrideResponse *UserRateLimitRequest
+	if override != nil {
+		overrideResponse = &UserRateLimitRequest{
+			RequestsPerMinute: override.RequestsPerMinute,
+			ConcurrentStreams: override.ConcurrentStreams,
+			DailyTokens:       override.DailyTokens,
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"user_id":  userID,
+		"data":     status,
+		"override": overrideResponse,
+	})
+}
+
+// RootGetUserRateLimit 管理员查看用户的限制
+func RootGetUserRateLimit(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+	rootRateLimitResponse(c, userID)
+}
+
+// RootSetUserRateLimit 管理员为用户设置限制（整体替换之前的设置）
+func RootSetUserRateLimit(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	var req UserRateLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求参数错误: " + err.Error()})
+		return
+	}
+
+	err := LLM_Chat_Service.GlobalRateLimiter.SetUserOverride(&database.UserRateLimit{
+		UserID:            userID,
+		RequestsPerMinute: req.RequestsPerMinute,
+		ConcurrentStreams: req.ConcurrentStreams,
+		DailyTokens:       req.DailyTokens,
+	})
+	if err != nil {
+		if errors.Is(err, LLM_Chat_Service.ErrRateLimitUserNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "设置限制失败: " + err.Error()})
+		return
+	}
+	rootRateLimitResponse(c, userID)
+}
+
+// RootDeleteUserRateLimit 管理员删除用户的覆盖设置，恢复使用角色的限制
+func RootDeleteUserRateLimit(c *gin.Context) {
+	userID, ok := parseUserID(c)
+	if !ok {
+		return
+	}
+
+	if err := LLM_Chat_Service.GlobalRateLimiter.DeleteUserOverride(userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "删除限制失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已恢复使用角色的限制"})
+}
@@ -66,6 +66,55 @@ func RootGetAllSessions(c *gin.Context) {
 	})
 }
 
+// RootGetUsageReport 管理员查看各用户在日期范围内的 token 用量和费用
+func RootGetUsageReport(c *gin.Context) {
+	from, to, ok := parseUsageRange(c)
+	if !ok {
+		return
+	}
+
+	report, err := LLMService.GlobalUsageService.RootGetUsageReport(from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用量失败: " + err.Error()})
+		return
+	}
+
+	// 关联查询用户名
+	userIDs := make([]uint, 0, len(report))
+	for _, row := range report {
+		userIDs = append(userIDs, row.UserID)
+	}
+	var users []database.User
+	database.DB.Where("id IN ?", userIDs).Find(&users)
+	userMap := make(map[uint]string)
+	for _, user := range users {
+		userMap[user.ID] = user.Username
+	}
+
+	type userUsageResponse struct {
+		LLMService.UserUsage
+		Username string `json:"username"`
+	}
+	response := make([]userUsageResponse, len(report))
+	var total LLMService.UsageTotals
+	for i, row := range report {
+		response[i] = userUsageResponse{UserUsage: row, Username: userMap[row.UserID]}
+		total.Requests += row.Requests
+		total.PromptTokens += row.PromptTokens
+		total.CompletionTokens += row.CompletionTokens
+		total.TotalTokens += row.TotalTokens
+		total.Cost += row.Cost
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":     from.Format("2006-01-02"),
+		"to":       to.Format("2006-01-02"),
+		"currency": LLMService.GlobalUsageService.Currency(),
+		"total":    total,
+		"users":    response,
+	})
+}
+
 // RootGetSessionMessages 管理员查看会话的所有消息
 func RootGetSessionMessages(c *gin.Context) {
 	sessionID := c.Param("session_id")
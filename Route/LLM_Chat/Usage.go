@@ -0,0 +1,97 @@
+package LLM_Chat
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"platfrom/service/LLM_Chat"
+	"time"
+)
+
+const (
+	usageDefaultDays = 30  // 未指定日期范围时统计最近 30 天
+	usageMaxDays     = 366 // 单次查询的最大天数
+)
+
+// parseUsageRange 读取查询参数 from、to（2006-01-02，包含首尾两天），默认最近 30 天
+func parseUsageRange(c *gin.Context) (time.Time, time.Time, bool) {
+	now := time.Now()
+	to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	if value := c.Query("to"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to 格式错误，应为 YYYY-MM-DD"})
+			return time.Time{}, time.Time{}, false
+		}
+		to = parsed
+	}
+	from := to.AddDate(0, 0, -(usageDefaultDays - 1))
+	if value := c.Query("from"); value != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from 格式错误，应为 YYYY-MM-DD"})
+			return time.Time{}, time.Time{}, false
+		}
+		from = parsed
+	}
+
+	if from.After(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from 不能晚于 to"})
+		return time.Time{}, time.Time{}, false
+	}
+	if to.Sub(from) >= usageMaxDays*24*time.Hour {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "查询范围不能超过 366 天"})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// GetUsage 获取当前用户的 token 用量和费用（总计、按天、按模型、按 API 配置）
+func GetUsage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	from, to, ok := parseUsageRange(c)
+	if !ok {
+		return
+	}
+
+	summary, err := LLM_Chat.GlobalUsageService.GetUsageSummary(userID.(uint), from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用量失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": summary})
+}
+
+// GetSessionUsage 获取会话的累计用量
+func GetSessionUsage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+	sessionID := c.Param("session_id")
+
+	if _, err := LLM_Chat.GlobalChatService.GetChatSession(sessionID, userID.(uint)); err != nil {
+		if errors.Is(err, LLM_Chat.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取会话失败: " + err.Error()})
+		return
+	}
+
+	totals, err := LLM_Chat.GlobalUsageService.GetSessionUsage(userID.(uint), sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "获取用量失败: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"session_id": sessionID,
+		"currency":   LLM_Chat.GlobalUsageService.Currency(),
+		"data":       totals,
+	})
+}
@@ -0,0 +1,366 @@
+package LLM_Chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gorilla/websocket"
+	"log"
+	"net/http"
+	LLM_Chat_Service "platfrom/service/LLM_Chat"
+	"sync"
+	"time"
+)
+
+// WebSocket 聊天：一个连接上可同时进行多个会话的生成。
+//
+// 客户端消息：{"type": "send" | "regenerate" | "continue" | "stop" | "typing" | "resume", "session_id": "...", ...}
+//   - send 的其余字段与 POST /chat/message/stream 相同；regenerate、continue 与对应接口的请求体相同
+//   - typing 带 "typing": true/false，转发给同一用户的其他连接
+//   - resume 带 "last_event_id"，补发断线期间错过的事件
+//
+// 服务端消息：{"session_id": "...", "id": "事件ID", "event": "事件名", "data": {...}}，
+// data 与 SSE 的数据相同（content/done/error 等）；命令失败时 event 为 "error"，data 为 {"error": "...", "status": 状态码}
+
+const (
+	wsWriteWait    = 10 * time.Second
+	wsPongWait     = 60 * time.Second
+	wsPingPeriod   = wsPongWait * 9 / 10
+	wsMaxMessageSz = 1 << 20
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// wsClientMessage 客户端发来的消息，按 type 解析其余字段
+type wsClientMessage struct {
+	Type        string `json:"type"`
+	SessionID   string `json:"session_id"`
+	Typing      bool   `json:"typing"`
+	LastEventID string `json:"last_event_id"`
+}
+
+// wsServerMessage 推送给客户端的消息
+type wsServerMessage struct {
+	SessionID string          `json:"session_id"`
+	ID        string          `json:"id,omitempty"`
+	Event     string          `json:"event,omitempty"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// wsClient 一个 WebSocket 连接，每个会话的事件由单独的 goroutine 推送
+type wsClient struct {
+	conn   *websocket.Conn
+	userID uint
+	ctx    context.Context
+
+	writeMu sync.Mutex // 连接同时只允许一个写入者
+
+	mu        sync.Mutex
+	followers map[string]*wsFollower // 会话 -> 正在推送的事件订阅
+	wg        sync.WaitGroup
+}
+
+// wsFollower 一个会话的事件订阅
+type wsFollower struct {
+	cancel context.CancelFunc
+}
+
+// wsHub 按用户记录在线的连接，用于转发输入状态
+type wsHub struct {
+	mu      sync.Mutex
+	clients map[uint]map[*wsClient]struct{}
+}
+
+var chatHub = &wsHub{clients: make(map[uint]map[*wsClient]struct{})}
+
+func (h *wsHub) add(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients[client.userID] == nil {
+		h.clients[client.userID] = make(map[*wsClient]struct{})
+	}
+	h.clients[client.userID][client] = struct{}{}
+}
+
+func (h *wsHub) remove(client *wsClient) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.clients[client.userID], client)
+	if len(h.clients[client.userID]) == 0 {
+		delete(h.clients, client.userID)
+	}
+}
+
+// others 同一用户的其他连接
+func (h *wsHub) others(client *wsClient) []*wsClient {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var clients []*wsClient
+	for other := range h.clients[client.userID] {
+		if other != client {
+			clients = append(clients, other)
+		}
+	}
+	return clients
+}
+
+// ChatWebSocket WebSocket 聊天入口，认证与其他接口相同（AuthMiddleware）
+func ChatWebSocket(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "用户未认证"})
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade 已写出错误响应
+		log.Printf("WebSocket 升级失败: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &wsClient{
+		conn:      conn,
+		userID:    userID.(uint),
+		ctx:       ctx,
+		followers: make(map[string]*wsFollower),
+	}
+	chatHub.add(client)
+	defer func() {
+		// 连接关闭只结束推送，后台生成继续进行并保存回复
+		chatHub.remove(client)
+		cancel()
+		client.wg.Wait()
+		conn.Close()
+	}()
+
+	go client.ping(ctx)
+	client.readLoop()
+}
+
+// readLoop 读取并处理客户端消息，直到连接关闭
+func (w *wsClient) readLoop() {
+	w.conn.SetReadLimit(wsMaxMessageSz)
+	_ = w.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	w.conn.SetPongHandler(func(string) error {
+		return w.conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+
+	for {
+		_, raw, err := w.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				log.Printf("WebSocket 连接异常关闭 (user: %d): %v", w.userID, err)
+			}
+			return
+		}
+		w.handle(raw)
+	}
+}
+
+// ping 定期发送 ping，保持连接并检测断线
+func (w *wsClient) ping(ctx context.Context) {
+	ticker := time.NewTicker(wsPingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			w.writeMu.Lock()
+			err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+			w.writeMu.Unlock()
+			if err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// handle 处理一条客户端消息。生成类命令和停止在单独的 goroutine 中执行，事件由订阅 goroutine 推送
+func (w *wsClient) handle(raw []byte) {
+	var message wsClientMessage
+	if err := json.Unmarshal(raw, &message); err != nil {
+		w.sendError("", http.StatusBadRequest, errors.New("消息格式错误: "+err.Error()))
+		return
+	}
+	if message.SessionID == "" {
+		w.sendError("", http.StatusBadRequest, errors.New("缺少 session_id"))
+		return
+	}
+
+	switch message.Type {
+	case "send", "regenerate", "continue":
+		// 准备任务时会检索文件片段和笔记，不阻塞连接上其他会话的消息
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.startGeneration(message, raw)
+		}()
+
+	case "stop":
+		// 停止会等待已生成的部分保存，不阻塞其他消息；结束事件由订阅推送
+		sessionID := message.SessionID
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			if _, err := LLM_Chat_Service.GlobalGenerationRegistry.Stop(sessionID, w.userID); err != nil {
+				w.sendError(sessionID, http.StatusNotFound, err)
+			}
+		}()
+
+	case "typing":
+		if !w.ownsSession(message.SessionID) {
+			return
+		}
+		data, _ := json.Marshal(gin.H{"typing": message.Typing})
+		for _, other := range chatHub.others(w) {
+			_ = other.write(wsServerMessage{SessionID: message.SessionID, Event: "typing", Data: data})
+		}
+
+	case "resume":
+		if !w.ownsSession(message.SessionID) {
+			return
+		}
+		events, err := LLM_Chat_Service.GlobalCacheService.ReadStreamEvents(w.ctx, message.SessionID, message.LastEventID, 0)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, LLM_Chat_Service.ErrInvalidEventID) {
+				status = http.StatusBadRequest
+			}
+			w.sendError(message.SessionID, status, errors.New("读取缓存的响应失败: "+err.Error()))
+			return
+		}
+		if len(events) == 0 && !LLM_Chat_Service.GlobalGenerationRegistry.Running(message.SessionID) {
+			w.sendError(message.SessionID, http.StatusNotFound, errors.New("无缓存的响应"))
+			return
+		}
+		w.follow(message.SessionID, message.LastEventID)
+
+	default:
+		w.sendError(message.SessionID, http.StatusBadRequest, errors.New("不支持的消息类型: "+message.Type))
+	}
+}
+
+// startGeneration 处理生成类命令：检查限流、准备并提交任务，然后订阅会话的事件
+func (w *wsClient) startGeneration(message wsClientMessage, raw []byte) {
+	// 生成类命令与 HTTP 接口一样受限流约束
+	if _, err := checkRateLimit(w.userID); err != nil {
+		w.sendError(message.SessionID, http.StatusTooManyRequests, err)
+		return
+	}
+
+	switch message.Type {
+	case "send":
+		var request streamMessageRequest
+		if err := json.Unmarshal(raw, &request); err != nil {
+			w.sendError(message.SessionID, http.StatusBadRequest, errors.New("参数错误: "+err.Error()))
+			return
+		}
+		if err := binding.Validator.ValidateStruct(&request); err != nil {
+			w.sendError(message.SessionID, http.StatusBadRequest, errors.New("参数错误: "+err.Error()))
+			return
+		}
+		job, preamble, status, err := prepareSendJob(w.ctx, w.userID, &request)
+		if err != nil {
+			w.sendError(message.SessionID, status, err)
+			return
+		}
+		w.submit(job, preamble...)
+
+	case "regenerate":
+		var request regenerateRequest
+		if err := json.Unmarshal(raw, &request); err != nil {
+			w.sendError(message.SessionID, http.StatusBadRequest, errors.New("参数错误: "+err.Error()))
+			return
+		}
+		job, status, err := prepareRegenerateJob(w.userID, message.SessionID, &request)
+		if err != nil {
+			w.sendError(message.SessionID, status, err)
+			return
+		}
+		w.submit(job)
+
+	case "continue":
+		var request continueRequest
+		if err := json.Unmarshal(raw, &request); err != nil {
+			w.sendError(message.SessionID, http.StatusBadRequest, errors.New("参数错误: "+err.Error()))
+			return
+		}
+		job, status, err := prepareContinueJob(w.userID, message.SessionID, &request)
+		if err != nil {
+			w.sendError(message.SessionID, status, err)
+			return
+		}
+		w.submit(job)
+	}
+}
+
+// ownsSession 检查会话属于当前用户，否则推送错误
+func (w *wsClient) ownsSession(sessionID string) bool {
+	if _, err := LLM_Chat_Service.GetSessionManager().GetChatService().GetChatSession(sessionID, w.userID); err != nil {
+		w.sendError(sessionID, sessionErrorStatus(err), errors.New("获取会话失败: "+err.Error()))
+		return false
+	}
+	return true
+}
+
+// submit 提交生成任务并订阅会话的事件
+func (w *wsClient) submit(job *LLM_Chat_Service.GenerationJob, preamble ...LLM_Chat_Service.StreamEvent) {
+	if err := LLM_Chat_Service.GetGenerationWorkerPool().Submit(job, preamble...); err != nil {
+		w.sendError(job.SessionID, submitErrorStatus(err), err)
+		return
+	}
+	w.follow(job.SessionID, "")
+}
+
+// follow 推送会话从 lastEventID 之后的事件，替换该会话之前的订阅
+func (w *wsClient) follow(sessionID, lastEventID string) {
+	ctx, cancel := context.WithCancel(w.ctx)
+	follower := &wsFollower{cancel: cancel}
+
+	w.mu.Lock()
+	if previous, exists := w.followers[sessionID]; exists {
+		previous.cancel()
+	}
+	w.followers[sessionID] = follower
+	w.mu.Unlock()
+
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		defer func() {
+			w.mu.Lock()
+			// 只移除自己的订阅（可能已被新的订阅替换）
+			if w.followers[sessionID] == follower {
+				delete(w.followers, sessionID)
+			}
+			w.mu.Unlock()
+			cancel()
+		}()
+
+		followEvents(ctx, sessionID, lastEventID, func(event LLM_Chat_Service.StreamEvent) error {
+			return w.write(wsServerMessage{SessionID: sessionID, ID: event.ID, Event: event.Event, Data: json.RawMessage(event.Data)})
+		}, nil)
+	}()
+}
+
+// sendError 推送命令失败的错误
+func (w *wsClient) sendError(sessionID string, status int, err error) {
+	data, _ := json.Marshal(gin.H{"error": err.Error(), "status": status})
+	_ = w.write(wsServerMessage{SessionID: sessionID, Event: "error", Data: data})
+}
+
+// write 推送一条消息
+func (w *wsClient) write(message wsServerMessage) error {
+	w.writeMu.Lock()
+	defer w.writeMu.Unlock()
+	_ = w.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return w.conn.WriteJSON(message)
+}
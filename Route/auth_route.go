@@ -21,7 +21,7 @@ func AuthRoute() {
 		AllowOrigins:     []string{"http://localhost:3000", "http://localhost:8080", "http://localhost:5173"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept", "X-Requested-With"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    append([]string{"Content-Length"}, LLM_Chat.RateLimitHeaders...),
 		AllowCredentials: true,
 		MaxAge:           120 * time.Hour,
 		AllowOriginFunc: func(origin string) bool {
@@ -40,6 +40,7 @@ func AuthRoute() {
 		api.POST("/register", Auth.Register)
 		api.POST("/login", Auth.Login)
 		api.POST("/logout", Auth.Logout)
+		api.POST("/auth/refresh", Auth.RefreshToken) // 用刷新令牌换取新的访问令牌
 		// ← 管理员专用登录入口
 		api.POST("/admin/login", Auth.RootLogin)
 		// 验证码相关路由
@@ -56,10 +57,16 @@ func AuthRoute() {
 		adminGroup.POST("/users", Auth.RootAddUser)          // 创建用户
 		adminGroup.DELETE("/users/:id", Auth.RootDeleteUser) // 删除用户
 
+		// 单个用户的限流设置，覆盖角色的默认限制
+		adminGroup.GET("/users/:id/rate-limit", LLM_Chat.RootGetUserRateLimit)
+		adminGroup.PUT("/users/:id/rate-limit", LLM_Chat.RootSetUserRateLimit)
+		adminGroup.DELETE("/users/:id/rate-limit", LLM_Chat.RootDeleteUserRateLimit)
+
 		// ← 新增：聊天管理
 		adminGroup.GET("/sessions", LLM_Chat.RootGetAllSessions)                 // 获取所有会话列表
 		adminGroup.GET("/sessions/:session_id", LLM_Chat.RootGetSessionMessages) // 查看会话消息
 		adminGroup.DELETE("/sessions/:session_id", LLM_Chat.RootDeleteSession)   // 删除会话
+		adminGroup.GET("/usage", LLM_Chat.RootGetUsageReport)                    // 各用户的用量和费用
 
 		// ← 新增：笔记管理
 		adminGroup.GET("/notes", Note.RootGetAllNotes)       // 获取所有笔记列表
@@ -75,6 +82,11 @@ func AuthRoute() {
 	{
 		auth.GET("/profile", Auth.GetProfile)
 		auth.POST("/update-password", Auth.UpdatePassword)
+
+		// 已登录的设备
+		auth.GET("/auth/sessions", Auth.ListAuthSessions)
+		auth.DELETE("/auth/sessions/:id", Auth.RevokeAuthSession)
+		auth.DELETE("/auth/sessions", Auth.RevokeOtherAuthSessions) // 注销除当前设备外的所有设备
 		auth.GET("/me", func(c *gin.Context) {
 			// 为前端提供更友好的用户信息端点
 			user, _ := c.Get("user_id")
@@ -90,19 +102,55 @@ func AuthRoute() {
 			auth.GET("/user/apis/:name", LLM_Chat.GetUserAPIByName)
 			auth.PUT("/user/apis/:id", LLM_Chat.UpdateUserAPI)
 			auth.DELETE("/user/apis/:id", LLM_Chat.DeleteUserAPI)
+			auth.POST("/user/apis/:id/test", LLM_Chat.TestUserAPI)
+
+			// 模型路由：多个 API 配置间的回退和负载均衡
+			auth.GET("/user/routes", LLM_Chat.GetModelRoutes)
+			auth.POST("/user/routes", LLM_Chat.CreateModelRoute)
+			auth.PUT("/user/routes/:id", LLM_Chat.UpdateModelRoute)
+			auth.DELETE("/user/routes/:id", LLM_Chat.DeleteModelRoute)
+		}
+
+		// = = = = = 调用 /v1 接口的 API 令牌 = = = = =
+
+		{
+			auth.POST("/tokens", Auth.CreateAPIToken)
+			auth.GET("/tokens", Auth.ListAPITokens)
+			auth.DELETE("/tokens/:id", Auth.DeleteAPIToken)
+		}
+
+		// = = = = = token 用量和费用 = = = = =
+
+		{
+			auth.GET("/usage", LLM_Chat.GetUsage)
+			auth.GET("/usage/sessions/:session_id", LLM_Chat.GetSessionUsage)
+			auth.GET("/usage/limits", LLM_Chat.GetRateLimits) // 当前的限流、配额和用量
 		}
 
 		// = = = = = 聊天相关路由 = = = = =
 
+		// 发起生成的接口受每分钟请求数和每日 token 配额限制；生成都由 worker 池执行，提交任务时占用并发名额
+		rateLimited := LLM_Chat.RateLimitMiddleware()
+
 		chat := auth.Group("/chat")
 		{
-			chat.POST("/message", LLM_Chat.SendMessage)
-			chat.POST("/message/stream", LLM_Chat.SendMessageStream)
+			chat.POST("/message", rateLimited, LLM_Chat.SendMessage)
+			chat.POST("/message/stream", rateLimited, LLM_Chat.SendMessageStream)
 			chat.POST("/session", LLM_Chat.CreateSession)
 			chat.GET("/sessions", LLM_Chat.GetSessions)
 			chat.GET("/sessions/:session_id/messages", LLM_Chat.GetSessionMessages)
 			chat.DELETE("/sessions/:session_id", LLM_Chat.DeleteSession)
+			chat.GET("/sessions/:session_id/settings", LLM_Chat.GetSessionSettings)
+			chat.PUT("/sessions/:session_id/settings", LLM_Chat.UpdateSessionSettings)
+			chat.POST("/sessions/:session_id/messages/:message_id/edit", rateLimited, LLM_Chat.EditMessage)
+			chat.POST("/sessions/:session_id/messages/:message_id/regenerate", rateLimited, LLM_Chat.RegenerateMessage)
+			chat.GET("/sessions/:session_id/messages/:message_id/siblings", LLM_Chat.GetMessageSiblings)
+			chat.PUT("/sessions/:session_id/branch", LLM_Chat.SwitchBranch)
+			chat.POST("/sessions/:session_id/stop", LLM_Chat.StopGeneration)
+			chat.POST("/sessions/:session_id/regenerate", rateLimited, LLM_Chat.RegenerateStream)
+			chat.POST("/sessions/:session_id/continue", rateLimited, LLM_Chat.ContinueStream)
 			chat.GET("/recover", LLM_Chat.RecoverStreamResponse)
+			chat.GET("/ws", LLM_Chat.ChatWebSocket) // WebSocket：一个连接上收发多个会话的消息，生成类命令逐条限流
 		}
 
 		// 人格管理路由
@@ -115,6 +163,7 @@ func AuthRoute() {
 		files := auth.Group("/files")
 		{
 			files.POST("/upload", LLM_Chat.UploadFile())
+			files.GET("/config", LLM_Chat.GetUploadConfig())
 			files.GET("/session/:session_id", LLM_Chat.GetSessionFiles())
 			files.DELETE("/:file_id", LLM_Chat.DeleteFile())
 		}
@@ -189,6 +238,15 @@ func AuthRoute() {
 		c.File("./web/root/admin_notes.html")
 	})
 
+	// OpenAI 兼容网关：使用平台签发的 API 令牌认证，按模型名转发到用户保存的 API 配置。
+	// 转发使用的是用户的 API 配置，与聊天接口受同样的限流、配额和并发限制
+	v1 := r.Group("/v1")
+	v1.Use(Auth.APITokenMiddleware())
+	{
+		v1.POST("/chat/completions", LLM_Chat.ProxyRateLimitMiddleware(), LLM_Chat.ProxyChatCompletions)
+		v1.GET("/models", LLM_Chat.ProxyListModels)
+	}
+
 	// 前端路由 - 支持SPA
 	r.NoRoute(func(c *gin.Context) {
 		// 如果是API请求，返回404
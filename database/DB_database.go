@@ -29,8 +29,19 @@ func InitDB() error {
 		&ChatSession{}, // 新增
 		&ChatMessage{}, // 新增
 		&UploadedFile{},
+		&Blob{},
+		&FileChunk{},
 		&Note{},
+		&NoteChunk{},
 		&SharedSession{},
+		&APIToken{},
+		&AuthSession{},
+		&RefreshToken{},
+		&RevokedToken{},
+		&ProxyUsageLog{},
+		&UsageDaily{},
+		&UserRateLimit{},
+		&ModelRoute{},
 	)
 	if err != nil {
 		return fmt.Errorf("数据库迁移失败:%s", err)
@@ -41,6 +52,11 @@ func InitDB() error {
 		return fmt.Errorf("警告: 修复 chat_sessions 表时间戳失败: %v", err)
 	}
 
+	// 早期按 ID 顺序保存的消息串成一条分支
+	if err := linkChatMessages(DB); err != nil {
+		return fmt.Errorf("警告: 迁移会话消息分支失败: %v", err)
+	}
+
 	log.Println("数据库连接成功")
 	return nil // ✅ 成功返回 nil
 }
@@ -99,3 +115,40 @@ func fixChatSessionTimestamps(db *gorm.DB) error {
 	}
 	return nil
 }
+
+// linkChatMessages 为还没有分支信息的会话（active_leaf_id 为 0 且有消息）按 ID 顺序设置 parent_id，
+// 最后一条消息作为当前分支；摘要消息不在树中
+func linkChatMessages(db *gorm.DB) error {
+	var sessionIDs []string
+	if err := db.Model(&ChatSession{}).
+		Where("active_leaf_id = 0 AND session_id IN (?)",
+			db.Model(&ChatMessage{}).Select("session_id").Where("role <> ?", MessageRoleSummary)).
+		Pluck("session_id", &sessionIDs).Error; err != nil {
+		return err
+	}
+
+	for _, sessionID := range sessionIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			var ids []uint
+			if err := tx.Model(&ChatMessage{}).
+				Where("session_id = ? AND role <> ?", sessionID, MessageRoleSummary).
+				Order("id ASC").
+				Pluck("id", &ids).Error; err != nil {
+				return err
+			}
+			for i := 1; i < len(ids); i++ {
+				if err := tx.Model(&ChatMessage{}).Where("id = ?", ids[i]).Update("parent_id", ids[i-1]).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(&ChatSession{}).Where("session_id = ?", sessionID).Update("active_leaf_id", ids[len(ids)-1]).Error
+		})
+		if err != nil {
+			return fmt.Errorf("会话 %s: %w", sessionID, err)
+		}
+	}
+	if len(sessionIDs) > 0 {
+		log.Printf("已为 %d 个会话建立消息分支", len(sessionIDs))
+	}
+	return nil
+}
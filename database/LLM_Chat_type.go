@@ -13,56 +13,242 @@ type Persona struct {
 
 // StyleConfig 完整配置结构
 type StyleConfig struct {
-	Personas []Persona `yaml:"personas" json:"personas"`
+	Personas   []Persona        `yaml:"personas" json:"personas"`
+	FileUpload FileUploadConfig `yaml:"file_upload" json:"file_upload"`
+	Generation GenerationConfig `yaml:"generation" json:"generation"`
+	Pricing    PricingConfig    `yaml:"pricing" json:"pricing"`
+	RateLimit  RateLimitConfig  `yaml:"rate_limit" json:"rate_limit"`
+	Mail       MailConfig       `yaml:"mail" json:"mail"`
+}
+
+// PricingConfig 模型价格表，用于计算 token 用量的费用
+type PricingConfig struct {
+	Currency string                `yaml:"currency"` // 仅用于展示，如 USD、CNY
+	Models   map[string]ModelPrice `yaml:"models"`   // 模型名 -> 价格，未精确匹配时使用最长的前缀匹配
+}
+
+// ModelPrice 每百万 token 的价格
+type ModelPrice struct {
+	Prompt     float64 `yaml:"prompt"`
+	Completion float64 `yaml:"completion"`
+}
+
+// RateLimitConfig 聊天接口的限流和配额，按角色设置，未配置的角色使用内置的默认值
+type RateLimitConfig struct {
+	Roles map[Role]RateLimits `yaml:"roles"`
+}
+
+// RateLimits 一个用户的限制，0 表示不限制
+type RateLimits struct {
+	RequestsPerMinute int   `yaml:"requests_per_minute" json:"requests_per_minute"` // 每分钟发起生成的次数
+	ConcurrentStreams int   `yaml:"concurrent_streams" json:"concurrent_streams"`   // 同时进行的生成数（所有实例合计）
+	DailyTokens       int64 `yaml:"daily_tokens" json:"daily_tokens"`               // 每天的 token 用量（输入 + 输出）
+}
+
+// UserRateLimit 管理员为单个用户设置的限制，字段为空时使用角色的限制
+type UserRateLimit struct {
+	UserID            uint `gorm:"primaryKey;autoIncrement:false"`
+	RequestsPerMinute *int
+	ConcurrentStreams *int
+	DailyTokens       *int64
+	UpdatedAt         time.Time
+}
+
+// GenerationConfig 后台生成任务配置
+type GenerationConfig struct {
+	Workers              int          `yaml:"workers"`                 // 执行生成的 worker 数
+	QueueSize            int          `yaml:"queue_size"`              // 进程内队列的容量（Redis 队列不限）
+	MaxConcurrentPerUser int          `yaml:"max_concurrent_per_user"` // 每个用户同时排队或执行的生成数上限
+	UserLimits           map[uint]int `yaml:"user_limits"`             // 按用户ID覆盖上限
 }
 
 // FileUploadConfig 文件上传配置结构
 type FileUploadConfig struct {
-	UploadDir         string   `yaml:"upload_dir"`
-	MaxFileSize       int64    `yaml:"max_file_size"`
-	AllowedExtensions []string `yaml:"allowed_extensions"`
+	UploadDir         string   `yaml:"upload_dir" json:"-"`
+	MaxFileSize       int64    `yaml:"max_file_size" json:"max_file_size"`
+	AllowedExtensions []string `yaml:"allowed_extensions" json:"allowed_extensions"`
 }
 
 type UploadedFile struct {
 	gorm.Model
 	SessionID   string `gorm:"index;not null"` // 关联的会话ID
 	FileName    string `gorm:"not null"`       // 原文件名
-	FilePath    string `gorm:"not null"`       // 存储路径
+	FilePath    string `gorm:"not null"`       // 存储路径（早期直接保存在磁盘上的文件）
+	BlobKey     string `gorm:"size:64;index"`  // 文件内容在 BlobStore 中的键（sha256），为空时读取 FilePath
 	FileSize    int64  `gorm:"not null"`       // 文件大小
 	FileType    string `gorm:"not null"`       // 文件类型
 	Content     string `gorm:"type:text"`      // 文件内容（文本文件）
-	IsProcessed bool   `gorm:"default:false"`  // 是否已处理
+	IsProcessed bool   `gorm:"default:false"`  // 是否已完成切块索引
+}
+
+// Blob 按内容寻址保存的文件及其引用计数，引用数归零时删除存储中的内容
+type Blob struct {
+	Hash      string `gorm:"primaryKey;size:64"` // 内容的 sha256
+	Size      int64  `gorm:"not null"`
+	RefCount  int    `gorm:"not null;default:0"` // 引用该内容的上传文件数
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// FileChunk 上传文件的切块及其向量，对话时只检索与问题相关的切块
+type FileChunk struct {
+	ID             uint   `gorm:"primarykey"`
+	FileID         uint   `gorm:"index;not null"`
+	SessionID      string `gorm:"index;not null"`
+	FileName       string `gorm:"size:255"`
+	ChunkIndex     int    `gorm:"not null"`
+	Content        string `gorm:"type:text"`
+	Embedding      []byte `gorm:"type:blob"` // 归一化后的 float32 向量（小端序）
+	EmbeddingModel string `gorm:"size:100"`
+	CreatedAt      time.Time
+}
+
+// 模型提供商类型
+const (
+	ProviderOpenAI    = "openai"    // OpenAI 及兼容接口（DeepSeek、Moonshot 等）
+	ProviderAnthropic = "anthropic" // Anthropic Messages API
+	ProviderOllama    = "ollama"    // 本地 Ollama
+)
+
+// API 配置连通性检查状态，未检查时为空
+const (
+	APIHealthy   = "healthy"
+	APIUnhealthy = "unhealthy"
+)
+
+// 模型路由策略
+const (
+	RoutePolicyFallback     = "fallback"      // 按顺序使用，失败时切换到下一个
+	RoutePolicyRoundRobin   = "round_robin"   // 轮流使用
+	RoutePolicyLeastLatency = "least_latency" // 优先使用延迟最低的
+)
+
+// ModelRoute 模型路由：聊天时把路由名称当作模型名使用，请求按策略分发到多个 API 配置，失败时自动切换。
+// 没有同名路由时，同一模型名的所有 API 配置按创建顺序组成回退列表
+type ModelRoute struct {
+	gorm.Model
+	UserID uint   `gorm:"index;not null"`
+	Name   string `gorm:"size:100;not null"`
+	Policy string `gorm:"size:20;not null;default:'fallback'"`
+	APIIDs []uint `gorm:"type:text;serializer:json"` // 按优先级排列的 API 配置
 }
 
 // UserAPI 用户API配置
 type UserAPI struct {
 	gorm.Model
-	UserID    uint   `gorm:"index;not null"`
-	APIName   string `gorm:"size:100;not null"`
-	APIKey    string `gorm:"size:500;not null"` // 加密存储
-	ModelName string `gorm:"size:100"`
-	BaseURL   string `gorm:"size:500"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
+	UserID         uint   `gorm:"index;not null"`
+	APIName        string `gorm:"size:100;not null"`
+	APIKey         string `gorm:"size:500;not null"` // 加密存储（AES-GCM 密文，KeyID 为空的旧记录是明文）
+	DataKey        string `gorm:"size:255"`          // 主密钥加密后的数据密钥
+	KeyID          string `gorm:"size:32;index"`     // 加密数据密钥使用的主密钥ID
+	ModelName      string `gorm:"size:100"`
+	BaseURL        string `gorm:"size:500"`
+	Provider       string `gorm:"size:20;not null;default:'openai'"` // 模型提供商：openai / anthropic / ollama
+	ContextBudget  int    `gorm:"default:0"`                         // 上下文 token 预算，0 表示使用模型的上下文长度
+	SupportsVision bool   `gorm:"default:false"`                     // 模型是否支持图片输入
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+
+	// 最近一次连通性检查的结果
+	HealthStatus    string `gorm:"size:20;default:''"` // healthy / unhealthy，空表示尚未检查
+	HealthLatencyMs int64
+	HealthError     string `gorm:"size:500"`
+	HealthCheckedAt *time.Time
+}
+
+// ProxyUsageLog OpenAI 兼容接口（/v1）的一次调用记录，token 数取自上游返回的 usage（没有返回时为 0）
+type ProxyUsageLog struct {
+	ID               uint   `gorm:"primarykey"`
+	UserID           uint   `gorm:"index;not null"`
+	TokenID          uint   `gorm:"index"`    // 调用使用的 API 令牌
+	APIID            uint   `gorm:"not null"` // 转发使用的 API 配置
+	Model            string `gorm:"size:100"`
+	Stream           bool
+	StatusCode       int // 上游返回的状态码，请求未到达上游时为 0
+	PromptTokens     int
+	CompletionTokens int
+	TotalTokens      int
+	LatencyMs        int64
+	CreatedAt        time.Time `gorm:"index"`
+}
+
+// UsageDaily 按日期、用户、会话、API 配置和模型汇总的 token 用量，每次记录用量时累加。
+// /v1 接口的调用没有会话，SessionID 为空
+type UsageDaily struct {
+	ID               uint    `gorm:"primarykey"`
+	Date             string  `gorm:"size:10;not null;uniqueIndex:idx_usage_daily_key"` // 2006-01-02（服务器时区）
+	UserID           uint    `gorm:"not null;index;uniqueIndex:idx_usage_daily_key"`
+	SessionID        string  `gorm:"size:100;not null;default:'';uniqueIndex:idx_usage_daily_key"`
+	APIID            uint    `gorm:"not null;default:0;uniqueIndex:idx_usage_daily_key"`
+	Model            string  `gorm:"size:100;not null;default:'';uniqueIndex:idx_usage_daily_key"`
+	Requests         int64   `gorm:"not null;default:0"`
+	PromptTokens     int64   `gorm:"not null;default:0"`
+	CompletionTokens int64   `gorm:"not null;default:0"`
+	Cost             float64 `gorm:"not null;default:0"`
+	UpdatedAt        time.Time
+}
+
+// GenerationParams 模型生成参数，字段为 nil 时使用提供商的默认值
+type GenerationParams struct {
+	Temperature      *float32 `json:"temperature,omitempty" binding:"omitempty,min=0,max=2"`
+	TopP             *float32 `json:"top_p,omitempty" binding:"omitempty,min=0,max=1"`
+	MaxTokens        *int     `json:"max_tokens,omitempty" binding:"omitempty,min=1"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty" binding:"omitempty,min=-2,max=2"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty" binding:"omitempty,min=-2,max=2"`
+	Stop             []string `json:"stop,omitempty" gorm:"type:text;serializer:json" binding:"omitempty,max=4"`
+	Seed             *int     `json:"seed,omitempty"`
 }
 
 // ChatSession 聊天会话
 type ChatSession struct {
-	SessionID    string    `gorm:"primaryKey;size:50"`
-	UserID       uint      `gorm:"index;not null"`
-	Title        string    `gorm:"size:200"`
-	ModelName    string    `gorm:"not null;default:''"`
-	MessageCount int       `gorm:"default:0"`
-	CreatedAt    time.Time `gorm:"autoCreateTime"`
-	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
+	SessionID        string           `gorm:"primaryKey;size:50"`
+	UserID           uint             `gorm:"index;not null"`
+	Title            string           `gorm:"size:200"`
+	ModelName        string           `gorm:"not null;default:''"`
+	MessageCount     int              `gorm:"default:0"`
+	GenerationParams GenerationParams `gorm:"embedded;embeddedPrefix:gen_"` // 会话级生成参数
+	SummaryEnabled   bool             `gorm:"default:false"`                // 是否开启滚动摘要
+	ActiveLeafID     uint             `gorm:"default:0"`                    // 当前分支最后一条消息的ID，新消息接在它后面
+	CreatedAt        time.Time        `gorm:"autoCreateTime"`
+	UpdatedAt        time.Time        `gorm:"autoUpdateTime"`
 }
 
+// MessageRoleSummary 滚动摘要消息的角色，只用于构造上下文，不在消息列表中展示
+const MessageRoleSummary = "summary"
+
 // ChatMessage 聊天消息
 type ChatMessage struct {
 	gorm.Model
-	SessionID string `gorm:"index;not null;size:50"`
-	Role      string `gorm:"size:20;not null"` // user, assistant, system
-	Content   string `gorm:"type:text"`
+	SessionID         string `gorm:"index;not null;size:50"`
+	ParentID          uint   `gorm:"index;default:0"`  // 上一条消息的ID，会话中的消息组成一棵树，编辑或重新生成时产生分支
+	Role              string `gorm:"size:20;not null"` // user, assistant, system, tool, summary
+	Content           string `gorm:"type:text"`
+	SummarizedUntilID uint   `gorm:"default:0"` // summary 消息覆盖到的最后一条消息ID
+	ToolCalls         string `gorm:"type:text"` // assistant 消息请求的工具调用（JSON）
+	ToolCallID        string `gorm:"size:100"`  // tool 消息对应的工具调用ID
+	// 多部分内容（文本 + 图片），为空时只有 Content；图片只保存文件引用，发送时再读取
+	Parts []MessagePart `gorm:"type:text;serializer:json"`
+	// 实际生成该回复的 API 配置和模型（模型路由切换后可能与会话的默认配置不同），旧消息为空
+	APIID       uint   `gorm:"default:0"`
+	ServedModel string `gorm:"size:100"`
+	// 生成该回复消耗的 token（含工具调用的中间请求）和按价格表计算的费用
+	PromptTokens     int
+	CompletionTokens int
+	Cost             float64
+}
+
+// 消息内容部分类型
+const (
+	MessagePartText  = "text"
+	MessagePartImage = "image"
+)
+
+// MessagePart 消息中的一个内容部分
+type MessagePart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	FileID   uint   `json:"file_id,omitempty"`   // 图片对应的上传文件
+	MimeType string `json:"mime_type,omitempty"` // 图片类型
 }
 
 type SharedSession struct {
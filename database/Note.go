@@ -15,6 +15,19 @@ type Note struct {
 	IsPublic bool     `gorm:"default:false" json:"is_public"`
 }
 
+// NoteChunk 笔记切块及其向量，用于检索增强对话
+type NoteChunk struct {
+	ID             uint   `gorm:"primarykey"`
+	UserID         uint   `gorm:"index;not null"`
+	NoteID         uint   `gorm:"index;not null"`
+	ChunkIndex     int    `gorm:"not null"`
+	Title          string `gorm:"size:255"`  // 切块时的笔记标题，用于引用
+	Content        string `gorm:"type:text"` // 切块原文
+	Embedding      []byte `gorm:"type:blob"` // 归一化后的 float32 向量（小端序）
+	EmbeddingModel string `gorm:"size:100;index"`
+	CreatedAt      time.Time
+}
+
 // ========== ROOT ==========
 
 // AdminNoteResponse 管理员查看的笔记信息（包含用户信息）
@@ -54,15 +54,23 @@ type UserResponse struct {
 
 // LoginResponse 登录响应结构体
 type LoginResponse struct {
-	Message string       `json:"message"`
-	Token   string       `json:"token"`
-	User    UserResponse `json:"user"`
+	Message      string       `json:"message"`
+	Token        string       `json:"token"`         // 访问令牌
+	RefreshToken string       `json:"refresh_token"` // 刷新令牌，用于换取新的访问令牌
+	ExpiresIn    int64        `json:"expires_in"`    // 访问令牌的有效秒数
+	User         UserResponse `json:"user"`
+}
+
+// RefreshTokenRequest 刷新令牌请求，未提供时读取 Cookie 中的刷新令牌
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
 }
 
 // SendCodeRequest 发送验证码请求
 type SendCodeRequest struct {
 	Username string `json:"username" binding:"required"`
 	CodeType string `json:"code_type" binding:"required,oneof=password_reset"`
+	Language string `json:"language"` // 邮件的语言（如 zh、en），为空时按 Accept-Language
 }
 
 // VerifyCodeRequest 验证验证码请求
@@ -129,3 +137,69 @@ type AdminUserResponse struct {
 	LastLogin time.Time `json:"last_login"`
 	CreatedAt time.Time `json:"created_at"`
 }
+
+// APIToken 平台签发的 API 令牌，供脚本和 IDE 插件调用 OpenAI 兼容接口（/v1）；只保存令牌的哈希
+type APIToken struct {
+	gorm.Model
+	UserID     uint   `gorm:"index;not null"`
+	Name       string `gorm:"size:100;not null"`
+	TokenHash  string `gorm:"uniqueIndex;size:64;not null"` // 令牌的 sha256
+	Prefix     string `gorm:"size:16"`                      // 令牌开头几位，用于在列表中辨认
+	LastUsedAt *time.Time
+}
+
+// APITokenCreateRequest 创建 API 令牌请求
+type APITokenCreateRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// AuthSession 一次登录（一个设备）。刷新令牌轮换时沿用同一个会话，吊销会话后其访问令牌和刷新令牌全部失效
+type AuthSession struct {
+	ID              string `gorm:"primaryKey;size:32"` // 随机ID，写入访问令牌的 sid
+	UserID          uint   `gorm:"index;not null"`
+	UserAgent       string `gorm:"size:255"`
+	IP              string `gorm:"size:64"`
+	AccessJTI       string `gorm:"size:32"` // 最近签发的访问令牌，吊销会话时加入吊销名单
+	AccessExpiresAt time.Time
+	ExpiresAt       time.Time `gorm:"index"` // 刷新令牌的有效期，每次轮换时顺延
+	LastUsedAt      time.Time
+	RevokedAt       *time.Time
+	CreatedAt       time.Time
+}
+
+// RefreshToken 刷新令牌，只保存哈希。每次刷新签发新令牌，旧令牌标记为已使用；
+// 已使用的令牌在宽限期后再次出现视为泄露，吊销整个会话
+type RefreshToken struct {
+	ID        uint      `gorm:"primaryKey"`
+	SessionID string    `gorm:"size:32;index;not null"`
+	UserID    uint      `gorm:"index;not null"`
+	TokenHash string    `gorm:"uniqueIndex;size:64;not null"` // 令牌的 sha256
+	ExpiresAt time.Time `gorm:"index"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// RevokedToken 吊销的访问令牌（jti）。Redis 可用时同时写入 Redis 并优先查询 Redis，过期后清理
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey;size:32"`
+	UserID    uint      `gorm:"index"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// MailConfig 验证码等邮件的发送队列和模板（style.yaml 的 mail），SMTP 账号在 .env 中配置
+type MailConfig struct {
+	AppName         string        `yaml:"app_name"`         // 邮件中的平台名称
+	DefaultLanguage string        `yaml:"default_language"` // 未指定语言或没有对应语言的模板时使用
+	Workers         int           `yaml:"workers"`
+	QueueSize       int           `yaml:"queue_size"`
+	MaxAttempts     int           `yaml:"max_attempts"` // 临时错误时的最多尝试次数
+	RetryDelay      time.Duration `yaml:"retry_delay"`  // 第一次重试前的等待时间，之后每次翻倍
+	// Templates 验证码类型 -> 语言 -> 模板，覆盖或补充内置模板
+	Templates map[string]map[string]MailTemplate `yaml:"templates"`
+}
+
+// MailTemplate 邮件模板（text/template），可用 {{.AppName}} {{.Username}} {{.Code}} {{.ExpiresInMinutes}}
+type MailTemplate struct {
+	Subject string `yaml:"subject"`
+	Body    string `yaml:"body"`
+}
@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"platfrom/Config"
 	"platfrom/Route"
 	"platfrom/database"
 	"platfrom/service/Auth"
 	"platfrom/service/LLM_Chat"
+	"platfrom/service/Mail"
 	"platfrom/service/Note"
+	"platfrom/service/RAG"
 )
 
 func main() {
@@ -46,23 +50,111 @@ func main() {
 	}
 	Auth.GlobalUserService.StartCleanupTask()
 
+	// 验证码邮件在后台发送，发送方式见 .env 的 MAILER
+	mailConfig, err := Mail.LoadMailConfig("style.yaml")
+	if err != nil {
+		log.Printf("加载邮件配置失败:%s", err)
+		os.Exit(1)
+	}
+	mailer, err := newMailer()
+	if err != nil {
+		log.Printf("初始化邮件发送失败:%s", err)
+		os.Exit(1)
+	}
+	if _, err := Mail.NewMailService(mailer, mailConfig); err != nil {
+		log.Printf("Failed to initialize GlobalMailService: %v", err)
+		os.Exit(1)
+	}
+
+	// 登录令牌：Redis 不可用时吊销名单只查数据库
+	_, _ = Auth.NewTokenService(database.DB, database.GetRedis())
+	if Auth.GlobalTokenService == nil {
+		log.Printf("Failed to initialize GlobalTokenService")
+		os.Exit(1)
+	}
+	Auth.GlobalTokenService.StartCleanupTask()
+
+	if _, err := LLM_Chat.InitAPIKeyCipher(Config.Cfg.APIKeyMasterKeys, Config.Cfg.APIKeyActiveKeyID, Config.Cfg.SecretKey); err != nil {
+		log.Printf("初始化API密钥加密失败: %v", err)
+		os.Exit(1)
+	}
 	_, _ = LLM_Chat.NewUserAPIService(database.DB)
 	if LLM_Chat.GlobalUserAPIService == nil {
 		log.Printf("Failed to initialize GlobalUserAPIService")
 		os.Exit(1)
 	}
 
+	// go run . rotate-api-keys：用当前主密钥重新加密所有 API 密钥后退出
+	if len(os.Args) > 1 && os.Args[1] == "rotate-api-keys" {
+		count, err := LLM_Chat.GlobalUserAPIService.RotateAPIKeys()
+		if err != nil {
+			log.Printf("API密钥轮换失败（已处理 %d 条）: %v", count, err)
+			os.Exit(1)
+		}
+		log.Printf("API密钥轮换完成: %d 条", count)
+		return
+	}
+	// 定期检查API配置的连通性
+	LLM_Chat.GlobalUserAPIService.StartHealthCheckTask()
+
+	_, _ = Auth.NewAPITokenService(database.DB)
+	if Auth.GlobalAPITokenService == nil {
+		log.Printf("Failed to initialize GlobalAPITokenService")
+		os.Exit(1)
+	}
+
+	_, _ = LLM_Chat.NewProxyService(database.DB, LLM_Chat.GlobalUserAPIService)
+	if LLM_Chat.GlobalProxyService == nil {
+		log.Printf("Failed to initialize GlobalProxyService")
+		os.Exit(1)
+	}
+
+	// 用量费用按 style.yaml 的 pricing 价格表计算
+	pricingConfig, err := LLM_Chat.LoadPricingConfig("style.yaml")
+	if err != nil {
+		log.Printf("加载价格表失败:%s", err)
+		os.Exit(1)
+	}
+	_, _ = LLM_Chat.NewUsageService(database.DB, pricingConfig)
+	if LLM_Chat.GlobalUsageService == nil {
+		log.Printf("Failed to initialize GlobalUsageService")
+		os.Exit(1)
+	}
+
+	// 聊天接口的限流和配额按 style.yaml 的 rate_limit 配置，Redis 不可用时在进程内计数
+	rateLimitConfig, err := LLM_Chat.LoadRateLimitConfig("style.yaml")
+	if err != nil {
+		log.Printf("加载限流配置失败:%s", err)
+		os.Exit(1)
+	}
+	if _, err := LLM_Chat.NewRateLimiter(database.DB, database.GetRedis(), rateLimitConfig); err != nil {
+		log.Printf("Failed to initialize GlobalRateLimiter: %v", err)
+		os.Exit(1)
+	}
+
 	_, _ = LLM_Chat.NewChatService(database.DB)
 	if LLM_Chat.GlobalChatService == nil {
 		log.Printf("Failed to initialize GlobalChatService")
 		os.Exit(1)
 	}
 
-	_, _ = LLM_Chat.NewFileService(database.DB)
+	// 上传策略（目录、大小上限、允许的扩展名）统一在 style.yaml 的 file_upload 中配置
+	uploadConfig, err := LLM_Chat.LoadFileUploadConfig("style.yaml")
+	if err != nil {
+		log.Printf("加载上传配置失败:%s", err)
+		os.Exit(1)
+	}
+	blobStore, err := newBlobStore(uploadConfig)
+	if err != nil {
+		log.Printf("初始化文件存储失败:%s", err)
+		os.Exit(1)
+	}
+	_, _ = LLM_Chat.NewFileService(database.DB, uploadConfig, blobStore)
 	if LLM_Chat.GlobalFileService == nil {
 		log.Printf("Failed to initialize GlobalFileService")
 		os.Exit(1)
 	}
+	LLM_Chat.GlobalFileService.StartBlobCleanupTask()
 
 	// 初始化人格配置
 	personaConfigs, err := LLM_Chat.LoadPersonaConfigs("style.yaml")
@@ -88,12 +180,92 @@ func main() {
 	}
 	LLM_Chat.InitSessionManager(LLM_Chat.GlobalChatService, LLM_Chat.GlobalCacheService, LLM_Chat.GlobalUserAPIService, LLM_Chat.GlobalPersonaManager)
 
+	// 流式生成在后台 worker 中执行，Redis 可用时任务队列放在 Redis 中
+	generationConfig, err := LLM_Chat.LoadGenerationConfig("style.yaml")
+	if err != nil {
+		log.Printf("加载生成配置失败:%s", err)
+		os.Exit(1)
+	}
+	LLM_Chat.InitGenerationWorkerPool(generationConfig, LLM_Chat.NewJobQueue(database.GetRedis(), generationConfig.QueueSize))
+
 	_ = Note.NewNoteService()
 	if Note.GlobalNoteService == nil {
 		log.Fatal("Failed to initialize GlobalNoteService")
 	}
 
+	// 初始化笔记和文件检索（未配置向量接口时使用本地哈希向量）
+	var embedder RAG.EmbedderInterface
+	if Config.Cfg.EmbeddingAPIKey != "" {
+		embedder = RAG.NewOpenAIEmbedder(Config.Cfg.EmbeddingAPIKey, Config.Cfg.EmbeddingBaseURL, Config.Cfg.EmbeddingModel)
+	} else {
+		embedder = RAG.NewHashEmbedder(0)
+	}
+	if _, err := RAG.NewRAGService(database.DB, embedder); err != nil {
+		log.Printf("Failed to initialize GlobalRAGService: %v", err)
+		os.Exit(1)
+	}
+	Note.GlobalNoteIndexer = RAG.GlobalRAGService
+	LLM_Chat.GlobalFileIndexer = RAG.GlobalRAGService
+	// 后台为已有笔记补建索引
+	go func() {
+		count, err := RAG.GlobalRAGService.SyncAll(context.Background())
+		if err != nil {
+			log.Printf("笔记索引同步失败: %v", err)
+			return
+		}
+		if count > 0 {
+			log.Printf("笔记索引同步完成: %d 篇", count)
+		}
+	}()
+
+	// 注册内置工具（依赖笔记服务和文件服务）
+	if err := LLM_Chat.RegisterBuiltinTools(LLM_Chat.GlobalToolRegistry, Note.GlobalNoteService, LLM_Chat.GlobalFileService); err != nil {
+		log.Printf("注册内置工具失败: %v", err)
+		os.Exit(1)
+	}
+
 	// 启动路由
 	log.Println("服务器启动中...")
 	Route.AuthRoute()
 }
+
+// newBlobStore 按配置创建上传文件的存储后端
+func newBlobStore(uploadConfig database.FileUploadConfig) (LLM_Chat.BlobStoreInterface, error) {
+	switch Config.Cfg.BlobStore {
+	case "s3":
+		return LLM_Chat.NewS3BlobStore(LLM_Chat.S3Config{
+			Endpoint:  Config.Cfg.S3Endpoint,
+			Region:    Config.Cfg.S3Region,
+			Bucket:    Config.Cfg.S3Bucket,
+			AccessKey: Config.Cfg.S3AccessKey,
+			SecretKey: Config.Cfg.S3SecretKey,
+			Prefix:    Config.Cfg.S3Prefix,
+			PathStyle: Config.Cfg.S3UsePathStyle,
+		})
+	case "", "local":
+		return LLM_Chat.NewLocalBlobStore(filepath.Join(uploadConfig.UploadDir, "blobs"))
+	default:
+		return nil, fmt.Errorf("不支持的文件存储: %s", Config.Cfg.BlobStore)
+	}
+}
+
+// newMailer 按配置创建邮件发送后端
+func newMailer() (Mail.Mailer, error) {
+	switch Config.Cfg.Mailer {
+	case "smtp":
+		return Mail.NewSMTPMailer(Mail.SMTPConfig{
+			Host:     Config.Cfg.SMTPHost,
+			Port:     Config.Cfg.SMTPPort,
+			Username: Config.Cfg.SMTPUsername,
+			Password: Config.Cfg.SMTPPassword,
+			From:     Config.Cfg.MailFrom,
+			Security: Config.Cfg.SMTPSecurity,
+		})
+	case "file":
+		return Mail.NewFileMailer(Config.Cfg.MailDir, Config.Cfg.MailFrom)
+	case "", "console":
+		return Mail.NewConsoleMailer(nil), nil
+	default:
+		return nil, fmt.Errorf("不支持的邮件发送方式: %s", Config.Cfg.Mailer)
+	}
+}
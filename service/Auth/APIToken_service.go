@@ -0,0 +1,110 @@
+package Auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gorm.io/gorm"
+	"platfrom/database"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix 平台签发的令牌前缀，便于与上游提供商的密钥区分
+const apiTokenPrefix = "sk-plat-"
+
+// ErrInvalidAPIToken 令牌不存在或已删除
+var ErrInvalidAPIToken = errors.New("API 令牌无效")
+
+// GlobalAPITokenService 全局 APITokenService 实例
+var GlobalAPITokenService APITokenService
+
+// APITokenService API 令牌服务接口
+type APITokenService interface {
+	// CreateToken 创建令牌，明文只在创建时返回一次
+	CreateToken(userID uint, name string) (*database.APIToken, string, error)
+	ListTokens(userID uint) ([]database.APIToken, error)
+	DeleteToken(userID, tokenID uint) error
+	// Authenticate 校验令牌并更新最后使用时间
+	Authenticate(token string) (*database.APIToken, error)
+}
+
+type apiTokenService struct {
+	db *gorm.DB
+}
+
+func NewAPITokenService(db *gorm.DB) (APITokenService, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接不能为空")
+	}
+
+	service := &apiTokenService{db}
+	GlobalAPITokenService = service
+	return service, nil
+}
+
+// hashToken 令牌的 sha256（十六进制），API 令牌和刷新令牌只保存哈希
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateToken 创建令牌
+func (s *apiTokenService) CreateToken(userID uint, name string) (*database.APIToken, string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", err
+	}
+	token := apiTokenPrefix + hex.EncodeToString(buf)
+
+	record := &database.APIToken{
+		UserID:    userID,
+		Name:      name,
+		TokenHash: hashToken(token),
+		Prefix:    token[:len(apiTokenPrefix)+4],
+	}
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, "", err
+	}
+	return record, token, nil
+}
+
+// ListTokens 获取用户的令牌列表
+func (s *apiTokenService) ListTokens(userID uint) ([]database.APIToken, error) {
+	var tokens []database.APIToken
+	err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&tokens).Error
+	return tokens, err
+}
+
+// DeleteToken 删除用户自己的令牌，删除后立即失效
+func (s *apiTokenService) DeleteToken(userID, tokenID uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", tokenID, userID).Delete(&database.APIToken{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("令牌不存在")
+	}
+	return nil
+}
+
+// Authenticate 校验令牌
+func (s *apiTokenService) Authenticate(token string) (*database.APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	var record database.APIToken
+	if err := s.db.Where("token_hash = ?", hashToken(token)).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIToken
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	record.LastUsedAt = &now
+	_ = s.db.Model(&record).UpdateColumn("last_used_at", now).Error
+	return &record, nil
+}
@@ -0,0 +1,524 @@
+package Auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"platfrom/Config"
+	"platfrom/database"
+	"time"
+)
+
+// 登录签发短期的访问令牌（JWT）和长期的刷新令牌。每次登录是一个 AuthSession，
+// 刷新时轮换刷新令牌并吊销上一个访问令牌，因此一个会话同时只有一个有效的访问令牌；
+// 吊销会话时把它加入 jti 吊销名单（Redis，不可用时查数据库），AuthMiddleware 据此拒绝
+
+const (
+	defaultAccessTokenExpiry  = 15 * time.Minute
+	defaultRefreshTokenExpiry = 30 * 24 * time.Hour
+
+	// refreshReuseGrace 刷新令牌轮换后的宽限期：并发的请求可能携带同一个刷新令牌，
+	// 宽限期内再次使用只签发访问令牌，超过宽限期视为令牌泄露
+	refreshReuseGrace = 30 * time.Second
+	// revokedSessionRetention 已吊销的会话保留的时间，之后由清理任务删除
+	revokedSessionRetention = 7 * 24 * time.Hour
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("刷新令牌无效或已过期")
+	ErrRefreshTokenReused  = errors.New("刷新令牌已被使用，该登录已被吊销，请重新登录")
+	ErrTokenRevoked        = errors.New("令牌已被吊销")
+	ErrAuthSessionNotFound = errors.New("登录会话不存在")
+)
+
+// GlobalTokenService 全局 TokenService 实例
+var GlobalTokenService TokenService
+
+// TokenPair 一次签发的令牌
+type TokenPair struct {
+	SessionID        string
+	AccessToken      string
+	AccessExpiresAt  time.Time
+	RefreshToken     string // 宽限期内重复刷新时为空，客户端继续使用已有的刷新令牌
+	RefreshExpiresAt time.Time
+}
+
+// TokenService 登录令牌服务接口
+type TokenService interface {
+	// IssueTokens 登录时创建会话并签发令牌
+	IssueTokens(user *database.User, userAgent, ip string) (*TokenPair, error)
+	// Refresh 用刷新令牌换取新的访问令牌和刷新令牌
+	Refresh(refreshToken, userAgent, ip string) (*TokenPair, error)
+	// Authenticate 校验访问令牌并检查吊销名单
+	Authenticate(accessToken string) (*Claims, error)
+	// Logout 吊销访问令牌或刷新令牌所属的会话，令牌无效时忽略
+	Logout(accessToken, refreshToken string) error
+
+	ListSessions(userID uint) ([]database.AuthSession, error)
+	RevokeSession(userID uint, sessionID string) error
+	// RevokeUserSessions 吊销用户的所有会话（exceptSessionID 除外），返回吊销的数量
+	RevokeUserSessions(userID uint, exceptSessionID string) (int64, error)
+
+	// StartCleanupTask 定期清理过期的令牌、会话和吊销记录
+	StartCleanupTask()
+}
+
+type tokenService struct {
+	db          *gorm.DB
+	redisClient *redis.Client // 为 nil 时吊销名单只查数据库
+}
+
+func NewTokenService(db *gorm.DB, client *redis.Client) (TokenService, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接不能为空")
+	}
+
+	service := &tokenService{db: db, redisClient: client}
+	GlobalTokenService = service
+	return service, nil
+}
+
+func accessTokenExpiry() time.Duration {
+	if Config.Cfg.TokenExpiry > 0 {
+		return time.Duration(Config.Cfg.TokenExpiry) * time.Minute
+	}
+	return defaultAccessTokenExpiry
+}
+
+func refreshTokenExpiry() time.Duration {
+	if Config.Cfg.RefreshTokenExpiry > 0 {
+		return time.Duration(Config.Cfg.RefreshTokenExpiry) * 24 * time.Hour
+	}
+	return defaultRefreshTokenExpiry
+}
+
+// randomHex n 字节随机数的十六进制
+func randomHex(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+func revokedTokenKey(jti string) string {
+	return "revoked_jti:" + jti
+}
+
+// truncate 截断到数据库列的长度
+func truncate(value string, size int) string {
+	if len(value) > size {
+		return value[:size]
+	}
+	return value
+}
+
+// signAccessToken 为会话签发新的访问令牌，记录在会话上（调用方负责保存会话）
+func signAccessToken(user *database.User, session *database.AuthSession, pair *TokenPair) error {
+	jti, err := randomHex(16)
+	if err != nil {
+		return err
+	}
+	expiresAt := time.Now().Add(accessTokenExpiry())
+	token, err := generateAccessToken(user.ID, user.Username, string(user.Role), session.ID, jti, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	session.AccessJTI = jti
+	session.AccessExpiresAt = expiresAt
+	pair.SessionID = session.ID
+	pair.AccessToken = token
+	pair.AccessExpiresAt = expiresAt
+	return nil
+}
+
+// newRefreshToken 生成刷新令牌，返回明文和只含哈希的记录
+func newRefreshToken(session *database.AuthSession) (string, *database.RefreshToken, error) {
+	token, err := randomHex(32)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &database.RefreshToken{
+		SessionID: session.ID,
+		UserID:    session.UserID,
+		TokenHash: hashToken(token),
+		ExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// IssueTokens 创建会话并签发令牌
+func (s *tokenService) IssueTokens(user *database.User, userAgent, ip string) (*TokenPair, error) {
+	sessionID, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	session := &database.AuthSession{
+		ID:         sessionID,
+		UserID:     user.ID,
+		UserAgent:  truncate(userAgent, 255),
+		IP:         truncate(ip, 64),
+		ExpiresAt:  now.Add(refreshTokenExpiry()),
+		LastUsedAt: now,
+	}
+
+	pair := &TokenPair{}
+	if err := signAccessToken(user, session, pair); err != nil {
+		return nil, fmt.Errorf("生成访问令牌失败: %w", err)
+	}
+	refreshToken, record, err := newRefreshToken(session)
+	if err != nil {
+		return nil, fmt.Errorf("生成刷新令牌失败: %w", err)
+	}
+	pair.RefreshToken = refreshToken
+	pair.RefreshExpiresAt = session.ExpiresAt
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		return tx.Create(record).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("保存登录会话失败: %w", err)
+	}
+	return pair, nil
+}
+
+// Refresh 轮换刷新令牌：旧令牌标记为已使用，签发新的访问令牌和刷新令牌，并吊销上一个访问令牌。
+// 已使用的令牌在宽限期内再次使用时按会话当前的 jti 重新签发访问令牌，不轮换也不吊销；
+// 超过宽限期则吊销整个会话并返回 ErrRefreshTokenReused
+func (s *tokenService) Refresh(refreshToken, userAgent, ip string) (*TokenPair, error) {
+	var record database.RefreshToken
+	if err := s.db.Where("token_hash = ?", hashToken(refreshToken)).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if now.After(record.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	session, err := s.activeSession(s.db, record.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	var user database.User
+	if err := s.db.First(&user, session.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if record.UsedAt != nil {
+		if now.Sub(*record.UsedAt) > refreshReuseGrace {
+			log.Printf("检测到刷新令牌重复使用，吊销登录会话 (user: %d, session: %s)", session.UserID, session.ID)
+			if err := s.revokeSessions([]database.AuthSession{*session}); err != nil {
+				return nil, err
+			}
+			return nil, ErrRefreshTokenReused
+		}
+		return reissueAccessToken(&user, session)
+	}
+
+	previousJTI, previousExpiresAt := session.AccessJTI, session.AccessExpiresAt
+	pair := &TokenPair{}
+	if err := signAccessToken(&user, session, pair); err != nil {
+		return nil, fmt.Errorf("生成访问令牌失败: %w", err)
+	}
+	session.ExpiresAt = now.Add(refreshTokenExpiry())
+	token, next, err := newRefreshToken(session)
+	if err != nil {
+		return nil, fmt.Errorf("生成刷新令牌失败: %w", err)
+	}
+	pair.RefreshToken = token
+	pair.RefreshExpiresAt = session.ExpiresAt
+
+	updates := map[string]interface{}{
+		"access_jti":        session.AccessJTI,
+		"access_expires_at": session.AccessExpiresAt,
+		"expires_at":        session.ExpiresAt,
+		"last_used_at":      now,
+	}
+	if userAgent != "" {
+		updates["user_agent"] = truncate(userAgent, 255)
+	}
+	if ip != "" {
+		updates["ip"] = truncate(ip, 64)
+	}
+
+	var current *database.AuthSession
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// 只有一个请求能标记成功，其余并发的请求按宽限期处理
+		result := tx.Model(&database.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL", record.ID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// 标记成功的请求已在同一事务中更新了会话，这里读到的是它签发的 jti
+			var err error
+			current, err = s.activeSession(tx, session.ID)
+			return err
+		}
+
+		if err := tx.Create(next).Error; err != nil {
+			return err
+		}
+		// 只更新轮换的列，并要求会话未被吊销，避免覆盖期间提交的吊销
+		result = tx.Model(&database.AuthSession{}).
+			Where("id = ? AND revoked_at IS NULL", session.ID).
+			Updates(updates)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidRefreshToken
+		}
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("刷新令牌失败: %w", err)
+	}
+	if current != nil {
+		return reissueAccessToken(&user, current)
+	}
+
+	if err := s.revokeJTI(session.UserID, previousJTI, previousExpiresAt); err != nil {
+		log.Printf("吊销上一个访问令牌失败 (session: %s): %v", session.ID, err)
+	}
+	return pair, nil
+}
+
+// activeSession 查询未吊销的会话，不存在或已吊销时返回 ErrInvalidRefreshToken
+func (s *tokenService) activeSession(db *gorm.DB, sessionID string) (*database.AuthSession, error) {
+	var session database.AuthSession
+	if err := db.Where("id = ?", sessionID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+	if session.RevokedAt != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return &session, nil
+}
+
+// reissueAccessToken 宽限期内按会话当前的 jti 和有效期重新签发访问令牌。
+// 不轮换 jti，因此不会吊销并发请求刚拿到的令牌，吊销会话时也会一并失效
+func reissueAccessToken(user *database.User, session *database.AuthSession) (*TokenPair, error) {
+	if session.AccessJTI == "" || !session.AccessExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidRefreshToken
+	}
+	token, err := generateAccessToken(user.ID, user.Username, string(user.Role), session.ID, session.AccessJTI, session.AccessExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("生成访问令牌失败: %w", err)
+	}
+	return &TokenPair{
+		SessionID:        session.ID,
+		AccessToken:      token,
+		AccessExpiresAt:  session.AccessExpiresAt,
+		RefreshExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// Authenticate 校验访问令牌并检查吊销名单
+func (s *tokenService) Authenticate(accessToken string) (*Claims, error) {
+	claims, err := ValidateToken(accessToken)
+	if err != nil {
+		return nil, err
+	}
+	revoked, err := s.isRevoked(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// isRevoked Redis 可用时查 Redis，出错时回退到数据库
+func (s *tokenService) isRevoked(jti string) (bool, error) {
+	if s.redisClient != nil {
+		count, err := s.redisClient.Exists(context.Background(), revokedTokenKey(jti)).Result()
+		if err == nil {
+			return count > 0, nil
+		}
+		log.Printf("查询 Redis 吊销名单失败，改为查询数据库: %v", err)
+	}
+
+	var count int64
+	if err := s.db.Model(&database.RevokedToken{}).
+		Where("jti = ? AND expires_at > ?", jti, time.Now()).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("查询吊销名单失败: %w", err)
+	}
+	return count > 0, nil
+}
+
+// revokeJTI 把访问令牌加入吊销名单（数据库中始终保存一份，Redis 重启后仍可回退查询），已过期的令牌无需记录
+func (s *tokenService) revokeJTI(userID uint, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if jti == "" || ttl <= 0 {
+		return nil
+	}
+
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&database.RevokedToken{
+		JTI:       jti,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+	}).Error; err != nil {
+		return fmt.Errorf("保存吊销记录失败: %w", err)
+	}
+	if s.redisClient != nil {
+		if err := s.redisClient.Set(context.Background(), revokedTokenKey(jti), userID, ttl).Err(); err != nil {
+			log.Printf("写入 Redis 吊销名单失败: %v", err)
+		}
+	}
+	return nil
+}
+
+// revokeSessions 吊销会话：标记会话、删除刷新令牌、吊销当前的访问令牌
+func (s *tokenService) revokeSessions(sessions []database.AuthSession) error {
+	if len(sessions) == 0 {
+		return nil
+	}
+	ids := make([]string, len(sessions))
+	for i := range sessions {
+		ids[i] = sessions[i].ID
+	}
+
+	var current []database.AuthSession
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.AuthSession{}).
+			Where("id IN ? AND revoked_at IS NULL", ids).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("session_id IN ?", ids).Delete(&database.RefreshToken{}).Error; err != nil {
+			return err
+		}
+		// 调用方读取会话后可能有刷新提交了新的 jti，标记吊销后会话不再轮换，重新读取最终的 jti
+		return tx.Where("id IN ?", ids).Find(&current).Error
+	})
+	if err != nil {
+		return fmt.Errorf("吊销登录会话失败: %w", err)
+	}
+
+	for _, session := range append(sessions, current...) {
+		if err := s.revokeJTI(session.UserID, session.AccessJTI, session.AccessExpiresAt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Logout 吊销访问令牌所属的会话；访问令牌无效（如已过期）时按刷新令牌查找会话
+func (s *tokenService) Logout(accessToken, refreshToken string) error {
+	if accessToken != "" {
+		if claims, err := ValidateToken(accessToken); err == nil {
+			err := s.RevokeSession(claims.UserID, claims.SessionID)
+			if err == nil || !errors.Is(err, ErrAuthSessionNotFound) {
+				return err
+			}
+		}
+	}
+	if refreshToken == "" {
+		return nil
+	}
+
+	var record database.RefreshToken
+	if err := s.db.Where("token_hash = ?", hashToken(refreshToken)).First(&record).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	}
+	err := s.RevokeSession(record.UserID, record.SessionID)
+	if errors.Is(err, ErrAuthSessionNotFound) {
+		return nil
+	}
+	return err
+}
+
+// ListSessions 获取用户未过期、未吊销的会话，最近使用的在前
+func (s *tokenService) ListSessions(userID uint) ([]database.AuthSession, error) {
+	var sessions []database.AuthSession
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession 吊销用户自己的一个会话
+func (s *tokenService) RevokeSession(userID uint, sessionID string) error {
+	var session database.AuthSession
+	if err := s.db.Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrAuthSessionNotFound
+		}
+		return err
+	}
+	return s.revokeSessions([]database.AuthSession{session})
+}
+
+// RevokeUserSessions 吊销用户的所有会话，修改密码、重置密码和删除用户时调用
+func (s *tokenService) RevokeUserSessions(userID uint, exceptSessionID string) (int64, error) {
+	var sessions []database.AuthSession
+	query := s.db.Where("user_id = ? AND revoked_at IS NULL", userID)
+	if exceptSessionID != "" {
+		query = query.Where("id <> ?", exceptSessionID)
+	}
+	if err := query.Find(&sessions).Error; err != nil {
+		return 0, fmt.Errorf("查询登录会话失败: %w", err)
+	}
+	if err := s.revokeSessions(sessions); err != nil {
+		return 0, err
+	}
+	return int64(len(sessions)), nil
+}
+
+// StartCleanupTask 启动过期令牌清理任务
+func (s *tokenService) StartCleanupTask() {
+	go func() {
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			s.cleanup()
+		}
+	}()
+}
+
+// cleanup 删除过期的吊销记录和刷新令牌，以及过期或吊销已久的会话
+func (s *tokenService) cleanup() {
+	now := time.Now()
+	s.db.Where("expires_at < ?", now).Delete(&database.RevokedToken{})
+	s.db.Where("expires_at < ?", now).Delete(&database.RefreshToken{})
+	s.db.Where("expires_at < ? OR revoked_at < ?", now, now.Add(-revokedSessionRetention)).Delete(&database.AuthSession{})
+}
+
+// revokeAllTokens 吊销用户的所有登录，未初始化令牌服务时跳过
+func revokeAllTokens(userID uint) error {
+	if GlobalTokenService == nil {
+		return nil
+	}
+	_, err := GlobalTokenService.RevokeUserSessions(userID, "")
+	return err
+}
@@ -7,6 +7,7 @@ import (
 	"gorm.io/gorm"
 	"math/rand"
 	"platfrom/database"
+	"platfrom/service/Mail"
 	"strings"
 	"time"
 )
@@ -21,7 +22,7 @@ type UserService interface {
 	GetUserByID(id uint) (*database.User, error)
 
 	// SendVerificationCode 验证码相关功能
-	SendVerificationCode(username, codeType string) (*database.VerificationCode, error)
+	SendVerificationCode(username, codeType, language string) (*database.VerificationCode, error) // language 为邮件的语言
 	VerifyCode(username, code, codeType string) (bool, error)
 
 	// ResetPassword 密码相关功能
@@ -104,13 +105,22 @@ func generateRandomCode() string {
 	return fmt.Sprintf("%06d", rng.Intn(1000000))
 }
 
-// SendVerificationCode 发送验证码
-func (s *userService) SendVerificationCode(username, codeType string) (*database.VerificationCode, error) {
+// SendVerificationCode 发送验证码：通过邮件服务发送到用户的邮箱，开发用的 Mailer 下用户没有邮箱时发给占位地址
+func (s *userService) SendVerificationCode(username, codeType, language string) (*database.VerificationCode, error) {
 	// 检查用户是否存在
 	user, err := s.GetUserByUsername(username)
 	if err != nil {
 		return nil, errors.New("用户不存在")
 	}
+	// 只有需要实际投递的邮件服务（SMTP）才要求邮箱，开发用的 Mailer 在用户没有邮箱时发给占位地址
+	mailService := Mail.Default()
+	to := user.Email
+	if to == "" {
+		if mailService.RequiresAddress() {
+			return nil, errors.New("用户未设置邮箱，无法发送验证码")
+		}
+		to = Mail.LocalRecipient
+	}
 
 	// 清理该用户之前的同类型验证码
 	s.db.Where("username = ? AND code_type = ?", username, codeType).Delete(&database.VerificationCode{})
@@ -132,9 +142,16 @@ func (s *userService) SendVerificationCode(username, codeType string) (*database
 		return nil, err
 	}
 
-	// 打印验证码到控制台（生产环境应该发送短信或邮件）
-	fmt.Printf("用户 %s 的验证码: %s (有效期至: %s)\n",
-		user.Username, code, expiresAt.Format("2006-01-02 15:04:05"))
+	// 邮件异步发送，放入队列失败时删除验证码，用户可以重新获取
+	err = mailService.SendCode(to, codeType, language, Mail.CodeData{
+		Username:  user.Username,
+		Code:      code,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		s.db.Delete(verificationCode)
+		return nil, fmt.Errorf("发送验证码邮件失败: %w", err)
+	}
 
 	return verificationCode, nil
 }
@@ -190,8 +207,12 @@ func (s *userService) ResetPassword(username, code, newPassword string) error {
 	err = s.db.Transaction(func(tx *gorm.DB) error {
 		return s.executePasswordResetTransaction(tx, user, username, code, hashedPassword)
 	})
+	if err != nil {
+		return err
+	}
 
-	return err
+	// 密码已修改，之前签发的令牌全部失效
+	return revokeAllTokens(user.ID)
 }
 
 // executePasswordResetTransaction 在事务中执行密码重置相关的数据库操作
@@ -250,7 +271,7 @@ func (s *userService) UpdatePassword(userID uint, oldPassword, newPassword strin
 		return err
 	}
 
-	return nil
+	return revokeAllTokens(user.ID)
 }
 
 // StartCleanupTask 启动验证码清理任务
@@ -350,7 +371,7 @@ func (s *userService) RootDeleteUserByID(userID uint) error {
 		return fmt.Errorf("删除用户失败: %w", err)
 	}
 
-	return nil
+	return revokeAllTokens(user.ID)
 }
 
 // RootAddUser 管理员创建用户
@@ -9,35 +9,38 @@ import (
 )
 
 type Claims struct {
-	UserID   uint   `json:"sub"`
-	Username string `json:"username"`
-	Role     string `json:"role"`
+	UserID    uint   `json:"sub"`
+	Username  string `json:"username"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"` // 所属的登录会话（AuthSession）
 	jwt.RegisteredClaims
 }
 
-// GenerateToken 生成JWT令牌（兼容旧版本，默认角色为 user）
-func GenerateToken(UserID uint, username string, role ...string) (string, error) {
-	userRole := "user" // 默认角色
-	if len(role) > 0 && role[0] != "" {
-		userRole = role[0]
-	}
+// ErrTokenMissingJTI 没有 jti 的令牌无法吊销，需要重新登录
+var ErrTokenMissingJTI = errors.New("令牌已失效，请重新登录")
 
-	expirationTime := time.Now().Add(time.Duration(Config.Cfg.TokenExpiry) * time.Minute)
+// generateAccessToken 生成访问令牌，jti 用于吊销
+func generateAccessToken(userID uint, username, role, sessionID, jti string, expiresAt time.Time) (string, error) {
+	if role == "" {
+		role = "user"
+	}
 	claims := &Claims{
-		UserID:   UserID,
-		Username: username,
-		Role:     userRole, // ← 加入角色
+		UserID:    userID,
+		Username:  username,
+		Role:      role,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			ID:        jti,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Subject:   strconv.FormatUint(uint64(UserID), 10),
+			Subject:   strconv.FormatUint(uint64(userID), 10),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString([]byte(Config.Cfg.SecretKey))
 }
 
-// ValidateToken 验证JWT令牌
+// ValidateToken 验证JWT令牌（签名、有效期和 jti），不检查吊销名单
 func ValidateToken(tokenString string) (*Claims, error) {
 
 	if Config.Cfg.SecretKey == "" {
@@ -57,5 +60,10 @@ func ValidateToken(tokenString string) (*Claims, error) {
 		return nil, jwt.ErrSignatureInvalid
 	}
 
+	// 引入刷新令牌之前签发的令牌没有 jti 和 sid
+	if claims.ID == "" || claims.SessionID == "" {
+		return nil, ErrTokenMissingJTI
+	}
+
 	return claims, nil
 }
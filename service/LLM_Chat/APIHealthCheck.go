@@ -0,0 +1,167 @@
+package LLM_Chat
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"platfrom/database"
+	"strings"
+	"time"
+)
+
+// API 配置的连通性检查：请求提供商的模型列表接口（不消耗 token），
+// 把延迟、状态和错误记录在配置上，GetFirstAvailableAPI 据此跳过不可用的配置
+
+const (
+	apiHealthCheckInterval = 10 * time.Minute // 后台检查间隔
+	apiHealthCheckTimeout  = 15 * time.Second // 单次检查超时
+	apiHealthErrorMaxLen   = 500              // 记录的错误信息最大长度（与字段长度一致）
+)
+
+// healthCheckRequest 构造模型列表请求：OpenAI 兼容接口为 /models，Anthropic 为 /v1/models，Ollama 为 /api/tags
+func healthCheckRequest(ctx context.Context, api *database.UserAPI) (*http.Request, error) {
+	var url string
+	switch NormalizeProvider(api.Provider) {
+	case database.ProviderAnthropic:
+		baseURL := api.BaseURL
+		if baseURL == "" {
+			baseURL = anthropicDefaultBaseURL
+		}
+		url = joinURL(baseURL, "/v1/models")
+	case database.ProviderOllama:
+		baseURL := api.BaseURL
+		if baseURL == "" {
+			baseURL = ollamaDefaultBaseURL
+		}
+		url = joinURL(baseURL, "/api/tags")
+	default:
+		baseURL := api.BaseURL
+		if baseURL == "" {
+			baseURL = openAIDefaultBaseURL
+		}
+		url = strings.TrimRight(baseURL, "/") + "/models"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch NormalizeProvider(api.Provider) {
+	case database.ProviderAnthropic:
+		req.Header.Set("x-api-key", api.APIKey)
+		req.Header.Set("anthropic-version", anthropicAPIVersion)
+	case database.ProviderOllama:
+	default:
+		if api.APIKey != "" {
+			req.Header.Set("Authorization", "Bearer "+api.APIKey)
+		}
+	}
+	return req, nil
+}
+
+// checkAPIHealth 请求一次模型列表，返回耗时和错误（nil 表示可用）
+func (s *userAPIService) checkAPIHealth(ctx context.Context, api *database.UserAPI) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiHealthCheckTimeout)
+	defer cancel()
+
+	req, err := healthCheckRequest(ctx, api)
+	if err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return latency, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return latency, newProviderError(NormalizeProvider(api.Provider), resp, "", body)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return latency, nil
+}
+
+// recordAPIHealth 检查并保存结果（不修改 updated_at），api 中的字段同步更新
+func (s *userAPIService) recordAPIHealth(ctx context.Context, api *database.UserAPI) error {
+	latency, checkErr := s.checkAPIHealth(ctx, api)
+
+	now := time.Now()
+	api.HealthStatus = database.APIHealthy
+	api.HealthLatencyMs = latency.Milliseconds()
+	api.HealthError = ""
+	api.HealthCheckedAt = &now
+	if checkErr != nil {
+		api.HealthStatus = database.APIUnhealthy
+		api.HealthError = checkErr.Error()
+		if len(api.HealthError) > apiHealthErrorMaxLen {
+			api.HealthError = strings.ToValidUTF8(api.HealthError[:apiHealthErrorMaxLen], "")
+		}
+	}
+
+	err := s.db.Model(&database.UserAPI{}).Where("id = ?", api.ID).UpdateColumns(map[string]interface{}{
+		"health_status":     api.HealthStatus,
+		"health_latency_ms": api.HealthLatencyMs,
+		"health_error":      api.HealthError,
+		"health_checked_at": now,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("保存检查结果失败: %w", err)
+	}
+	return nil
+}
+
+// TestAPIConnection 检查API配置的连通性，结果保存在配置上并返回。
+// 提供商不可用不算错误（记录在 HealthStatus 和 HealthError 中），只有配置不存在或保存失败时返回错误
+func (s *userAPIService) TestAPIConnection(ctx context.Context, apiID uint) (*database.UserAPI, error) {
+	api, err := s.GetAPIByID(apiID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.recordAPIHealth(ctx, api); err != nil {
+		return nil, err
+	}
+	return api, nil
+}
+
+// CheckAllAPIs 依次检查所有API配置，返回检查的数量
+func (s *userAPIService) CheckAllAPIs(ctx context.Context) (int, error) {
+	var ids []uint
+	if err := s.db.Model(&database.UserAPI{}).Order("id").Pluck("id", &ids).Error; err != nil {
+		return 0, fmt.Errorf("查询API配置失败: %w", err)
+	}
+
+	checked := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return checked, ctx.Err()
+		}
+		api, err := s.TestAPIConnection(ctx, id)
+		if err != nil {
+			log.Printf("检查API配置失败 (api: %d): %v", id, err)
+			continue
+		}
+		if api.HealthStatus == database.APIUnhealthy {
+			log.Printf("API配置不可用 (api: %d, user: %d): %s", api.ID, api.UserID, api.HealthError)
+		}
+		checked++
+	}
+	return checked, nil
+}
+
+// StartHealthCheckTask 启动定期连通性检查任务
+func (s *userAPIService) StartHealthCheckTask() {
+	go func() {
+		ticker := time.NewTicker(apiHealthCheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if _, err := s.CheckAllAPIs(context.Background()); err != nil {
+				log.Printf("API连通性检查失败: %v", err)
+			}
+		}
+	}()
+}
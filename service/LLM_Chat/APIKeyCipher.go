@@ -0,0 +1,221 @@
+package LLM_Chat
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// 用户 API 密钥使用信封加密保存：每条记录生成随机数据密钥，用 AES-GCM 加密 API 密钥，
+// 数据密钥再用主密钥加密后与主密钥ID一起保存。轮换主密钥时只需重新加密数据密钥
+
+// defaultMasterKeyID 未配置主密钥时由 SECRET_KEY 派生的主密钥ID
+const defaultMasterKeyID = "default"
+
+// ErrUnknownMasterKey 记录使用的主密钥没有配置（已被移除）
+var ErrUnknownMasterKey = errors.New("未知的主密钥")
+
+// APIKeyCipher API 密钥加解密
+type APIKeyCipher struct {
+	keys     map[string][]byte // 主密钥ID -> 32 字节主密钥
+	activeID string            // 加密新记录使用的主密钥
+}
+
+// GlobalAPIKeyCipher 全局 APIKeyCipher 实例
+var GlobalAPIKeyCipher *APIKeyCipher
+
+// NewAPIKeyCipher 创建加解密器，主密钥必须是 32 字节（AES-256），activeID 必须是其中之一
+func NewAPIKeyCipher(keys map[string][]byte, activeID string) (*APIKeyCipher, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("主密钥不能为空")
+	}
+	for id, key := range keys {
+		if id == "" {
+			return nil, errors.New("主密钥ID不能为空")
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("主密钥 %s 长度必须为 32 字节", id)
+		}
+	}
+	if _, ok := keys[activeID]; !ok {
+		return nil, fmt.Errorf("当前主密钥 %s 未配置", activeID)
+	}
+	return &APIKeyCipher{keys: keys, activeID: activeID}, nil
+}
+
+// ParseMasterKeys 解析主密钥配置，格式为 "v1:<base64>,v2:<base64>"
+func ParseMasterKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(item, ":")
+		if !ok {
+			return nil, fmt.Errorf("主密钥格式错误: %s", item)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("主密钥 %s 不是合法的 base64: %w", id, err)
+		}
+		keys[strings.TrimSpace(id)] = key
+	}
+	return keys, nil
+}
+
+// InitAPIKeyCipher 按配置初始化全局加解密器。未配置主密钥时由 secretKey 派生，
+// 此时修改 SECRET_KEY 会导致已保存的密钥无法解密
+func InitAPIKeyCipher(masterKeys, activeID, secretKey string) (*APIKeyCipher, error) {
+	var keys map[string][]byte
+	if masterKeys == "" {
+		if secretKey == "" {
+			return nil, errors.New("未配置 API_KEY_MASTER_KEYS 和 SECRET_KEY")
+		}
+		log.Printf("未配置 API_KEY_MASTER_KEYS，使用 SECRET_KEY 派生的主密钥加密 API 密钥")
+		sum := sha256.Sum256([]byte("api-key-master:" + secretKey))
+		keys = map[string][]byte{defaultMasterKeyID: sum[:]}
+		activeID = defaultMasterKeyID
+	} else {
+		var err error
+		if keys, err = ParseMasterKeys(masterKeys); err != nil {
+			return nil, err
+		}
+		// 只配置了一个主密钥时可以不指定当前主密钥
+		if activeID == "" && len(keys) == 1 {
+			for id := range keys {
+				activeID = id
+			}
+		}
+	}
+
+	c, err := NewAPIKeyCipher(keys, activeID)
+	if err != nil {
+		return nil, err
+	}
+	GlobalAPIKeyCipher = c
+	return c, nil
+}
+
+// ActiveKeyID 当前主密钥ID
+func (c *APIKeyCipher) ActiveKeyID() string {
+	return c.activeID
+}
+
+// Encrypt 用新的数据密钥加密明文，返回密文、加密后的数据密钥和主密钥ID（都是 base64 字符串）
+func (c *APIKeyCipher) Encrypt(plaintext string) (ciphertext, dataKey, keyID string, err error) {
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", "", "", err
+	}
+	sealed, err := sealAESGCM(key, []byte(plaintext), nil)
+	if err != nil {
+		return "", "", "", err
+	}
+	wrapped, err := sealAESGCM(c.keys[c.activeID], key, []byte(c.activeID))
+	if err != nil {
+		return "", "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), base64.StdEncoding.EncodeToString(wrapped), c.activeID, nil
+}
+
+// Decrypt 解密 Encrypt 的结果
+func (c *APIKeyCipher) Decrypt(ciphertext, dataKey, keyID string) (string, error) {
+	key, err := c.unwrap(dataKey, keyID)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("密文格式错误: %w", err)
+	}
+	plaintext, err := openAESGCM(key, sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap 用当前主密钥重新加密数据密钥，密文不变
+func (c *APIKeyCipher) Rewrap(dataKey, keyID string) (string, string, error) {
+	key, err := c.unwrap(dataKey, keyID)
+	if err != nil {
+		return "", "", err
+	}
+	wrapped, err := sealAESGCM(c.keys[c.activeID], key, []byte(c.activeID))
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(wrapped), c.activeID, nil
+}
+
+// unwrap 用主密钥解密数据密钥
+func (c *APIKeyCipher) unwrap(dataKey, keyID string) ([]byte, error) {
+	master, ok := c.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMasterKey, keyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("数据密钥格式错误: %w", err)
+	}
+	return openAESGCM(master, wrapped, []byte(keyID))
+}
+
+// sealAESGCM AES-GCM 加密，随机 nonce 放在密文前面
+func sealAESGCM(key, plaintext, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// openAESGCM 解密 sealAESGCM 的结果
+func openAESGCM(key, sealed, additionalData []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("密文长度错误")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("解密失败: %w", err)
+	}
+	return plaintext, nil
+}
+
+// maskAPIKeyMinLength 短于此长度的密钥完全隐藏，露出的几位占比过高
+const maskAPIKeyMinLength = 20
+
+// MaskAPIKey 脱敏显示密钥，只保留结尾 4 位（sk- 等前缀也不显示），较短的密钥完全隐藏
+func MaskAPIKey(key string) string {
+	if key == "" {
+		return ""
+	}
+	if len(key) < maskAPIKeyMinLength {
+		return "****"
+	}
+	return "****" + key[len(key)-4:]
+}
@@ -0,0 +1,344 @@
+package LLM_Chat
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"platfrom/database"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 模型路由的运行时部分：RoutedProvider 实现 LLMProviderInterface，会话持有它而不是单个提供商，
+// 每次请求按策略选择 API 配置；遇到 429/5xx 或网络错误时退避重试，仍失败则切换到下一个配置。
+// 对话历史保存在会话中，切换配置对调用方透明
+
+const (
+	routeMaxRetries       = 2                      // 每个配置在切换前的最多重试次数
+	routeBaseBackoff      = 100 * time.Millisecond // 重试的基础退避时间，按次数翻倍
+	routeMaxRetryAfter    = 10 * time.Second       // Retry-After 超过该值时不等待，直接切换配置
+	breakerFailureLimit   = 3                      // 连续失败的请求数达到后熔断（每次请求在重试用完后只计一次）
+	breakerOpenDuration   = 30 * time.Second       // 熔断持续时间，之后放行一次试探请求
+	routeLatencySmoothing = 0.3                    // 延迟的指数移动平均系数
+)
+
+// ErrAllAPIsUnavailable 路由中的配置都处于熔断状态
+var ErrAllAPIsUnavailable = errors.New("所有 API 配置暂时不可用，请稍后重试")
+
+// ServedBy 实际生成回复的 API 配置
+type ServedBy struct {
+	APIID uint
+	Model string
+}
+
+// providerAttempt 一次请求中由 HTTP 层记录的上游响应状态，用于判断是否重试
+type providerAttempt struct {
+	mu         sync.Mutex
+	statusCode int
+	retryAfter time.Duration
+}
+
+type providerAttemptKey struct{}
+
+// attemptRecordingTransport 把响应状态码和 Retry-After 记录到请求 context 中的 providerAttempt，
+// 各提供商返回的错误类型不同（go-openai 的错误不带响应头），由此统一判断
+type attemptRecordingTransport struct {
+	base http.RoundTripper
+}
+
+func (t attemptRecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if attempt, ok := req.Context().Value(providerAttemptKey{}).(*providerAttempt); ok && resp != nil {
+		attempt.mu.Lock()
+		attempt.statusCode = resp.StatusCode
+		attempt.retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+		attempt.mu.Unlock()
+	}
+	return resp, err
+}
+
+// providerHTTPClient 各提供商共用的 HTTP 客户端（不设置整体超时，由请求的 context 控制）
+var providerHTTPClient = &http.Client{Transport: attemptRecordingTransport{base: http.DefaultTransport}}
+
+// parseRetryAfter 解析 Retry-After（秒数或 HTTP 日期），无法解析时返回 0
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(at); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// apiRouteState 单个 API 配置的熔断状态和延迟统计
+type apiRouteState struct {
+	failures  int
+	openUntil time.Time
+	probing   bool          // 熔断结束后的试探请求进行中
+	latency   time.Duration // 延迟的指数移动平均，0 表示尚无数据
+}
+
+// APIRouter 所有会话共享的路由状态：每个配置的熔断和延迟、每个路由的轮询计数
+type APIRouter struct {
+	mu       sync.Mutex
+	states   map[uint]*apiRouteState
+	counters map[string]int
+}
+
+func NewAPIRouter() *APIRouter {
+	return &APIRouter{
+		states:   make(map[uint]*apiRouteState),
+		counters: make(map[string]int),
+	}
+}
+
+func (r *APIRouter) state(apiID uint) *apiRouteState {
+	state, ok := r.states[apiID]
+	if !ok {
+		state = &apiRouteState{}
+		r.states[apiID] = state
+	}
+	return state
+}
+
+// allow 配置是否可以使用：熔断期间拒绝，熔断结束后只放行一个试探请求
+func (r *APIRouter) allow(apiID uint) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := r.state(apiID)
+	if state.failures < breakerFailureLimit {
+		return true
+	}
+	if time.Now().Before(state.openUntil) || state.probing {
+		return false
+	}
+	state.probing = true
+	return true
+}
+
+// success 记录成功的请求，关闭熔断并更新延迟
+func (r *APIRouter) success(apiID uint, latency time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := r.state(apiID)
+	state.failures = 0
+	state.probing = false
+	if state.latency == 0 {
+		state.latency = latency
+	} else {
+		state.latency = time.Duration(routeLatencySmoothing*float64(latency) + (1-routeLatencySmoothing)*float64(state.latency))
+	}
+}
+
+// failure 记录失败的请求，连续失败达到上限（或试探失败）时熔断
+func (r *APIRouter) failure(apiID uint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state := r.state(apiID)
+	state.failures++
+	state.probing = false
+	if state.failures >= breakerFailureLimit {
+		state.openUntil = time.Now().Add(breakerOpenDuration)
+	}
+}
+
+// order 按策略排列本次请求尝试的顺序
+func (r *APIRouter) order(key, policy string, candidates []routeCandidate) []routeCandidate {
+	ordered := make([]routeCandidate, len(candidates))
+	copy(ordered, candidates)
+	if len(ordered) < 2 {
+		return ordered
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch policy {
+	case database.RoutePolicyRoundRobin:
+		start := r.counters[key] % len(ordered)
+		r.counters[key]++
+		ordered = append(ordered[start:], ordered[:start]...)
+	case database.RoutePolicyLeastLatency:
+		// 没有延迟数据时使用连通性检查的结果，仍没有的排在最前面以便采样
+		latency := func(c routeCandidate) time.Duration {
+			if state, ok := r.states[c.api.ID]; ok && state.latency > 0 {
+				return state.latency
+			}
+			return time.Duration(c.api.HealthLatencyMs) * time.Millisecond
+		}
+		sort.SliceStable(ordered, func(i, j int) bool { return latency(ordered[i]) < latency(ordered[j]) })
+	}
+	return ordered
+}
+
+// routeCandidate 路由中的一个 API 配置及其提供商
+type routeCandidate struct {
+	api      database.UserAPI
+	provider LLMProviderInterface
+}
+
+// RoutedProvider 在多个 API 配置间路由的提供商，每个会话一个
+type RoutedProvider struct {
+	router     *APIRouter
+	key        string
+	policy     string
+	candidates []routeCandidate
+
+	mu     sync.Mutex
+	served ServedBy
+}
+
+// NewRoutedProvider 为候选配置创建提供商，key 用于区分轮询计数
+func NewRoutedProvider(router *APIRouter, key, policy string, apis []database.UserAPI) (*RoutedProvider, error) {
+	if len(apis) == 0 {
+		return nil, errors.New("路由中没有可用的API配置")
+	}
+	candidates := make([]routeCandidate, 0, len(apis))
+	for _, api := range apis {
+		provider, err := NewLLMProvider(api.Provider, api.APIKey, api.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, routeCandidate{api: api, provider: provider})
+	}
+	return &RoutedProvider{router: router, key: key, policy: policy, candidates: candidates}, nil
+}
+
+func (p *RoutedProvider) Name() string {
+	return p.candidates[0].provider.Name()
+}
+
+// LastServedBy 最近一次请求实际使用的配置（流式请求在收到第一段内容时确定）
+func (p *RoutedProvider) LastServedBy() ServedBy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.served
+}
+
+func (p *RoutedProvider) setServed(c routeCandidate) {
+	p.mu.Lock()
+	p.served = ServedBy{APIID: c.api.ID, Model: c.api.ModelName}
+	p.mu.Unlock()
+}
+
+func (p *RoutedProvider) CreateChatCompletion(ctx context.Context, req ProviderRequest) (*ProviderResponse, error) {
+	return p.do(ctx, req, nil)
+}
+
+func (p *RoutedProvider) CreateChatCompletionStream(ctx context.Context, req ProviderRequest, onChunk func(chunk string) error) (*ProviderResponse, error) {
+	return p.do(ctx, req, onChunk)
+}
+
+// do 按顺序尝试各配置。流式请求已输出内容后失败时不再切换（否则客户端会收到重复或拼接的内容）
+func (p *RoutedProvider) do(ctx context.Context, req ProviderRequest, onChunk func(chunk string) error) (*ProviderResponse, error) {
+	p.mu.Lock()
+	p.served = ServedBy{}
+	p.mu.Unlock()
+
+	var lastErr error
+	attempted := false
+	for _, c := range p.router.order(p.key, p.policy, p.candidates) {
+		if !p.router.allow(c.api.ID) {
+			continue
+		}
+		attempted = true
+
+		for retry := 0; ; retry++ {
+			resp, emitted, latency, attempt, err := p.attempt(ctx, c, req, onChunk)
+			if err == nil {
+				p.router.success(c.api.ID, latency)
+				p.setServed(c)
+				return resp, nil
+			}
+			if ctx.Err() != nil {
+				return nil, err
+			}
+			if emitted {
+				p.router.failure(c.api.ID)
+				return nil, err
+			}
+			lastErr = err
+
+			// 请求本身有问题（参数错误、上下文过长等），换配置也不会成功
+			if attempt.statusCode == http.StatusBadRequest || attempt.statusCode == http.StatusRequestEntityTooLarge || attempt.statusCode == http.StatusUnprocessableEntity {
+				return nil, err
+			}
+
+			// 重试用完才记一次失败：一次请求遇到短暂的错误不应直接熔断，熔断需要多次请求连续失败
+			wait, retryable := routeBackoff(attempt, retry)
+			if !retryable || retry >= routeMaxRetries || !p.router.allow(c.api.ID) {
+				p.router.failure(c.api.ID)
+				break
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return nil, ctx.Err()
+			case <-timer.C:
+			}
+		}
+	}
+
+	if !attempted {
+		return nil, ErrAllAPIsUnavailable
+	}
+	return nil, lastErr
+}
+
+// attempt 请求一次，返回是否已输出内容、延迟（流式为首段内容的延迟）和上游响应状态
+func (p *RoutedProvider) attempt(ctx context.Context, c routeCandidate, req ProviderRequest, onChunk func(chunk string) error) (*ProviderResponse, bool, time.Duration, *providerAttempt, error) {
+	attempt := &providerAttempt{}
+	ctx = context.WithValue(ctx, providerAttemptKey{}, attempt)
+	req.Model = c.api.ModelName
+
+	start := time.Now()
+	var latency time.Duration
+	emitted := false
+	var resp *ProviderResponse
+	var err error
+	if onChunk != nil {
+		resp, err = c.provider.CreateChatCompletionStream(ctx, req, func(chunk string) error {
+			if !emitted {
+				emitted = true
+				latency = time.Since(start)
+				p.setServed(c)
+			}
+			return onChunk(chunk)
+		})
+	} else {
+		resp, err = c.provider.CreateChatCompletion(ctx, req)
+	}
+	if latency == 0 {
+		latency = time.Since(start)
+	}
+
+	attempt.mu.Lock()
+	defer attempt.mu.Unlock()
+	return resp, emitted, latency, &providerAttempt{statusCode: attempt.statusCode, retryAfter: attempt.retryAfter}, err
+}
+
+// routeBackoff 判断失败的请求是否值得在同一配置上重试及等待时间：
+// 429、5xx 和未收到响应（网络错误）时重试，优先使用 Retry-After，否则指数退避加随机抖动
+func routeBackoff(attempt *providerAttempt, retry int) (time.Duration, bool) {
+	status := attempt.statusCode
+	if status != 0 && status != http.StatusTooManyRequests && status < 500 {
+		return 0, false
+	}
+	if attempt.retryAfter > 0 {
+		if attempt.retryAfter > routeMaxRetryAfter {
+			return 0, false
+		}
+		return attempt.retryAfter, true
+	}
+	backoff := routeBaseBackoff << retry
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/2+1)), true
+}
@@ -0,0 +1,404 @@
+package LLM_Chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+	"platfrom/database"
+	"strings"
+)
+
+const (
+	anthropicDefaultBaseURL   = "https://api.anthropic.com"
+	anthropicAPIVersion       = "2023-06-01"
+	anthropicDefaultMaxTokens = 4096
+)
+
+// AnthropicProvider Anthropic Messages API 的实现
+type AnthropicProvider struct {
+	APIKey     string
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+func NewAnthropicProvider(apiKey, baseURL string) LLMProviderInterface {
+	if baseURL == "" {
+		baseURL = anthropicDefaultBaseURL
+	}
+	return &AnthropicProvider{
+		APIKey:     apiKey,
+		BaseURL:    baseURL,
+		HTTPClient: providerHTTPClient,
+	}
+}
+
+// anthropicContentBlock 消息内容块：text / image / tool_use / tool_result
+type anthropicContentBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text,omitempty"`
+	Source    *anthropicImageSource `json:"source,omitempty"`
+	ID        string                `json:"id,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Input     json.RawMessage       `json:"input,omitempty"`
+	ToolUseID string                `json:"tool_use_id,omitempty"`
+	Content   string                `json:"content,omitempty"`
+}
+
+type anthropicImageSource struct {
+	Type      string `json:"type"` // base64 / url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string                  `json:"role"`
+	Content []anthropicContentBlock `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	MaxTokens     int                `json:"max_tokens"`
+	System        string             `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	Stream        bool               `json:"stream,omitempty"`
+	Temperature   *float32           `json:"temperature,omitempty"`
+	TopP          *float32           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+}
+
+type anthropicResponse struct {
+	Content    []anthropicContentBlock `json:"content"`
+	StopReason string                  `json:"stop_reason"`
+	Usage      anthropicUsage          `json:"usage"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicStreamEvent struct {
+	Type         string                `json:"type"`
+	Index        int                   `json:"index"`
+	ContentBlock anthropicContentBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	// message_start 中带输入 token 数，message_delta 中带累计的输出 token 数
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Usage *anthropicUsage `json:"usage"`
+	Error *anthropicError `json:"error"`
+}
+
+type anthropicError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+func (p *AnthropicProvider) Name() string {
+	return database.ProviderAnthropic
+}
+
+// CreateChatCompletion 同步请求
+func (p *AnthropicProvider) CreateChatCompletion(ctx context.Context, req ProviderRequest) (*ProviderResponse, error) {
+	resp, err := p.doRequest(ctx, p.buildRequest(req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result anthropicResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析 Anthropic 响应失败: %w", err)
+	}
+
+	var content strings.Builder
+	var toolCalls []openai.ToolCall
+	for _, block := range result.Content {
+		switch block.Type {
+		case "text":
+			content.WriteString(block.Text)
+		case "tool_use":
+			toolCalls = append(toolCalls, newAnthropicToolCall(block.ID, block.Name, string(block.Input)))
+		}
+	}
+
+	return &ProviderResponse{
+		Content:      content.String(),
+		FinishReason: result.StopReason,
+		ToolCalls:    toolCalls,
+		Usage:        TokenUsage{PromptTokens: result.Usage.InputTokens, CompletionTokens: result.Usage.OutputTokens},
+	}, nil
+}
+
+// CreateChatCompletionStream 流式请求（SSE）
+func (p *AnthropicProvider) CreateChatCompletionStream(ctx context.Context, req ProviderRequest, onChunk func(chunk string) error) (*ProviderResponse, error) {
+	resp, err := p.doRequest(ctx, p.buildRequest(req, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var fullResponse strings.Builder
+	var finishReason string
+	var usage TokenUsage
+	// tool_use 内容块的参数以 partial_json 增量返回，按内容块 index 拼接
+	var toolCalls []openai.ToolCall
+	toolIndex := make(map[int]int)
+
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("Stream error: %w", err)
+		}
+
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "data:") {
+			var event anthropicStreamEvent
+			data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+			if jsonErr := json.Unmarshal([]byte(data), &event); jsonErr != nil {
+				return nil, fmt.Errorf("解析 Anthropic 流式数据失败: %w", jsonErr)
+			}
+
+			switch event.Type {
+			case "message_start":
+				usage.PromptTokens = event.Message.Usage.InputTokens
+				usage.CompletionTokens = event.Message.Usage.OutputTokens
+			case "content_block_start":
+				if event.ContentBlock.Type == "tool_use" {
+					toolIndex[event.Index] = len(toolCalls)
+					toolCalls = append(toolCalls, newAnthropicToolCall(event.ContentBlock.ID, event.ContentBlock.Name, ""))
+				}
+			case "content_block_delta":
+				if event.Delta.Type == "input_json_delta" {
+					if i, ok := toolIndex[event.Index]; ok {
+						toolCalls[i].Function.Arguments += event.Delta.PartialJSON
+					}
+				}
+				if event.Delta.Type == "text_delta" && event.Delta.Text != "" {
+					fullResponse.WriteString(event.Delta.Text)
+					if onChunk != nil {
+						if cbErr := onChunk(event.Delta.Text); cbErr != nil {
+							return nil, cbErr
+						}
+					}
+				}
+			case "message_delta":
+				if event.Delta.StopReason != "" {
+					finishReason = event.Delta.StopReason
+				}
+				if event.Usage != nil {
+					usage.CompletionTokens = event.Usage.OutputTokens
+				}
+			case "error":
+				if event.Error != nil {
+					return nil, fmt.Errorf("Stream error: %s", event.Error.Message)
+				}
+				return nil, errors.New("Stream error: 未知错误")
+			case "message_stop":
+				return &ProviderResponse{
+					Content:      fullResponse.String(),
+					FinishReason: finishReason,
+					ToolCalls:    normalizeAnthropicToolCalls(toolCalls),
+					Usage:        usage,
+				}, nil
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+
+	return &ProviderResponse{
+		Content:      fullResponse.String(),
+		FinishReason: finishReason,
+		ToolCalls:    normalizeAnthropicToolCalls(toolCalls),
+		Usage:        usage,
+	}, nil
+}
+
+func newAnthropicToolCall(id, name, arguments string) openai.ToolCall {
+	return openai.ToolCall{
+		ID:   id,
+		Type: openai.ToolTypeFunction,
+		Function: openai.FunctionCall{
+			Name:      name,
+			Arguments: arguments,
+		},
+	}
+}
+
+// normalizeAnthropicToolCalls 没有参数的工具调用补全为空对象
+func normalizeAnthropicToolCalls(toolCalls []openai.ToolCall) []openai.ToolCall {
+	for i := range toolCalls {
+		if strings.TrimSpace(toolCalls[i].Function.Arguments) == "" {
+			toolCalls[i].Function.Arguments = "{}"
+		}
+	}
+	return toolCalls
+}
+
+// anthropicUserBlocks 转换用户消息，多部分内容中的图片转为 image 块
+func anthropicUserBlocks(msg openai.ChatCompletionMessage) []anthropicContentBlock {
+	if len(msg.MultiContent) == 0 {
+		return []anthropicContentBlock{{Type: "text", Text: msg.Content}}
+	}
+
+	var blocks []anthropicContentBlock
+	for _, part := range msg.MultiContent {
+		switch part.Type {
+		case openai.ChatMessagePartTypeText:
+			blocks = append(blocks, anthropicContentBlock{Type: "text", Text: part.Text})
+		case openai.ChatMessagePartTypeImageURL:
+			if part.ImageURL == nil {
+				continue
+			}
+			source := &anthropicImageSource{Type: "url", URL: part.ImageURL.URL}
+			if mediaType, data, ok := ParseDataURL(part.ImageURL.URL); ok {
+				source = &anthropicImageSource{Type: "base64", MediaType: mediaType, Data: data}
+			}
+			blocks = append(blocks, anthropicContentBlock{Type: "image", Source: source})
+		}
+	}
+	return blocks
+}
+
+// buildRequest 将 OpenAI 格式的消息转换为 Anthropic 格式
+// system 消息合并到顶层 system 字段，相邻的同角色消息合并为一条（Anthropic 要求角色交替），
+// assistant 的 tool_calls 转为 tool_use 内容块，tool 消息转为 user 角色的 tool_result 内容块
+// Anthropic 不支持 presence/frequency penalty 和 seed，这些参数会被忽略
+func (p *AnthropicProvider) buildRequest(req ProviderRequest, stream bool) *anthropicRequest {
+	var systemParts []string
+	var messages []anthropicMessage
+
+	for _, msg := range req.Messages {
+		if msg.Role == openai.ChatMessageRoleSystem {
+			if msg.Content != "" {
+				systemParts = append(systemParts, msg.Content)
+			}
+			continue
+		}
+
+		role := "user"
+		var blocks []anthropicContentBlock
+		switch msg.Role {
+		case openai.ChatMessageRoleAssistant:
+			role = "assistant"
+			if msg.Content != "" {
+				blocks = append(blocks, anthropicContentBlock{Type: "text", Text: msg.Content})
+			}
+			for _, call := range msg.ToolCalls {
+				input := json.RawMessage(call.Function.Arguments)
+				if !json.Valid(input) {
+					input = json.RawMessage("{}")
+				}
+				blocks = append(blocks, anthropicContentBlock{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+			}
+		case openai.ChatMessageRoleTool:
+			blocks = append(blocks, anthropicContentBlock{Type: "tool_result", ToolUseID: msg.ToolCallID, Content: msg.Content})
+		default:
+			blocks = append(blocks, anthropicUserBlocks(msg)...)
+		}
+
+		if len(messages) > 0 && messages[len(messages)-1].Role == role {
+			last := &messages[len(messages)-1]
+			last.Content = append(last.Content, blocks...)
+			continue
+		}
+		messages = append(messages, anthropicMessage{Role: role, Content: blocks})
+	}
+
+	var tools []anthropicTool
+	for _, tool := range req.Tools {
+		if tool.Function == nil {
+			continue
+		}
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		tools = append(tools, anthropicTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	maxTokens := anthropicDefaultMaxTokens
+	if req.Params.MaxTokens != nil {
+		maxTokens = *req.Params.MaxTokens
+	}
+
+	// Anthropic 的 temperature 取值范围为 0~1
+	temperature := req.Params.Temperature
+	if temperature != nil && *temperature > 1 {
+		clamped := float32(1)
+		temperature = &clamped
+	}
+
+	return &anthropicRequest{
+		Model:         req.Model,
+		MaxTokens:     maxTokens,
+		System:        strings.Join(systemParts, "\n\n"),
+		Messages:      messages,
+		Stream:        stream,
+		Temperature:   temperature,
+		TopP:          req.Params.TopP,
+		StopSequences: req.Params.Stop,
+		Tools:         tools,
+	}
+}
+
+// doRequest 发送请求，非 2xx 响应转换为 ProviderError
+func (p *AnthropicProvider) doRequest(ctx context.Context, body *anthropicRequest) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, joinURL(p.BaseURL, "/v1/messages"), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("x-api-key", p.APIKey)
+	httpReq.Header.Set("anthropic-version", anthropicAPIVersion)
+
+	resp, err := p.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("请求 Anthropic 失败: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		var errResp struct {
+			Error anthropicError `json:"error"`
+		}
+		_ = json.Unmarshal(respBody, &errResp)
+		return nil, newProviderError(p.Name(), resp, errResp.Error.Message, respBody)
+	}
+
+	return resp, nil
+}
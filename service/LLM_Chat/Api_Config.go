@@ -1,9 +1,11 @@
 package LLM_Chat
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"gorm.io/gorm"
+	"net/http"
 	"platfrom/database"
 )
 
@@ -18,9 +20,22 @@ type UserAPIServiceInterface interface {
 	UpdateAPI(apiID uint, updates map[string]interface{}) error
 	DeleteAPI(apiID uint) error
 
-	// TestAPIConnection API验证与选择
-	TestAPIConnection() (bool, error)
+	// TestAPIConnection API验证与选择：请求提供商的模型列表检查连通性，结果记录在配置上
+	TestAPIConnection(ctx context.Context, apiID uint) (*database.UserAPI, error)
+	CheckAllAPIs(ctx context.Context) (int, error)
+	StartHealthCheckTask()
+	// GetFirstAvailableAPI 优先返回检查通过的配置，跳过检查失败的配置
 	GetFirstAvailableAPI(userID uint) (*database.UserAPI, error)
+
+	// RotateAPIKeys 用当前主密钥重新加密所有记录（包括旧的明文记录），返回处理的记录数
+	RotateAPIKeys() (int, error)
+
+	// 模型路由：一个名称对应多个API配置，按策略分发并在失败时切换
+	CreateModelRoute(userID uint, route *database.ModelRoute) (*database.ModelRoute, error)
+	GetModelRoutes(userID uint) ([]database.ModelRoute, error)
+	UpdateModelRoute(userID, routeID uint, route *database.ModelRoute) (*database.ModelRoute, error)
+	DeleteModelRoute(userID, routeID uint) error
+	ResolveModelRoute(userID uint, modelName string) (*ResolvedRoute, error)
 }
 
 // GlobalUserAPIService 全局UserAPIService实例
@@ -28,7 +43,9 @@ var GlobalUserAPIService UserAPIServiceInterface
 
 // userAPIService 用户API配置服务实现
 type userAPIService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	cipher *APIKeyCipher
+	client *http.Client // 连通性检查使用
 }
 
 // NewUserAPIService 创建新的UserAPI服务
@@ -37,9 +54,14 @@ func NewUserAPIService(db *gorm.DB) (UserAPIServiceInterface, error) {
 	if db == nil {
 		return nil, errors.New("数据库连接不能为空")
 	}
+	if GlobalAPIKeyCipher == nil {
+		return nil, errors.New("API密钥加密未初始化")
+	}
 
 	service := &userAPIService{
 		db,
+		GlobalAPIKeyCipher,
+		&http.Client{Timeout: apiHealthCheckTimeout},
 	}
 	GlobalUserAPIService = service
 	return service, nil
@@ -53,9 +75,18 @@ func (s *userAPIService) CreateAPI(userID uint, api *database.UserAPI) (*databas
 	if api.APIName == "" {
 		return nil, errors.New("API名称不能为空")
 	}
-	if api.APIKey == "" {
+	// 未指定提供商时默认为 OpenAI 兼容接口
+	api.Provider = NormalizeProvider(api.Provider)
+	if !IsSupportedProvider(api.Provider) {
+		return nil, fmt.Errorf("不支持的模型提供商: %s", api.Provider)
+	}
+	// 本地 Ollama 不需要密钥
+	if api.APIKey == "" && api.Provider != database.ProviderOllama {
 		return nil, errors.New("API密钥不能为空")
 	}
+	if api.ContextBudget < 0 {
+		return nil, errors.New("上下文预算不能为负数")
+	}
 	// 检查同名的API是否已存在（重要：这里是修正的逻辑）
 	var existingAPI database.UserAPI
 	err := s.db.Where("user_id = ? AND api_name = ?", userID, api.APIName).First(&existingAPI).Error
@@ -71,10 +102,17 @@ func (s *userAPIService) CreateAPI(userID uint, api *database.UserAPI) (*databas
 	}
 	// 设置用户ID
 	api.UserID = userID
+	// 密钥加密后保存，返回给调用方的仍是明文
+	plainKey := api.APIKey
+	if err := s.sealAPIKey(api); err != nil {
+		return nil, err
+	}
 	// 创建API配置
 	if err := s.db.Create(api).Error; err != nil {
+		api.APIKey = plainKey
 		return nil, fmt.Errorf("创建API配置失败: %w", err)
 	}
+	api.APIKey = plainKey
 	return api, nil
 }
 
@@ -87,6 +125,9 @@ func (s *userAPIService) GetAPIByID(apiID uint) (*database.UserAPI, error) {
 		}
 		return nil, fmt.Errorf("查询API配置失败: %w", err)
 	}
+	if err := s.openAPIKey(&api); err != nil {
+		return nil, err
+	}
 
 	return &api, nil
 }
@@ -100,6 +141,9 @@ func (s *userAPIService) GetAPIByName(userID uint, apiName string) (*database.Us
 		}
 		return nil, fmt.Errorf("查询API配置失败: %w", err)
 	}
+	if err := s.openAPIKey(&api); err != nil {
+		return nil, err
+	}
 
 	return &api, nil
 }
@@ -113,6 +157,9 @@ func (s *userAPIService) GetAPIByModelName(userID uint, modelName string) (*data
 		}
 		return nil, fmt.Errorf("查询API配置失败: %w", err)
 	}
+	if err := s.openAPIKey(&api); err != nil {
+		return nil, err
+	}
 
 	return &api, nil
 }
@@ -123,6 +170,11 @@ func (s *userAPIService) GetUserAPIs(userID uint) ([]database.UserAPI, error) {
 	if err := s.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&apis).Error; err != nil {
 		return nil, fmt.Errorf("查询用户API配置失败: %w", err)
 	}
+	for i := range apis {
+		if err := s.openAPIKey(&apis[i]); err != nil {
+			return nil, err
+		}
+	}
 
 	return apis, nil
 }
@@ -149,6 +201,38 @@ func (s *userAPIService) UpdateAPI(apiID uint, updates map[string]interface{}) e
 			return errors.New("该API名称已存在")
 		}
 	}
+	if provider, ok := updates["provider"].(string); ok {
+		if !IsSupportedProvider(provider) {
+			return fmt.Errorf("不支持的模型提供商: %s", provider)
+		}
+		updates["provider"] = NormalizeProvider(provider)
+	}
+	if budget, ok := updates["context_budget"].(int); ok && budget < 0 {
+		return errors.New("上下文预算不能为负数")
+	}
+	// 复制一份再补充字段，不修改调用方传入的明文密钥
+	plainUpdates := updates
+	updates = make(map[string]interface{}, len(plainUpdates)+3)
+	for k, v := range plainUpdates {
+		updates[k] = v
+	}
+	// 连接相关的配置变化后，之前的检查结果不再有效
+	for _, key := range []string{"api_key", "base_url", "provider"} {
+		if _, ok := updates[key]; ok {
+			updates["health_status"] = ""
+			break
+		}
+	}
+	// 新密钥加密后保存
+	if apiKey, ok := updates["api_key"].(string); ok {
+		sealed := database.UserAPI{APIKey: apiKey}
+		if err := s.sealAPIKey(&sealed); err != nil {
+			return err
+		}
+		updates["api_key"] = sealed.APIKey
+		updates["data_key"] = sealed.DataKey
+		updates["key_id"] = sealed.KeyID
+	}
 	// 执行更新
 	if err := s.db.Model(&database.UserAPI{}).Where("id = ?", apiID).Updates(updates).Error; err != nil {
 		return fmt.Errorf("更新API配置失败: %w", err)
@@ -173,19 +257,87 @@ func (s *userAPIService) DeleteAPI(apiID uint) error {
 	return nil
 }
 
-// TestAPIConnection 测试API连接（简单实现）
-func (s *userAPIService) TestAPIConnection() (bool, error) {
-	return true, nil
-}
+// clauseHealthyFirst 检查通过的配置排在前面
+const clauseHealthyFirst = "CASE WHEN health_status = '" + database.APIHealthy + "' THEN 0 ELSE 1 END"
 
 // GetFirstAvailableAPI 获取用户第一个可用的API配置
 func (s *userAPIService) GetFirstAvailableAPI(userID uint) (*database.UserAPI, error) {
 	var api database.UserAPI
-	if err := s.db.Where("user_id = ?", userID).First(&api).Error; err != nil {
+	// 检查通过的优先，其次是尚未检查的
+	err := s.db.Where("user_id = ? AND (health_status IS NULL OR health_status <> ?)", userID, database.APIUnhealthy).
+		Order(clauseHealthyFirst).Order("id").First(&api).Error
+	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
+			var count int64
+			s.db.Model(&database.UserAPI{}).Where("user_id = ?", userID).Count(&count)
+			if count > 0 {
+				return nil, errors.New("用户没有可用的API（连通性检查均未通过）")
+			}
 			return nil, errors.New("用户没有配置任何API")
 		}
 		return nil, fmt.Errorf("获取API配置失败: %w", err)
 	}
+	if err := s.openAPIKey(&api); err != nil {
+		return nil, err
+	}
 	return &api, nil
 }
+
+// RotateAPIKeys 用当前主密钥重新加密所有记录。已加密的记录只重新加密数据密钥，
+// 明文记录整体加密；已删除的记录也会处理，轮换完成后即可移除旧主密钥
+func (s *userAPIService) RotateAPIKeys() (int, error) {
+	activeID := s.cipher.ActiveKeyID()
+	var apis []database.UserAPI
+	rotated := 0
+	result := s.db.Unscoped().Where("key_id IS NULL OR key_id <> ?", activeID).
+		FindInBatches(&apis, 100, func(tx *gorm.DB, batch int) error {
+			for i := range apis {
+				api := &apis[i]
+				updates := map[string]interface{}{}
+				if api.KeyID == "" {
+					if err := s.sealAPIKey(api); err != nil {
+						return err
+					}
+					updates["api_key"] = api.APIKey
+				} else {
+					dataKey, keyID, err := s.cipher.Rewrap(api.DataKey, api.KeyID)
+					if err != nil {
+						return fmt.Errorf("重新加密API配置 %d 失败: %w", api.ID, err)
+					}
+					api.DataKey, api.KeyID = dataKey, keyID
+				}
+				updates["data_key"] = api.DataKey
+				updates["key_id"] = api.KeyID
+				// 不修改 updated_at
+				if err := s.db.Unscoped().Model(&database.UserAPI{}).Where("id = ?", api.ID).UpdateColumns(updates).Error; err != nil {
+					return fmt.Errorf("保存API配置 %d 失败: %w", api.ID, err)
+				}
+				rotated++
+			}
+			return nil
+		})
+	return rotated, result.Error
+}
+
+// sealAPIKey 加密 api.APIKey，并设置 DataKey 和 KeyID
+func (s *userAPIService) sealAPIKey(api *database.UserAPI) error {
+	ciphertext, dataKey, keyID, err := s.cipher.Encrypt(api.APIKey)
+	if err != nil {
+		return fmt.Errorf("加密API密钥失败: %w", err)
+	}
+	api.APIKey, api.DataKey, api.KeyID = ciphertext, dataKey, keyID
+	return nil
+}
+
+// openAPIKey 把 api.APIKey 解密为明文，KeyID 为空的旧记录本身就是明文
+func (s *userAPIService) openAPIKey(api *database.UserAPI) error {
+	if api.KeyID == "" {
+		return nil
+	}
+	plaintext, err := s.cipher.Decrypt(api.APIKey, api.DataKey, api.KeyID)
+	if err != nil {
+		return fmt.Errorf("解密API密钥失败 (api: %d): %w", api.ID, err)
+	}
+	api.APIKey = plaintext
+	return nil
+}
@@ -0,0 +1,167 @@
+package LLM_Chat
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrBlobNotFound 存储中没有对应的内容
+var ErrBlobNotFound = errors.New("文件内容不存在")
+
+// BlobStoreInterface 按内容寻址的文件存储，键为内容的 sha256（十六进制），相同内容只保存一份
+// 引用计数由 FileService 在数据库中维护，存储本身只负责读写
+type BlobStoreInterface interface {
+	// Put 写入内容，键与内容的 sha256 不一致时返回错误；键已存在时直接返回。size 未知时传 -1
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	// Delete 删除内容，键不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// List 列出所有已保存的内容，用于清理孤立文件
+	List(ctx context.Context) ([]BlobInfo, error)
+}
+
+// BlobInfo 存储中的一个内容
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobKey 返回内容的键
+func BlobKey(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// validBlobKey 键必须是 64 位小写十六进制，防止路径穿越
+func validBlobKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range key {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalBlobStore 本地目录存储，按键的前两级前缀分目录：<root>/ab/cd/abcd...
+type LocalBlobStore struct {
+	root string
+}
+
+// NewLocalBlobStore 创建本地存储
+func NewLocalBlobStore(root string) (BlobStoreInterface, error) {
+	if root == "" {
+		return nil, errors.New("存储目录不能为空")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, fmt.Errorf("创建存储目录失败: %w", err)
+	}
+	return &LocalBlobStore{root: root}, nil
+}
+
+func (s *LocalBlobStore) path(key string) string {
+	return filepath.Join(s.root, key[:2], key[2:4], key)
+}
+
+func (s *LocalBlobStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if !validBlobKey(key) {
+		return fmt.Errorf("无效的文件键: %s", key)
+	}
+	target := s.path(key)
+	if _, err := os.Stat(target); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("创建存储目录失败: %w", err)
+	}
+
+	// 先写临时文件，校验通过后再重命名，保证存储中不会出现写了一半的内容
+	tmp, err := os.CreateTemp(filepath.Dir(target), key+".tmp*")
+	if err != nil {
+		return fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("写入文件失败: %w", err)
+	}
+	if (size >= 0 && written != size) || hex.EncodeToString(hasher.Sum(nil)) != key {
+		return errors.New("文件内容与键不一致")
+	}
+
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return fmt.Errorf("保存文件失败: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validBlobKey(key) {
+		return nil, ErrBlobNotFound
+	}
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return file, err
+}
+
+func (s *LocalBlobStore) Exists(ctx context.Context, key string) (bool, error) {
+	if !validBlobKey(key) {
+		return false, nil
+	}
+	_, err := os.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalBlobStore) Delete(ctx context.Context, key string) error {
+	if !validBlobKey(key) {
+		return nil
+	}
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("删除文件失败: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalBlobStore) List(ctx context.Context) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	err := filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !validBlobKey(entry.Name()) {
+			return ctx.Err()
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, BlobInfo{Key: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("列出存储内容失败: %w", err)
+	}
+	return blobs, nil
+}
@@ -0,0 +1,164 @@
+package LLM_Chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"platfrom/service/Note"
+	"strings"
+)
+
+const (
+	toolNoteSnippetLength = 500   // search_notes 返回的每条笔记内容的最大字数
+	toolNoteMaxResults    = 10    // search_notes 最多返回的笔记数
+	toolFileContentLength = 20000 // read_uploaded_file 返回的文件内容最大字数
+)
+
+// RegisterBuiltinTools 注册内置工具：搜索笔记、读取上传文件
+func RegisterBuiltinTools(registry *ToolRegistry, noteService Note.NoteServiceInterface, fileService FileServiceInterface) error {
+	if noteService != nil {
+		if err := registry.Register(newSearchNotesTool(noteService)); err != nil {
+			return err
+		}
+	}
+	if fileService != nil {
+		if err := registry.Register(newReadUploadedFileTool(fileService)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newSearchNotesTool(noteService Note.NoteServiceInterface) Tool {
+	return Tool{
+		Name:        "search_notes",
+		Description: "按关键词搜索当前用户的笔记，返回匹配笔记的标题、分类、标签和内容片段",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"query": map[string]interface{}{
+					"type":        "string",
+					"description": "搜索关键词，匹配笔记标题和内容",
+				},
+				"limit": map[string]interface{}{
+					"type":        "integer",
+					"description": fmt.Sprintf("最多返回的笔记数，默认 5，最大 %d", toolNoteMaxResults),
+				},
+			},
+			"required": []string{"query"},
+		},
+		Handler: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			var params struct {
+				Query string `json:"query"`
+				Limit int    `json:"limit"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", fmt.Errorf("参数错误: %v", err)
+			}
+			if strings.TrimSpace(params.Query) == "" {
+				return "", errors.New("query 不能为空")
+			}
+			if params.Limit <= 0 {
+				params.Limit = 5
+			}
+			if params.Limit > toolNoteMaxResults {
+				params.Limit = toolNoteMaxResults
+			}
+
+			notes, err := noteService.SearchNotes(tc.UserID, strings.TrimSpace(params.Query))
+			if err != nil {
+				return "", fmt.Errorf("搜索笔记失败: %v", err)
+			}
+			if len(notes) == 0 {
+				return "没有找到匹配的笔记", nil
+			}
+			if len(notes) > params.Limit {
+				notes = notes[:params.Limit]
+			}
+
+			type noteResult struct {
+				ID       uint     `json:"id"`
+				Title    string   `json:"title"`
+				Category string   `json:"category"`
+				Tags     []string `json:"tags,omitempty"`
+				Content  string   `json:"content"`
+			}
+			results := make([]noteResult, 0, len(notes))
+			for _, note := range notes {
+				results = append(results, noteResult{
+					ID:       note.ID,
+					Title:    note.Title,
+					Category: note.Category,
+					Tags:     note.Tags,
+					Content:  truncateRunes(note.Content, toolNoteSnippetLength),
+				})
+			}
+			data, err := json.Marshal(results)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		},
+	}
+}
+
+func newReadUploadedFileTool(fileService FileServiceInterface) Tool {
+	return Tool{
+		Name:        "read_uploaded_file",
+		Description: "读取当前会话中用户上传的文件内容；不传 file_id 时返回本会话的文件列表",
+		Parameters: map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"file_id": map[string]interface{}{
+					"type":        "integer",
+					"description": "要读取的文件ID",
+				},
+			},
+		},
+		Handler: func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error) {
+			var params struct {
+				FileID uint `json:"file_id"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", fmt.Errorf("参数错误: %v", err)
+			}
+
+			if params.FileID == 0 {
+				files, err := fileService.GetFilesBySession(tc.SessionID, tc.UserID)
+				if err != nil {
+					return "", fmt.Errorf("获取文件列表失败: %v", err)
+				}
+				if len(files) == 0 {
+					return "当前会话没有上传的文件", nil
+				}
+				var lines []string
+				for _, file := range files {
+					lines = append(lines, fmt.Sprintf("file_id=%d, 文件名=%s, 大小=%d 字节", file.ID, file.FileName, file.FileSize))
+				}
+				return strings.Join(lines, "\n"), nil
+			}
+
+			// 只允许读取本会话上传的文件
+			file, err := fileService.GetFileByID(params.FileID, tc.UserID)
+			if err != nil || file.SessionID != tc.SessionID {
+				return "", errors.New("文件不存在")
+			}
+
+			content, err := fileService.ProcessFileContent(file)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("【文件：%s】\n%s", file.FileName, truncateRunes(content, toolFileContentLength)), nil
+		},
+	}
+}
+
+// truncateRunes 按字符数截断文本
+func truncateRunes(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return string(runes[:maxRunes]) + "..."
+}
@@ -15,13 +15,15 @@ import (
 type CacheService struct {
 	redisClient *redis.Client
 	available   bool
+	memoryLog   *memoryStreamLog // Redis 不可用时的流式事件存储
 }
 
+// CachedSession 缓存的完整会话状态。不缓存 API 配置（尤其是密钥），恢复时按会话的模型名重新解析
 type CachedSession struct {
-	Session     *database.ChatSession          `json:"session"`
-	Messages    []openai.ChatCompletionMessage `json:"messages"`
-	ModelAPIKey string                         `json:"model_api_key"`
-	BaseUrl     string                         `json:"baseUrl"`
+	Session  *database.ChatSession          `json:"session"`
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+	BaseUrl  string                         `json:"baseUrl"` // 请求中指定的地址，为空时使用 API 配置中的地址
+	Summary  string                         `json:"summary"`
 }
 
 // CacheServiceInterface 缓存服务接口
@@ -31,12 +33,21 @@ type CacheServiceInterface interface {
 	CacheModelConfig(modelName string, model *database.UserAPI) error
 	CacheFullSession(sessionID string, cachedSession *CachedSession, expiration time.Duration) error
 	GetCachedFullSession(sessionID string) (*CachedSession, error)
-
-	// AppendStreamResponse 新增：流式响应缓存相关
-	AppendStreamResponse(sessionID string, chunk string) error                               // 增量追加数据
-	GetStreamResponse(sessionID string) (string, error)                                      // 获取完整响应
-	DeleteStreamResponse(sessionID string) error                                             // 删除缓存
-	SaveWithRetry(sessionID string, role, content string, userID uint, maxRetries int) error // 带重试的保存
+	DeleteCachedFullSession(sessionID string) error
+
+	// AppendStreamEvent 流式响应事件日志，断线重连时按事件ID补发
+	AppendStreamEvent(sessionID string, event StreamEvent) (string, error)                                       // 追加事件，返回事件ID
+	ReadStreamEvents(ctx context.Context, sessionID, afterID string, block time.Duration) ([]StreamEvent, error) // 读取之后的事件
+	ResetStreamEvents(sessionID string) error                                                                    // 清空事件
+	SaveWithRetry(sessionID string, role, content string, userID uint, maxRetries int) error                     // 带重试的保存
+	SaveReplyWithRetry(sessionID, content string, servedBy ServedBy, usage TokenUsage, userID uint, maxRetries int) error
+
+	// 多实例共享的生成登记和停止请求，见 GenerationSignal.go
+	ClaimGeneration(sessionID string, userID uint, jobID string, ttl time.Duration) (bool, error)
+	ReleaseGeneration(sessionID string, userID uint, jobID string) error
+	GenerationOwner(sessionID string) (uint, bool, error)
+	RequestStopGeneration(sessionID string) error
+	GenerationStopRequested(sessionID string) (bool, error)
 }
 
 var GlobalCacheService CacheServiceInterface
@@ -45,6 +56,7 @@ func NewCacheService(client *redis.Client, available bool) CacheServiceInterface
 	service := &CacheService{
 		redisClient: client,
 		available:   available,
+		memoryLog:   newMemoryStreamLog(),
 	}
 	GlobalCacheService = service
 	return service
@@ -89,8 +101,12 @@ func (cs *CacheService) CacheModelConfig(modelName string, model *database.UserA
 		return nil // 降级：直接返回成功
 	}
 
+	// 密钥不写入缓存
+	cached := *model
+	cached.APIKey, cached.DataKey = "", ""
+
 	ctx := context.Background()
-	data, err := json.Marshal(model)
+	data, err := json.Marshal(cached)
 	if err != nil {
 		return err
 	}
@@ -129,59 +145,34 @@ func (cs *CacheService) GetCachedFullSession(sessionID string) (*CachedSession,
 	return &cachedSession, err
 }
 
-// AppendStreamResponse 增量追加流式响应到 Redis
-func (cs *CacheService) AppendStreamResponse(sessionID string, chunk string) error {
+// DeleteCachedFullSession 删除完整会话状态，下次请求时从数据库重建
+func (cs *CacheService) DeleteCachedFullSession(sessionID string) error {
 	if cs.redisClient == nil {
-		return nil // 降级：Redis 不可用时不报错
+		return nil
 	}
 
 	ctx := context.Background()
-	key := "stream_response:" + sessionID
-
-	// 先检查 key 是否存在，不存在则设置过期时间
-	exists, _ := cs.redisClient.Exists(ctx, key).Result()
-	if err := cs.redisClient.Append(ctx, key, chunk).Err(); err != nil {
-		return err
-	}
-
-	// 首次写入时设置 1 小时过期
-	if exists == 0 {
-		cs.redisClient.Expire(ctx, key, 10*time.Minute)
-	}
-
-	return cs.redisClient.Append(ctx, key, chunk).Err()
+	return cs.redisClient.Del(ctx, "full_session:"+sessionID).Err()
 }
 
-// GetStreamResponse 获取完整的流式响应
-func (cs *CacheService) GetStreamResponse(sessionID string) (string, error) {
-	if cs.redisClient == nil {
-		return "", errors.New("redis不可用")
-	}
-
-	ctx := context.Background()
-	key := "stream_response:" + sessionID
-	result, err := cs.redisClient.Get(ctx, key).Result()
-	if errors.Is(err, redis.Nil) {
-		return "", nil // 缓存不存在，返回空字符串
-	}
-	return result, err
+// SaveWithRetry 带重试的消息保存
+func (cs *CacheService) SaveWithRetry(sessionID string, role, content string, userID uint, maxRetries int) error {
+	return retrySave(maxRetries, func() error {
+		return GlobalSessionManager.SaveMessage(sessionID, role, content, userID)
+	})
 }
 
-// DeleteStreamResponse 删除流式响应缓存
-func (cs *CacheService) DeleteStreamResponse(sessionID string) error {
-	if cs.redisClient == nil {
-		return nil
-	}
-
-	ctx := context.Background()
-	return cs.redisClient.Del(ctx, "stream_response:"+sessionID).Err()
+// SaveReplyWithRetry 带重试的模型回复保存
+func (cs *CacheService) SaveReplyWithRetry(sessionID, content string, servedBy ServedBy, usage TokenUsage, userID uint, maxRetries int) error {
+	return retrySave(maxRetries, func() error {
+		return GlobalSessionManager.SaveReply(sessionID, content, servedBy, usage, userID)
+	})
 }
 
-// SaveWithRetry 带重试的消息保存
-func (cs *CacheService) SaveWithRetry(sessionID string, role, content string, userID uint, maxRetries int) error {
+func retrySave(maxRetries int, save func() error) error {
 	var lastErr error
 	for i := 0; i < maxRetries; i++ {
-		err := GlobalSessionManager.SaveMessage(sessionID, role, content, userID)
+		err := save()
 		if err == nil {
 			return nil // 成功则退出
 		}
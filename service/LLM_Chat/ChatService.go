@@ -1,6 +1,7 @@
 package LLM_Chat
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/sashabaranov/go-openai"
@@ -12,21 +13,42 @@ import (
 type ChatServiceInterface interface {
 	CreateChatSession(sessionID, modelName string, UserId uint) (*database.ChatSession, error)
 	SaveChatMessage(sessionID, role, content string, UserId uint) error
-	GetChatMessages(sessionID string, cursor uint, limit int) ([]database.ChatMessage, uint, bool, error)
+	SaveChatMessageWithParts(sessionID, role, content string, parts []database.MessagePart, UserId uint) error
+	SaveAssistantMessage(sessionID, content string, servedBy ServedBy, usage TokenUsage, UserId uint) error
+	SaveToolMessage(sessionID string, UserId uint, message openai.ChatCompletionMessage) error
+	GetChatMessages(sessionID string, UserId uint, cursor uint, limit int) ([]database.ChatMessage, uint, bool, error)
 	GetChatSessions(UserId uint, page, pageSize int) ([]database.ChatSession, int64, error) // 返回会话列表 + 总数
 	GetChatSession(sessionID string, UserId uint) (*database.ChatSession, error)
-	DeleteChatSession(sessionID string) error
-	UpdateSessionTitle(sessionID, title string) error
-	GetRecentChatMessages(sessionID string, limit int) ([]openai.ChatCompletionMessage, error)
+	DeleteChatSession(sessionID string, UserId uint) error
+	UpdateSessionGenerationParams(sessionID string, UserId uint, params database.GenerationParams) (*database.ChatSession, error)
+	GetRecentChatMessages(sessionID string, UserId uint, limit int) ([]openai.ChatCompletionMessage, error)
+
+	// UpdateSessionSummaryEnabled 滚动摘要相关
+	UpdateSessionSummaryEnabled(sessionID string, UserId uint, enabled bool) error
+	GetLatestSummary(sessionID string, UserId uint) (*database.ChatMessage, error)
+	GetMessagesAfter(sessionID string, UserId uint, afterID uint) ([]database.ChatMessage, error)
+	SaveSummary(sessionID string, UserId uint, content string, untilID uint) error
 
 	// RootGetAllSessions ← 新增：管理员功能
 	RootGetAllSessions(page, pageSize int) ([]database.ChatSession, int64, error)
 	RootGetSessionMessages(sessionID string) ([]database.ChatMessage, error)
 	RootDeleteSession(sessionID string) error
+
+	// 分支：编辑用户消息、重新生成回复会产生兄弟消息，会话只沿当前分支展示和发送
+	EditUserMessage(sessionID string, UserId uint, messageID uint, content string) (*database.ChatMessage, error)
+	BranchForRegenerate(sessionID string, UserId uint, messageID uint) (*database.ChatMessage, error)
+	RestoreActiveLeaf(sessionID string, UserId uint, leafID, expectedLeafID uint) error
+	GetSiblings(sessionID string, UserId uint, messageID uint) ([]database.ChatMessage, error)
+	SwitchBranch(sessionID string, UserId uint, messageID uint) (uint, error)
+	GetLastMessage(sessionID string, UserId uint) (*database.ChatMessage, error)
+	AppendToMessage(sessionID string, UserId uint, messageID uint, content string, servedBy ServedBy, usage TokenUsage) error
 }
 
 var GlobalChatService ChatServiceInterface
 
+// ErrSessionNotFound 会话不存在或不属于当前用户（两种情况不做区分，避免泄露其他用户的会话）
+var ErrSessionNotFound = errors.New("会话不存在")
+
 type ChatSessionService struct {
 	db *gorm.DB
 }
@@ -50,10 +72,16 @@ func (s *ChatSessionService) CreateChatSession(sessionID, modelName string, User
 		return nil, errors.New("sessionID、modelName 和 UserId 不能为空")
 	}
 
-	// 检查是否已存在
+	// 检查是否已存在，会话ID已被其他用户使用时视为不存在
 	var existingSession database.ChatSession
-	result := s.db.Where("session_id = ? AND user_id = ?", sessionID, UserId).First(&existingSession)
+	result := s.db.Where("session_id = ?", sessionID).Limit(1).Find(&existingSession)
+	if result.Error != nil {
+		return nil, result.Error
+	}
 	if result.RowsAffected > 0 {
+		if existingSession.UserID != UserId {
+			return nil, ErrSessionNotFound
+		}
 		return &existingSession, nil
 	}
 
@@ -80,20 +108,49 @@ func (s *ChatSessionService) CreateChatSession(sessionID, modelName string, User
 
 // SaveChatMessage 保存聊天消息
 func (s *ChatSessionService) SaveChatMessage(sessionID, role, content string, UserId uint) error {
-	if sessionID == "" || role == "" || content == "" {
+	return s.SaveChatMessageWithParts(sessionID, role, content, nil, UserId)
+}
+
+// SaveChatMessageWithParts 保存带多部分内容（如图片）的聊天消息，Content 保存其中的文本
+func (s *ChatSessionService) SaveChatMessageWithParts(sessionID, role, content string, parts []database.MessagePart, UserId uint) error {
+	return s.saveMessage(&database.ChatMessage{
+		SessionID: sessionID,
+		Role:      role,
+		Content:   content,
+		Parts:     parts,
+	}, UserId)
+}
+
+// SaveAssistantMessage 保存模型回复，并记录实际生成它的 API 配置和 token 用量
+func (s *ChatSessionService) SaveAssistantMessage(sessionID, content string, servedBy ServedBy, usage TokenUsage, UserId uint) error {
+	return s.saveMessage(&database.ChatMessage{
+		SessionID:        sessionID,
+		Role:             "assistant",
+		Content:          content,
+		APIID:            servedBy.APIID,
+		ServedModel:      servedBy.Model,
+		PromptTokens:     usage.PromptTokens,
+		CompletionTokens: usage.CompletionTokens,
+		Cost:             usageCost(servedBy.Model, usage),
+	}, UserId)
+}
+
+// saveMessage 把消息接在当前分支末尾并更新会话的消息计数
+func (s *ChatSessionService) saveMessage(message *database.ChatMessage, UserId uint) error {
+	sessionID, role, content := message.SessionID, message.Role, message.Content
+	if sessionID == "" || role == "" || (content == "" && len(message.Parts) == 0) {
 		return errors.New("sessionID, role 和 content 不能为空")
 	}
 
 	// 事务：创建消息 + 更新计数（这两个必须保证一致性）
 	err := s.db.Transaction(func(tx *gorm.DB) error {
-		// 1. 创建消息
-		message := &database.ChatMessage{
-			SessionID: sessionID,
-			Role:      role,
-			Content:   content,
+		// 1. 创建消息，接在当前分支末尾
+		if err := appendToActiveBranch(tx, message); err != nil {
+			return err
 		}
-		if err := tx.Create(message).Error; err != nil {
-			return fmt.Errorf("创建消息失败: %w", err)
+		usage := TokenUsage{PromptTokens: message.PromptTokens, CompletionTokens: message.CompletionTokens}
+		if err := recordUsage(tx, UserId, sessionID, message.APIID, message.ServedModel, usage, message.Cost); err != nil {
+			return err
 		}
 
 		// 2. 更新会话的消息计数
@@ -114,13 +171,104 @@ func (s *ChatSessionService) SaveChatMessage(sessionID, role, content string, Us
 	}
 
 	// 非事务：更新标题（允许失败，不影响消息保存）
-	if role == "user" {
+	if role == "user" && content != "" {
 		go s.updateSessionTitle(sessionID, content)
 	}
 
 	return nil
 }
 
+// SaveToolMessage 保存工具调用过程中的消息（带 tool_calls 的 assistant 消息或 tool 消息）
+// 这些消息只用于恢复上下文，不计入会话消息数
+func (s *ChatSessionService) SaveToolMessage(sessionID string, UserId uint, message openai.ChatCompletionMessage) error {
+	if sessionID == "" {
+		return errors.New("sessionID 不能为空")
+	}
+
+	record := &database.ChatMessage{
+		SessionID:  sessionID,
+		Role:       message.Role,
+		Content:    message.Content,
+		ToolCallID: message.ToolCallID,
+	}
+	if len(message.ToolCalls) > 0 {
+		data, err := json.Marshal(message.ToolCalls)
+		if err != nil {
+			return fmt.Errorf("序列化工具调用失败: %w", err)
+		}
+		record.ToolCalls = string(data)
+	}
+
+	if err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkSessionOwner(tx, sessionID, UserId); err != nil {
+			return err
+		}
+		return appendToActiveBranch(tx, record)
+	}); err != nil {
+		return fmt.Errorf("保存工具消息失败: %w", err)
+	}
+	return nil
+}
+
+// ToChatCompletionMessage 把数据库消息转换为发送给模型的消息，图片以文字占位（用于摘要、计数等不需要图片数据的场景）
+func ToChatCompletionMessage(msg database.ChatMessage) openai.ChatCompletionMessage {
+	return toChatCompletionMessage(msg, nil)
+}
+
+// toChatCompletionMessage 转换数据库消息，loadImage 为 nil 时图片以文字占位
+func toChatCompletionMessage(msg database.ChatMessage, loadImage func(part database.MessagePart) (string, error)) openai.ChatCompletionMessage {
+	chatMessage := openai.ChatCompletionMessage{
+		Role:       msg.Role,
+		Content:    msg.Content,
+		ToolCallID: msg.ToolCallID,
+	}
+	if msg.ToolCalls != "" {
+		if err := json.Unmarshal([]byte(msg.ToolCalls), &chatMessage.ToolCalls); err != nil {
+			log.Printf("解析工具调用失败 (message: %d): %v", msg.ID, err)
+		}
+	}
+	if len(msg.Parts) == 0 {
+		return chatMessage
+	}
+
+	// 多部分消息只能使用 MultiContent，Content 必须为空
+	chatMessage.Content = ""
+	for _, part := range msg.Parts {
+		switch part.Type {
+		case database.MessagePartText:
+			chatMessage.MultiContent = append(chatMessage.MultiContent, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeText,
+				Text: part.Text,
+			})
+		case database.MessagePartImage:
+			placeholder := "[图片]"
+			if loadImage != nil {
+				dataURL, err := loadImage(part)
+				if err == nil {
+					chatMessage.MultiContent = append(chatMessage.MultiContent, NewImagePart(dataURL))
+					continue
+				}
+				log.Printf("加载图片失败 (message: %d, file: %d): %v", msg.ID, part.FileID, err)
+				placeholder = "[图片已失效]"
+			}
+			chatMessage.MultiContent = append(chatMessage.MultiContent, openai.ChatMessagePart{
+				Type: openai.ChatMessagePartTypeText,
+				Text: placeholder,
+			})
+		}
+	}
+	return chatMessage
+}
+
+// loadImagePart 读取消息中引用的图片文件并编码为 data URL
+func (s *ChatSessionService) loadImagePart(part database.MessagePart) (string, error) {
+	var file database.UploadedFile
+	if err := s.db.First(&file, part.FileID).Error; err != nil {
+		return "", fmt.Errorf("图片文件不存在: %w", err)
+	}
+	return LoadImageDataURL(&file)
+}
+
 // updateSessionTitle 更新会话标题（异步，允许失败）
 func (s *ChatSessionService) updateSessionTitle(sessionID, content string) {
 	var messageCount int64
@@ -141,34 +289,54 @@ func (s *ChatSessionService) updateSessionTitle(sessionID, content string) {
 	}
 }
 
-// GetChatMessages 获取会话的所有消息
-func (s *ChatSessionService) GetChatMessages(sessionID string, cursor uint, limit int) ([]database.ChatMessage, uint, bool, error) {
+// checkSessionOwner 确认会话属于指定用户，否则返回 ErrSessionNotFound
+func (s *ChatSessionService) checkSessionOwner(tx *gorm.DB, sessionID string, UserId uint) error {
+	var count int64
+	if err := tx.Model(&database.ChatSession{}).
+		Where("session_id = ? AND user_id = ?", sessionID, UserId).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("查询会话失败: %w", err)
+	}
+	if count == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// GetChatMessages 获取用户会话当前分支上的消息
+func (s *ChatSessionService) GetChatMessages(sessionID string, UserId uint, cursor uint, limit int) ([]database.ChatMessage, uint, bool, error) {
 	if sessionID == "" {
 		return nil, 0, false, errors.New("sessionID 不能为空")
 	}
+	if err := s.checkSessionOwner(s.db, sessionID, UserId); err != nil {
+		return nil, 0, false, err
+	}
 
 	// 设置默认值和上限
 	if limit <= 0 || limit > 100 {
 		limit = 50
 	}
 
-	var messages []database.ChatMessage
-	query := s.db.Where("session_id = ?", sessionID)
+	path, err := activePathIDs(s.db, sessionID)
+	if err != nil {
+		return nil, 0, false, err
+	}
 
-	// 基于 ID 游标分页（获取比 cursor 更早的消息）
+	// 基于 ID 游标分页（获取比 cursor 更早的消息），路径上的 ID 从根到叶子递增
+	end := len(path)
 	if cursor > 0 {
-		query = query.Where("id < ?", cursor)
+		for end > 0 && path[end-1] >= cursor {
+			end--
+		}
 	}
-
-	// 按 ID 降序获取，这样最新的消息在前
-	result := query.Order("id DESC").Limit(limit).Find(&messages)
-	if result.Error != nil {
-		return nil, 0, false, result.Error
+	start := end - limit
+	if start < 0 {
+		start = 0
 	}
 
-	// 反转消息顺序，使最早的消息在前
-	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
-		messages[i], messages[j] = messages[j], messages[i]
+	messages, err := findMessages(s.db, path[start:end])
+	if err != nil {
+		return nil, 0, false, err
 	}
 
 	// 计算下一页 cursor（当前批次中最小的 ID）
@@ -177,7 +345,7 @@ func (s *ChatSessionService) GetChatMessages(sessionID string, cursor uint, limi
 		nextCursor = messages[0].ID
 	}
 
-	return messages, nextCursor, len(messages) == limit, nil
+	return messages, nextCursor, start > 0, nil
 }
 
 // GetChatSessions 获取指定用户的所有聊天会话
@@ -224,19 +392,31 @@ func (s *ChatSessionService) GetChatSession(sessionID string, UserId uint) (*dat
 	var session database.ChatSession
 	result := s.db.Where("session_id = ? AND user_id = ?", sessionID, UserId).First(&session)
 	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
 		return nil, result.Error
 	}
 
 	return &session, nil
 }
 
-// DeleteChatSession 删除聊天会话及其所有消息
-func (s *ChatSessionService) DeleteChatSession(sessionID string) error {
+// DeleteChatSession 删除用户的聊天会话及其所有消息和文件
+func (s *ChatSessionService) DeleteChatSession(sessionID string, UserId uint) error {
 	if sessionID == "" {
 		return errors.New("sessionID 不能为空")
 	}
+	if err := s.checkSessionOwner(s.db, sessionID, UserId); err != nil {
+		return err
+	}
+	if err := deleteSessionFiles(sessionID); err != nil {
+		return err
+	}
 	// 开启事务
 	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.checkSessionOwner(tx, sessionID, UserId); err != nil {
+			return err
+		}
 		// 删除所有相关消息
 		if err := tx.Where("session_id = ?", sessionID).Delete(&database.ChatMessage{}).Error; err != nil {
 			return err
@@ -250,21 +430,54 @@ func (s *ChatSessionService) DeleteChatSession(sessionID string) error {
 	})
 }
 
-// UpdateSessionTitle 更新会话标题
-func (s *ChatSessionService) UpdateSessionTitle(sessionID, title string) error {
-	if sessionID == "" || title == "" {
-		return errors.New("sessionID 和 title 不能为空")
+// deleteSessionFiles 在删除会话前删除其中的文件：文件记录是内容的引用，会话删除后这些记录既无法访问也无法清理
+func deleteSessionFiles(sessionID string) error {
+	if GlobalFileService == nil {
+		return nil
+	}
+	if err := GlobalFileService.DeleteSessionFiles(sessionID); err != nil {
+		return fmt.Errorf("删除会话文件失败: %w", err)
 	}
+	return nil
+}
 
-	result := s.db.Model(&database.ChatSession{}).
-		Where("session_id = ?", sessionID).
-		Update("title", title)
+// UpdateSessionGenerationParams 更新会话级生成参数（整体替换，未设置的字段恢复为提供商默认值）
+func (s *ChatSessionService) UpdateSessionGenerationParams(sessionID string, UserId uint, params database.GenerationParams) (*database.ChatSession, error) {
+	if sessionID == "" {
+		return nil, errors.New("sessionID 不能为空")
+	}
 
-	return result.Error
+	var session database.ChatSession
+	if err := s.db.Where("session_id = ? AND user_id = ?", sessionID, UserId).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, fmt.Errorf("查询会话失败: %w", err)
+	}
+
+	// 只写入生成参数列（Select 使 nil 参数也被清空），不覆盖同时进行的生成更新的消息数、当前分支等字段
+	session.GenerationParams = params
+	if err := s.db.Model(&database.ChatSession{}).
+		Where("session_id = ? AND user_id = ?", sessionID, UserId).
+		Select(generationParamColumns).
+		Updates(&session).Error; err != nil {
+		return nil, fmt.Errorf("更新生成参数失败: %w", err)
+	}
+
+	if err := s.db.Where("session_id = ? AND user_id = ?", sessionID, UserId).First(&session).Error; err != nil {
+		return nil, fmt.Errorf("查询会话失败: %w", err)
+	}
+	return &session, nil
+}
+
+// generationParamColumns 会话级生成参数对应的列（GenerationParams 以 gen_ 为前缀嵌入 ChatSession）
+var generationParamColumns = []string{
+	"gen_temperature", "gen_top_p", "gen_max_tokens", "gen_presence_penalty",
+	"gen_frequency_penalty", "gen_stop", "gen_seed", "updated_at",
 }
 
 // GetRecentChatMessages 获取会话的最新 N 条消息（用于恢复会话状态）
-func (s *ChatSessionService) GetRecentChatMessages(sessionID string, limit int) ([]openai.ChatCompletionMessage, error) {
+func (s *ChatSessionService) GetRecentChatMessages(sessionID string, UserId uint, limit int) ([]openai.ChatCompletionMessage, error) {
 	if sessionID == "" {
 		return nil, errors.New("sessionID 不能为空")
 	}
@@ -277,36 +490,123 @@ func (s *ChatSessionService) GetRecentChatMessages(sessionID string, limit int)
 		limit = 100 // 最大 100 条
 	}
 
-	var messages []database.ChatMessage
-	// 先按 ID 倒序获取最新的 limit 条
-	result := s.db.Where("session_id = ?", sessionID).
-		Order("id DESC").
-		Limit(limit).
-		Find(&messages)
+	// 已被摘要覆盖的消息不再加载，由摘要代替
+	var afterID uint
+	summary, err := s.GetLatestSummary(sessionID, UserId)
+	if err != nil {
+		return nil, err
+	}
+	if summary != nil {
+		afterID = summary.SummarizedUntilID
+	}
 
-	if result.Error != nil {
-		return nil, result.Error
+	// 取当前分支上摘要之后的最新 limit 条
+	path, err := activePathIDs(s.db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	path = pathAfter(path, afterID)
+	if len(path) > limit {
+		path = path[len(path)-limit:]
 	}
 
-	// 反转顺序，使消息按时间正序排列
-	// 检查是否有 nil 值
-	if messages == nil {
-		return []openai.ChatCompletionMessage{}, nil
+	messages, err := findMessages(s.db, path)
+	if err != nil {
+		return nil, err
 	}
 
 	chatMessages := make([]openai.ChatCompletionMessage, len(messages))
-	for i := 0; i < len(messages); i++ {
-		// 从后往前遍历，实现反转
-		msg := messages[len(messages)-1-i]
-		chatMessages[i] = openai.ChatCompletionMessage{
-			Role:    msg.Role,
-			Content: msg.Content,
-		}
+	for i, msg := range messages {
+		chatMessages[i] = toChatCompletionMessage(msg, s.loadImagePart)
 	}
 
 	return chatMessages, nil
 }
 
+// UpdateSessionSummaryEnabled 开启或关闭会话的滚动摘要
+func (s *ChatSessionService) UpdateSessionSummaryEnabled(sessionID string, UserId uint, enabled bool) error {
+	if sessionID == "" {
+		return errors.New("sessionID 不能为空")
+	}
+
+	result := s.db.Model(&database.ChatSession{}).
+		Where("session_id = ? AND user_id = ?", sessionID, UserId).
+		Update("summary_enabled", enabled)
+	if result.Error != nil {
+		return fmt.Errorf("更新摘要设置失败: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// GetLatestSummary 获取当前分支最新的摘要，没有摘要时返回 nil
+// 摘要覆盖到的消息在当前分支上时，它之前的对话与当前分支相同，摘要才适用
+func (s *ChatSessionService) GetLatestSummary(sessionID string, UserId uint) (*database.ChatMessage, error) {
+	if err := s.checkSessionOwner(s.db, sessionID, UserId); err != nil {
+		return nil, err
+	}
+	path, err := activePathIDs(s.db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	onPath := make(map[uint]bool, len(path))
+	for _, id := range path {
+		onPath[id] = true
+	}
+
+	var summaries []database.ChatMessage
+	if err := s.db.Where("session_id = ? AND role = ?", sessionID, database.MessageRoleSummary).
+		Order("id DESC").
+		Find(&summaries).Error; err != nil {
+		return nil, fmt.Errorf("查询摘要失败: %w", err)
+	}
+	for i := range summaries {
+		if onPath[summaries[i].SummarizedUntilID] {
+			return &summaries[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// GetMessagesAfter 获取当前分支上指定ID之后的所有对话消息（不含摘要），按时间正序
+func (s *ChatSessionService) GetMessagesAfter(sessionID string, UserId uint, afterID uint) ([]database.ChatMessage, error) {
+	if sessionID == "" {
+		return nil, errors.New("sessionID 不能为空")
+	}
+	if err := s.checkSessionOwner(s.db, sessionID, UserId); err != nil {
+		return nil, err
+	}
+
+	path, err := activePathIDs(s.db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return findMessages(s.db, pathAfter(path, afterID))
+}
+
+// SaveSummary 保存滚动摘要（不计入会话消息数）
+func (s *ChatSessionService) SaveSummary(sessionID string, UserId uint, content string, untilID uint) error {
+	if sessionID == "" || content == "" {
+		return errors.New("sessionID 和 content 不能为空")
+	}
+	if err := s.checkSessionOwner(s.db, sessionID, UserId); err != nil {
+		return err
+	}
+
+	summary := &database.ChatMessage{
+		SessionID:         sessionID,
+		Role:              database.MessageRoleSummary,
+		Content:           content,
+		SummarizedUntilID: untilID,
+	}
+	if err := s.db.Create(summary).Error; err != nil {
+		return fmt.Errorf("保存摘要失败: %w", err)
+	}
+	return nil
+}
+
 // ====== ROOT ======
 
 // RootGetAllSessions 管理员获取所有会话（可按用户筛选）
@@ -356,6 +656,10 @@ func (s *ChatSessionService) RootGetSessionMessages(sessionID string) ([]databas
 
 // RootDeleteSession 管理员删除会话（硬删除或软删除都可以）
 func (s *ChatSessionService) RootDeleteSession(sessionID string) error {
+	if err := deleteSessionFiles(sessionID); err != nil {
+		return err
+	}
+
 	// 使用事务确保数据一致性
 	return s.db.Transaction(func(tx *gorm.DB) error {
 		// 1. 删除所有消息
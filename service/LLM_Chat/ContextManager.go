@@ -0,0 +1,297 @@
+package LLM_Chat
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/sashabaranov/go-openai"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	defaultContextLength   = 8192 // 未知模型的默认上下文长度
+	defaultReplyTokens     = 1024 // 未设置 max_tokens 时为回复预留的 token 数
+	messageOverheadTokens  = 4    // 每条消息的角色、分隔符等额外开销
+	maxStoredHistoryLength = 200  // 内存中最多保留的历史消息条数
+)
+
+// summaryPrefix 注入上下文时摘要消息的前缀
+const summaryPrefix = "以下是之前对话的摘要，请结合摘要继续对话：\n"
+
+// modelFamily 模型系列：上下文长度和分词使用的 tiktoken 词表。
+// ExactEncoding 为 false 的系列没有公开词表，用 cl100k 近似
+type modelFamily struct {
+	Prefix        string
+	Length        int
+	Encoding      string
+	ExactEncoding bool
+}
+
+// modelFamilies 常见模型系列，按前缀匹配（取最长的前缀）
+var modelFamilies = []modelFamily{
+	{"gpt-4o", 128000, tiktoken.MODEL_O200K_BASE, true},
+	{"gpt-4.1", 1047576, tiktoken.MODEL_O200K_BASE, true},
+	{"gpt-4-turbo", 128000, tiktoken.MODEL_CL100K_BASE, true},
+	{"gpt-4-32k", 32768, tiktoken.MODEL_CL100K_BASE, true},
+	{"gpt-4", 8192, tiktoken.MODEL_CL100K_BASE, true},
+	{"gpt-3.5-turbo", 16385, tiktoken.MODEL_CL100K_BASE, true},
+	{"o1", 200000, tiktoken.MODEL_O200K_BASE, true},
+	{"o3", 200000, tiktoken.MODEL_O200K_BASE, true},
+	{"o4", 200000, tiktoken.MODEL_O200K_BASE, true},
+	{"claude", 200000, tiktoken.MODEL_CL100K_BASE, false},
+	{"deepseek", 65536, tiktoken.MODEL_CL100K_BASE, false},
+	{"qwen-long", 1000000, tiktoken.MODEL_CL100K_BASE, false},
+	{"qwen", 32768, tiktoken.MODEL_CL100K_BASE, false},
+	{"moonshot-v1-128k", 131072, tiktoken.MODEL_CL100K_BASE, false},
+	{"moonshot-v1-32k", 32768, tiktoken.MODEL_CL100K_BASE, false},
+	{"moonshot-v1-8k", 8192, tiktoken.MODEL_CL100K_BASE, false},
+	{"glm-4", 128000, tiktoken.MODEL_CL100K_BASE, false},
+	{"llama3", 8192, tiktoken.MODEL_CL100K_BASE, false},
+	{"llama3.1", 131072, tiktoken.MODEL_CL100K_BASE, false},
+	{"mistral", 32768, tiktoken.MODEL_CL100K_BASE, false},
+	{"gemini", 1048576, tiktoken.MODEL_CL100K_BASE, false},
+}
+
+// TokenizerInterface 分词器接口，用于估算文本占用的 token 数
+type TokenizerInterface interface {
+	CountTokens(text string) int
+}
+
+// HeuristicTokenizer 启发式分词器：中日韩字符约 1 字 1 token，其余字符约 4 个 1 token
+// 不依赖具体模型的词表，只用于未知模型；代码、base64 等内容可能估少
+type HeuristicTokenizer struct{}
+
+func (t *HeuristicTokenizer) CountTokens(text string) int {
+	cjk, other := 0, 0
+	for _, r := range text {
+		if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+			cjk++
+		} else {
+			other++
+		}
+	}
+	return cjk + (other+3)/4
+}
+
+// ContextManager 按 token 预算构造发送给模型的上下文
+type ContextManager struct {
+	Tokenizer     TokenizerInterface
+	ContextLength int // 模型上下文长度（或用户配置的预算）
+}
+
+// NewContextManager 创建上下文管理器，budget > 0 时使用用户配置的预算，否则使用模型的上下文长度
+func NewContextManager(modelName string, budget int) *ContextManager {
+	contextLength := ModelContextLength(modelName)
+	if budget > 0 && budget < contextLength {
+		contextLength = budget
+	}
+	return &ContextManager{
+		Tokenizer:     NewTokenizer(modelName),
+		ContextLength: contextLength,
+	}
+}
+
+// ModelContextLength 根据模型名称查询上下文长度，未知模型返回默认值
+func ModelContextLength(modelName string) int {
+	if family, found := lookupModelFamily(modelName); found {
+		return family.Length
+	}
+	return defaultContextLength
+}
+
+// lookupModelFamily 按前缀查找模型所属的系列
+func lookupModelFamily(modelName string) (modelFamily, bool) {
+	name := strings.ToLower(modelName)
+	// 去掉 "deepseek/deepseek-chat"、"library/llama3" 之类的命名空间前缀
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[idx+1:]
+	}
+
+	var best modelFamily
+	for _, family := range modelFamilies {
+		if strings.HasPrefix(name, family.Prefix) && len(family.Prefix) > len(best.Prefix) {
+			best = family
+		}
+	}
+	return best, best.Prefix != ""
+}
+
+// CountMessageTokens 估算单条消息占用的 token 数
+func (cm *ContextManager) CountMessageTokens(msg openai.ChatCompletionMessage) int {
+	tokens := messageOverheadTokens + cm.Tokenizer.CountTokens(msg.Content)
+	for _, part := range msg.MultiContent {
+		tokens += cm.Tokenizer.CountTokens(part.Text)
+		if part.Type == openai.ChatMessagePartTypeImageURL {
+			tokens += imageTokenCost
+		}
+	}
+	for _, call := range msg.ToolCalls {
+		tokens += messageOverheadTokens + cm.Tokenizer.CountTokens(call.Function.Name) + cm.Tokenizer.CountTokens(call.Function.Arguments)
+	}
+	return tokens
+}
+
+// BuildContext 构造发送给模型的消息列表
+// 系统提示词和本轮（最后一条用户消息及其后的工具调用）总是保留，剩余预算从最近的历史开始向前填充，
+// maxTokens 为回复预留的 token 数（<= 0 时使用默认值）
+func (cm *ContextManager) BuildContext(systemPrompt string, history []openai.ChatCompletionMessage, maxTokens int) []openai.ChatCompletionMessage {
+	return cm.BuildContextWithSummary(systemPrompt, "", history, maxTokens)
+}
+
+// BuildContextWithSummary 同 BuildContext，summary 不为空时作为系统消息放在系统提示词之后
+func (cm *ContextManager) BuildContextWithSummary(systemPrompt, summary string, history []openai.ChatCompletionMessage, maxTokens int) []openai.ChatCompletionMessage {
+	systemMessages := []string{systemPrompt}
+	if summary != "" {
+		systemMessages = append(systemMessages, summaryPrefix+summary)
+	}
+	return cm.BuildContextWithSystem(systemMessages, history, maxTokens)
+}
+
+// BuildContextWithSystem 同 BuildContext，systemMessages 依次作为系统消息放在最前面（空字符串跳过）
+func (cm *ContextManager) BuildContextWithSystem(systemMessages []string, history []openai.ChatCompletionMessage, maxTokens int) []openai.ChatCompletionMessage {
+	if maxTokens <= 0 {
+		maxTokens = defaultReplyTokens
+	}
+	// 回复预留不超过上下文的一半，避免把历史全部挤掉
+	if maxTokens > cm.ContextLength/2 {
+		maxTokens = cm.ContextLength / 2
+	}
+	budget := cm.ContextLength - maxTokens
+
+	var result []openai.ChatCompletionMessage
+	for _, content := range systemMessages {
+		if content == "" {
+			continue
+		}
+		systemMsg := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleSystem, Content: content}
+		result = append(result, systemMsg)
+		budget -= cm.CountMessageTokens(systemMsg)
+	}
+
+	if len(history) == 0 {
+		return result
+	}
+
+	// 本轮（最后一条用户消息及其后的工具调用和工具结果）必须完整保留，超出预算时截断其内容
+	pin := currentTurnStart(history)
+	pinned := cm.fitMessages(history[pin:], budget)
+	for _, msg := range pinned {
+		budget -= cm.CountMessageTokens(msg)
+	}
+
+	// 从近到远按块填充历史，工具调用和它的工具结果作为一个整体保留或丢弃
+	start := pin
+	for start > 0 {
+		blockStart := toolBlockStart(history, start-1)
+		cost := 0
+		for _, msg := range history[blockStart:start] {
+			cost += cm.CountMessageTokens(msg)
+		}
+		if cost > budget {
+			break
+		}
+		budget -= cost
+		start = blockStart
+	}
+
+	// 从用户消息开始，避免上下文从半轮对话或孤立的工具结果开始
+	for start < pin && history[start].Role != openai.ChatMessageRoleUser {
+		start++
+	}
+
+	result = append(result, history[start:pin]...)
+	return append(result, pinned...)
+}
+
+// currentTurnStart 返回本轮的起点：最后一条用户消息；没有用户消息时为最后一个消息块的起点
+func currentTurnStart(history []openai.ChatCompletionMessage) int {
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Role == openai.ChatMessageRoleUser {
+			return i
+		}
+	}
+	return toolBlockStart(history, len(history)-1)
+}
+
+// toolBlockStart i 是工具结果时返回发起这次调用的 assistant 消息的下标，否则返回 i
+func toolBlockStart(history []openai.ChatCompletionMessage, i int) int {
+	start := i
+	for start > 0 && history[start].Role == openai.ChatMessageRoleTool {
+		start--
+	}
+	if start < i && (history[start].Role != openai.ChatMessageRoleAssistant || len(history[start].ToolCalls) == 0) {
+		// 没有对应的工具调用，孤立的工具结果自成一块
+		return start + 1
+	}
+	return start
+}
+
+// fitMessages 保证 messages 总计不超过 budget：内容较短的消息完整保留，
+// 其余消息平分剩余的预算并截断内容。返回副本，不修改原始历史
+func (cm *ContextManager) fitMessages(messages []openai.ChatCompletionMessage, budget int) []openai.ChatCompletionMessage {
+	fitted := make([]openai.ChatCompletionMessage, len(messages))
+	copy(fitted, messages)
+
+	total := 0
+	for _, msg := range fitted {
+		total += cm.CountMessageTokens(msg)
+	}
+	if total <= budget {
+		return fitted
+	}
+
+	// 扣除角色开销、工具调用参数等不可截断的部分，剩余的预算分配给各条消息的内容
+	available := budget
+	contentTokens := make(map[int]int)
+	for i, msg := range fitted {
+		tokens := cm.Tokenizer.CountTokens(msg.Content)
+		available -= cm.CountMessageTokens(msg) - tokens
+		if tokens > 0 {
+			contentTokens[i] = tokens
+		}
+	}
+
+	for len(contentTokens) > 0 {
+		share := available / len(contentTokens)
+		kept := false
+		for i, tokens := range contentTokens {
+			if tokens <= share {
+				available -= tokens
+				delete(contentTokens, i)
+				kept = true
+			}
+		}
+		if !kept {
+			break
+		}
+	}
+	if len(contentTokens) > 0 {
+		share := available / len(contentTokens)
+		for i := range contentTokens {
+			fitted[i].Content = cm.TruncateToTokens(fitted[i].Content, share)
+		}
+	}
+	return fitted
+}
+
+// TruncateToTokens 按 token 数截断文本，用于单条消息本身超过预算的场景
+func (cm *ContextManager) TruncateToTokens(text string, maxTokens int) string {
+	if maxTokens <= 0 {
+		return ""
+	}
+	if cm.Tokenizer.CountTokens(text) <= maxTokens {
+		return text
+	}
+	// 二分查找可保留的最长前缀
+	lo, hi := 0, utf8.RuneCountInString(text)
+	runes := []rune(text)
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		if cm.Tokenizer.CountTokens(string(runes[:mid])) <= maxTokens {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return string(runes[:lo])
+}
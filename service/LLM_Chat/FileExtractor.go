@@ -0,0 +1,230 @@
+package LLM_Chat
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/gabriel-vasile/mimetype"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"mime"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedFileType 没有可用的文本提取器
+var ErrUnsupportedFileType = errors.New("不支持的文件类型")
+
+// ExtractorFunc 从文件内容中提取纯文本，params 为 MIME 类型参数（如 charset）
+type ExtractorFunc func(data []byte, params map[string]string) (string, error)
+
+// ExtractorRegistry 按 MIME 类型注册的文本提取器
+// 类型通过内容嗅探识别，不依赖扩展名；找不到精确匹配时沿父类型查找（如 application/json → text/plain）
+type ExtractorRegistry struct {
+	mu         sync.RWMutex
+	extractors map[string]ExtractorFunc
+}
+
+var GlobalExtractorRegistry = NewExtractorRegistry()
+
+// NewExtractorRegistry 创建注册了内置提取器的注册表
+func NewExtractorRegistry() *ExtractorRegistry {
+	r := &ExtractorRegistry{
+		extractors: make(map[string]ExtractorFunc),
+	}
+	r.Register("text/plain", extractPlainText)
+	r.Register("text/csv", extractDelimited(','))
+	r.Register("text/tab-separated-values", extractDelimited('\t'))
+	r.Register("text/html", extractHTML)
+	r.Register("application/pdf", extractPDF)
+	r.Register("application/vnd.openxmlformats-officedocument.wordprocessingml.document", extractDOCX)
+	r.Register("application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", extractXLSX)
+	return r
+}
+
+// Register 注册提取器，同一类型重复注册时覆盖
+func (r *ExtractorRegistry) Register(mimeType string, extractor ExtractorFunc) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.extractors[mimeType] = extractor
+}
+
+// DetectMIME 通过内容嗅探识别文件类型，返回不含参数的类型和参数
+func DetectMIME(data []byte) (string, map[string]string) {
+	return splitMIME(mimetype.Detect(data))
+}
+
+// splitMIME 拆分类型和参数（如 "text/plain; charset=utf-8"）
+func splitMIME(m *mimetype.MIME) (string, map[string]string) {
+	mediaType, params, err := mime.ParseMediaType(m.String())
+	if err != nil {
+		return m.String(), nil
+	}
+	return mediaType, params
+}
+
+// Extract 识别文件类型并提取文本，返回文本和识别出的 MIME 类型
+func (r *ExtractorRegistry) Extract(data []byte) (string, string, error) {
+	detected := mimetype.Detect(data)
+	mediaType, params := splitMIME(detected)
+
+	r.mu.RLock()
+	var extractor ExtractorFunc
+	for m := detected; m != nil && extractor == nil; m = m.Parent() {
+		name, _ := splitMIME(m)
+		extractor = r.extractors[name]
+	}
+	r.mu.RUnlock()
+
+	if extractor == nil {
+		return "", mediaType, fmt.Errorf("%w: %s", ErrUnsupportedFileType, mediaType)
+	}
+
+	text, err := extractor(data, params)
+	if err != nil {
+		return "", mediaType, fmt.Errorf("提取文件内容失败: %w", err)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return "", mediaType, errors.New("未能从文件中提取到文本内容")
+	}
+	return text, mediaType, nil
+}
+
+// ========== 内置提取器 ==========
+
+// extractPlainText 纯文本，按嗅探出的字符集转为 UTF-8（如 GBK 编码的文本）
+func extractPlainText(data []byte, params map[string]string) (string, error) {
+	return decodeText(data, params["charset"])
+}
+
+// decodeText 按字符集解码为 UTF-8
+func decodeText(data []byte, charset string) (string, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	charset = strings.ToLower(charset)
+	if charset == "" || charset == "utf-8" || charset == "us-ascii" || utf8.Valid(data) {
+		return string(data), nil
+	}
+
+	// 字符集嗅探对 GBK 识别不可靠（常被识别为 iso-8859-1），优先尝试按 GB18030 解码
+	if decoded, ok := decodeGB18030(data); ok {
+		return decoded, nil
+	}
+
+	encoding, err := htmlindex.Get(charset)
+	if err != nil {
+		return "", fmt.Errorf("不支持的字符集: %s", charset)
+	}
+	decoded, err := encoding.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("字符集 %s 解码失败: %v", charset, err)
+	}
+	return string(decoded), nil
+}
+
+// decodeGB18030 按 GB18030 解码，结果不含非法字符且以中文为主时认为解码正确
+func decodeGB18030(data []byte) (string, bool) {
+	decoded, err := simplifiedchinese.GB18030.NewDecoder().Bytes(data)
+	if err != nil || bytes.ContainsRune(decoded, utf8.RuneError) {
+		return "", false
+	}
+
+	han, nonASCII := 0, 0
+	for _, r := range string(decoded) {
+		if r < utf8.RuneSelf {
+			continue
+		}
+		nonASCII++
+		if unicode.Is(unicode.Han, r) || unicode.In(r, unicode.Punct) {
+			han++
+		}
+	}
+	if nonASCII == 0 || han*2 < nonASCII {
+		return "", false
+	}
+	return string(decoded), true
+}
+
+// extractDelimited CSV/TSV，每行单元格以 " | " 分隔输出
+func extractDelimited(separator rune) ExtractorFunc {
+	return func(data []byte, params map[string]string) (string, error) {
+		text, err := decodeText(data, params["charset"])
+		if err != nil {
+			return "", err
+		}
+
+		reader := csv.NewReader(strings.NewReader(text))
+		reader.Comma = separator
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		records, err := reader.ReadAll()
+		if err != nil {
+			return "", err
+		}
+
+		var builder strings.Builder
+		for _, record := range records {
+			builder.WriteString(strings.Join(record, " | "))
+			builder.WriteString("\n")
+		}
+		return builder.String(), nil
+	}
+}
+
+// extractHTML 提取 HTML 的可见文本，跳过脚本和样式，块级元素换行
+func extractHTML(data []byte, params map[string]string) (string, error) {
+	text, err := decodeText(data, params["charset"])
+	if err != nil {
+		return "", err
+	}
+	doc, err := html.Parse(strings.NewReader(text))
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	var walk func(node *html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			switch node.Data {
+			case "script", "style", "noscript", "head", "template":
+				return
+			}
+		}
+		if node.Type == html.TextNode {
+			if content := strings.TrimSpace(node.Data); content != "" {
+				builder.WriteString(content)
+				builder.WriteString(" ")
+			}
+		}
+		for child := node.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+		if node.Type == html.ElementNode && isHTMLBlock(node.Data) {
+			builder.WriteString("\n")
+		}
+	}
+	walk(doc)
+
+	// 合并多余的空行
+	var lines []string
+	for _, line := range strings.Split(builder.String(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func isHTMLBlock(tag string) bool {
+	switch tag {
+	case "p", "div", "br", "li", "tr", "h1", "h2", "h3", "h4", "h5", "h6",
+		"section", "article", "header", "footer", "pre", "blockquote", "table", "ul", "ol":
+		return true
+	}
+	return false
+}
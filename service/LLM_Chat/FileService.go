@@ -1,97 +1,548 @@
 package LLM_Chat
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"gopkg.in/yaml.v3"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"platfrom/database"
 	"strings"
+	"sync"
+	"time"
 )
 
 type FileServiceInterface interface {
+	UploadConfig() database.FileUploadConfig
+	ValidateUpload(fileName string, size int64) error
+	StoreUpload(sessionID, fileName string, src io.Reader, userID uint) (*database.UploadedFile, error)
+	ReadFile(file *database.UploadedFile) ([]byte, error)
 	SaveFile(file *database.UploadedFile) error
-	GetFilesBySession(sessionID string) ([]database.UploadedFile, error)
-	GetFileByID(id uint) (*database.UploadedFile, error)
-	DeleteFile(id uint) error
+
+	// 以下方法只访问 userID 自己会话中的文件，其他用户的会话和文件按不存在处理
+	GetFilesBySession(sessionID string, userID uint) ([]database.UploadedFile, error)
+	GetFileByID(id, userID uint) (*database.UploadedFile, error)
+	DeleteFile(id, userID uint) error
 	ProcessFileContent(file *database.UploadedFile) (string, error)
+	IndexFile(file *database.UploadedFile) error
+
+	// DeleteSessionFiles 删除会话中的所有文件（删除会话时调用，不检查会话归属）
+	DeleteSessionFiles(sessionID string) error
+
+	// ReconcileBlobs 清理没有文件引用的存储内容
+	ReconcileBlobs(ctx context.Context) (int, error)
+	StartBlobCleanupTask()
 }
 
 // GlobalFileService 全局FileService实例
 var GlobalFileService FileServiceInterface
 
+// FileIndexerInterface 文件切块索引（由检索服务实现）
+type FileIndexerInterface interface {
+	IndexFile(file *database.UploadedFile, content string) error
+	RemoveFile(fileID uint) error
+}
+
+// GlobalFileIndexer 为空时文件无法建立索引
+var GlobalFileIndexer FileIndexerInterface
+
+var (
+	// ErrFileTooLarge 文件超过 max_file_size
+	ErrFileTooLarge = errors.New("文件大小超过限制")
+	// ErrExtensionNotAllowed 扩展名不在 allowed_extensions 中
+	ErrExtensionNotAllowed = errors.New("不允许上传该类型的文件")
+	// ErrInvalidFile 文件内容无法识别或校验不通过
+	ErrInvalidFile = errors.New("文件内容无效")
+	// ErrFileNotFound 文件不存在或不属于当前用户
+	ErrFileNotFound = errors.New("文件不存在")
+)
+
+const (
+	uploadTempPattern   = "upload_*.tmp" // 上传过程中的临时文件
+	blobCleanupInterval = 6 * time.Hour  // 孤立文件清理间隔
+	orphanGracePeriod   = 1 * time.Hour  // 新写入的内容在此时间内不会被当作孤立文件清理
+)
+
 // fileService 文件服务实现
 type FileService struct {
-	db *gorm.DB
+	db        *gorm.DB
+	config    database.FileUploadConfig
+	store     BlobStoreInterface
+	blobLocks [64]sync.Mutex
 }
 
-// NewFileService 创建新的文件服务
-func NewFileService(db *gorm.DB) (FileServiceInterface, error) {
+// NewFileService 创建新的文件服务，上传策略必须在 style.yaml 的 file_upload 中完整配置
+func NewFileService(db *gorm.DB, config database.FileUploadConfig, store BlobStoreInterface) (FileServiceInterface, error) {
 
 	if db == nil {
 		return nil, errors.New("数据库连接不能为空")
 	}
+	if store == nil {
+		return nil, errors.New("文件存储不能为空")
+	}
+	config, err := normalizeUploadConfig(config)
+	if err != nil {
+		return nil, err
+	}
 
 	service := &FileService{
-		db,
+		db:     db,
+		config: config,
+		store:  store,
 	}
 	GlobalFileService = service
 	return service, nil
 }
 
+// LoadFileUploadConfig 从 YAML 文件读取 file_upload 配置，缺少必填项时返回错误
+func LoadFileUploadConfig(configPath string) (database.FileUploadConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return database.FileUploadConfig{}, err
+	}
+
+	var config database.StyleConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return database.FileUploadConfig{}, err
+	}
+	return normalizeUploadConfig(config.FileUpload)
+}
+
+// normalizeUploadConfig 校验必填项（上传策略只在 style.yaml 中配置，不使用内置默认值），扩展名统一为带点的小写形式
+func normalizeUploadConfig(config database.FileUploadConfig) (database.FileUploadConfig, error) {
+	if strings.TrimSpace(config.UploadDir) == "" {
+		return config, errors.New("file_upload.upload_dir 未配置")
+	}
+	if config.MaxFileSize <= 0 {
+		return config, errors.New("file_upload.max_file_size 必须大于 0")
+	}
+
+	extensions := config.AllowedExtensions
+	config.AllowedExtensions = make([]string, 0, len(extensions))
+	for _, ext := range extensions {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		config.AllowedExtensions = append(config.AllowedExtensions, ext)
+	}
+	if len(config.AllowedExtensions) == 0 {
+		return config, errors.New("file_upload.allowed_extensions 未配置")
+	}
+	return config, nil
+}
+
+// UploadConfig 返回生效的上传配置
+func (s *FileService) UploadConfig() database.FileUploadConfig {
+	return s.config
+}
+
+// ValidateUpload 按扩展名和大小校验上传文件，size 未知时传 0
+func (s *FileService) ValidateUpload(fileName string, size int64) error {
+	if size > s.config.MaxFileSize {
+		return s.fileTooLarge()
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	allowed := false
+	for _, allowedExt := range s.config.AllowedExtensions {
+		if ext == allowedExt {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s", ErrExtensionNotAllowed, fileName)
+	}
+	return nil
+}
+
+func (s *FileService) fileTooLarge() error {
+	return fmt.Errorf("%w（最大 %.1fMB）", ErrFileTooLarge, float64(s.config.MaxFileSize)/(1<<20))
+}
+
+// StoreUpload 校验并保存上传文件：边读边写入临时文件，超过大小限制时立即中止；
+// 写入完成后按内容识别类型（图片只校验，其他文件提取文本），再存入 BlobStore 并保存文件记录
+func (s *FileService) StoreUpload(sessionID, fileName string, src io.Reader, userID uint) (*database.UploadedFile, error) {
+	if err := s.checkSessionOwner(sessionID, userID); err != nil {
+		return nil, err
+	}
+	if err := s.ValidateUpload(fileName, 0); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(s.config.UploadDir, 0755); err != nil {
+		return nil, fmt.Errorf("创建上传目录失败: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.config.UploadDir, uploadTempPattern)
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hasher := sha256.New()
+	written, err := io.Copy(io.MultiWriter(tmp, hasher), io.LimitReader(src, s.config.MaxFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("保存文件失败: %w", err)
+	}
+	if written > s.config.MaxFileSize {
+		return nil, s.fileTooLarge()
+	}
+
+	file, err := describeUpload(tmp)
+	if err != nil {
+		return nil, err
+	}
+	file.SessionID = sessionID
+	file.FileName = fileName
+	file.FileSize = written
+	file.BlobKey = hex.EncodeToString(hasher.Sum(nil))
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("读取临时文件失败: %w", err)
+	}
+
+	// 写入内容和增加引用在同一把锁内完成，避免与删除最后一个引用的操作交错
+	lock := s.blobLock(file.BlobKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if err := s.store.Put(context.Background(), file.BlobKey, tmp, written); err != nil {
+		return nil, fmt.Errorf("保存文件失败: %w", err)
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(file).Error; err != nil {
+			return err
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "hash"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"ref_count":  gorm.Expr("ref_count + ?", 1),
+				"updated_at": time.Now(),
+			}),
+		}).Create(&database.Blob{Hash: file.BlobKey, Size: written, RefCount: 1}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("保存文件信息失败: %w", err)
+	}
+	return file, nil
+}
+
+// describeUpload 识别上传文件的类型：图片只做校验，对话时发送给视觉模型；
+// 其他文件提取文本，等待切块索引
+func describeUpload(src io.ReadSeeker) (*database.UploadedFile, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("读取上传文件失败: %w", err)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, fmt.Errorf("读取上传文件失败: %w", err)
+	}
+
+	file := &database.UploadedFile{}
+	if detected, _ := DetectMIME(data); IsImageMIME(detected) {
+		if file.FileType, err = ValidateImage(data); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+		}
+		file.IsProcessed = true
+		return file, nil
+	}
+
+	if file.Content, file.FileType, err = GlobalExtractorRegistry.Extract(data); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+	}
+	return file, nil
+}
+
+// blobLock 按键分段加锁，同一内容的写入、删除和清理互斥
+func (s *FileService) blobLock(key string) *sync.Mutex {
+	index := 0
+	if len(key) >= 2 {
+		if decoded, err := hex.DecodeString(key[:2]); err == nil {
+			index = int(decoded[0])
+		}
+	}
+	return &s.blobLocks[index%len(s.blobLocks)]
+}
+
 func (s *FileService) SaveFile(file *database.UploadedFile) error {
 	return s.db.Create(file).Error
 }
 
-func (s *FileService) GetFilesBySession(sessionID string) ([]database.UploadedFile, error) {
+// checkSessionOwner 确认会话属于指定用户，否则返回 ErrSessionNotFound
+func (s *FileService) checkSessionOwner(sessionID string, userID uint) error {
+	var count int64
+	if err := s.db.Model(&database.ChatSession{}).
+		Where("session_id = ? AND user_id = ?", sessionID, userID).
+		Count(&count).Error; err != nil {
+		return fmt.Errorf("查询会话失败: %w", err)
+	}
+	if count == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (s *FileService) GetFilesBySession(sessionID string, userID uint) ([]database.UploadedFile, error) {
+	if err := s.checkSessionOwner(sessionID, userID); err != nil {
+		return nil, err
+	}
 	var files []database.UploadedFile
 	result := s.db.Where("session_id = ?", sessionID).Order("created_at DESC").Find(&files)
 	return files, result.Error
 }
 
-func (s *FileService) GetFileByID(id uint) (*database.UploadedFile, error) {
+// GetFileByID 获取文件，文件所在会话不属于该用户时返回 ErrFileNotFound
+func (s *FileService) GetFileByID(id, userID uint) (*database.UploadedFile, error) {
 	var file database.UploadedFile
-	result := s.db.First(&file, id)
+	result := s.db.
+		Joins("JOIN chat_sessions ON chat_sessions.session_id = uploaded_files.session_id").
+		Where("uploaded_files.id = ? AND chat_sessions.user_id = ?", id, userID).
+		First(&file)
 	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, ErrFileNotFound
+		}
 		return nil, result.Error
 	}
 	return &file, nil
 }
 
-func (s *FileService) DeleteFile(id uint) error {
-	return s.db.Delete(&database.UploadedFile{}, id).Error
+// DeleteFile 删除文件记录和索引，内容的最后一个引用被删除时同时删除存储中的内容
+func (s *FileService) DeleteFile(id, userID uint) error {
+	file, err := s.GetFileByID(id, userID)
+	if err != nil {
+		return err
+	}
+	return s.removeFile(file)
 }
 
-// ProcessFileContent 处理文件内容，支持文本文件直接读取
-func (s *FileService) ProcessFileContent(file *database.UploadedFile) (string, error) {
-	// 如果是文本文件，直接读取内容
-	textExtensions := []string{".txt", ".py", ".go", ".c", ".cpp", ".h", ".hpp", ".js", ".ts", ".java", ".html", ".css", ".md", ".json", ".xml", ".yaml", ".yml"}
-
-	ext := strings.ToLower(filepath.Ext(file.FileName))
-	isTextFile := false
-	for _, textExt := range textExtensions {
-		if ext == textExt {
-			isTextFile = true
-			break
+// DeleteSessionFiles 逐个删除会话中的文件，保证内容的引用数和索引随之更新
+func (s *FileService) DeleteSessionFiles(sessionID string) error {
+	var files []database.UploadedFile
+	if err := s.db.Where("session_id = ?", sessionID).Find(&files).Error; err != nil {
+		return fmt.Errorf("查询会话文件失败: %w", err)
+	}
+	for i := range files {
+		if err := s.removeFile(&files[i]); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	if isTextFile {
-		// 如果文件内容已经存在，直接返回
-		if file.Content != "" {
-			return file.Content, nil
+// removeFile 删除文件记录、内容的引用和索引
+func (s *FileService) removeFile(file *database.UploadedFile) error {
+	if file.BlobKey == "" {
+		if err := s.db.Delete(&database.UploadedFile{}, file.ID).Error; err != nil {
+			return err
 		}
-
-		// 否则从文件路径读取
+		// 早期直接保存在磁盘上的文件
 		if file.FilePath != "" {
-			content, err := os.ReadFile(file.FilePath)
+			if err := os.Remove(file.FilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("删除文件失败 (file: %d): %v", file.ID, err)
+			}
+		}
+	} else if err := s.releaseBlob(file); err != nil {
+		return err
+	}
+
+	if GlobalFileIndexer != nil {
+		if err := GlobalFileIndexer.RemoveFile(file.ID); err != nil {
+			log.Printf("删除文件索引失败 (file: %d): %v", file.ID, err)
+		}
+	}
+	return nil
+}
+
+// releaseBlob 删除文件记录并减少内容的引用数，引用数归零时删除内容
+func (s *FileService) releaseBlob(file *database.UploadedFile) error {
+	lock := s.blobLock(file.BlobKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var blob database.Blob
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&database.UploadedFile{}, file.ID).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&database.Blob{}).Where("hash = ?", file.BlobKey).
+			Update("ref_count", gorm.Expr("ref_count - ?", 1)).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("hash = ?", file.BlobKey).Limit(1).Find(&blob).Error; err != nil {
+			return err
+		}
+		if blob.RefCount > 0 {
+			return nil
+		}
+		return tx.Where("hash = ?", file.BlobKey).Delete(&database.Blob{}).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	if blob.RefCount <= 0 {
+		if err := s.store.Delete(context.Background(), file.BlobKey); err != nil {
+			// 删除失败的内容由定期清理任务处理
+			log.Printf("删除文件内容失败 (blob: %s): %v", file.BlobKey, err)
+		}
+	}
+	return nil
+}
+
+// ReadFile 读取文件的原始内容
+func (s *FileService) ReadFile(file *database.UploadedFile) ([]byte, error) {
+	if file.BlobKey == "" {
+		if file.FilePath == "" {
+			return nil, errors.New("文件内容为空")
+		}
+		data, err := os.ReadFile(file.FilePath)
+		if err != nil {
+			return nil, fmt.Errorf("读取文件内容失败: %w", err)
+		}
+		return data, nil
+	}
+
+	reader, err := s.store.Open(context.Background(), file.BlobKey)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件内容失败: %w", err)
+	}
+	defer reader.Close()
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("读取文件内容失败: %w", err)
+	}
+	return data, nil
+}
+
+// ReconcileBlobs 按 uploaded_files 表核对存储中的内容：删除没有任何文件引用的内容，
+// 并修正引用计数；同时清理上传中断遗留的临时文件。返回删除的内容数
+func (s *FileService) ReconcileBlobs(ctx context.Context) (int, error) {
+	blobs, err := s.store.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, blob := range blobs {
+		if err := ctx.Err(); err != nil {
+			return removed, err
+		}
+		deleted, err := s.reconcileBlob(ctx, blob)
+		if err != nil {
+			log.Printf("核对文件内容失败 (blob: %s): %v", blob.Key, err)
+			continue
+		}
+		if deleted {
+			removed++
+		}
+	}
+
+	s.removeStaleTempFiles()
+	return removed, nil
+}
+
+func (s *FileService) reconcileBlob(ctx context.Context, blob BlobInfo) (bool, error) {
+	lock := s.blobLock(blob.Key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var refs int64
+	if err := s.db.Model(&database.UploadedFile{}).Where("blob_key = ?", blob.Key).Count(&refs).Error; err != nil {
+		return false, err
+	}
+
+	if refs > 0 {
+		return false, s.db.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "hash"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": refs}),
+		}).Create(&database.Blob{Hash: blob.Key, Size: blob.Size, RefCount: int(refs)}).Error
+	}
+
+	// 多个实例共用存储时，其他实例可能刚写入内容、尚未保存文件记录
+	if time.Since(blob.ModTime) < orphanGracePeriod {
+		return false, nil
+	}
+	if err := s.store.Delete(ctx, blob.Key); err != nil {
+		return false, err
+	}
+	return true, s.db.Where("hash = ?", blob.Key).Delete(&database.Blob{}).Error
+}
+
+// removeStaleTempFiles 删除上传中断遗留的临时文件
+func (s *FileService) removeStaleTempFiles() {
+	matches, _ := filepath.Glob(filepath.Join(s.config.UploadDir, uploadTempPattern))
+	for _, path := range matches {
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > orphanGracePeriod {
+			os.Remove(path)
+		}
+	}
+}
+
+// StartBlobCleanupTask 启动孤立文件清理任务
+func (s *FileService) StartBlobCleanupTask() {
+	go func() {
+		ticker := time.NewTicker(blobCleanupInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			removed, err := s.ReconcileBlobs(context.Background())
 			if err != nil {
-				return "", fmt.Errorf("读取文件内容失败: %v", err)
+				log.Printf("清理孤立文件失败: %v", err)
+				continue
+			}
+			if removed > 0 {
+				log.Printf("清理孤立文件完成: %d 个", removed)
 			}
-			return string(content), nil
 		}
+	}()
+}
+
+// IndexFile 提取文件文本并切块建立索引，完成后标记为已处理
+func (s *FileService) IndexFile(file *database.UploadedFile) error {
+	if GlobalFileIndexer == nil {
+		return errors.New("文件索引服务未初始化")
+	}
+
+	content, err := s.ProcessFileContent(file)
+	if err != nil {
+		return err
+	}
+	if err := GlobalFileIndexer.IndexFile(file, content); err != nil {
+		return fmt.Errorf("文件索引失败: %w", err)
 	}
 
-	return "", fmt.Errorf("不支持的文件类型或文件内容为空")
+	if err := s.db.Model(&database.UploadedFile{}).Where("id = ?", file.ID).Update("is_processed", true).Error; err != nil {
+		return err
+	}
+	file.IsProcessed = true
+	return nil
+}
+
+// ProcessFileContent 返回文件的文本内容：上传时已提取的直接返回，否则读取存储的文件重新提取
+func (s *FileService) ProcessFileContent(file *database.UploadedFile) (string, error) {
+	if file.Content != "" {
+		return file.Content, nil
+	}
+	data, err := s.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	content, _, err := GlobalExtractorRegistry.Extract(data)
+	if err != nil {
+		return "", err
+	}
+	return content, nil
 }
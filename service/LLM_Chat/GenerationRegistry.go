@@ -0,0 +1,229 @@
+package LLM_Chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrGenerationInProgress 会话已有正在进行的生成
+var ErrGenerationInProgress = errors.New("该会话正在生成回复")
+
+// ErrNoGeneration 会话没有正在进行的生成
+var ErrNoGeneration = errors.New("没有正在进行的生成")
+
+// ErrTooManyGenerations 用户同时进行（排队或执行中）的生成数达到上限
+var ErrTooManyGenerations = errors.New("同时进行的生成过多，请稍后再试")
+
+// stopWaitTimeout 停止生成时等待流式请求保存已生成内容的最长时间
+const stopWaitTimeout = 5 * time.Second
+
+// Generation 一次正在进行的流式生成，记录已推送的内容，供停止时保存
+type Generation struct {
+	SessionID string
+	UserID    uint
+	JobID     string // 对应的后台任务，直接登记（非任务）时为空
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	doneOnce sync.Once
+	mu       sync.Mutex
+	content  strings.Builder
+	stopped  bool
+}
+
+// Append 记录一段已推送给客户端的内容
+func (g *Generation) Append(chunk string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.content.WriteString(chunk)
+}
+
+// Content 返回目前为止生成的内容
+func (g *Generation) Content() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.content.String()
+}
+
+// Stopped 是否由用户主动停止（区别于超时和客户端断开）
+func (g *Generation) Stopped() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.stopped
+}
+
+// stop 标记为用户主动停止并取消 context
+func (g *Generation) stop() {
+	g.mu.Lock()
+	g.stopped = true
+	g.mu.Unlock()
+	g.cancel()
+}
+
+// GenerationRegistry 按会话记录正在进行的流式生成，同一会话同时只允许一个生成
+type GenerationRegistry struct {
+	mu          sync.Mutex
+	generations map[string]*Generation
+}
+
+var GlobalGenerationRegistry = NewGenerationRegistry()
+
+func NewGenerationRegistry() *GenerationRegistry {
+	return &GenerationRegistry{
+		generations: make(map[string]*Generation),
+	}
+}
+
+// Start 登记一次生成，返回可被 Stop 取消的 context。生成结束后必须调用 Finish
+func (r *GenerationRegistry) Start(parent context.Context, sessionID string, userID uint) (context.Context, *Generation, error) {
+	return r.start(parent, sessionID, userID, "", 0)
+}
+
+// StartJob 为后台任务登记生成（从入队开始计算），用户已登记的生成数达到 limit 时返回 ErrTooManyGenerations（limit 为 0 时不限制）
+func (r *GenerationRegistry) StartJob(job *GenerationJob, limit int) (*Generation, error) {
+	_, generation, err := r.start(context.Background(), job.SessionID, job.UserID, job.ID, limit)
+	return generation, err
+}
+
+func (r *GenerationRegistry) start(parent context.Context, sessionID string, userID uint, jobID string, limit int) (context.Context, *Generation, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, exists := r.generations[sessionID]; exists {
+		return nil, nil, ErrGenerationInProgress
+	}
+	if limit > 0 {
+		active := 0
+		for _, generation := range r.generations {
+			if generation.UserID == userID {
+				active++
+			}
+		}
+		if active >= limit {
+			return nil, nil, ErrTooManyGenerations
+		}
+	}
+
+	ctx, cancel := context.WithCancel(parent)
+	generation := &Generation{
+		SessionID: sessionID,
+		UserID:    userID,
+		JobID:     jobID,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+	}
+	r.generations[sessionID] = generation
+	return ctx, generation, nil
+}
+
+// Finish 移除登记并通知等待中的 Stop（在已生成内容保存之后调用），可重复调用
+func (r *GenerationRegistry) Finish(generation *Generation) {
+	r.mu.Lock()
+	if r.generations[generation.SessionID] == generation {
+		delete(r.generations, generation.SessionID)
+	}
+	r.mu.Unlock()
+
+	generation.cancel()
+	generation.doneOnce.Do(func() { close(generation.done) })
+}
+
+// Get 获取会话正在进行的生成
+func (r *GenerationRegistry) Get(sessionID string) (*Generation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	generation, exists := r.generations[sessionID]
+	return generation, exists
+}
+
+// Running 会话是否有正在进行的生成（本实例登记的，或 Redis 中其他实例登记的）
+func (r *GenerationRegistry) Running(sessionID string) bool {
+	if _, exists := r.Get(sessionID); exists {
+		return true
+	}
+	_, exists := remoteGenerationOwner(sessionID)
+	return exists
+}
+
+// Stop 取消用户会话中正在进行的生成，等待其保存已生成的内容后返回这部分内容。
+// 后台任务可能由其他实例执行（Redis 队列），因此停止请求同时写入 Redis，并以事件日志的结束事件为准等待。
+// 没有正在进行的生成或会话属于其他用户时返回 ErrNoGeneration
+func (r *GenerationRegistry) Stop(sessionID string, userID uint) (string, error) {
+	generation, exists := r.Get(sessionID)
+	if exists && generation.UserID != userID {
+		return "", ErrNoGeneration
+	}
+	if !exists {
+		if owner, found := remoteGenerationOwner(sessionID); !found || owner != userID {
+			return "", ErrNoGeneration
+		}
+	}
+
+	if exists {
+		generation.stop()
+		if generation.JobID == "" {
+			// 直接登记的生成在本实例执行，不写事件日志
+			select {
+			case <-generation.done:
+			case <-time.After(stopWaitTimeout):
+			}
+			return generation.Content(), nil
+		}
+	}
+
+	if err := GlobalCacheService.RequestStopGeneration(sessionID); err != nil {
+		log.Printf("写入停止请求失败 (session: %s): %v", sessionID, err)
+	}
+	return waitStreamDone(sessionID), nil
+}
+
+// remoteGenerationOwner 查询 Redis 中登记的生成所属的用户
+func remoteGenerationOwner(sessionID string) (uint, bool) {
+	if GlobalCacheService == nil {
+		return 0, false
+	}
+	owner, exists, err := GlobalCacheService.GenerationOwner(sessionID)
+	if err != nil {
+		log.Printf("查询生成登记失败 (session: %s): %v", sessionID, err)
+		return 0, false
+	}
+	return owner, exists
+}
+
+// waitStreamDone 等待会话事件日志中的结束事件（已生成的部分在结束事件之前保存），返回日志中已推送的内容
+func waitStreamDone(sessionID string) string {
+	ctx, cancel := context.WithTimeout(context.Background(), stopWaitTimeout)
+	defer cancel()
+
+	var content strings.Builder
+	lastID := ""
+	for ctx.Err() == nil {
+		events, err := GlobalCacheService.ReadStreamEvents(ctx, sessionID, lastID, time.Second)
+		if err != nil {
+			break
+		}
+		for _, event := range events {
+			lastID = event.ID
+			if event.Event == "" {
+				var payload struct {
+					Content string `json:"content"`
+				}
+				if json.Unmarshal([]byte(event.Data), &payload) == nil {
+					content.WriteString(payload.Content)
+				}
+			}
+			if event.Done {
+				return content.String()
+			}
+		}
+	}
+	return content.String()
+}
@@ -0,0 +1,105 @@
+package LLM_Chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 使用 Redis 队列时，入队、执行和停止可能发生在不同的实例上，进程内的 GenerationRegistry 只能看到本实例的登记。
+// 会话正在进行的生成和停止请求因此也记录在 Redis 中：入队时登记 generation_active，停止时写入 generation_stop，
+// 执行任务的实例轮询 generation_stop 并取消生成。Redis 不可用时只有一个实例，以下方法退化为空操作
+
+const (
+	// generationStopTTL 停止请求保留的时间，执行的实例在此期间内轮询到即可
+	generationStopTTL = time.Minute
+	// generationStopPollInterval 执行任务的实例检查停止请求的间隔
+	generationStopPollInterval = 300 * time.Millisecond
+)
+
+func generationActiveKey(sessionID string) string {
+	return "generation_active:" + sessionID
+}
+
+func generationStopKey(sessionID string) string {
+	return "generation_stop:" + sessionID
+}
+
+// releaseGenerationScript 只删除自己登记的任务，避免释放已被下一次生成覆盖的登记
+var releaseGenerationScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+func generationClaimValue(userID uint, jobID string) string {
+	return strconv.FormatUint(uint64(userID), 10) + ":" + jobID
+}
+
+// ClaimGeneration 登记会话正在进行的生成并清除上一次的停止请求，会话已有生成时返回 false
+func (cs *CacheService) ClaimGeneration(sessionID string, userID uint, jobID string, ttl time.Duration) (bool, error) {
+	if cs.redisClient == nil {
+		return true, nil
+	}
+
+	ctx := context.Background()
+	claimed, err := cs.redisClient.SetNX(ctx, generationActiveKey(sessionID), generationClaimValue(userID, jobID), ttl).Result()
+	if err != nil || !claimed {
+		return false, err
+	}
+	return true, cs.redisClient.Del(ctx, generationStopKey(sessionID)).Err()
+}
+
+// ReleaseGeneration 移除 ClaimGeneration 的登记
+func (cs *CacheService) ReleaseGeneration(sessionID string, userID uint, jobID string) error {
+	if cs.redisClient == nil {
+		return nil
+	}
+
+	ctx := context.Background()
+	return releaseGenerationScript.Run(ctx, cs.redisClient, []string{generationActiveKey(sessionID)}, generationClaimValue(userID, jobID)).Err()
+}
+
+// GenerationOwner 返回会话正在进行的生成所属的用户，没有登记时返回 false
+func (cs *CacheService) GenerationOwner(sessionID string) (uint, bool, error) {
+	if cs.redisClient == nil {
+		return 0, false, nil
+	}
+
+	value, err := cs.redisClient.Get(context.Background(), generationActiveKey(sessionID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	user, _, _ := strings.Cut(value, ":")
+	userID, err := strconv.ParseUint(user, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("解析生成登记失败: %s", value)
+	}
+	return uint(userID), true, nil
+}
+
+// RequestStopGeneration 请求停止会话正在进行的生成，由执行任务的实例轮询
+func (cs *CacheService) RequestStopGeneration(sessionID string) error {
+	if cs.redisClient == nil {
+		return nil
+	}
+
+	return cs.redisClient.Set(context.Background(), generationStopKey(sessionID), 1, generationStopTTL).Err()
+}
+
+// GenerationStopRequested 会话是否有未处理的停止请求
+func (cs *CacheService) GenerationStopRequested(sessionID string) (bool, error) {
+	if cs.redisClient == nil {
+		return false, nil
+	}
+
+	count, err := cs.redisClient.Exists(context.Background(), generationStopKey(sessionID)).Result()
+	return count > 0, err
+}
@@ -0,0 +1,494 @@
+package LLM_Chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gopkg.in/yaml.v3"
+	"log"
+	"os"
+	"platfrom/database"
+	"sync"
+	"time"
+)
+
+// 流式生成不在 HTTP 请求中执行：路由把任务放入队列并订阅会话的事件日志，worker 执行生成、写入事件并保存回复。
+// 客户端断开不影响生成，回复总会保存；重新连接时按事件ID补发
+
+const (
+	defaultWorkers              = 8
+	defaultQueueSize            = 256
+	defaultMaxConcurrentPerUser = 2
+
+	// generationTimeout 单次生成的最长时间（从开始执行计算）
+	generationTimeout = 120 * time.Second
+	// jobWatchTimeout 入队后等待任务结束的最长时间，超时后释放登记（执行任务的实例异常退出时）
+	jobWatchTimeout = 10 * time.Minute
+)
+
+// GenerationWorkerPool 从队列中取出生成任务并执行
+type GenerationWorkerPool struct {
+	queue    JobQueueInterface
+	registry *GenerationRegistry
+	config   database.GenerationConfig
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+var GlobalGenerationWorkerPool *GenerationWorkerPool
+
+// LoadGenerationConfig 从 style.yaml 读取 generation 配置
+func LoadGenerationConfig(configPath string) (database.GenerationConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return database.GenerationConfig{}, err
+	}
+
+	var config database.StyleConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return database.GenerationConfig{}, err
+	}
+	return config.Generation, nil
+}
+
+// InitGenerationWorkerPool 启动 worker，替换并停止之前的全局 worker 池
+func InitGenerationWorkerPool(config database.GenerationConfig, queue JobQueueInterface) *GenerationWorkerPool {
+	if config.Workers <= 0 {
+		config.Workers = defaultWorkers
+	}
+	if config.MaxConcurrentPerUser <= 0 {
+		config.MaxConcurrentPerUser = defaultMaxConcurrentPerUser
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pool := &GenerationWorkerPool{
+		queue:    queue,
+		registry: GlobalGenerationRegistry,
+		config:   config,
+		cancel:   cancel,
+	}
+	for i := 0; i < config.Workers; i++ {
+		pool.wg.Add(1)
+		go pool.work(ctx)
+	}
+
+	if GlobalGenerationWorkerPool != nil {
+		GlobalGenerationWorkerPool.Shutdown()
+	}
+	GlobalGenerationWorkerPool = pool
+	return pool
+}
+
+func GetGenerationWorkerPool() *GenerationWorkerPool {
+	if GlobalGenerationWorkerPool == nil {
+		log.Fatal("GenerationWorkerPool 未初始化，请先调用 InitGenerationWorkerPool")
+	}
+	return GlobalGenerationWorkerPool
+}
+
+// Shutdown 停止取新任务，等待正在执行的任务结束
+func (p *GenerationWorkerPool) Shutdown() {
+	p.cancel()
+	p.wg.Wait()
+}
+
+// userLimit 用户同时排队或执行的生成数上限
+func (p *GenerationWorkerPool) userLimit(userID uint) int {
+	if limit, ok := p.config.UserLimits[userID]; ok && limit > 0 {
+		return limit
+	}
+	return p.config.MaxConcurrentPerUser
+}
+
+// Submit 登记并入队一个任务：清空会话上一次生成的事件，先写入 preamble（如引用的参考资料），再由 worker 追加生成过程的事件。
+// 会话已有生成时返回 ErrGenerationInProgress，用户达到并发上限时返回 ErrTooManyGenerations，
+// 超出限流的并发名额时返回 RateLimitError
+func (p *GenerationWorkerPool) Submit(job *GenerationJob, preamble ...StreamEvent) error {
+	release, err := acquireStreamSlot(job.UserID)
+	if err != nil {
+		return err
+	}
+	generation, err := p.registry.StartJob(job, p.userLimit(job.UserID))
+	if err != nil {
+		release()
+		return err
+	}
+	// 其他实例可能正在为该会话生成，先在 Redis 中登记，再清空事件日志
+	claimed, err := GlobalCacheService.ClaimGeneration(job.SessionID, job.UserID, job.ID, jobWatchTimeout)
+	if err != nil {
+		log.Printf("登记生成失败，本次只检查本实例 (session: %s): %v", job.SessionID, err)
+	} else if !claimed {
+		p.registry.Finish(generation)
+		release()
+		return ErrGenerationInProgress
+	}
+
+	if err := GlobalCacheService.ResetStreamEvents(job.SessionID); err != nil {
+		log.Printf("清理流式事件失败 (session: %s): %v", job.SessionID, err)
+	}
+	for _, event := range preamble {
+		if _, err := GlobalCacheService.AppendStreamEvent(job.SessionID, event); err != nil {
+			log.Printf("记录流式事件失败: %v", err)
+		}
+	}
+
+	if err := p.queue.Push(context.Background(), job); err != nil {
+		p.releaseClaim(job)
+		p.registry.Finish(generation)
+		release()
+		return err
+	}
+	go p.watch(generation, release)
+	return nil
+}
+
+// watch 等待任务的结束事件后释放登记和并发名额。任务可能由其他实例执行（Redis 队列），因此以事件日志为准
+func (p *GenerationWorkerPool) watch(generation *Generation, release func()) {
+	defer release()
+	defer p.registry.Finish(generation)
+	defer p.releaseClaim(&GenerationJob{ID: generation.JobID, SessionID: generation.SessionID, UserID: generation.UserID})
+
+	ctx, cancel := context.WithTimeout(context.Background(), jobWatchTimeout)
+	defer cancel()
+
+	lastID := ""
+	for ctx.Err() == nil {
+		events, err := GlobalCacheService.ReadStreamEvents(ctx, generation.SessionID, lastID, 5*time.Second)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("读取流式事件失败 (session: %s): %v", generation.SessionID, err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+		for _, event := range events {
+			if event.Done {
+				return
+			}
+			lastID = event.ID
+		}
+	}
+}
+
+// releaseClaim 移除任务在 Redis 中的登记
+func (p *GenerationWorkerPool) releaseClaim(job *GenerationJob) {
+	if err := GlobalCacheService.ReleaseGeneration(job.SessionID, job.UserID, job.ID); err != nil {
+		log.Printf("释放生成登记失败 (session: %s): %v", job.SessionID, err)
+	}
+}
+
+// watchStopRequest 轮询 Redis 中的停止请求（可能由其他实例收到），直到生成结束
+func (p *GenerationWorkerPool) watchStopRequest(ctx context.Context, generation *Generation) {
+	ticker := time.NewTicker(generationStopPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		requested, err := GlobalCacheService.GenerationStopRequested(generation.SessionID)
+		if err != nil {
+			log.Printf("查询停止请求失败 (session: %s): %v", generation.SessionID, err)
+			continue
+		}
+		if requested {
+			generation.stop()
+			return
+		}
+	}
+}
+
+func (p *GenerationWorkerPool) work(ctx context.Context) {
+	defer p.wg.Done()
+	for {
+		job, err := p.queue.Pop(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("获取生成任务失败: %v", err)
+			time.Sleep(time.Second)
+			continue
+		}
+		p.execute(job)
+	}
+}
+
+// jobRun 一个任务的执行方式：generate 请求模型，persist 保存回复（停止时保存已生成的部分），
+// rollback（可为 nil）在没有保存回复时撤销准备阶段对当前分支的修改，
+// messageID 为任务创建的用户消息（JobEdit），以 message 事件告知客户端
+type jobRun struct {
+	generate  func(ctx context.Context, onChunk func(chunk string) error) (string, error)
+	persist   func(content string) error
+	rollback  func() error
+	messageID uint
+}
+
+// abandon 没有保存回复时撤销对当前分支的修改，内存中的会话按数据库重建
+func (r *jobRun) abandon(sessionID string) {
+	if r.rollback != nil {
+		if err := r.rollback(); err != nil {
+			log.Printf("恢复当前分支失败 (session: %s): %v", sessionID, err)
+		}
+	}
+	GetSessionManager().InvalidateSession(sessionID)
+}
+
+// execute 执行任务并把过程写入事件日志，最后一个事件的 done 为 true
+func (p *GenerationWorkerPool) execute(job *GenerationJob) {
+	emit := func(event string, payload map[string]interface{}) {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			log.Printf("序列化流式事件失败: %v", err)
+			return
+		}
+		done, _ := payload["done"].(bool)
+		if _, err := GlobalCacheService.AppendStreamEvent(job.SessionID, StreamEvent{Event: event, Data: string(data), Done: done}); err != nil {
+			log.Printf("记录流式事件失败: %v", err)
+		}
+	}
+
+	// 本实例入队的任务使用入队时的登记；其他实例入队（或重启前遗留）的任务在本实例登记，以便停止
+	generation, exists := p.registry.Get(job.SessionID)
+	if !exists || generation.JobID != job.ID {
+		var err error
+		generation, err = p.registry.StartJob(job, 0)
+		if err != nil {
+			emit("", map[string]interface{}{"error": err.Error(), "done": true})
+			return
+		}
+	}
+	defer p.registry.Finish(generation)
+
+	// finish 先移除登记（包括 Redis 中的）再发送结束事件，客户端收到结束事件后即可发起下一次生成
+	finish := func(payload map[string]interface{}) {
+		p.releaseClaim(job)
+		p.registry.Finish(generation)
+		payload["done"] = true
+		emit("", payload)
+	}
+
+	ctx, cancel := context.WithTimeout(generation.ctx, generationTimeout)
+	defer cancel()
+	go p.watchStopRequest(ctx, generation)
+
+	run, err := p.prepare(job, func(event ToolEvent) error {
+		for _, payload := range toolEventPayloads(event) {
+			emit(event.Type, payload)
+		}
+		return nil
+	})
+	if err != nil {
+		finish(map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if run.messageID != 0 {
+		emit("message", map[string]interface{}{
+			"type":       "message",
+			"message_id": run.messageID,
+			"done":       false,
+		})
+	}
+
+	fullResponse, err := run.generate(ctx, func(chunk string) error {
+		// 先写入事件再计入已生成内容，停止时保存的内容不会多于客户端可补发的内容
+		emit("", map[string]interface{}{
+			"content": chunk,
+			"done":    false,
+		})
+		generation.Append(chunk)
+		return nil
+	})
+
+	if err != nil && generation.Stopped() {
+		// 用户主动停止：保存已生成的部分，内存中的会话已回滚，按数据库重建
+		saved := false
+		if partial := generation.Content(); partial != "" {
+			if err := run.persist(partial); err != nil {
+				log.Printf("保存已生成的部分失败 (session: %s): %v", job.SessionID, err)
+			} else {
+				saved = true
+			}
+		}
+		if saved {
+			GetSessionManager().InvalidateSession(job.SessionID)
+		} else {
+			run.abandon(job.SessionID)
+		}
+		finish(map[string]interface{}{
+			"content": "",
+			"stopped": true,
+		})
+		return
+	}
+
+	if err != nil {
+		run.abandon(job.SessionID)
+		finish(map[string]interface{}{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 保存AI回复到数据库（没有客户端在接收时也保存）
+	if err := run.persist(fullResponse); err != nil {
+		run.abandon(job.SessionID)
+		finish(map[string]interface{}{
+			"error": "保存AI回复失败: " + err.Error(),
+		})
+		return
+	}
+
+	// 历史过长时在后台生成滚动摘要
+	GetSessionManager().ScheduleSummary(job.UserID, job.SessionID)
+
+	// 发送结束信号（事件日志保留到过期，生成结束后重连仍可补发）
+	finish(map[string]interface{}{
+		"content": "",
+	})
+}
+
+// prepare 按任务类型准备会话和保存方式
+func (p *GenerationWorkerPool) prepare(job *GenerationJob, onToolEvent func(event ToolEvent) error) (*jobRun, error) {
+	sm := GetSessionManager()
+	chatService := sm.GetChatService()
+	saveReply := func(session LLMSessionInterface) func(content string) error {
+		return func(content string) error {
+			return GlobalCacheService.SaveReplyWithRetry(job.SessionID, content, session.LastServedBy(), session.LastUsage(), job.UserID, 3)
+		}
+	}
+
+	switch job.Kind {
+	case JobSend:
+		session, err := sm.GetOrCreateSession(job.UserID, job.SessionID, job.ModelName, job.BaseUrl, job.Persona)
+		if err != nil {
+			return nil, err
+		}
+		if err := sm.SaveMessageWithParts(job.SessionID, "user", job.UserMessage, job.Parts, job.UserID); err != nil {
+			return nil, errors.New("保存用户消息失败: " + err.Error())
+		}
+		opts := sm.NewSendOptions(job.SessionID, job.UserID, &job.Params, job.UseTools, onToolEvent)
+		opts.References = job.References
+		opts.Images = job.Images
+		return &jobRun{
+			generate: func(ctx context.Context, onChunk func(chunk string) error) (string, error) {
+				return session.SendMessageStream(ctx, job.Message, opts, onChunk)
+			},
+			persist: saveReply(session),
+		}, nil
+
+	case JobRegenerate:
+		// 未指定回复时重新生成当前分支的最后一条；最后一条是用户消息（上次生成失败）时直接为它生成回复。
+		// 原回复保留为兄弟分支
+		chatSession, err := chatService.GetChatSession(job.SessionID, job.UserID)
+		if err != nil {
+			return nil, err
+		}
+		replyID := job.MessageID
+		if replyID == 0 {
+			last, err := chatService.GetLastMessage(job.SessionID, job.UserID)
+			if err != nil {
+				return nil, err
+			}
+			if last.Role != "user" {
+				replyID = last.ID
+			}
+		}
+		// 切换到提问的用户消息后生成；没有保存新回复时切回原来的分支，原回复不会被隐藏
+		var rollback func() error
+		if replyID != 0 {
+			question, err := chatService.BranchForRegenerate(job.SessionID, job.UserID, replyID)
+			if err != nil {
+				return nil, err
+			}
+			rollback = func() error {
+				return chatService.RestoreActiveLeaf(job.SessionID, job.UserID, chatSession.ActiveLeafID, question.ID)
+			}
+		}
+		session, err := sm.ReloadSession(job.UserID, job.SessionID)
+		if err != nil {
+			if rollback != nil {
+				_ = rollback()
+			}
+			return nil, err
+		}
+		opts := sm.NewSendOptions(job.SessionID, job.UserID, &job.Params, job.UseTools, onToolEvent)
+		return &jobRun{
+			generate: func(ctx context.Context, onChunk func(chunk string) error) (string, error) {
+				return session.Regenerate(ctx, opts, onChunk)
+			},
+			persist:  saveReply(session),
+			rollback: rollback,
+		}, nil
+
+	case JobEdit:
+		edited, err := chatService.EditUserMessage(job.SessionID, job.UserID, job.MessageID, job.UserMessage)
+		if err != nil {
+			return nil, err
+		}
+		session, err := sm.ReloadSession(job.UserID, job.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		opts := sm.NewSendOptions(job.SessionID, job.UserID, &job.Params, job.UseTools, onToolEvent)
+		return &jobRun{
+			generate: func(ctx context.Context, onChunk func(chunk string) error) (string, error) {
+				return session.Regenerate(ctx, opts, onChunk)
+			},
+			persist:   saveReply(session),
+			messageID: edited.ID,
+		}, nil
+
+	case JobContinue:
+		last, err := chatService.GetLastMessage(job.SessionID, job.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if last.Role != "assistant" {
+			return nil, errors.New("最后一条消息不是模型回复，无法续写")
+		}
+		session, err := sm.ReloadSession(job.UserID, job.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		opts := sm.NewSendOptions(job.SessionID, job.UserID, &job.Params, false, nil)
+		return &jobRun{
+			generate: func(ctx context.Context, onChunk func(chunk string) error) (string, error) {
+				return session.Continue(ctx, opts, onChunk)
+			},
+			persist: func(content string) error {
+				return chatService.AppendToMessage(job.SessionID, job.UserID, last.ID, content, session.LastServedBy(), session.LastUsage())
+			},
+		}, nil
+	}
+	return nil, errors.New("未知的任务类型: " + job.Kind)
+}
+
+// toolEventPayloads 工具调用过程对应的 SSE 数据，一次调用多个工具时每个工具一条
+func toolEventPayloads(event ToolEvent) []map[string]interface{} {
+	var payloads []map[string]interface{}
+	switch event.Type {
+	case ToolEventCall:
+		for _, call := range event.Message.ToolCalls {
+			payloads = append(payloads, map[string]interface{}{
+				"type":         event.Type,
+				"tool_call_id": call.ID,
+				"name":         call.Function.Name,
+				"arguments":    call.Function.Arguments,
+				"done":         false,
+			})
+		}
+	case ToolEventResult:
+		payloads = append(payloads, map[string]interface{}{
+			"type":         event.Type,
+			"tool_call_id": event.Message.ToolCallID,
+			"name":         event.ToolName,
+			"result":       event.Message.Content,
+			"is_error":     event.IsError,
+			"done":         false,
+		})
+	}
+	return payloads
+}
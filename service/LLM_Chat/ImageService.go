@@ -0,0 +1,114 @@
+package LLM_Chat
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	_ "golang.org/x/image/webp"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"platfrom/database"
+	"strings"
+)
+
+const (
+	maxImageSize      = 10 << 20 // 单张图片最大 10MB
+	maxImageDimension = 8192     // 图片宽高上限
+	minImageDimension = 16       // 图片宽高下限，过小的图片模型无法识别
+	imageTokenCost    = 1000     // 每张图片在上下文中的估算 token 数
+)
+
+// supportedImageTypes 支持的图片类型
+var supportedImageTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/webp": true,
+}
+
+// IsImageMIME 是否为支持的图片类型
+func IsImageMIME(mimeType string) bool {
+	return supportedImageTypes[mimeType]
+}
+
+// ValidateImage 校验图片类型、大小和尺寸，返回嗅探出的 MIME 类型
+func ValidateImage(data []byte) (string, error) {
+	mimeType, _ := DetectMIME(data)
+	if !IsImageMIME(mimeType) {
+		return "", fmt.Errorf("%w: %s，图片仅支持 PNG、JPEG、WebP", ErrUnsupportedFileType, mimeType)
+	}
+	if len(data) > maxImageSize {
+		return "", fmt.Errorf("图片大小不能超过 %dMB", maxImageSize>>20)
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("图片解析失败: %v", err)
+	}
+	if config.Width > maxImageDimension || config.Height > maxImageDimension {
+		return "", fmt.Errorf("图片尺寸不能超过 %dx%d，当前为 %dx%d", maxImageDimension, maxImageDimension, config.Width, config.Height)
+	}
+	if config.Width < minImageDimension || config.Height < minImageDimension {
+		return "", fmt.Errorf("图片尺寸不能小于 %dx%d，当前为 %dx%d", minImageDimension, minImageDimension, config.Width, config.Height)
+	}
+	return mimeType, nil
+}
+
+// ImageDataURL 把图片内容编码为 base64 data URL
+func ImageDataURL(mimeType string, data []byte) string {
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// LoadImageDataURL 读取上传的图片文件并编码为 data URL
+func LoadImageDataURL(file *database.UploadedFile) (string, error) {
+	if !IsImageMIME(file.FileType) {
+		return "", errors.New("文件不是图片")
+	}
+	if GlobalFileService == nil {
+		return "", errors.New("文件服务未初始化")
+	}
+	data, err := GlobalFileService.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("读取图片失败: %w", err)
+	}
+	return ImageDataURL(file.FileType, data), nil
+}
+
+// NewImagePart 构造 image_url 类型的消息部分
+func NewImagePart(dataURL string) openai.ChatMessagePart {
+	return openai.ChatMessagePart{
+		Type:     openai.ChatMessagePartTypeImageURL,
+		ImageURL: &openai.ChatMessageImageURL{URL: dataURL},
+	}
+}
+
+// ParseDataURL 拆分 base64 data URL，返回 MIME 类型和 base64 数据
+func ParseDataURL(url string) (string, string, bool) {
+	if !strings.HasPrefix(url, "data:") {
+		return "", "", false
+	}
+	meta, data, found := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+	if !found || !strings.HasSuffix(meta, ";base64") {
+		return "", "", false
+	}
+	return strings.TrimSuffix(meta, ";base64"), data, true
+}
+
+// MessageText 返回消息的文本内容，多部分消息拼接其中的文本部分
+func MessageText(msg openai.ChatCompletionMessage) string {
+	if len(msg.MultiContent) == 0 {
+		return msg.Content
+	}
+	var texts []string
+	for _, part := range msg.MultiContent {
+		switch part.Type {
+		case openai.ChatMessagePartTypeText:
+			texts = append(texts, part.Text)
+		case openai.ChatMessagePartTypeImageURL:
+			texts = append(texts, "[图片]")
+		}
+	}
+	return strings.Join(texts, "\n")
+}
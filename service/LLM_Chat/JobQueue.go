@@ -0,0 +1,134 @@
+package LLM_Chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"github.com/sashabaranov/go-openai"
+	"platfrom/database"
+	"time"
+)
+
+// 生成任务类型
+const (
+	JobSend       = "send"       // 保存用户消息并请求回复
+	JobRegenerate = "regenerate" // 重新生成当前分支的最后一条回复
+	JobContinue   = "continue"   // 续写当前分支最后一条被截断的回复
+	JobEdit       = "edit"       // 编辑一条用户消息（创建兄弟分支）并请求回复
+)
+
+// ErrQueueFull 进程内队列已满
+var ErrQueueFull = errors.New("生成队列已满，请稍后再试")
+
+// GenerationJob 一次后台生成任务，执行所需的数据都在任务中，可序列化后放入 Redis 队列
+type GenerationJob struct {
+	ID        string `json:"id"`
+	Kind      string `json:"kind"`
+	SessionID string `json:"session_id"`
+	UserID    uint   `json:"user_id"`
+	ModelName string `json:"model_name"`
+	BaseUrl   string `json:"base_url"`
+	Persona   string `json:"persona"`
+
+	// JobSend：保存的用户消息、发送给模型的完整消息（附加了文件片段）、图片和参考资料
+	UserMessage string                   `json:"user_message"`
+	Parts       []database.MessagePart   `json:"parts"`
+	Message     string                   `json:"message"`
+	Images      []openai.ChatMessagePart `json:"images"`
+	References  string                   `json:"references"`
+
+	// JobRegenerate：重新生成的回复，为 0 时为当前分支的最后一条；JobEdit：被编辑的用户消息，新内容为 UserMessage
+	MessageID uint `json:"message_id"`
+
+	UseTools  bool                      `json:"use_tools"`
+	Params    database.GenerationParams `json:"params"` // 本次请求的生成参数覆盖值
+	CreatedAt time.Time                 `json:"created_at"`
+}
+
+// NewGenerationJob 创建指定类型的任务
+func NewGenerationJob(kind, sessionID string, userID uint) *GenerationJob {
+	now := time.Now()
+	return &GenerationJob{
+		ID:        fmt.Sprintf("job_%d", now.UnixNano()),
+		Kind:      kind,
+		SessionID: sessionID,
+		UserID:    userID,
+		CreatedAt: now,
+	}
+}
+
+// JobQueueInterface 生成任务队列
+type JobQueueInterface interface {
+	Push(ctx context.Context, job *GenerationJob) error
+	Pop(ctx context.Context) (*GenerationJob, error) // 阻塞直到取到任务或 ctx 结束
+}
+
+// NewJobQueue Redis 可用时使用 Redis 列表（重启后未执行的任务仍会被取出），否则使用进程内队列
+func NewJobQueue(client *redis.Client, size int) JobQueueInterface {
+	if client != nil {
+		return &redisJobQueue{client: client, key: "generation_jobs"}
+	}
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	return &memoryJobQueue{jobs: make(chan *GenerationJob, size)}
+}
+
+type memoryJobQueue struct {
+	jobs chan *GenerationJob
+}
+
+func (q *memoryJobQueue) Push(ctx context.Context, job *GenerationJob) error {
+	select {
+	case q.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (q *memoryJobQueue) Pop(ctx context.Context) (*GenerationJob, error) {
+	select {
+	case job := <-q.jobs:
+		return job, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type redisJobQueue struct {
+	client *redis.Client
+	key    string
+}
+
+func (q *redisJobQueue) Push(ctx context.Context, job *GenerationJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return q.client.LPush(ctx, q.key, data).Err()
+}
+
+func (q *redisJobQueue) Pop(ctx context.Context) (*GenerationJob, error) {
+	for {
+		// 短超时轮询，以便及时响应 ctx 结束
+		result, err := q.client.BRPop(ctx, time.Second, q.key).Result()
+		if errors.Is(err, redis.Nil) {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var job GenerationJob
+		if err := json.Unmarshal([]byte(result[1]), &job); err != nil {
+			return nil, fmt.Errorf("解析任务失败: %w", err)
+		}
+		return &job, nil
+	}
+}
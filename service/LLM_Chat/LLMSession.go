@@ -2,53 +2,83 @@ package LLM_Chat
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/sashabaranov/go-openai"
-	"io"
-	"strings"
+	"platfrom/database"
 )
 
 // LLMSessionInterface 消息管理
 type LLMSessionInterface interface {
 	SetSessionID(sessionID string)
 	GetMessages() []openai.ChatCompletionMessage
-	SendMessage(message string) (string, error)
-	SendMessageStream(ctx context.Context, message string, onChunk func(chunk string) error) (string, error)
+	SendMessage(message string, opts SendOptions) (string, error)
+	SendMessageStream(ctx context.Context, message string, opts SendOptions, onChunk func(chunk string) error) (string, error)
+	// Regenerate 不追加用户消息，按现有历史（最后一条为用户消息）重新请求回复；onChunk 为 nil 时使用同步请求
+	Regenerate(ctx context.Context, opts SendOptions, onChunk func(chunk string) error) (string, error)
+	// Continue 让模型接着历史中最后一条回复继续写，返回续写的部分并拼接到该回复
+	Continue(ctx context.Context, opts SendOptions, onChunk func(chunk string) error) (string, error)
 	SetSystemPrompt(prompt string)
+	SetSummary(summary string)
+	// LastServedBy 最近一次生成实际使用的 API 配置
+	LastServedBy() ServedBy
+	// LastUsage 最近一次生成消耗的 token（包括工具调用的中间请求）
+	LastUsage() TokenUsage
 }
 
+// SendOptions 单次发送消息的选项
+type SendOptions struct {
+	Params      database.GenerationParams // 生成参数（会话默认值与本次请求覆盖值合并后的结果）
+	UserID      uint                      // 当前用户，工具执行时用于权限隔离
+	Tools       *ToolRegistry             // 可用的工具，为 nil 时不启用工具调用
+	OnToolEvent func(event ToolEvent) error
+	References  string                   // 本次检索到的参考资料，作为系统消息注入，不写入历史
+	Images      []openai.ChatMessagePart // 随用户消息发送的图片（image_url 部分），仅视觉模型可用
+}
+
+// referencesRatio 参考资料最多占用的上下文比例，超出部分截断
+const referencesRatio = 0.25
+
+// maxToolRounds 单条消息最多进行的工具调用轮数，超过后不再提供工具，要求模型直接回答
+const maxToolRounds = 5
+
 type AdvancedChatSession struct {
-	APIKey       string
-	Client       *openai.Client
-	Messages     []openai.ChatCompletionMessage
-	MaxHistory   int
-	SystemPrompt string
-	SessionID    string
+	Provider       LLMProviderInterface
+	ModelName      string
+	Messages       []openai.ChatCompletionMessage // 对话历史（不含系统提示词）
+	ContextManager *ContextManager
+	SystemPrompt   string
+	Summary        string // 较早对话的滚动摘要，发送时放在系统提示词之后
+	SessionID      string
+	lastUsage      TokenUsage
 }
 
-func NewAdvancedChatSession(apiKey, systemPrompt, BaseUrl string, maxHistory int) LLMSessionInterface {
-	config := openai.DefaultConfig(apiKey)
-	config.BaseURL = BaseUrl
+func NewAdvancedChatSession(provider LLMProviderInterface, modelName, systemPrompt string, contextBudget int) LLMSessionInterface {
 	session := &AdvancedChatSession{
-		APIKey:       apiKey,
-		Client:       openai.NewClientWithConfig(config),
-		Messages:     make([]openai.ChatCompletionMessage, 0),
-		MaxHistory:   maxHistory,
-		SystemPrompt: systemPrompt,
+		Provider:       provider,
+		ModelName:      modelName,
+		Messages:       make([]openai.ChatCompletionMessage, 0),
+		ContextManager: NewContextManager(modelName, contextBudget),
+		SystemPrompt:   systemPrompt,
 	}
 
 	return session
 }
 
-func NewAdvancedChatSessionFromHistory(apiKey, systemPrompt, BaseUrl string, maxHistory int, existingMessages []openai.ChatCompletionMessage) LLMSessionInterface {
-	config := openai.DefaultConfig(apiKey)
-	config.BaseURL = BaseUrl
+func NewAdvancedChatSessionFromHistory(provider LLMProviderInterface, modelName, systemPrompt string, contextBudget int, existingMessages []openai.ChatCompletionMessage) LLMSessionInterface {
+	// 系统提示词单独保存，历史中的 system 消息不再重复发送
+	messages := make([]openai.ChatCompletionMessage, 0, len(existingMessages))
+	for _, msg := range existingMessages {
+		if msg.Role != openai.ChatMessageRoleSystem {
+			messages = append(messages, msg)
+		}
+	}
+
 	session := &AdvancedChatSession{
-		APIKey:       apiKey,
-		Client:       openai.NewClientWithConfig(config),
-		Messages:     existingMessages,
-		MaxHistory:   maxHistory,
-		SystemPrompt: systemPrompt,
+		Provider:       provider,
+		ModelName:      modelName,
+		Messages:       messages,
+		ContextManager: NewContextManager(modelName, contextBudget),
+		SystemPrompt:   systemPrompt,
 	}
 
 	return session
@@ -59,147 +89,208 @@ func (s *AdvancedChatSession) SetSessionID(sessionID string) {
 	s.SessionID = sessionID
 }
 
+// LastServedBy 提供商经过模型路由时返回实际使用的配置，否则只有模型名
+func (s *AdvancedChatSession) LastServedBy() ServedBy {
+	if routed, ok := s.Provider.(interface{ LastServedBy() ServedBy }); ok {
+		return routed.LastServedBy()
+	}
+	return ServedBy{Model: s.ModelName}
+}
+
+func (s *AdvancedChatSession) LastUsage() TokenUsage {
+	return s.lastUsage
+}
+
 // GetMessages 新增：获取当前消息历史
 func (s *AdvancedChatSession) GetMessages() []openai.ChatCompletionMessage {
 	return s.Messages
 }
 
 // SendMessage 原有的同步发送消息方法
-func (s *AdvancedChatSession) SendMessage(message string) (string, error) {
+func (s *AdvancedChatSession) SendMessage(message string, opts SendOptions) (string, error) {
 	// 添加用户消息
-	s.Messages = append(s.Messages, openai.ChatCompletionMessage{
-		Role:    "user",
-		Content: message,
-	})
+	s.Messages = append(s.Messages, newUserMessage(message, opts.Images))
 
-	// 限制历史记录长度（保留系统消息）
-	startIndex := 0
-	if len(s.Messages) > 0 && s.Messages[0].Role == "system" {
-		startIndex = 1 // 保留系统消息
-	}
+	return s.complete(context.Background(), opts, nil, len(s.Messages)-1)
+}
 
-	if len(s.Messages) > s.MaxHistory*2+startIndex {
-		// 保留系统消息和最近的对话
-		s.Messages = append(
-			s.Messages[:startIndex],
-			s.Messages[len(s.Messages)-s.MaxHistory*2:]...,
-		)
-	}
+// SendMessageStream 新增：流式发送消息
+func (s *AdvancedChatSession) SendMessageStream(ctx context.Context, message string, opts SendOptions, onChunk func(chunk string) error) (string, error) {
+	// 添加用户消息
+	s.Messages = append(s.Messages, newUserMessage(message, opts.Images))
 
-	resp, err := s.Client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    "deepseek-chat",
-			Messages: s.Messages,
-		},
-	)
+	return s.complete(ctx, opts, onChunk, len(s.Messages)-1)
+}
 
-	if err != nil {
-		s.Messages = s.Messages[:len(s.Messages)-1] // 移除失败的用户消息
-		return "", fmt.Errorf("ChatCompletion error: %v", err)
+// Regenerate 按现有历史重新请求回复，失败时保留历史中的用户消息
+func (s *AdvancedChatSession) Regenerate(ctx context.Context, opts SendOptions, onChunk func(chunk string) error) (string, error) {
+	if len(s.Messages) == 0 || s.Messages[len(s.Messages)-1].Role != openai.ChatMessageRoleUser {
+		return "", errors.New("历史的最后一条不是用户消息，无法重新生成")
 	}
+	return s.complete(ctx, opts, onChunk, len(s.Messages))
+}
 
-	aiResponse := resp.Choices[0].Message.Content
+// continuePrompt 续写时临时追加的指令，不写入历史
+const continuePrompt = "你的上一条回答被截断了。请从中断的地方直接接着写，不要重复已经写过的内容，也不要加任何开场白。"
 
-	// 添加AI回复
-	s.Messages = append(s.Messages, openai.ChatCompletionMessage{
-		Role:    "assistant",
-		Content: aiResponse,
-	})
+// Continue 续写最后一条回复：临时追加续写指令请求模型，成功后把续写内容拼接到原回复，不启用工具调用
+func (s *AdvancedChatSession) Continue(ctx context.Context, opts SendOptions, onChunk func(chunk string) error) (string, error) {
+	last := len(s.Messages) - 1
+	if last < 0 || s.Messages[last].Role != openai.ChatMessageRoleAssistant || len(s.Messages[last].ToolCalls) > 0 {
+		return "", errors.New("历史的最后一条不是模型回复，无法继续生成")
+	}
 
-	return aiResponse, nil
-}
+	s.Messages = append(s.Messages, openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: continuePrompt})
+	opts.Tools = nil
+	continuation, err := s.complete(ctx, opts, onChunk, last+1)
+	if err != nil {
+		return "", err
+	}
 
-// SendMessageStream 新增：流式发送消息
-func (s *AdvancedChatSession) SendMessageStream(ctx context.Context, message string, onChunk func(chunk string) error) (string, error) {
-	// 添加用户消息
-	s.Messages = append(s.Messages, openai.ChatCompletionMessage{
-		Role:    "user",
-		Content: message,
-	})
+	// 移除续写指令和新回复（历史过长时开头可能已被截掉，从末尾定位）
+	n := len(s.Messages)
+	s.Messages = s.Messages[:n-2]
+	s.Messages[n-3].Content += continuation
+	return continuation, nil
+}
 
-	// 限制历史记录长度（保留系统消息）
-	startIndex := 0
-	if len(s.Messages) > 0 && s.Messages[0].Role == "system" {
-		startIndex = 1 // 保留系统消息
+// newUserMessage 构造用户消息，带图片时使用多部分内容
+func newUserMessage(message string, images []openai.ChatMessagePart) openai.ChatCompletionMessage {
+	if len(images) == 0 {
+		return openai.ChatCompletionMessage{Role: "user", Content: message}
 	}
 
-	if len(s.Messages) > s.MaxHistory*2+startIndex {
-		// 保留系统消息和最近的对话
-		s.Messages = append(
-			s.Messages[:startIndex],
-			s.Messages[len(s.Messages)-s.MaxHistory*2:]...,
-		)
+	parts := make([]openai.ChatMessagePart, 0, len(images)+1)
+	if message != "" {
+		parts = append(parts, openai.ChatMessagePart{Type: openai.ChatMessagePartTypeText, Text: message})
 	}
+	parts = append(parts, images...)
+	return openai.ChatCompletionMessage{Role: "user", MultiContent: parts}
+}
 
-	// 创建流式请求
-	req := openai.ChatCompletionRequest{
-		Model:    "deepseek-chat",
-		Messages: s.Messages,
-		Stream:   true,
-	}
+// complete 请求模型并处理工具调用：模型返回 tool_calls 时执行工具、追加 tool 消息后继续请求，
+// 直到模型给出最终回答。onChunk 为 nil 时使用同步请求；失败时历史截断到 rollback（移除本轮的用户消息及之后的工具消息）
+func (s *AdvancedChatSession) complete(ctx context.Context, opts SendOptions, onChunk func(chunk string) error, rollback int) (string, error) {
 
-	stream, err := s.Client.CreateChatCompletionStream(ctx, req)
-	if err != nil {
-		s.Messages = s.Messages[:len(s.Messages)-1] // 移除失败的用户消息
-		return "", fmt.Errorf("ChatCompletionStream error: %v", err)
+	var tools []openai.Tool
+	if opts.Tools != nil {
+		tools = opts.Tools.Definitions()
 	}
-	defer stream.Close()
+	s.lastUsage = TokenUsage{}
 
-	var fullResponse strings.Builder
+	for round := 0; ; round++ {
+		req := ProviderRequest{
+			Model:    s.ModelName,
+			Messages: s.buildContext(opts),
+			Params:   opts.Params,
+		}
+		if round < maxToolRounds {
+			req.Tools = tools
+		}
 
-	for {
-		response, err := stream.Recv()
+		var resp *ProviderResponse
+		var err error
+		if onChunk != nil {
+			resp, err = s.Provider.CreateChatCompletionStream(ctx, req, onChunk)
+		} else {
+			resp, err = s.Provider.CreateChatCompletion(ctx, req)
+		}
 		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return "", fmt.Errorf("Stream error: %v", err)
+			s.Messages = s.Messages[:rollback]
+			return "", err
 		}
+		s.lastUsage = s.lastUsage.Add(resp.Usage)
 
-		if len(response.Choices) > 0 {
-			chunk := response.Choices[0].Delta.Content
-			fullResponse.WriteString(chunk)
+		if len(resp.ToolCalls) == 0 || len(req.Tools) == 0 {
+			aiResponse := resp.Content
 
-			// 调用回调函数处理每个chunk
-			if onChunk != nil {
-				if err := onChunk(chunk); err != nil {
-					return "", err
-				}
-			}
+			// 添加AI回复到消息历史
+			s.appendAssistantMessage(aiResponse)
+
+			return aiResponse, nil
+		}
+
+		if err := s.runToolCalls(ctx, opts, resp); err != nil {
+			s.Messages = s.Messages[:rollback]
+			return "", err
 		}
 	}
+}
 
-	aiResponse := fullResponse.String()
+// runToolCalls 执行一轮工具调用，工具执行失败时把错误信息作为结果返回给模型
+func (s *AdvancedChatSession) runToolCalls(ctx context.Context, opts SendOptions, resp *ProviderResponse) error {
+	assistantMsg := openai.ChatCompletionMessage{
+		Role:      openai.ChatMessageRoleAssistant,
+		Content:   resp.Content,
+		ToolCalls: resp.ToolCalls,
+	}
+	s.Messages = append(s.Messages, assistantMsg)
+	if err := opts.emitToolEvent(ToolEvent{Type: ToolEventCall, Message: assistantMsg}); err != nil {
+		return err
+	}
 
-	// 添加AI回复到消息历史
-	s.Messages = append(s.Messages, openai.ChatCompletionMessage{
-		Role:    "assistant",
-		Content: aiResponse,
-	})
+	toolCtx := ToolContext{UserID: opts.UserID, SessionID: s.SessionID}
+	for _, call := range resp.ToolCalls {
+		result, execErr := opts.Tools.Execute(ctx, toolCtx, call)
+		if execErr != nil {
+			result = "工具执行失败: " + execErr.Error()
+		}
 
-	return aiResponse, nil
+		toolMsg := openai.ChatCompletionMessage{
+			Role:       openai.ChatMessageRoleTool,
+			Content:    result,
+			ToolCallID: call.ID,
+		}
+		s.Messages = append(s.Messages, toolMsg)
+		if err := opts.emitToolEvent(ToolEvent{Type: ToolEventResult, Message: toolMsg, ToolName: call.Function.Name, IsError: execErr != nil}); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// SetSystemPrompt 设置系统提示词（新增方法）
+func (opts SendOptions) emitToolEvent(event ToolEvent) error {
+	if opts.OnToolEvent == nil {
+		return nil
+	}
+	return opts.OnToolEvent(event)
+}
+
+// SetSystemPrompt 设置系统提示词，发送时由上下文管理器放在消息列表开头
 func (s *AdvancedChatSession) SetSystemPrompt(prompt string) {
 	s.SystemPrompt = prompt
+}
 
-	// 重建消息列表，保留系统消息之后的消息
-	if len(s.Messages) > 0 && s.Messages[0].Role == "system" {
-		// 替换第一个系统消息
-		s.Messages[0] = openai.ChatCompletionMessage{
-			Role:    "system",
-			Content: prompt,
-		}
-	} else {
-		// 在开头插入系统消息
-		newMessages := []openai.ChatCompletionMessage{
-			{
-				Role:    "system",
-				Content: prompt,
-			},
-		}
-		s.Messages = append(newMessages, s.Messages...)
+// SetSummary 设置较早对话的滚动摘要
+func (s *AdvancedChatSession) SetSummary(summary string) {
+	s.Summary = summary
+}
+
+// buildContext 按 token 预算构造本次请求的上下文：系统提示词、摘要、参考资料、历史
+func (s *AdvancedChatSession) buildContext(opts SendOptions) []openai.ChatCompletionMessage {
+	maxTokens := 0
+	if opts.Params.MaxTokens != nil {
+		maxTokens = *opts.Params.MaxTokens
+	}
+
+	systemMessages := []string{s.SystemPrompt}
+	if s.Summary != "" {
+		systemMessages = append(systemMessages, summaryPrefix+s.Summary)
+	}
+	if opts.References != "" {
+		limit := int(float64(s.ContextManager.ContextLength) * referencesRatio)
+		systemMessages = append(systemMessages, s.ContextManager.TruncateToTokens(opts.References, limit))
+	}
+	return s.ContextManager.BuildContextWithSystem(systemMessages, s.Messages, maxTokens)
+}
+
+// appendAssistantMessage 添加AI回复，内存中的历史超过上限时丢弃最早的消息
+func (s *AdvancedChatSession) appendAssistantMessage(content string) {
+	s.Messages = append(s.Messages, openai.ChatCompletionMessage{
+		Role:    "assistant",
+		Content: content,
+	})
+	if len(s.Messages) > maxStoredHistoryLength {
+		s.Messages = s.Messages[len(s.Messages)-maxStoredHistoryLength:]
 	}
 }
@@ -0,0 +1,303 @@
+package LLM_Chat
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"platfrom/database"
+)
+
+// 会话中的消息通过 ParentID 组成一棵树：编辑用户消息或重新生成回复时，新消息与原消息共用父节点（互为兄弟），
+// ChatSession.ActiveLeafID 指向当前分支的最后一条消息。发送给模型和展示给用户的都是从根到该消息的路径
+
+// ErrMessageNotFound 消息不存在或不在该会话中
+var ErrMessageNotFound = errors.New("消息不存在")
+
+// messageNode 构造路径只需要的字段
+type messageNode struct {
+	ID       uint
+	ParentID uint
+}
+
+// activePathIDs 返回会话当前分支从根到叶子的消息ID（按时间正序，摘要消息不在树中）
+func activePathIDs(tx *gorm.DB, sessionID string) ([]uint, error) {
+	var session database.ChatSession
+	result := tx.Select("active_leaf_id").Where("session_id = ?", sessionID).Limit(1).Find(&session)
+	if result.Error != nil {
+		return nil, fmt.Errorf("查询会话失败: %w", result.Error)
+	}
+	if session.ActiveLeafID == 0 {
+		return nil, nil
+	}
+
+	var nodes []messageNode
+	if err := tx.Model(&database.ChatMessage{}).
+		Select("id, parent_id").
+		Where("session_id = ? AND role <> ?", sessionID, database.MessageRoleSummary).
+		Find(&nodes).Error; err != nil {
+		return nil, fmt.Errorf("查询消息失败: %w", err)
+	}
+	parents := make(map[uint]uint, len(nodes))
+	for _, node := range nodes {
+		parents[node.ID] = node.ParentID
+	}
+
+	var path []uint
+	for id := session.ActiveLeafID; id != 0 && len(path) <= len(nodes); {
+		parent, ok := parents[id]
+		if !ok {
+			break
+		}
+		path = append(path, id)
+		id = parent
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path, nil
+}
+
+// pathAfter 返回路径上 ID 大于 afterID 的部分
+func pathAfter(path []uint, afterID uint) []uint {
+	for i, id := range path {
+		if id > afterID {
+			return path[i:]
+		}
+	}
+	return nil
+}
+
+// findMessages 按ID顺序获取消息
+func findMessages(tx *gorm.DB, ids []uint) ([]database.ChatMessage, error) {
+	messages := []database.ChatMessage{}
+	if len(ids) == 0 {
+		return messages, nil
+	}
+	if err := tx.Where("id IN ?", ids).Order("id ASC").Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("查询消息失败: %w", err)
+	}
+	return messages, nil
+}
+
+// appendToActiveBranch 在当前分支末尾保存消息并把它设为新的叶子
+func appendToActiveBranch(tx *gorm.DB, message *database.ChatMessage) error {
+	var session database.ChatSession
+	if err := tx.Select("active_leaf_id").Where("session_id = ?", message.SessionID).Limit(1).Find(&session).Error; err != nil {
+		return fmt.Errorf("查询会话失败: %w", err)
+	}
+	message.ParentID = session.ActiveLeafID
+	if err := tx.Create(message).Error; err != nil {
+		return fmt.Errorf("创建消息失败: %w", err)
+	}
+	return setActiveLeaf(tx, message.SessionID, message.ID)
+}
+
+func setActiveLeaf(tx *gorm.DB, sessionID string, leafID uint) error {
+	if err := tx.Model(&database.ChatSession{}).
+		Where("session_id = ?", sessionID).
+		Update("active_leaf_id", leafID).Error; err != nil {
+		return fmt.Errorf("更新当前分支失败: %w", err)
+	}
+	return nil
+}
+
+// getSessionMessage 获取用户会话中的一条消息（不含摘要）
+func (s *ChatSessionService) getSessionMessage(tx *gorm.DB, sessionID string, UserId uint, messageID uint) (*database.ChatMessage, error) {
+	if err := s.checkSessionOwner(tx, sessionID, UserId); err != nil {
+		return nil, err
+	}
+	var message database.ChatMessage
+	result := tx.Where("id = ? AND session_id = ? AND role <> ?", messageID, sessionID, database.MessageRoleSummary).Limit(1).Find(&message)
+	if result.Error != nil {
+		return nil, fmt.Errorf("查询消息失败: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return &message, nil
+}
+
+// EditUserMessage 编辑一条用户消息：在原消息旁边创建内容不同的兄弟消息并切换到这条新分支，原分支保持不变。
+// 原消息带图片时保留图片，只替换文字
+func (s *ChatSessionService) EditUserMessage(sessionID string, UserId uint, messageID uint, content string) (*database.ChatMessage, error) {
+	if content == "" {
+		return nil, errors.New("content 不能为空")
+	}
+
+	var edited *database.ChatMessage
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		original, err := s.getSessionMessage(tx, sessionID, UserId, messageID)
+		if err != nil {
+			return err
+		}
+		if original.Role != "user" {
+			return errors.New("只能编辑用户消息")
+		}
+
+		edited = &database.ChatMessage{
+			SessionID: sessionID,
+			ParentID:  original.ParentID,
+			Role:      "user",
+			Content:   content,
+		}
+		if len(original.Parts) > 0 {
+			edited.Parts = []database.MessagePart{{Type: database.MessagePartText, Text: content}}
+			for _, part := range original.Parts {
+				if part.Type == database.MessagePartImage {
+					edited.Parts = append(edited.Parts, part)
+				}
+			}
+		}
+		if err := tx.Create(edited).Error; err != nil {
+			return fmt.Errorf("创建消息失败: %w", err)
+		}
+		if err := tx.Model(&database.ChatSession{}).
+			Where("session_id = ?", sessionID).
+			Updates(map[string]interface{}{
+				"active_leaf_id": edited.ID,
+				"message_count":  gorm.Expr("message_count + ?", 1),
+			}).Error; err != nil {
+			return fmt.Errorf("更新当前分支失败: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return edited, nil
+}
+
+// BranchForRegenerate 准备重新生成一条回复：切换到该回复所对应的用户消息，
+// 之后保存的回复会成为原回复的兄弟消息。返回该用户消息
+func (s *ChatSessionService) BranchForRegenerate(sessionID string, UserId uint, messageID uint) (*database.ChatMessage, error) {
+	var question *database.ChatMessage
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		reply, err := s.getSessionMessage(tx, sessionID, UserId, messageID)
+		if err != nil {
+			return err
+		}
+		if reply.Role != "assistant" {
+			return errors.New("只能重新生成模型的回复")
+		}
+
+		// 回复之前可能有工具调用消息，向上找到提问的用户消息
+		for id := reply.ParentID; id != 0; {
+			var parent database.ChatMessage
+			if err := tx.Where("id = ? AND session_id = ?", id, sessionID).First(&parent).Error; err != nil {
+				return fmt.Errorf("查询消息失败: %w", err)
+			}
+			if parent.Role == "user" {
+				question = &parent
+				break
+			}
+			id = parent.ParentID
+		}
+		if question == nil {
+			return errors.New("找不到该回复对应的用户消息")
+		}
+		return setActiveLeaf(tx, sessionID, question.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return question, nil
+}
+
+// RestoreActiveLeaf 当前分支仍停在 expectedLeafID 时切回 leafID，用于重新生成失败时恢复显示原回复。
+// 期间当前分支已被其他操作移动时不做修改
+func (s *ChatSessionService) RestoreActiveLeaf(sessionID string, UserId uint, leafID, expectedLeafID uint) error {
+	if err := s.checkSessionOwner(s.db, sessionID, UserId); err != nil {
+		return err
+	}
+	if err := s.db.Model(&database.ChatSession{}).
+		Where("session_id = ? AND active_leaf_id = ?", sessionID, expectedLeafID).
+		Update("active_leaf_id", leafID).Error; err != nil {
+		return fmt.Errorf("恢复当前分支失败: %w", err)
+	}
+	return nil
+}
+
+// GetSiblings 获取与指定消息共用父节点的所有消息（包括它自己），按创建顺序
+func (s *ChatSessionService) GetSiblings(sessionID string, UserId uint, messageID uint) ([]database.ChatMessage, error) {
+	message, err := s.getSessionMessage(s.db, sessionID, UserId, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	var siblings []database.ChatMessage
+	if err := s.db.Where("session_id = ? AND parent_id = ? AND role <> ?", sessionID, message.ParentID, database.MessageRoleSummary).
+		Order("id ASC").
+		Find(&siblings).Error; err != nil {
+		return nil, fmt.Errorf("查询消息失败: %w", err)
+	}
+	return siblings, nil
+}
+
+// SwitchBranch 切换到包含指定消息的分支：从该消息开始每一层选择最新的子消息，直到叶子。返回新的叶子ID
+func (s *ChatSessionService) SwitchBranch(sessionID string, UserId uint, messageID uint) (uint, error) {
+	var leafID uint
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := s.getSessionMessage(tx, sessionID, UserId, messageID); err != nil {
+			return err
+		}
+
+		leafID = messageID
+		for {
+			var children []uint
+			if err := tx.Model(&database.ChatMessage{}).
+				Where("session_id = ? AND parent_id = ? AND role <> ?", sessionID, leafID, database.MessageRoleSummary).
+				Order("id DESC").
+				Limit(1).
+				Pluck("id", &children).Error; err != nil {
+				return fmt.Errorf("查询消息失败: %w", err)
+			}
+			if len(children) == 0 {
+				break
+			}
+			leafID = children[0]
+		}
+		return setActiveLeaf(tx, sessionID, leafID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return leafID, nil
+}
+
+// GetLastMessage 获取当前分支的最后一条消息，会话为空时返回 ErrMessageNotFound
+func (s *ChatSessionService) GetLastMessage(sessionID string, UserId uint) (*database.ChatMessage, error) {
+	if err := s.checkSessionOwner(s.db, sessionID, UserId); err != nil {
+		return nil, err
+	}
+	path, err := activePathIDs(s.db, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if len(path) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return s.getSessionMessage(s.db, sessionID, UserId, path[len(path)-1])
+}
+
+// AppendToMessage 在一条模型回复末尾追加内容（续写被截断的回复），续写的用量累加到该回复上
+func (s *ChatSessionService) AppendToMessage(sessionID string, UserId uint, messageID uint, content string, servedBy ServedBy, usage TokenUsage) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		message, err := s.getSessionMessage(tx, sessionID, UserId, messageID)
+		if err != nil {
+			return err
+		}
+		if message.Role != "assistant" {
+			return errors.New("只能续写模型的回复")
+		}
+		cost := usageCost(servedBy.Model, usage)
+		if err := tx.Model(message).Updates(map[string]interface{}{
+			"content":           message.Content + content,
+			"prompt_tokens":     gorm.Expr("prompt_tokens + ?", usage.PromptTokens),
+			"completion_tokens": gorm.Expr("completion_tokens + ?", usage.CompletionTokens),
+			"cost":              gorm.Expr("cost + ?", cost),
+		}).Error; err != nil {
+			return fmt.Errorf("更新消息失败: %w", err)
+		}
+		return recordUsage(tx, UserId, sessionID, servedBy.APIID, servedBy.Model, usage, cost)
+	})
+}
@@ -0,0 +1,173 @@
+package LLM_Chat
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"platfrom/database"
+	"sort"
+)
+
+// ErrModelRouteNotFound 模型路由不存在或不属于当前用户
+var ErrModelRouteNotFound = errors.New("模型路由不存在")
+
+// ResolvedRoute 模型名对应的候选 API 配置（密钥已解密）和路由策略
+type ResolvedRoute struct {
+	Key    string // 区分轮询计数：用户ID + 模型名
+	Policy string
+	APIs   []database.UserAPI
+}
+
+// Primary 首选配置，会话的上下文预算、视觉能力等按它确定
+func (r *ResolvedRoute) Primary() *database.UserAPI {
+	return &r.APIs[0]
+}
+
+// IsSupportedRoutePolicy 检查路由策略是否受支持
+func IsSupportedRoutePolicy(policy string) bool {
+	switch policy {
+	case database.RoutePolicyFallback, database.RoutePolicyRoundRobin, database.RoutePolicyLeastLatency:
+		return true
+	}
+	return false
+}
+
+// validateModelRoute 检查路由的名称、策略，以及引用的配置都属于该用户
+func (s *userAPIService) validateModelRoute(userID uint, route *database.ModelRoute, excludeID uint) error {
+	if route.Name == "" {
+		return errors.New("路由名称不能为空")
+	}
+	if route.Policy == "" {
+		route.Policy = database.RoutePolicyFallback
+	}
+	if !IsSupportedRoutePolicy(route.Policy) {
+		return fmt.Errorf("不支持的路由策略: %s", route.Policy)
+	}
+	if len(route.APIIDs) == 0 {
+		return errors.New("路由至少需要一个API配置")
+	}
+
+	seen := make(map[uint]bool, len(route.APIIDs))
+	for _, id := range route.APIIDs {
+		if seen[id] {
+			return fmt.Errorf("API配置 %d 重复", id)
+		}
+		seen[id] = true
+	}
+	var count int64
+	if err := s.db.Model(&database.UserAPI{}).Where("user_id = ? AND id IN ?", userID, route.APIIDs).Count(&count).Error; err != nil {
+		return fmt.Errorf("查询API配置失败: %w", err)
+	}
+	if int(count) != len(route.APIIDs) {
+		return errors.New("API配置不存在")
+	}
+
+	var existing database.ModelRoute
+	err := s.db.Where("user_id = ? AND name = ? AND id <> ?", userID, route.Name, excludeID).First(&existing).Error
+	if err == nil {
+		return errors.New("该路由名称已存在")
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return fmt.Errorf("查询模型路由失败: %w", err)
+	}
+	return nil
+}
+
+// CreateModelRoute 创建模型路由
+func (s *userAPIService) CreateModelRoute(userID uint, route *database.ModelRoute) (*database.ModelRoute, error) {
+	if err := s.validateModelRoute(userID, route, 0); err != nil {
+		return nil, err
+	}
+	route.UserID = userID
+	if err := s.db.Create(route).Error; err != nil {
+		return nil, fmt.Errorf("创建模型路由失败: %w", err)
+	}
+	return route, nil
+}
+
+// GetModelRoutes 获取用户的模型路由
+func (s *userAPIService) GetModelRoutes(userID uint) ([]database.ModelRoute, error) {
+	var routes []database.ModelRoute
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&routes).Error; err != nil {
+		return nil, fmt.Errorf("查询模型路由失败: %w", err)
+	}
+	return routes, nil
+}
+
+// UpdateModelRoute 修改模型路由的名称、策略和配置列表
+func (s *userAPIService) UpdateModelRoute(userID, routeID uint, route *database.ModelRoute) (*database.ModelRoute, error) {
+	var existing database.ModelRoute
+	if err := s.db.Where("id = ? AND user_id = ?", routeID, userID).First(&existing).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrModelRouteNotFound
+		}
+		return nil, fmt.Errorf("查询模型路由失败: %w", err)
+	}
+	if err := s.validateModelRoute(userID, route, routeID); err != nil {
+		return nil, err
+	}
+
+	existing.Name, existing.Policy, existing.APIIDs = route.Name, route.Policy, route.APIIDs
+	if err := s.db.Save(&existing).Error; err != nil {
+		return nil, fmt.Errorf("更新模型路由失败: %w", err)
+	}
+	return &existing, nil
+}
+
+// DeleteModelRoute 删除模型路由
+func (s *userAPIService) DeleteModelRoute(userID, routeID uint) error {
+	result := s.db.Where("id = ? AND user_id = ?", routeID, userID).Delete(&database.ModelRoute{})
+	if result.Error != nil {
+		return fmt.Errorf("删除模型路由失败: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrModelRouteNotFound
+	}
+	return nil
+}
+
+// ResolveModelRoute 按模型名解析候选配置：有同名路由时使用路由中的配置和策略，
+// 否则同一模型名的所有配置按创建顺序回退，连通性检查失败的排在最后
+func (s *userAPIService) ResolveModelRoute(userID uint, modelName string) (*ResolvedRoute, error) {
+	resolved := &ResolvedRoute{Key: fmt.Sprintf("%d:%s", userID, modelName), Policy: database.RoutePolicyFallback}
+
+	var route database.ModelRoute
+	err := s.db.Where("user_id = ? AND name = ?", userID, modelName).First(&route).Error
+	switch {
+	case err == nil:
+		var apis []database.UserAPI
+		if err := s.db.Where("user_id = ? AND id IN ?", userID, route.APIIDs).Find(&apis).Error; err != nil {
+			return nil, fmt.Errorf("查询API配置失败: %w", err)
+		}
+		byID := make(map[uint]database.UserAPI, len(apis))
+		for _, api := range apis {
+			byID[api.ID] = api
+		}
+		// 按路由中的顺序排列，已删除的配置跳过
+		for _, id := range route.APIIDs {
+			if api, ok := byID[id]; ok {
+				resolved.APIs = append(resolved.APIs, api)
+			}
+		}
+		resolved.Policy = route.Policy
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if err := s.db.Where("user_id = ? AND model_name = ?", userID, modelName).Order("id").Find(&resolved.APIs).Error; err != nil {
+			return nil, fmt.Errorf("查询API配置失败: %w", err)
+		}
+		sort.SliceStable(resolved.APIs, func(i, j int) bool {
+			return resolved.APIs[i].HealthStatus != database.APIUnhealthy && resolved.APIs[j].HealthStatus == database.APIUnhealthy
+		})
+	default:
+		return nil, fmt.Errorf("查询模型路由失败: %w", err)
+	}
+
+	if len(resolved.APIs) == 0 {
+		return nil, errors.New("API配置不存在")
+	}
+	for i := range resolved.APIs {
+		if err := s.openAPIKey(&resolved.APIs[i]); err != nil {
+			return nil, err
+		}
+	}
+	return resolved, nil
+}
@@ -0,0 +1,286 @@
+package LLM_Chat
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"github.com/ledongthuc/pdf"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxZipEntrySize 解压单个 XML 的上限，防止压缩炸弹
+const maxZipEntrySize = 100 << 20
+
+// extractPDF 提取 PDF 文本层（扫描件没有文本层，会提取到空内容）
+func extractPDF(data []byte, _ map[string]string) (text string, err error) {
+	// 解析库遇到损坏的文件会 panic
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("PDF 解析失败: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("PDF 解析失败: %w", err)
+	}
+	plain, err := reader.GetPlainText()
+	if err != nil {
+		return "", fmt.Errorf("PDF 解析失败: %w", err)
+	}
+	content, err := io.ReadAll(plain)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// extractDOCX 提取 Word 文档正文，段落之间换行
+func extractDOCX(data []byte, _ map[string]string) (string, error) {
+	files, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+	document, err := readZipEntry(files, "word/document.xml")
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	decoder := xml.NewDecoder(bytes.NewReader(document))
+	inText := false
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("DOCX 解析失败: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				builder.WriteString("\t")
+			case "br", "cr":
+				builder.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				builder.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				builder.Write(t)
+			}
+		}
+	}
+	return builder.String(), nil
+}
+
+// extractXLSX 提取 Excel 各工作表的单元格文本，每行单元格以 " | " 分隔
+func extractXLSX(data []byte, _ map[string]string) (string, error) {
+	files, err := openZip(data)
+	if err != nil {
+		return "", err
+	}
+
+	var sharedStrings []string
+	if content, err := readZipEntry(files, "xl/sharedStrings.xml"); err == nil {
+		if sharedStrings, err = parseSharedStrings(content); err != nil {
+			return "", err
+		}
+	}
+
+	sheets, err := listSheets(files)
+	if err != nil {
+		return "", err
+	}
+
+	var builder strings.Builder
+	for _, sheet := range sheets {
+		content, err := readZipEntry(files, sheet.path)
+		if err != nil {
+			return "", err
+		}
+		rows, err := parseSheetRows(content, sharedStrings)
+		if err != nil {
+			return "", err
+		}
+		if len(rows) == 0 {
+			continue
+		}
+		builder.WriteString(fmt.Sprintf("【工作表：%s】\n", sheet.name))
+		for _, row := range rows {
+			builder.WriteString(strings.Join(row, " | "))
+			builder.WriteString("\n")
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String(), nil
+}
+
+type xlsxSheet struct {
+	name string
+	path string
+}
+
+// listSheets 按工作簿中的顺序返回工作表名称和对应的 XML 路径
+func listSheets(files map[string]*zip.File) ([]xlsxSheet, error) {
+	workbook, err := readZipEntry(files, "xl/workbook.xml")
+	if err != nil {
+		return nil, err
+	}
+	var wb struct {
+		Sheets []struct {
+			Name string `xml:"name,attr"`
+			RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := xml.Unmarshal(workbook, &wb); err != nil {
+		return nil, fmt.Errorf("XLSX 解析失败: %w", err)
+	}
+
+	targets := make(map[string]string)
+	if rels, err := readZipEntry(files, "xl/_rels/workbook.xml.rels"); err == nil {
+		var relationships struct {
+			Items []struct {
+				ID     string `xml:"Id,attr"`
+				Target string `xml:"Target,attr"`
+			} `xml:"Relationship"`
+		}
+		if err := xml.Unmarshal(rels, &relationships); err != nil {
+			return nil, fmt.Errorf("XLSX 解析失败: %w", err)
+		}
+		for _, item := range relationships.Items {
+			target := strings.TrimPrefix(item.Target, "/")
+			if !strings.HasPrefix(target, "xl/") {
+				target = path.Join("xl", target)
+			}
+			targets[item.ID] = target
+		}
+	}
+
+	sheets := make([]xlsxSheet, 0, len(wb.Sheets))
+	for i, sheet := range wb.Sheets {
+		sheetPath, ok := targets[sheet.RID]
+		if !ok {
+			sheetPath = fmt.Sprintf("xl/worksheets/sheet%d.xml", i+1)
+		}
+		sheets = append(sheets, xlsxSheet{name: sheet.Name, path: sheetPath})
+	}
+	return sheets, nil
+}
+
+// parseSharedStrings 解析共享字符串表，富文本的多个片段拼接为一个字符串
+func parseSharedStrings(content []byte) ([]string, error) {
+	var sst struct {
+		Items []struct {
+			Text string `xml:"t"`
+			Runs []struct {
+				Text string `xml:"t"`
+			} `xml:"r"`
+		} `xml:"si"`
+	}
+	if err := xml.Unmarshal(content, &sst); err != nil {
+		return nil, fmt.Errorf("XLSX 解析失败: %w", err)
+	}
+
+	result := make([]string, len(sst.Items))
+	for i, item := range sst.Items {
+		text := item.Text
+		for _, run := range item.Runs {
+			text += run.Text
+		}
+		result[i] = text
+	}
+	return result, nil
+}
+
+// parseSheetRows 解析工作表中的非空行
+func parseSheetRows(content []byte, sharedStrings []string) ([][]string, error) {
+	var sheet struct {
+		Rows []struct {
+			Cells []struct {
+				Type   string `xml:"t,attr"`
+				Value  string `xml:"v"`
+				Inline struct {
+					Text string `xml:"t"`
+				} `xml:"is"`
+			} `xml:"c"`
+		} `xml:"sheetData>row"`
+	}
+	if err := xml.Unmarshal(content, &sheet); err != nil {
+		return nil, fmt.Errorf("XLSX 解析失败: %w", err)
+	}
+
+	var rows [][]string
+	for _, row := range sheet.Rows {
+		var cells []string
+		empty := true
+		for _, cell := range row.Cells {
+			value := cell.Value
+			switch cell.Type {
+			case "s":
+				if index, err := strconv.Atoi(value); err == nil && index >= 0 && index < len(sharedStrings) {
+					value = sharedStrings[index]
+				}
+			case "inlineStr":
+				value = cell.Inline.Text
+			case "b":
+				value = map[string]string{"1": "TRUE", "0": "FALSE"}[value]
+			}
+			if value != "" {
+				empty = false
+			}
+			cells = append(cells, value)
+		}
+		if !empty {
+			rows = append(rows, cells)
+		}
+	}
+	return rows, nil
+}
+
+func openZip(data []byte) (map[string]*zip.File, error) {
+	reader, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("文件解压失败: %w", err)
+	}
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, file := range reader.File {
+		files[file.Name] = file
+	}
+	return files, nil
+}
+
+func readZipEntry(files map[string]*zip.File, name string) ([]byte, error) {
+	file, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("文件中缺少 %s", name)
+	}
+	rc, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	content, err := io.ReadAll(io.LimitReader(rc, maxZipEntrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > maxZipEntrySize {
+		return nil, errors.New("文件解压后过大")
+	}
+	return content, nil
+}
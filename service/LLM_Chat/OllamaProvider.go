@@ -0,0 +1,270 @@
+package LLM_Chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"net/http"
+	"platfrom/database"
+	"strings"
+)
+
+const ollamaDefaultBaseURL = "http://localhost:11434"
+
+// OllamaProvider 本地 Ollama /api/chat 接口的实现
+type OllamaProvider struct {
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+func NewOllamaProvider(baseURL string) LLMProviderInterface {
+	if baseURL == "" {
+		baseURL = ollamaDefaultBaseURL
+	}
+	return &OllamaProvider{
+		BaseURL:    baseURL,
+		HTTPClient: providerHTTPClient,
+	}
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Images    []string         `json:"images,omitempty"` // base64 编码的图片（不含 data URL 前缀）
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaOptions struct {
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Options  *ollamaOptions  `json:"options,omitempty"`
+	Tools    []openai.Tool   `json:"tools,omitempty"`
+}
+
+type ollamaResponse struct {
+	Message    ollamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason"`
+	Error      string        `json:"error"`
+	// 最后一个响应中返回的输入、输出 token 数
+	PromptEvalCount int `json:"prompt_eval_count"`
+	EvalCount       int `json:"eval_count"`
+}
+
+func (p *OllamaProvider) Name() string {
+	return database.ProviderOllama
+}
+
+// CreateChatCompletion 同步请求
+func (p *OllamaProvider) CreateChatCompletion(ctx context.Context, req ProviderRequest) (*ProviderResponse, error) {
+	resp, err := p.doRequest(ctx, p.buildRequest(req, false))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result ollamaResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析 Ollama 响应失败: %w", err)
+	}
+	if result.Error != "" {
+		return nil, fmt.Errorf("ChatCompletion error: %s", result.Error)
+	}
+
+	return &ProviderResponse{
+		Content:      result.Message.Content,
+		FinishReason: result.DoneReason,
+		ToolCalls:    convertOllamaToolCalls(result.Message.ToolCalls, 0),
+		Usage:        TokenUsage{PromptTokens: result.PromptEvalCount, CompletionTokens: result.EvalCount},
+	}, nil
+}
+
+// CreateChatCompletionStream 流式请求（每行一个 JSON 对象）
+func (p *OllamaProvider) CreateChatCompletionStream(ctx context.Context, req ProviderRequest, onChunk func(chunk string) error) (*ProviderResponse, error) {
+	resp, err := p.doRequest(ctx, p.buildRequest(req, true))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var fullResponse strings.Builder
+	var finishReason string
+	var usage TokenUsage
+	var toolCalls []openai.ToolCall
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		var chunk ollamaResponse
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return nil, fmt.Errorf("解析 Ollama 流式数据失败: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("Stream error: %s", chunk.Error)
+		}
+
+		// Ollama 的工具调用一次性返回，不会分片
+		toolCalls = append(toolCalls, convertOllamaToolCalls(chunk.Message.ToolCalls, len(toolCalls))...)
+
+		if chunk.Message.Content != "" {
+			fullResponse.WriteString(chunk.Message.Content)
+			if onChunk != nil {
+				if err := onChunk(chunk.Message.Content); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if chunk.Done {
+			finishReason = chunk.DoneReason
+			usage = TokenUsage{PromptTokens: chunk.PromptEvalCount, CompletionTokens: chunk.EvalCount}
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Stream error: %w", err)
+	}
+
+	return &ProviderResponse{
+		Content:      fullResponse.String(),
+		FinishReason: finishReason,
+		ToolCalls:    toolCalls,
+		Usage:        usage,
+	}, nil
+}
+
+// convertOllamaToolCalls Ollama 的工具调用没有 ID，按序号生成
+func convertOllamaToolCalls(calls []ollamaToolCall, offset int) []openai.ToolCall {
+	var toolCalls []openai.ToolCall
+	for i, call := range calls {
+		arguments := string(call.Function.Arguments)
+		if arguments == "" || arguments == "null" {
+			arguments = "{}"
+		}
+		toolCalls = append(toolCalls, openai.ToolCall{
+			ID:   fmt.Sprintf("call_%d", offset+i),
+			Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{
+				Name:      call.Function.Name,
+				Arguments: arguments,
+			},
+		})
+	}
+	return toolCalls
+}
+
+// buildRequest 将 OpenAI 格式的消息转换为 Ollama 格式
+func (p *OllamaProvider) buildRequest(req ProviderRequest, stream bool) *ollamaRequest {
+	// 工具结果消息需要带上工具名，先记录 tool_call_id 与工具名的对应关系
+	toolNames := make(map[string]string)
+	messages := make([]ollamaMessage, 0, len(req.Messages))
+	for _, msg := range req.Messages {
+		converted := ollamaMessage{Role: msg.Role, Content: msg.Content}
+		if len(msg.MultiContent) > 0 {
+			var texts []string
+			for _, part := range msg.MultiContent {
+				switch part.Type {
+				case openai.ChatMessagePartTypeText:
+					texts = append(texts, part.Text)
+				case openai.ChatMessagePartTypeImageURL:
+					// Ollama 只接受 base64 图片
+					if part.ImageURL == nil {
+						continue
+					}
+					if _, data, ok := ParseDataURL(part.ImageURL.URL); ok {
+						converted.Images = append(converted.Images, data)
+					}
+				}
+			}
+			converted.Content = strings.Join(texts, "\n")
+		}
+		for _, call := range msg.ToolCalls {
+			toolNames[call.ID] = call.Function.Name
+			var toolCall ollamaToolCall
+			toolCall.Function.Name = call.Function.Name
+			toolCall.Function.Arguments = json.RawMessage(call.Function.Arguments)
+			if !json.Valid(toolCall.Function.Arguments) {
+				toolCall.Function.Arguments = json.RawMessage("{}")
+			}
+			converted.ToolCalls = append(converted.ToolCalls, toolCall)
+		}
+		if msg.Role == openai.ChatMessageRoleTool {
+			converted.ToolName = toolNames[msg.ToolCallID]
+		}
+		messages = append(messages, converted)
+	}
+	return &ollamaRequest{
+		Model:    req.Model,
+		Messages: messages,
+		Stream:   stream,
+		Options: &ollamaOptions{
+			Temperature:      req.Params.Temperature,
+			TopP:             req.Params.TopP,
+			NumPredict:       req.Params.MaxTokens,
+			PresencePenalty:  req.Params.PresencePenalty,
+			FrequencyPenalty: req.Params.FrequencyPenalty,
+			Stop:             req.Params.Stop,
+			Seed:             req.Params.Seed,
+		},
+		Tools: req.Tools,
+	}
+}
+
+// doRequest 发送请求，非 2xx 响应转换为 ProviderError
+func (p *OllamaProvider) doRequest(ctx context.Context, body *ollamaRequest) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, joinURL(p.BaseURL, "/api/chat"), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.HTTPClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("请求 Ollama 失败: %w", err)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		respBody, _ := io.ReadAll(resp.Body)
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		_ = json.Unmarshal(respBody, &errResp)
+		return nil, newProviderError(p.Name(), resp, errResp.Error, respBody)
+	}
+
+	return resp, nil
+}
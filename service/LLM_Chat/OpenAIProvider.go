@@ -0,0 +1,170 @@
+package LLM_Chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"io"
+	"math"
+	"platfrom/database"
+	"strings"
+)
+
+// OpenAIProvider OpenAI 及兼容接口（DeepSeek、Moonshot、vLLM 等）的实现
+type OpenAIProvider struct {
+	Client *openai.Client
+}
+
+func NewOpenAIProvider(apiKey, baseURL string) LLMProviderInterface {
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = strings.TrimRight(baseURL, "/")
+	}
+	config.HTTPClient = providerHTTPClient
+	return &OpenAIProvider{
+		Client: openai.NewClientWithConfig(config),
+	}
+}
+
+func (p *OpenAIProvider) Name() string {
+	return database.ProviderOpenAI
+}
+
+// CreateChatCompletion 同步请求
+func (p *OpenAIProvider) CreateChatCompletion(ctx context.Context, req ProviderRequest) (*ProviderResponse, error) {
+	resp, err := p.Client.CreateChatCompletion(ctx, p.buildRequest(req, false))
+	if err != nil {
+		return nil, fmt.Errorf("ChatCompletion error: %w", err)
+	}
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("ChatCompletion error: 响应中没有可用的回复")
+	}
+
+	return &ProviderResponse{
+		Content:      resp.Choices[0].Message.Content,
+		FinishReason: string(resp.Choices[0].FinishReason),
+		ToolCalls:    resp.Choices[0].Message.ToolCalls,
+		Usage:        TokenUsage{PromptTokens: resp.Usage.PromptTokens, CompletionTokens: resp.Usage.CompletionTokens},
+	}, nil
+}
+
+// CreateChatCompletionStream 流式请求
+func (p *OpenAIProvider) CreateChatCompletionStream(ctx context.Context, req ProviderRequest, onChunk func(chunk string) error) (*ProviderResponse, error) {
+	stream, err := p.Client.CreateChatCompletionStream(ctx, p.buildRequest(req, true))
+	if err != nil {
+		return nil, fmt.Errorf("ChatCompletionStream error: %w", err)
+	}
+	defer stream.Close()
+
+	var fullResponse strings.Builder
+	var finishReason string
+	var usage TokenUsage
+	// 工具调用以增量形式返回，按 index 拼接
+	var toolCalls []openai.ToolCall
+
+	for {
+		response, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, fmt.Errorf("Stream error: %w", err)
+		}
+
+		// 开启 include_usage 后用量在最后一个（choices 为空的）数据块中返回
+		if response.Usage != nil {
+			usage = TokenUsage{PromptTokens: response.Usage.PromptTokens, CompletionTokens: response.Usage.CompletionTokens}
+		}
+		if len(response.Choices) == 0 {
+			continue
+		}
+		if response.Choices[0].FinishReason != "" {
+			finishReason = string(response.Choices[0].FinishReason)
+		}
+		toolCalls = mergeToolCallDeltas(toolCalls, response.Choices[0].Delta.ToolCalls)
+
+		chunk := response.Choices[0].Delta.Content
+		if chunk == "" {
+			continue
+		}
+		fullResponse.WriteString(chunk)
+
+		if onChunk != nil {
+			if err := onChunk(chunk); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &ProviderResponse{
+		Content:      fullResponse.String(),
+		FinishReason: finishReason,
+		ToolCalls:    toolCalls,
+		Usage:        usage,
+	}, nil
+}
+
+// mergeToolCallDeltas 把流式返回的工具调用片段合并到已有结果中
+func mergeToolCallDeltas(toolCalls []openai.ToolCall, deltas []openai.ToolCall) []openai.ToolCall {
+	for i, delta := range deltas {
+		index := i
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		for len(toolCalls) <= index {
+			toolCalls = append(toolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
+		}
+
+		call := &toolCalls[index]
+		if delta.ID != "" {
+			call.ID = delta.ID
+		}
+		if delta.Function.Name != "" {
+			call.Function.Name = delta.Function.Name
+		}
+		call.Function.Arguments += delta.Function.Arguments
+	}
+	return toolCalls
+}
+
+// buildRequest 构造 OpenAI 请求并填充生成参数
+func (p *OpenAIProvider) buildRequest(req ProviderRequest, stream bool) openai.ChatCompletionRequest {
+	chatReq := openai.ChatCompletionRequest{
+		Model:    req.Model,
+		Messages: req.Messages,
+		Stream:   stream,
+		Stop:     req.Params.Stop,
+		Seed:     req.Params.Seed,
+		Tools:    req.Tools,
+	}
+	if stream {
+		chatReq.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+
+	// go-openai 使用 omitempty 序列化浮点数，0 会被省略，这里用最小正数代替显式的 0
+	if req.Params.Temperature != nil {
+		chatReq.Temperature = nonZeroFloat32(*req.Params.Temperature)
+	}
+	if req.Params.TopP != nil {
+		chatReq.TopP = nonZeroFloat32(*req.Params.TopP)
+	}
+	if req.Params.MaxTokens != nil {
+		chatReq.MaxTokens = *req.Params.MaxTokens
+	}
+	if req.Params.PresencePenalty != nil {
+		chatReq.PresencePenalty = *req.Params.PresencePenalty
+	}
+	if req.Params.FrequencyPenalty != nil {
+		chatReq.FrequencyPenalty = *req.Params.FrequencyPenalty
+	}
+
+	return chatReq
+}
+
+func nonZeroFloat32(v float32) float32 {
+	if v == 0 {
+		return math.SmallestNonzeroFloat32
+	}
+	return v
+}
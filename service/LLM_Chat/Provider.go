@@ -0,0 +1,161 @@
+package LLM_Chat
+
+import (
+	"context"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"platfrom/database"
+	"strings"
+)
+
+// ProviderRequest 发送给模型提供商的统一请求
+type ProviderRequest struct {
+	Model    string
+	Messages []openai.ChatCompletionMessage
+	Params   database.GenerationParams
+	Tools    []openai.Tool // 可供模型调用的工具，为空时不启用工具调用
+}
+
+// ProviderResponse 模型提供商返回的统一响应
+type ProviderResponse struct {
+	Content      string
+	FinishReason string
+	ToolCalls    []openai.ToolCall // 模型请求调用的工具
+	Usage        TokenUsage        // 提供商返回的 token 用量，没有返回时为 0
+}
+
+// TokenUsage 一次或多次请求的 token 用量
+type TokenUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+// Add 累加另一次请求的用量（工具调用会产生多次请求）
+func (u TokenUsage) Add(other TokenUsage) TokenUsage {
+	return TokenUsage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+	}
+}
+
+// Total 输入和输出 token 之和
+func (u TokenUsage) Total() int {
+	return u.PromptTokens + u.CompletionTokens
+}
+
+// LLMProviderInterface 模型提供商接口，屏蔽不同厂商 API 的差异
+// 消息统一使用 openai.ChatCompletionMessage 表示，由各实现自行转换为厂商格式
+type LLMProviderInterface interface {
+	Name() string
+	CreateChatCompletion(ctx context.Context, req ProviderRequest) (*ProviderResponse, error)
+	CreateChatCompletionStream(ctx context.Context, req ProviderRequest, onChunk func(chunk string) error) (*ProviderResponse, error)
+}
+
+// ProviderConfig 创建会话所需的模型连接配置
+type ProviderConfig struct {
+	Provider      string
+	APIKey        string
+	BaseURL       string
+	ModelName     string
+	ContextBudget int // 上下文 token 预算，0 表示使用模型的上下文长度
+	// Route 非空时会话在路由的候选配置间分发请求，上面的连接字段不再使用
+	Route *ResolvedRoute
+}
+
+// ProviderError 提供商返回的 HTTP 错误
+type ProviderError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *ProviderError) Error() string {
+	return fmt.Sprintf("%s 请求失败 (HTTP %d): %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// NewLLMProvider 根据提供商类型创建对应的实现
+func NewLLMProvider(provider, apiKey, baseURL string) (LLMProviderInterface, error) {
+	switch NormalizeProvider(provider) {
+	case database.ProviderOpenAI:
+		return NewOpenAIProvider(apiKey, baseURL), nil
+	case database.ProviderAnthropic:
+		return NewAnthropicProvider(apiKey, baseURL), nil
+	case database.ProviderOllama:
+		return NewOllamaProvider(baseURL), nil
+	default:
+		return nil, fmt.Errorf("不支持的模型提供商: %s", provider)
+	}
+}
+
+// NormalizeProvider 规范化提供商名称，空值视为 OpenAI 兼容接口（兼容旧数据）
+func NormalizeProvider(provider string) string {
+	provider = strings.ToLower(strings.TrimSpace(provider))
+	if provider == "" {
+		return database.ProviderOpenAI
+	}
+	return provider
+}
+
+// IsSupportedProvider 检查提供商是否受支持
+func IsSupportedProvider(provider string) bool {
+	switch NormalizeProvider(provider) {
+	case database.ProviderOpenAI, database.ProviderAnthropic, database.ProviderOllama:
+		return true
+	}
+	return false
+}
+
+// MergeGenerationParams 用 override 中已设置的字段覆盖 base，返回合并后的参数
+func MergeGenerationParams(base database.GenerationParams, override *database.GenerationParams) database.GenerationParams {
+	if override == nil {
+		return base
+	}
+	merged := base
+	if override.Temperature != nil {
+		merged.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		merged.TopP = override.TopP
+	}
+	if override.MaxTokens != nil {
+		merged.MaxTokens = override.MaxTokens
+	}
+	if override.PresencePenalty != nil {
+		merged.PresencePenalty = override.PresencePenalty
+	}
+	if override.FrequencyPenalty != nil {
+		merged.FrequencyPenalty = override.FrequencyPenalty
+	}
+	if len(override.Stop) > 0 {
+		merged.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		merged.Seed = override.Seed
+	}
+	return merged
+}
+
+// joinURL 拼接 BaseURL 与接口路径，避免重复的版本前缀和斜杠
+func joinURL(baseURL, path string) string {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if strings.HasSuffix(baseURL, "/v1") && strings.HasPrefix(path, "/v1/") {
+		path = strings.TrimPrefix(path, "/v1")
+	}
+	return baseURL + path
+}
+
+// newProviderError 从非 2xx 响应中构造错误，message 为空时使用响应体原文
+func newProviderError(provider string, resp *http.Response, message string, body []byte) error {
+	if message == "" {
+		message = strings.TrimSpace(string(body))
+	}
+	if message == "" {
+		message = http.StatusText(resp.StatusCode)
+	}
+	return &ProviderError{
+		Provider:   provider,
+		StatusCode: resp.StatusCode,
+		Message:    message,
+	}
+}
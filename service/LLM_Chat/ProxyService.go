@@ -0,0 +1,308 @@
+package LLM_Chat
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"io"
+	"log"
+	"net/http"
+	"platfrom/database"
+	"strings"
+	"time"
+)
+
+// OpenAI 兼容网关：脚本和 IDE 插件使用平台签发的令牌调用 /v1 接口，
+// 按请求中的模型名找到用户保存的 API 配置，带上其中的密钥转发给上游，客户端不需要持有提供商的密钥
+
+var (
+	// ErrInvalidProxyRequest 请求体不是合法的 Chat Completions 请求
+	ErrInvalidProxyRequest = errors.New("请求格式错误")
+	// ErrModelNotConfigured 用户没有配置该模型
+	ErrModelNotConfigured = errors.New("未配置该模型")
+	// ErrUpstreamUnavailable 上游请求失败（未收到响应）
+	ErrUpstreamUnavailable = errors.New("上游服务不可用")
+)
+
+// openAIDefaultBaseURL 未配置 BaseURL 时 OpenAI 兼容接口的地址（与 go-openai 的默认值一致）
+const openAIDefaultBaseURL = "https://api.openai.com/v1"
+
+// proxyMaxResponseSize 非流式响应的最大读取长度
+const proxyMaxResponseSize = 32 << 20
+
+// ProxyModel /v1/models 返回的模型
+type ProxyModel struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	OwnedBy string `json:"owned_by"`
+}
+
+// ProxyServiceInterface OpenAI 兼容网关服务接口
+type ProxyServiceInterface interface {
+	// ChatCompletions 转发 Chat Completions 请求并把上游响应（包括流式响应）原样写入 w。
+	// 写入响应之前失败时返回 ErrInvalidProxyRequest、ErrModelNotConfigured 或 ErrUpstreamUnavailable，由调用方返回错误
+	ChatCompletions(ctx context.Context, userID, tokenID uint, body []byte, w http.ResponseWriter) error
+	// ListModels 用户已配置的模型
+	ListModels(userID uint) ([]ProxyModel, error)
+}
+
+// ProxyService OpenAI 兼容网关服务实现
+type ProxyService struct {
+	db         *gorm.DB
+	apiService UserAPIServiceInterface
+	client     *http.Client
+}
+
+var GlobalProxyService ProxyServiceInterface
+
+func NewProxyService(db *gorm.DB, apiService UserAPIServiceInterface) (ProxyServiceInterface, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接不能为空")
+	}
+	if apiService == nil {
+		return nil, errors.New("API配置服务不能为空")
+	}
+
+	// 不设置整体超时，流式响应可能持续较长时间，由请求的 context 控制
+	service := &ProxyService{
+		db:         db,
+		apiService: apiService,
+		client:     &http.Client{},
+	}
+	GlobalProxyService = service
+	return service, nil
+}
+
+// proxyUsage 上游返回的 token 用量
+type proxyUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+// proxyUpstreamURL 上游 Chat Completions 接口地址。OpenAI 兼容接口与会话中一样直接拼接 BaseURL，
+// Anthropic 和 Ollama 使用它们的 OpenAI 兼容接口（/v1/chat/completions）
+func proxyUpstreamURL(api *database.UserAPI) string {
+	switch NormalizeProvider(api.Provider) {
+	case database.ProviderAnthropic:
+		baseURL := api.BaseURL
+		if baseURL == "" {
+			baseURL = anthropicDefaultBaseURL
+		}
+		return joinURL(baseURL, "/v1/chat/completions")
+	case database.ProviderOllama:
+		baseURL := api.BaseURL
+		if baseURL == "" {
+			baseURL = ollamaDefaultBaseURL
+		}
+		return joinURL(baseURL, "/v1/chat/completions")
+	default:
+		baseURL := api.BaseURL
+		if baseURL == "" {
+			baseURL = openAIDefaultBaseURL
+		}
+		return strings.TrimRight(baseURL, "/") + "/chat/completions"
+	}
+}
+
+// ChatCompletions 转发 Chat Completions 请求
+func (s *ProxyService) ChatCompletions(ctx context.Context, userID, tokenID uint, body []byte, w http.ResponseWriter) error {
+	var request struct {
+		Model  string `json:"model"`
+		Stream bool   `json:"stream"`
+	}
+	if err := json.Unmarshal(body, &request); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidProxyRequest, err)
+	}
+	if request.Model == "" {
+		return fmt.Errorf("%w: 缺少 model", ErrInvalidProxyRequest)
+	}
+
+	api, err := s.apiService.GetAPIByModelName(userID, request.Model)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrModelNotConfigured, request.Model)
+	}
+
+	// 流式请求要求上游返回用量，客户端自己没有要求时不转发只含用量的事件
+	usageRequested := true
+	if request.Stream {
+		if body, usageRequested, err = withStreamUsage(body); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidProxyRequest, err)
+		}
+	}
+
+	usageLog := &database.ProxyUsageLog{
+		UserID:  userID,
+		TokenID: tokenID,
+		APIID:   api.ID,
+		Model:   request.Model,
+		Stream:  request.Stream,
+	}
+	start := time.Now()
+	defer func() {
+		usageLog.LatencyMs = time.Since(start).Milliseconds()
+		// /v1 接口的用量同样计入每日汇总，没有会话
+		usage := TokenUsage{PromptTokens: usageLog.PromptTokens, CompletionTokens: usageLog.CompletionTokens}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Create(usageLog).Error; err != nil {
+				return err
+			}
+			return recordUsage(tx, userID, "", api.ID, api.ModelName, usage, usageCost(api.ModelName, usage))
+		})
+		if err != nil {
+			log.Printf("记录接口用量失败 (user: %d): %v", userID, err)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, proxyUpstreamURL(api), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if api.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+api.APIKey)
+	}
+	if request.Stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)
+	}
+	defer resp.Body.Close()
+	usageLog.StatusCode = resp.StatusCode
+
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	// 上游返回错误或非流式响应：整体读取后原样返回
+	if !request.Stream || resp.StatusCode/100 != 2 {
+		data, err := io.ReadAll(io.LimitReader(resp.Body, proxyMaxResponseSize))
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrUpstreamUnavailable, err)
+		}
+		var response struct {
+			Usage *proxyUsage `json:"usage"`
+		}
+		if json.Unmarshal(data, &response) == nil && response.Usage != nil {
+			applyProxyUsage(usageLog, response.Usage)
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = w.Write(data)
+		return nil
+	}
+
+	// 流式响应：逐行转发，每个事件结束（空行）时刷新；上游在最后的事件中返回 usage 时记录
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(resp.StatusCode)
+	flusher, _ := w.(http.Flusher)
+	reader := bufio.NewReader(resp.Body)
+	skipping := false // 正在跳过一个只含用量的事件，直到事件结束的空行
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			blank := strings.TrimSpace(line) == ""
+			if data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:"); ok {
+				var chunk struct {
+					Choices []json.RawMessage `json:"choices"`
+					Usage   *proxyUsage       `json:"usage"`
+				}
+				if json.Unmarshal([]byte(strings.TrimSpace(data)), &chunk) == nil && chunk.Usage != nil {
+					applyProxyUsage(usageLog, chunk.Usage)
+					skipping = !usageRequested && len(chunk.Choices) == 0
+				}
+			}
+			if !skipping {
+				if _, writeErr := io.WriteString(w, line); writeErr != nil {
+					// 客户端已断开
+					return nil
+				}
+				if blank && flusher != nil {
+					flusher.Flush()
+				}
+			}
+			if blank {
+				skipping = false
+			}
+		}
+		if err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("读取上游流式响应失败 (user: %d, model: %s): %v", userID, request.Model, err)
+			}
+			break
+		}
+	}
+	if flusher != nil {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// withStreamUsage 在流式请求中设置 stream_options.include_usage，OpenAI 兼容的上游只在设置后于流的最后返回用量。
+// 返回修改后的请求体，以及客户端自己是否要求了用量
+func withStreamUsage(body []byte) ([]byte, bool, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, false, err
+	}
+	options := make(map[string]json.RawMessage)
+	if raw, ok := fields["stream_options"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &options); err != nil {
+			return nil, false, fmt.Errorf("stream_options: %w", err)
+		}
+	}
+
+	requested := false
+	if raw, ok := options["include_usage"]; ok {
+		_ = json.Unmarshal(raw, &requested)
+	}
+	options["include_usage"] = json.RawMessage("true")
+	raw, err := json.Marshal(options)
+	if err != nil {
+		return nil, false, err
+	}
+	fields["stream_options"] = raw
+
+	data, err := json.Marshal(fields)
+	return data, requested, err
+}
+
+func applyProxyUsage(usageLog *database.ProxyUsageLog, usage *proxyUsage) {
+	usageLog.PromptTokens = usage.PromptTokens
+	usageLog.CompletionTokens = usage.CompletionTokens
+	usageLog.TotalTokens = usage.TotalTokens
+	if usageLog.TotalTokens == 0 {
+		usageLog.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	}
+}
+
+// ListModels 用户已配置的模型（同名模型只列出一次）
+func (s *ProxyService) ListModels(userID uint) ([]ProxyModel, error) {
+	apis, err := s.apiService.GetUserAPIs(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	models := make([]ProxyModel, 0, len(apis))
+	seen := make(map[string]bool)
+	for _, api := range apis {
+		if api.ModelName == "" || seen[api.ModelName] {
+			continue
+		}
+		seen[api.ModelName] = true
+		models = append(models, ProxyModel{
+			ID:      api.ModelName,
+			Object:  "model",
+			Created: api.CreatedAt.Unix(),
+			OwnedBy: NormalizeProvider(api.Provider),
+		})
+	}
+	return models, nil
+}
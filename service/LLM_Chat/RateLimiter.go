@@ -0,0 +1,459 @@
+package LLM_Chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"os"
+	"platfrom/database"
+	"sync"
+	"time"
+)
+
+// 聊天接口的限流：每分钟请求数和同时进行的生成数用计数器实现（Redis 可用时多个实例共享，否则在进程内计数），
+// 每天的 token 配额按 UsageDaily 中当天的用量判断。限制按角色配置，管理员可为单个用户覆盖
+
+// 限制的种类，也是 RateLimits 中对应字段的 JSON 名
+const (
+	RateLimitRequests = "requests_per_minute"
+	RateLimitStreams  = "concurrent_streams"
+	RateLimitTokens   = "daily_tokens"
+)
+
+const (
+	// streamSlotTTL 并发计数的过期时间，实例异常退出未释放时由过期兜底
+	streamSlotTTL = jobWatchTimeout
+	// streamRetryAfter 并发数达到上限时建议的重试间隔
+	streamRetryAfter = 5 * time.Second
+)
+
+// defaultRateLimits 配置中未列出的角色使用的限制
+var defaultRateLimits = map[database.Role]database.RateLimits{
+	database.RoleGuest: {RequestsPerMinute: 5, ConcurrentStreams: 1, DailyTokens: 50000},
+	database.RoleUser:  {RequestsPerMinute: 20, ConcurrentStreams: 2, DailyTokens: 1000000},
+	database.RoleAdmin: {},
+}
+
+// ErrRateLimited 超出限制，具体的限制见 RateLimitError
+var ErrRateLimited = errors.New("超出使用限制")
+
+var ErrRateLimitUserNotFound = errors.New("用户不存在")
+
+// RateLimitError 超出的限制和可以重试的时间
+type RateLimitError struct {
+	Scope string // RateLimitRequests、RateLimitStreams 或 RateLimitTokens
+	Limit int64
+	Reset time.Time
+}
+
+func (e *RateLimitError) Error() string {
+	switch e.Scope {
+	case RateLimitRequests:
+		return fmt.Sprintf("请求过于频繁，每分钟最多 %d 次", e.Limit)
+	case RateLimitStreams:
+		return fmt.Sprintf("同时进行的生成过多，最多 %d 个", e.Limit)
+	case RateLimitTokens:
+		return fmt.Sprintf("今日 token 用量已达上限 %d", e.Limit)
+	}
+	return ErrRateLimited.Error()
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// RetryAfter 距离可以重试的时间，至少 1 秒
+func (e *RateLimitError) RetryAfter() time.Duration {
+	if wait := time.Until(e.Reset); wait > time.Second {
+		return wait
+	}
+	return time.Second
+}
+
+// RateLimitStatus 用户当前的限制和用量
+type RateLimitStatus struct {
+	Role          database.Role       `json:"role"`
+	Limits        database.RateLimits `json:"limits"`
+	Requests      int64               `json:"requests"` // 本分钟已发起的请求数
+	RequestsReset time.Time           `json:"requests_reset"`
+	Streams       int64               `json:"streams"`     // 正在进行的生成数
+	TokensUsed    int64               `json:"tokens_used"` // 今天已用的 token 数
+	TokensReset   time.Time           `json:"tokens_reset"`
+}
+
+type RateLimiterInterface interface {
+	AllowRequest(userID uint) (*RateLimitStatus, error)    // 检查配额并计入一次请求，超出时同时返回状态和 RateLimitError
+	AcquireStream(userID uint) (release func(), err error) // 占用一个并发名额，生成结束后调用 release
+	Status(userID uint) (*RateLimitStatus, error)
+
+	// 管理员为单个用户覆盖限制
+	GetUserOverride(userID uint) (*database.UserRateLimit, error) // 未设置时返回 nil
+	SetUserOverride(override *database.UserRateLimit) error
+	DeleteUserOverride(userID uint) error
+}
+
+var GlobalRateLimiter RateLimiterInterface
+
+type RateLimiter struct {
+	db      *gorm.DB
+	counter rateCounter
+	roles   map[database.Role]database.RateLimits
+}
+
+// NewRateLimiter Redis 不可用（client 为 nil）时在进程内计数，多个实例之间不共享
+func NewRateLimiter(db *gorm.DB, client *redis.Client, config database.RateLimitConfig) (RateLimiterInterface, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接不能为空")
+	}
+
+	roles := make(map[database.Role]database.RateLimits, len(defaultRateLimits))
+	for role, limits := range defaultRateLimits {
+		roles[role] = limits
+	}
+	for role, limits := range config.Roles {
+		if limits.RequestsPerMinute < 0 || limits.ConcurrentStreams < 0 || limits.DailyTokens < 0 {
+			return nil, fmt.Errorf("角色 %s 的限制不能为负数", role)
+		}
+		roles[role] = limits
+	}
+
+	var counter rateCounter
+	if client != nil {
+		counter = &redisRateCounter{client: client}
+	} else {
+		counter = newMemoryRateCounter()
+	}
+
+	limiter := &RateLimiter{db: db, counter: counter, roles: roles}
+	GlobalRateLimiter = limiter
+	return limiter, nil
+}
+
+// LoadRateLimitConfig 读取 style.yaml 中的 rate_limit 配置
+func LoadRateLimitConfig(configPath string) (database.RateLimitConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return database.RateLimitConfig{}, err
+	}
+
+	var config database.StyleConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return database.RateLimitConfig{}, err
+	}
+	return config.RateLimit, nil
+}
+
+func requestCounterKey(userID uint, window time.Time) string {
+	return fmt.Sprintf("rate_limit:requests:%d:%d", userID, window.Unix())
+}
+
+func streamCounterKey(userID uint) string {
+	return fmt.Sprintf("rate_limit:streams:%d", userID)
+}
+
+// resolve 用户的角色和生效的限制：角色的限制，再应用管理员的覆盖。未知角色按访客处理
+func (s *RateLimiter) resolve(userID uint) (database.Role, database.RateLimits, error) {
+	var roles []database.Role
+	if err := s.db.Model(&database.User{}).Where("id = ?", userID).Pluck("role", &roles).Error; err != nil {
+		return "", database.RateLimits{}, fmt.Errorf("查询用户角色失败: %w", err)
+	}
+	role := database.RoleGuest
+	if len(roles) > 0 {
+		role = roles[0]
+	}
+	limits, ok := s.roles[role]
+	if !ok {
+		limits = s.roles[database.RoleGuest]
+	}
+
+	override, err := s.GetUserOverride(userID)
+	if err != nil {
+		return "", database.RateLimits{}, err
+	}
+	if override != nil {
+		if override.RequestsPerMinute != nil {
+			limits.RequestsPerMinute = *override.RequestsPerMinute
+		}
+		if override.ConcurrentStreams != nil {
+			limits.ConcurrentStreams = *override.ConcurrentStreams
+		}
+		if override.DailyTokens != nil {
+			limits.DailyTokens = *override.DailyTokens
+		}
+	}
+	return role, limits, nil
+}
+
+// newStatus 查询角色、限制和今天的 token 用量
+func (s *RateLimiter) newStatus(userID uint) (*RateLimitStatus, error) {
+	role, limits, err := s.resolve(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	status := &RateLimitStatus{
+		Role:          role,
+		Limits:        limits,
+		RequestsReset: now.Truncate(time.Minute).Add(time.Minute),
+		TokensReset:   today.AddDate(0, 0, 1),
+	}
+	if err := s.db.Model(&database.UsageDaily{}).
+		Where("user_id = ? AND date = ?", userID, now.Format(usageDateLayout)).
+		Select("COALESCE(SUM(prompt_tokens + completion_tokens), 0)").Scan(&status.TokensUsed).Error; err != nil {
+		return nil, fmt.Errorf("查询用量失败: %w", err)
+	}
+	return status, nil
+}
+
+// AllowRequest 先检查今天的 token 配额（不计数），再计入本分钟的请求数
+func (s *RateLimiter) AllowRequest(userID uint) (*RateLimitStatus, error) {
+	status, err := s.newStatus(userID)
+	if err != nil {
+		return nil, err
+	}
+	limits := status.Limits
+
+	if limits.DailyTokens > 0 && status.TokensUsed >= limits.DailyTokens {
+		return status, &RateLimitError{Scope: RateLimitTokens, Limit: limits.DailyTokens, Reset: status.TokensReset}
+	}
+
+	if limits.RequestsPerMinute > 0 {
+		window := status.RequestsReset.Add(-time.Minute)
+		count, err := s.counter.incr(context.Background(), requestCounterKey(userID, window), 2*time.Minute)
+		if err != nil {
+			return nil, fmt.Errorf("请求计数失败: %w", err)
+		}
+		status.Requests = count
+		if count > int64(limits.RequestsPerMinute) {
+			return status, &RateLimitError{Scope: RateLimitRequests, Limit: int64(limits.RequestsPerMinute), Reset: status.RequestsReset}
+		}
+	}
+	return status, nil
+}
+
+// AcquireStream 并发数达到上限时返回 RateLimitError。release 可重复调用，只释放一次
+func (s *RateLimiter) AcquireStream(userID uint) (func(), error) {
+	_, limits, err := s.resolve(userID)
+	if err != nil {
+		return nil, err
+	}
+	if limits.ConcurrentStreams <= 0 {
+		return func() {}, nil
+	}
+
+	key := streamCounterKey(userID)
+	count, err := s.counter.incr(context.Background(), key, streamSlotTTL)
+	if err != nil {
+		return nil, fmt.Errorf("并发计数失败: %w", err)
+	}
+	if count > int64(limits.ConcurrentStreams) {
+		if err := s.counter.decr(context.Background(), key); err != nil {
+			log.Printf("释放并发计数失败 (user: %d): %v", userID, err)
+		}
+		return nil, &RateLimitError{Scope: RateLimitStreams, Limit: int64(limits.ConcurrentStreams), Reset: time.Now().Add(streamRetryAfter)}
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if err := s.counter.decr(context.Background(), key); err != nil {
+				log.Printf("释放并发计数失败 (user: %d): %v", userID, err)
+			}
+		})
+	}, nil
+}
+
+// Status 查询用户当前的限制和用量，不计入请求
+func (s *RateLimiter) Status(userID uint) (*RateLimitStatus, error) {
+	status, err := s.newStatus(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	window := status.RequestsReset.Add(-time.Minute)
+	if status.Requests, err = s.counter.get(ctx, requestCounterKey(userID, window)); err != nil {
+		return nil, fmt.Errorf("读取请求计数失败: %w", err)
+	}
+	if status.Streams, err = s.counter.get(ctx, streamCounterKey(userID)); err != nil {
+		return nil, fmt.Errorf("读取并发计数失败: %w", err)
+	}
+	return status, nil
+}
+
+func (s *RateLimiter) GetUserOverride(userID uint) (*database.UserRateLimit, error) {
+	var override database.UserRateLimit
+	err := s.db.Where("user_id = ?", userID).Take(&override).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("查询用户限制失败: %w", err)
+	}
+	return &override, nil
+}
+
+// SetUserOverride 整体替换用户的覆盖设置
+func (s *RateLimiter) SetUserOverride(override *database.UserRateLimit) error {
+	if (override.RequestsPerMinute != nil && *override.RequestsPerMinute < 0) ||
+		(override.ConcurrentStreams != nil && *override.ConcurrentStreams < 0) ||
+		(override.DailyTokens != nil && *override.DailyTokens < 0) {
+		return errors.New("限制不能为负数")
+	}
+
+	var count int64
+	if err := s.db.Model(&database.User{}).Where("id = ?", override.UserID).Count(&count).Error; err != nil {
+		return fmt.Errorf("查询用户失败: %w", err)
+	}
+	if count == 0 {
+		return ErrRateLimitUserNotFound
+	}
+
+	override.UpdatedAt = time.Now()
+	if err := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(override).Error; err != nil {
+		return fmt.Errorf("保存用户限制失败: %w", err)
+	}
+	return nil
+}
+
+func (s *RateLimiter) DeleteUserOverride(userID uint) error {
+	if err := s.db.Delete(&database.UserRateLimit{}, userID).Error; err != nil {
+		return fmt.Errorf("删除用户限制失败: %w", err)
+	}
+	return nil
+}
+
+// acquireStreamSlot 为一次生成占用并发名额。未启用限流或计数出错时不限制
+func acquireStreamSlot(userID uint) (func(), error) {
+	if GlobalRateLimiter == nil {
+		return func() {}, nil
+	}
+	release, err := GlobalRateLimiter.AcquireStream(userID)
+	if err != nil {
+		if errors.Is(err, ErrRateLimited) {
+			return nil, err
+		}
+		log.Printf("检查并发限制失败，本次不限制 (user: %d): %v", userID, err)
+		return func() {}, nil
+	}
+	return release, nil
+}
+
+// rateCounter 带过期时间的计数器
+type rateCounter interface {
+	incr(ctx context.Context, key string, ttl time.Duration) (int64, error) // 加一并刷新过期时间，返回新值
+	decr(ctx context.Context, key string) error
+	get(ctx context.Context, key string) (int64, error)
+}
+
+type redisRateCounter struct {
+	client *redis.Client
+}
+
+func (c *redisRateCounter) incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	pipe := c.client.TxPipeline()
+	incr := pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return incr.Val(), nil
+}
+
+func (c *redisRateCounter) decr(ctx context.Context, key string) error {
+	value, err := c.client.Decr(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	// 计数已过期后再释放会减到负数
+	if value <= 0 {
+		return c.client.Del(ctx, key).Err()
+	}
+	return nil
+}
+
+func (c *redisRateCounter) get(ctx context.Context, key string) (int64, error) {
+	value, err := c.client.Get(ctx, key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return value, err
+}
+
+// memoryRateCounter 进程内计数，过期的计数在访问时清理
+type memoryRateCounter struct {
+	mu        sync.Mutex
+	counts    map[string]*memoryCount
+	lastSweep time.Time
+}
+
+type memoryCount struct {
+	value   int64
+	expires time.Time
+}
+
+func newMemoryRateCounter() *memoryRateCounter {
+	return &memoryRateCounter{counts: make(map[string]*memoryCount), lastSweep: time.Now()}
+}
+
+// lookup 返回未过期的计数，调用方持有锁
+func (c *memoryRateCounter) lookup(key string, now time.Time) *memoryCount {
+	if now.Sub(c.lastSweep) > time.Minute {
+		for k, count := range c.counts {
+			if now.After(count.expires) {
+				delete(c.counts, k)
+			}
+		}
+		c.lastSweep = now
+	}
+	count, ok := c.counts[key]
+	if !ok || now.After(count.expires) {
+		return nil
+	}
+	return count
+}
+
+func (c *memoryRateCounter) incr(ctx context.Context, key string, ttl time.Duration) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	count := c.lookup(key, now)
+	if count == nil {
+		count = &memoryCount{}
+		c.counts[key] = count
+	}
+	count.value++
+	count.expires = now.Add(ttl)
+	return count.value, nil
+}
+
+func (c *memoryRateCounter) decr(ctx context.Context, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if count := c.lookup(key, time.Now()); count != nil {
+		count.value--
+		if count.value <= 0 {
+			delete(c.counts, key)
+		}
+	}
+	return nil
+}
+
+func (c *memoryRateCounter) get(ctx context.Context, key string) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if count := c.lookup(key, time.Now()); count != nil {
+		return count.value, nil
+	}
+	return 0, nil
+}
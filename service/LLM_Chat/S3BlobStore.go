@@ -0,0 +1,274 @@
+package LLM_Chat
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// emptyPayloadHash 空请求体的 sha256
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3Config S3 兼容存储配置（AWS S3、MinIO 等）
+type S3Config struct {
+	Endpoint  string // 如 https://s3.us-east-1.amazonaws.com 或 http://localhost:9000
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Prefix    string // 对象键前缀，如 "uploads/"
+	PathStyle bool   // 使用 endpoint/bucket/key 形式的地址（MinIO 需要开启）
+}
+
+// S3BlobStore S3 兼容存储，请求使用 AWS Signature V4 签名
+type S3BlobStore struct {
+	config   S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3BlobStore 创建 S3 兼容存储
+func NewS3BlobStore(config S3Config) (BlobStoreInterface, error) {
+	if config.Endpoint == "" || config.Bucket == "" {
+		return nil, errors.New("S3 endpoint 和 bucket 不能为空")
+	}
+	if config.AccessKey == "" || config.SecretKey == "" {
+		return nil, errors.New("S3 access key 和 secret key 不能为空")
+	}
+	endpoint, err := url.Parse(strings.TrimRight(config.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("无效的 S3 endpoint: %s", config.Endpoint)
+	}
+	if config.Region == "" {
+		config.Region = "us-east-1"
+	}
+
+	return &S3BlobStore{
+		config:   config,
+		endpoint: endpoint,
+		client:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// bucketURL 返回存储桶地址
+func (s *S3BlobStore) bucketURL() *url.URL {
+	u := *s.endpoint
+	if s.config.PathStyle {
+		u.Path += "/" + s.config.Bucket
+	} else {
+		u.Host = s.config.Bucket + "." + u.Host
+	}
+	return &u
+}
+
+// objectURL 返回对象地址
+func (s *S3BlobStore) objectURL(key string) *url.URL {
+	u := s.bucketURL()
+	u.Path += "/" + s.config.Prefix + key
+	return u
+}
+
+func (s *S3BlobStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if !validBlobKey(key) {
+		return fmt.Errorf("无效的文件键: %s", key)
+	}
+	if size < 0 {
+		return errors.New("S3 上传需要文件大小")
+	}
+	if exists, err := s.Exists(ctx, key); err != nil || exists {
+		return err
+	}
+
+	// 键即内容的 sha256，作为 x-amz-content-sha256 发送，由服务端校验内容是否一致
+	resp, err := s.do(ctx, http.MethodPut, s.objectURL(key), io.NopCloser(r), size, key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+func (s *S3BlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	if !validBlobKey(key) {
+		return nil, ErrBlobNotFound
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.objectURL(key), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrBlobNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, s3Error(resp)
+	}
+	return resp.Body, nil
+}
+
+func (s *S3BlobStore) Exists(ctx context.Context, key string) (bool, error) {
+	if !validBlobKey(key) {
+		return false, nil
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.objectURL(key), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, s3Error(resp)
+	}
+}
+
+func (s *S3BlobStore) Delete(ctx context.Context, key string) error {
+	if !validBlobKey(key) {
+		return nil
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.objectURL(key), nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return s3Error(resp)
+	}
+	return nil
+}
+
+// listBucketResult ListObjectsV2 的响应
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *S3BlobStore) List(ctx context.Context) ([]BlobInfo, error) {
+	var blobs []BlobInfo
+	token := ""
+	for {
+		u := s.bucketURL()
+		if !s.config.PathStyle {
+			u.Path = "/"
+		}
+		query := url.Values{"list-type": {"2"}}
+		if s.config.Prefix != "" {
+			query.Set("prefix", s.config.Prefix)
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = query.Encode()
+
+		resp, err := s.do(ctx, http.MethodGet, u, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		if resp.StatusCode != http.StatusOK {
+			err = s3Error(resp)
+		} else if decodeErr := xml.NewDecoder(resp.Body).Decode(&result); decodeErr != nil {
+			err = fmt.Errorf("解析 S3 列表失败: %w", decodeErr)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, object := range result.Contents {
+			key := strings.TrimPrefix(object.Key, s.config.Prefix)
+			if validBlobKey(key) {
+				blobs = append(blobs, BlobInfo{Key: key, Size: object.Size, ModTime: object.LastModified})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return blobs, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// do 发送签名后的请求
+func (s *S3BlobStore) do(ctx context.Context, method string, u *url.URL, body io.ReadCloser, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 请求失败: %w", err)
+	}
+	return resp, nil
+}
+
+// sign 按 AWS Signature V4 签名请求
+func (s *S3BlobStore) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20"),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.config.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.config.SecretKey), date)
+	for _, part := range []string{s.config.Region, "s3", "aws4_request"} {
+		signingKey = hmacSHA256(signingKey, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.config.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Error 读取错误响应
+func s3Error(resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 请求失败: %s %s", resp.Status, strings.TrimSpace(string(body)))
+}
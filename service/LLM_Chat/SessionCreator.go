@@ -4,29 +4,56 @@ import (
 	"fmt"
 	"github.com/sashabaranov/go-openai"
 	"log"
+	"platfrom/database"
 	"time"
 )
 
+// SessionCreatorInterface 会话创建器，根据 ProviderConfig.Provider 选择模型后端
 type SessionCreatorInterface interface {
-	CreateSession(apiKey, systemPrompt, BaseUrl string, maxHistory int) LLMSessionInterface
-	CreateSessionFromHistory(apiKey, systemPrompt, BaseUrl string, maxHistory int, existingMessages []openai.ChatCompletionMessage) LLMSessionInterface
+	CreateSession(config ProviderConfig, systemPrompt string) (LLMSessionInterface, error)
+	CreateSessionFromHistory(config ProviderConfig, systemPrompt string, existingMessages []openai.ChatCompletionMessage) (LLMSessionInterface, error)
 }
 
-type DefaultSessionCreator struct{}
+type DefaultSessionCreator struct {
+	router *APIRouter // 模型路由的共享状态（熔断、延迟、轮询），为 nil 时使用 defaultAPIRouter
+}
 
 var GlobalDefaultSessionCreator SessionCreatorInterface
 
+// defaultAPIRouter 未指定路由状态时使用
+var defaultAPIRouter = NewAPIRouter()
+
 // init 函数自动初始化（Go 的惯用法）
 func init() {
 	GlobalDefaultSessionCreator = &DefaultSessionCreator{}
 }
 
-func (d *DefaultSessionCreator) CreateSession(apiKey, systemPrompt, BaseUrl string, maxHistory int) LLMSessionInterface {
-	return NewAdvancedChatSession(apiKey, systemPrompt, BaseUrl, maxHistory)
+// newProvider 按配置创建提供商，配置了路由时创建 RoutedProvider
+func (d *DefaultSessionCreator) newProvider(config ProviderConfig) (LLMProviderInterface, error) {
+	if config.Route == nil {
+		return NewLLMProvider(config.Provider, config.APIKey, config.BaseURL)
+	}
+	router := d.router
+	if router == nil {
+		router = defaultAPIRouter
+	}
+	return NewRoutedProvider(router, config.Route.Key, config.Route.Policy, config.Route.APIs)
+}
+
+func (d *DefaultSessionCreator) CreateSession(config ProviderConfig, systemPrompt string) (LLMSessionInterface, error) {
+	provider, err := d.newProvider(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewAdvancedChatSession(provider, config.ModelName, systemPrompt, config.ContextBudget), nil
 }
 
-func (d *DefaultSessionCreator) CreateSessionFromHistory(apiKey, systemPrompt, BaseUrl string, maxHistory int, existingMessages []openai.ChatCompletionMessage) LLMSessionInterface {
-	return NewAdvancedChatSessionFromHistory(apiKey, systemPrompt, BaseUrl, maxHistory, existingMessages)
+func (d *DefaultSessionCreator) CreateSessionFromHistory(config ProviderConfig, systemPrompt string, existingMessages []openai.ChatCompletionMessage) (LLMSessionInterface, error) {
+	provider, err := d.newProvider(config)
+	if err != nil {
+		return nil, err
+	}
+	return NewAdvancedChatSessionFromHistory(provider, config.ModelName, systemPrompt, config.ContextBudget, existingMessages), nil
 }
 
 // InitSessionManager 初始化会话管理器
@@ -36,13 +63,15 @@ func InitSessionManager(
 	modelService UserAPIServiceInterface,
 	personaManager PersonaManagerInterface,
 ) {
+	router := NewAPIRouter()
 	GlobalSessionManager = &SessionManager{
 		sessions:       make(map[string]LLMSessionInterface),
 		chatService:    chatService,
 		cacheService:   cacheService,
 		modelService:   modelService,
 		personaManager: personaManager,
-		sessionCreator: &DefaultSessionCreator{},
+		sessionCreator: &DefaultSessionCreator{router: router},
+		router:         router,
 	}
 }
 
@@ -54,13 +83,16 @@ func GetSessionManager() *SessionManager {
 	return GlobalSessionManager
 }
 
-// GetOrCreateSession 获取或创建会话
+// GetOrCreateSession 获取或创建会话，会话属于其他用户时返回 ErrSessionNotFound
 func (sm *SessionManager) GetOrCreateSession(userID uint, sessionID, modelName, BaseUrl, persona string) (LLMSessionInterface, error) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	// 检查内存中是否已存在
+	// 检查内存中是否已存在（内存中的会话都已有数据库记录）
 	if session, exists := sm.sessions[sessionID]; exists {
+		if _, err := sm.chatService.GetChatSession(sessionID, userID); err != nil {
+			return nil, err
+		}
 		// 如果指定了人格且与当前不同，更新系统提示词
 		if persona != "" {
 			systemPrompt := sm.personaManager.GetPersonaContent(persona)
@@ -77,76 +109,93 @@ func (sm *SessionManager) GetOrCreateSession(userID uint, sessionID, modelName,
 	// 尝试从缓存加载完整会话
 	if sm.cacheService != nil {
 		cachedFullSession, err := sm.cacheService.GetCachedFullSession(sessionID)
-		if err == nil && cachedFullSession != nil {
-			session := sm.sessionCreator.CreateSessionFromHistory(
-				cachedFullSession.ModelAPIKey,
-				systemPrompt,
-				BaseUrl,
-				10,
-				cachedFullSession.Messages,
-			)
-			session.SetSessionID(sessionID)
-			sm.sessions[sessionID] = session
-			log.Printf("从缓存恢复会话: %s", sessionID)
-			return session, nil
+		if err == nil && cachedFullSession != nil && cachedFullSession.Session != nil {
+			if cachedFullSession.Session.UserID != userID {
+				return nil, ErrSessionNotFound
+			}
+			// 缓存中不保存密钥，从数据库解析路由（API 配置已删除时按未缓存处理）
+			if route, err := sm.modelService.ResolveModelRoute(userID, cachedFullSession.Session.ModelName); err == nil {
+				if BaseUrl == "" {
+					BaseUrl = cachedFullSession.BaseUrl
+				}
+				session, err := sm.sessionCreator.CreateSessionFromHistory(
+					newRouteProviderConfig(route, BaseUrl),
+					systemPrompt,
+					cachedFullSession.Messages,
+				)
+				if err != nil {
+					return nil, fmt.Errorf("创建会话失败: %v", err)
+				}
+				session.SetSessionID(sessionID)
+				session.SetSummary(cachedFullSession.Summary)
+				sm.sessions[sessionID] = session
+				log.Printf("从缓存恢复会话: %s", sessionID)
+				return session, nil
+			}
 		} else if err != nil && err.Error() != "redis不可用" {
 			log.Printf("从缓存获取会话失败: %v", err)
 		}
 	}
 
-	// 从数据库获取模型配置
-	model, err := sm.modelService.GetAPIByModelName(userID, modelName)
+	// 从数据库获取模型配置（模型名可以是模型路由的名称）
+	route, err := sm.modelService.ResolveModelRoute(userID, modelName)
 	if err != nil {
 		return nil, fmt.Errorf("获取模型配置失败: %v", err)
 	}
 
-	// 如果 BaseUrl 为空，使用模型配置中的 BaseURL
-	if BaseUrl == "" && model.BaseURL != "" {
-		BaseUrl = model.BaseURL
-	}
-
 	// 创建数据库会话记录
 	dbSession, err := sm.chatService.CreateChatSession(sessionID, modelName, userID)
 	if err != nil {
-		return nil, fmt.Errorf("创建会话记录失败: %v", err)
+		return nil, fmt.Errorf("创建会话记录失败: %w", err)
 	}
 
 	// 从数据库加载历史消息
-	existingMessages, err := sm.chatService.GetRecentChatMessages(sessionID, 50)
+	existingMessages, err := sm.chatService.GetRecentChatMessages(sessionID, userID, 100)
 	if err != nil {
 		return nil, fmt.Errorf("加载历史消息失败: %v", err)
 	}
 
+	// 加载滚动摘要（被摘要覆盖的消息不会出现在 existingMessages 中）
+	var summary string
+	if latest, err := sm.chatService.GetLatestSummary(sessionID, userID); err != nil {
+		log.Printf("加载会话摘要失败: %v", err)
+	} else if latest != nil {
+		summary = latest.Content
+	}
+
+	// 根据路由中各 API 配置的提供商选择模型后端
+	providerConfig := newRouteProviderConfig(route, BaseUrl)
+
 	var session LLMSessionInterface
 	if len(existingMessages) > 0 {
 		// 从历史消息创建会话
-		session = sm.sessionCreator.CreateSessionFromHistory(
-			model.APIKey,
+		session, err = sm.sessionCreator.CreateSessionFromHistory(
+			providerConfig,
 			systemPrompt,
-			BaseUrl,
-			10,
 			existingMessages,
 		)
 	} else {
 		// 创建新会话
-		session = sm.sessionCreator.CreateSession(
-			model.APIKey,
+		session, err = sm.sessionCreator.CreateSession(
+			providerConfig,
 			systemPrompt,
-			BaseUrl,
-			10,
 		)
 	}
+	if err != nil {
+		return nil, fmt.Errorf("创建会话失败: %v", err)
+	}
 
 	session.SetSessionID(sessionID)
+	session.SetSummary(summary)
 	sm.sessions[sessionID] = session
 
 	// 缓存完整会话状态
 	if sm.cacheService != nil {
 		cachedFullSession := &CachedSession{
-			Session:     dbSession,
-			Messages:    existingMessages,
-			ModelAPIKey: model.APIKey,
-			BaseUrl:     BaseUrl,
+			Session:  dbSession,
+			Messages: existingMessages,
+			BaseUrl:  BaseUrl,
+			Summary:  summary,
 		}
 		if err := sm.cacheService.CacheFullSession(sessionID, cachedFullSession, 1*time.Hour); err != nil && err.Error() != "redis不可用" {
 			log.Printf("缓存会话失败: %v", err)
@@ -156,6 +205,22 @@ func (sm *SessionManager) GetOrCreateSession(userID uint, sessionID, modelName,
 	return session, nil
 }
 
+// newRouteProviderConfig 按路由构造会话配置，上下文预算等按首选配置确定；
+// 指定了 baseURL 时只替换首选配置的地址
+func newRouteProviderConfig(route *ResolvedRoute, baseURL string) ProviderConfig {
+	if baseURL != "" {
+		route.APIs[0].BaseURL = baseURL
+	}
+	primary := route.Primary()
+	return ProviderConfig{
+		Provider:      primary.Provider,
+		BaseURL:       primary.BaseURL,
+		ModelName:     primary.ModelName,
+		ContextBudget: primary.ContextBudget,
+		Route:         route,
+	}
+}
+
 // GetSession 获取会话（不创建）
 func (sm *SessionManager) GetSession(sessionID string) (LLMSessionInterface, bool) {
 	sm.mu.RLock()
@@ -170,23 +235,70 @@ func (sm *SessionManager) SaveMessage(sessionID, role, content string, userID ui
 	return sm.chatService.SaveChatMessage(sessionID, role, content, userID)
 }
 
-// DeleteSession 从内存中删除会话
-func (sm *SessionManager) DeleteSession(sessionID string) error {
+// SaveReply 保存模型回复，记录实际使用的 API 配置和 token 用量
+func (sm *SessionManager) SaveReply(sessionID, content string, servedBy ServedBy, usage TokenUsage, userID uint) error {
+	return sm.chatService.SaveAssistantMessage(sessionID, content, servedBy, usage, userID)
+}
+
+// SaveMessageWithParts 保存带图片等多部分内容的消息到数据库
+func (sm *SessionManager) SaveMessageWithParts(sessionID, role, content string, parts []database.MessagePart, userID uint) error {
+	return sm.chatService.SaveChatMessageWithParts(sessionID, role, content, parts, userID)
+}
+
+// DeleteSession 删除用户的会话（数据库记录和内存中的会话）
+func (sm *SessionManager) DeleteSession(sessionID string, userID uint) error {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
 
-	// 从内存删除
-	delete(sm.sessions, sessionID)
-
-	// 从数据库删除
-	if err := sm.chatService.DeleteChatSession(sessionID); err != nil {
+	// 先从数据库删除，会话不属于该用户时不动内存中的会话
+	if err := sm.chatService.DeleteChatSession(sessionID, userID); err != nil {
 		return err
 	}
+	delete(sm.sessions, sessionID)
 
 	log.Printf("删除会话成功: %s", sessionID)
 	return nil
 }
 
+// ReloadSession 丢弃内存中的会话，按数据库中的当前分支重新加载
+func (sm *SessionManager) ReloadSession(userID uint, sessionID string) (LLMSessionInterface, error) {
+	chatSession, err := sm.chatService.GetChatSession(sessionID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.InvalidateSession(sessionID)
+	return sm.GetOrCreateSession(userID, sessionID, chatSession.ModelName, "", "")
+}
+
+// NewSendOptions 构造发送选项：合并会话级生成参数与本次请求的覆盖值，按需启用工具，工具调用过程逐条保存到数据库
+// onToolEvent 在保存之后调用，用于流式推送
+func (sm *SessionManager) NewSendOptions(sessionID string, userID uint, override *database.GenerationParams, useTools bool, onToolEvent func(event ToolEvent) error) SendOptions {
+	var base database.GenerationParams
+	if chatSession, err := sm.chatService.GetChatSession(sessionID, userID); err == nil {
+		base = chatSession.GenerationParams
+	}
+	opts := SendOptions{
+		Params: MergeGenerationParams(base, override),
+		UserID: userID,
+	}
+	if !useTools {
+		return opts
+	}
+
+	opts.Tools = GlobalToolRegistry
+	opts.OnToolEvent = func(event ToolEvent) error {
+		if err := sm.chatService.SaveToolMessage(sessionID, userID, event.Message); err != nil {
+			return err
+		}
+		if onToolEvent != nil {
+			return onToolEvent(event)
+		}
+		return nil
+	}
+	return opts
+}
+
 // GenerateSessionID 生成会话ID
 func GenerateSessionID() string {
 	return fmt.Sprintf("session_%d", time.Now().UnixNano())
@@ -13,6 +13,7 @@ type SessionManager struct {
 	modelService   UserAPIServiceInterface
 	personaManager PersonaManagerInterface
 	sessionCreator SessionCreatorInterface
+	router         *APIRouter // 会话和摘要共用的模型路由状态
 }
 
 var GlobalSessionManager *SessionManager
@@ -168,8 +168,12 @@ func (s *SharedSessionService) AccessSharedLink(shareID string) (*database.ChatS
 			return errors.New("关联的会话不存在")
 		}
 
-		// 4. 查询消息列表
-		if err := tx.Where("session_id = ?", shared.SessionID).Order("created_at ASC").Find(&messages).Error; err != nil {
+		// 4. 查询当前分支上的消息
+		path, err := activePathIDs(tx, shared.SessionID)
+		if err != nil {
+			return err
+		}
+		if messages, err = findMessages(tx, path); err != nil {
 			return err
 		}
 
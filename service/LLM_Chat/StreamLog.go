@@ -0,0 +1,239 @@
+package LLM_Chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 流式响应按事件记录：每个推送给客户端的 SSE 事件带有递增的 ID，客户端断线重连时带上 Last-Event-ID，
+// 服务端补发之后的事件。Redis 可用时使用 Redis Streams（多实例共享），否则使用进程内的环形缓冲
+
+// StreamEvent 一条 SSE 事件
+type StreamEvent struct {
+	ID    string // 事件ID，追加时生成
+	Event string // SSE 事件名，普通内容为空
+	Data  string // JSON 数据
+	Done  bool   // 是否为本次生成的最后一个事件
+}
+
+// ErrInvalidEventID Last-Event-ID 格式不正确
+var ErrInvalidEventID = errors.New("无效的事件ID")
+
+const (
+	// streamLogSize 每个会话最多保留的事件数，超出后丢弃最早的事件
+	streamLogSize = 4096
+	// streamLogTTL 最后一次写入后事件保留的时间，生成结束后仍可在此期间重连补发
+	streamLogTTL = 10 * time.Minute
+)
+
+// eventIDPattern Redis Streams 的 ID 为 "毫秒-序号"，内存缓冲的 ID 为序号
+var eventIDPattern = regexp.MustCompile(`^\d+(-\d+)?$`)
+
+func streamLogKey(sessionID string) string {
+	return "stream_events:" + sessionID
+}
+
+// AppendStreamEvent 追加一条事件，返回事件ID
+func (cs *CacheService) AppendStreamEvent(sessionID string, event StreamEvent) (string, error) {
+	if cs.redisClient == nil {
+		return cs.memoryLog.append(sessionID, event), nil
+	}
+
+	ctx := context.Background()
+	key := streamLogKey(sessionID)
+	done := "0"
+	if event.Done {
+		done = "1"
+	}
+	id, err := cs.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: key,
+		MaxLen: streamLogSize,
+		Approx: true,
+		Values: map[string]interface{}{"event": event.Event, "data": event.Data, "done": done},
+	}).Result()
+	if err != nil {
+		return "", err
+	}
+	cs.redisClient.Expire(ctx, key, streamLogTTL)
+	return id, nil
+}
+
+// ReadStreamEvents 读取 afterID 之后的事件（afterID 为空时从头读取）。
+// 暂无新事件且 block 大于 0 时最多等待 block，期间有新事件立即返回
+func (cs *CacheService) ReadStreamEvents(ctx context.Context, sessionID, afterID string, block time.Duration) ([]StreamEvent, error) {
+	if afterID != "" && !eventIDPattern.MatchString(afterID) {
+		return nil, ErrInvalidEventID
+	}
+	if cs.redisClient == nil {
+		return cs.memoryLog.read(ctx, sessionID, afterID, block)
+	}
+
+	if afterID == "" {
+		afterID = "0"
+	}
+	if block <= 0 {
+		block = -1 // 不阻塞
+	}
+	streams, err := cs.redisClient.XRead(ctx, &redis.XReadArgs{
+		Streams: []string{streamLogKey(sessionID), afterID},
+		Block:   block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var events []StreamEvent
+	for _, stream := range streams {
+		for _, message := range stream.Messages {
+			event := StreamEvent{ID: message.ID}
+			event.Event, _ = message.Values["event"].(string)
+			event.Data, _ = message.Values["data"].(string)
+			event.Done = message.Values["done"] == "1"
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+// ResetStreamEvents 清空会话的事件，新的生成开始前调用
+func (cs *CacheService) ResetStreamEvents(sessionID string) error {
+	if cs.redisClient == nil {
+		cs.memoryLog.reset(sessionID)
+		return nil
+	}
+
+	ctx := context.Background()
+	return cs.redisClient.Del(ctx, streamLogKey(sessionID)).Err()
+}
+
+// memoryStreamLog Redis 不可用时的事件存储：每个会话一个固定容量的环形缓冲，ID 在进程内全局递增
+type memoryStreamLog struct {
+	mu   sync.Mutex
+	seq  uint64
+	logs map[string]*streamRing
+}
+
+type streamRing struct {
+	events  []StreamEvent // 未满时按顺序追加，满后从 head 开始覆盖
+	head    int           // 最早一条事件的位置
+	notify  chan struct{} // 有新事件时关闭，唤醒等待中的读取
+	expires time.Time
+}
+
+func newMemoryStreamLog() *memoryStreamLog {
+	return &memoryStreamLog{logs: make(map[string]*streamRing)}
+}
+
+// ring 获取会话的缓冲，不存在时创建（顺便清理过期的缓冲）。调用方需持有锁
+func (l *memoryStreamLog) ring(sessionID string) *streamRing {
+	if ring, exists := l.logs[sessionID]; exists {
+		return ring
+	}
+
+	now := time.Now()
+	for id, ring := range l.logs {
+		if now.After(ring.expires) {
+			close(ring.notify)
+			delete(l.logs, id)
+		}
+	}
+	ring := &streamRing{
+		notify:  make(chan struct{}),
+		expires: now.Add(streamLogTTL),
+	}
+	l.logs[sessionID] = ring
+	return ring
+}
+
+func (l *memoryStreamLog) append(sessionID string, event StreamEvent) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.seq++
+	event.ID = strconv.FormatUint(l.seq, 10)
+
+	ring := l.ring(sessionID)
+	if len(ring.events) < streamLogSize {
+		ring.events = append(ring.events, event)
+	} else {
+		ring.events[ring.head] = event
+		ring.head = (ring.head + 1) % len(ring.events)
+	}
+	ring.expires = time.Now().Add(streamLogTTL)
+
+	close(ring.notify)
+	ring.notify = make(chan struct{})
+	return event.ID
+}
+
+func (l *memoryStreamLog) read(ctx context.Context, sessionID, afterID string, block time.Duration) ([]StreamEvent, error) {
+	var after uint64
+	if afterID != "" {
+		// 兼容 "序号-0" 形式
+		seq, _, _ := strings.Cut(afterID, "-")
+		parsed, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEventID, afterID)
+		}
+		after = parsed
+	}
+
+	l.mu.Lock()
+	ring := l.ring(sessionID)
+	events := ring.after(after)
+	notify := ring.notify
+	l.mu.Unlock()
+
+	if len(events) > 0 || block <= 0 {
+		return events, nil
+	}
+
+	timer := time.NewTimer(block)
+	defer timer.Stop()
+	select {
+	case <-notify:
+	case <-timer.C:
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if current, exists := l.logs[sessionID]; exists {
+		return current.after(after), nil
+	}
+	return nil, nil
+}
+
+// after 返回 ID 大于 after 的事件。调用方需持有锁
+func (r *streamRing) after(after uint64) []StreamEvent {
+	var events []StreamEvent
+	for i := 0; i < len(r.events); i++ {
+		event := r.events[(r.head+i)%len(r.events)]
+		if seq, _ := strconv.ParseUint(event.ID, 10, 64); seq > after {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+func (l *memoryStreamLog) reset(sessionID string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ring, exists := l.logs[sessionID]; exists {
+		close(ring.notify)
+		delete(l.logs, sessionID)
+	}
+}
@@ -0,0 +1,211 @@
+package LLM_Chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"log"
+	"platfrom/database"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	summaryTriggerRatio = 0.6  // 未摘要的历史超过上下文预算的该比例时触发摘要
+	summaryKeepRatio    = 0.3  // 摘要后原文保留的最近历史占上下文预算的比例
+	summaryMaxTokens    = 1024 // 摘要本身的最大长度
+	summaryTimeout      = 2 * time.Minute
+)
+
+const summaryPrompt = `你是一个对话摘要助手。请把下面的对话压缩成一份简洁的摘要，供后续对话参考。
+要求：
+1. 保留用户的目标、偏好、已确认的事实、做出的决定和尚未解决的问题；
+2. 保留代码、文件名、数字等关键细节，省略寒暄和重复内容；
+3. 如果提供了之前的摘要，请把新的对话内容合并进去，输出一份完整的新摘要；
+4. 使用对话所用的语言，直接输出摘要正文，不要添加额外说明。`
+
+// Summarizer 使用会话自身的模型把较早的对话压缩为摘要
+type Summarizer struct {
+	Provider       LLMProviderInterface
+	ModelName      string
+	ContextManager *ContextManager
+}
+
+func NewSummarizer(provider LLMProviderInterface, modelName string, contextBudget int) *Summarizer {
+	return &Summarizer{
+		Provider:       provider,
+		ModelName:      modelName,
+		ContextManager: NewContextManager(modelName, contextBudget),
+	}
+}
+
+// Summarize 在之前摘要的基础上合并新的对话，返回新的完整摘要
+func (s *Summarizer) Summarize(ctx context.Context, previousSummary string, messages []openai.ChatCompletionMessage) (string, error) {
+	var transcript strings.Builder
+	if previousSummary != "" {
+		transcript.WriteString("【之前的摘要】\n")
+		transcript.WriteString(previousSummary)
+		transcript.WriteString("\n\n")
+	}
+	transcript.WriteString("【新的对话】\n")
+	for _, msg := range messages {
+		role := "用户"
+		switch msg.Role {
+		case openai.ChatMessageRoleAssistant:
+			role = "助手"
+		case openai.ChatMessageRoleTool:
+			role = "工具结果"
+		}
+		content := MessageText(msg)
+		for _, call := range msg.ToolCalls {
+			content += fmt.Sprintf("[调用工具 %s(%s)]", call.Function.Name, call.Function.Arguments)
+		}
+		transcript.WriteString(fmt.Sprintf("%s：%s\n", role, content))
+	}
+
+	// 待摘要内容本身也不能超出上下文预算：扣除摘要提示词和回复预留
+	maxTokens := summaryMaxTokens
+	if maxTokens > s.ContextManager.ContextLength/4 {
+		maxTokens = s.ContextManager.ContextLength / 4
+	}
+	inputBudget := s.ContextManager.ContextLength - maxTokens - s.ContextManager.Tokenizer.CountTokens(summaryPrompt) - 2*messageOverheadTokens
+	input := s.ContextManager.TruncateToTokens(transcript.String(), inputBudget)
+
+	resp, err := s.Provider.CreateChatCompletion(ctx, ProviderRequest{
+		Model: s.ModelName,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: summaryPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: input},
+		},
+		Params: database.GenerationParams{MaxTokens: &maxTokens},
+	})
+	if err != nil {
+		return "", fmt.Errorf("生成摘要失败: %w", err)
+	}
+
+	summary := strings.TrimSpace(resp.Content)
+	if summary == "" {
+		return "", errors.New("生成摘要失败: 模型返回了空内容")
+	}
+	return summary, nil
+}
+
+// summarizing 正在生成摘要的会话，避免同一会话并发摘要
+var summarizing sync.Map
+
+// ScheduleSummary 异步检查会话是否需要摘要
+func (sm *SessionManager) ScheduleSummary(userID uint, sessionID string) {
+	if _, running := summarizing.LoadOrStore(sessionID, struct{}{}); running {
+		return
+	}
+	go func() {
+		defer summarizing.Delete(sessionID)
+
+		ctx, cancel := context.WithTimeout(context.Background(), summaryTimeout)
+		defer cancel()
+		if _, err := sm.SummarizeIfNeeded(ctx, userID, sessionID); err != nil {
+			log.Printf("会话摘要失败 (session: %s): %v", sessionID, err)
+		}
+	}()
+}
+
+// SummarizeIfNeeded 未摘要的历史超过阈值时，把较早的对话合并进摘要，返回是否生成了新摘要
+// 生成摘要后清除内存和缓存中的会话，下次请求时从数据库按"摘要 + 之后的消息"重建
+func (sm *SessionManager) SummarizeIfNeeded(ctx context.Context, userID uint, sessionID string) (bool, error) {
+	chatSession, err := sm.chatService.GetChatSession(sessionID, userID)
+	if err != nil {
+		return false, fmt.Errorf("获取会话失败: %w", err)
+	}
+	if !chatSession.SummaryEnabled {
+		return false, nil
+	}
+
+	route, err := sm.modelService.ResolveModelRoute(userID, chatSession.ModelName)
+	if err != nil {
+		return false, fmt.Errorf("获取模型配置失败: %w", err)
+	}
+	router := sm.router
+	if router == nil {
+		router = defaultAPIRouter
+	}
+	provider, err := NewRoutedProvider(router, route.Key, route.Policy, route.APIs)
+	if err != nil {
+		return false, err
+	}
+	model := route.Primary()
+	summarizer := NewSummarizer(provider, model.ModelName, model.ContextBudget)
+	cm := summarizer.ContextManager
+
+	var previousSummary string
+	var afterID uint
+	latest, err := sm.chatService.GetLatestSummary(sessionID, userID)
+	if err != nil {
+		return false, err
+	}
+	if latest != nil {
+		previousSummary = latest.Content
+		afterID = latest.SummarizedUntilID
+	}
+
+	messages, err := sm.chatService.GetMessagesAfter(sessionID, userID, afterID)
+	if err != nil {
+		return false, err
+	}
+
+	total := 0
+	costs := make([]int, len(messages))
+	for i, msg := range messages {
+		costs[i] = cm.CountMessageTokens(ToChatCompletionMessage(msg))
+		total += costs[i]
+	}
+	if total <= int(float64(cm.ContextLength)*summaryTriggerRatio) {
+		return false, nil
+	}
+
+	// 从最新的消息向前保留原文，剩余的较早消息合并进摘要
+	keepBudget := int(float64(cm.ContextLength) * summaryKeepRatio)
+	cut := len(messages)
+	for cut > 0 && keepBudget-costs[cut-1] >= 0 {
+		keepBudget -= costs[cut-1]
+		cut--
+	}
+	// 保留部分从用户消息开始，保证对话轮次完整
+	for cut < len(messages) && messages[cut].Role != openai.ChatMessageRoleUser {
+		cut++
+	}
+	if cut == 0 {
+		return false, nil
+	}
+
+	toSummarize := make([]openai.ChatCompletionMessage, cut)
+	for i, msg := range messages[:cut] {
+		toSummarize[i] = ToChatCompletionMessage(msg)
+	}
+
+	summary, err := summarizer.Summarize(ctx, previousSummary, toSummarize)
+	if err != nil {
+		return false, err
+	}
+	if err := sm.chatService.SaveSummary(sessionID, userID, summary, messages[cut-1].ID); err != nil {
+		return false, err
+	}
+
+	sm.InvalidateSession(sessionID)
+	log.Printf("会话摘要已更新 (session: %s): 合并 %d 条消息", sessionID, cut)
+	return true, nil
+}
+
+// InvalidateSession 清除内存和缓存中的会话状态（不删除数据库记录），下次请求时按数据库中的当前分支重建历史
+func (sm *SessionManager) InvalidateSession(sessionID string) {
+	sm.mu.Lock()
+	delete(sm.sessions, sessionID)
+	sm.mu.Unlock()
+
+	if sm.cacheService != nil {
+		if err := sm.cacheService.DeleteCachedFullSession(sessionID); err != nil {
+			log.Printf("清除会话缓存失败 (session: %s): %v", sessionID, err)
+		}
+	}
+}
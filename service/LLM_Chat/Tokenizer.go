@@ -0,0 +1,97 @@
+package LLM_Chat
+
+import (
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// 词表随程序打包，不在运行时下载
+func init() {
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// BPETokenizer 使用 tiktoken 词表精确计算 token 数
+type BPETokenizer struct {
+	encoding *tiktoken.Tiktoken
+}
+
+// bpeChunkSize 分段编码的长度（字节）。BPE 合并的耗时随单个片段长度平方增长，
+// 没有空格的长段中文或 base64 会成为一个很长的片段，分段后只会在段落边界多算几个 token
+const bpeChunkSize = 2048
+
+func (t *BPETokenizer) CountTokens(text string) int {
+	tokens := 0
+	for len(text) > 0 {
+		size := len(text)
+		if size > bpeChunkSize {
+			size = bpeChunkSize
+			// 在字符边界切分，优先在换行处
+			for size > 0 && !utf8.RuneStart(text[size]) {
+				size--
+			}
+			if newline := strings.LastIndexByte(text[:size], '\n'); newline > bpeChunkSize/2 {
+				size = newline + 1
+			}
+		}
+		// 按普通文本编码，用户输入中的 <|endoftext|> 等特殊标记不会报错
+		tokens += len(t.encoding.EncodeOrdinary(text[:size]))
+		text = text[size:]
+	}
+	return tokens
+}
+
+// MaxTokenizer 取多个分词器估算的最大值。用于没有公开词表的模型：
+// BPE 能算准代码和 base64 之类的内容，启发式估算对中文偏保守，取较大值避免超出上下文
+type MaxTokenizer []TokenizerInterface
+
+func (t MaxTokenizer) CountTokens(text string) int {
+	tokens := 0
+	for _, tokenizer := range t {
+		if count := tokenizer.CountTokens(text); count > tokens {
+			tokens = count
+		}
+	}
+	return tokens
+}
+
+var (
+	bpeEncodingsMu sync.Mutex
+	bpeEncodings   = make(map[string]*tiktoken.Tiktoken) // 词表加载较慢，按名称缓存
+)
+
+// bpeEncoding 加载 tiktoken 词表，失败时返回 nil
+func bpeEncoding(name string) *tiktoken.Tiktoken {
+	bpeEncodingsMu.Lock()
+	defer bpeEncodingsMu.Unlock()
+
+	if encoding, exists := bpeEncodings[name]; exists {
+		return encoding
+	}
+	encoding, err := tiktoken.GetEncoding(name)
+	if err != nil {
+		log.Printf("加载词表 %s 失败，使用启发式估算: %v", name, err)
+	}
+	bpeEncodings[name] = encoding
+	return encoding
+}
+
+// NewTokenizer 按模型系列选择分词器：OpenAI 模型使用对应的词表，其他已知系列用 cl100k 近似（和启发式估算取较大值），
+// 未知模型使用启发式估算
+func NewTokenizer(modelName string) TokenizerInterface {
+	family, found := lookupModelFamily(modelName)
+	if !found || family.Encoding == "" {
+		return &HeuristicTokenizer{}
+	}
+	encoding := bpeEncoding(family.Encoding)
+	if encoding == nil {
+		return &HeuristicTokenizer{}
+	}
+	if family.ExactEncoding {
+		return &BPETokenizer{encoding: encoding}
+	}
+	return MaxTokenizer{&BPETokenizer{encoding: encoding}, &HeuristicTokenizer{}}
+}
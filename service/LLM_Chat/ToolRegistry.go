@@ -0,0 +1,123 @@
+package LLM_Chat
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"sync"
+)
+
+// ToolContext 工具执行时的调用方信息，工具需要据此做权限隔离
+type ToolContext struct {
+	UserID    uint
+	SessionID string
+}
+
+// ToolHandler 工具的执行函数，args 为模型生成的 JSON 参数，返回值作为 tool 消息发回模型
+type ToolHandler func(ctx context.Context, tc ToolContext, args json.RawMessage) (string, error)
+
+// Tool 可供模型调用的工具
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{} // JSON Schema
+	Handler     ToolHandler
+}
+
+// ToolEvent 工具调用过程中的事件，用于流式推送和持久化
+type ToolEvent struct {
+	Type     string                       // tool_call / tool_result
+	Message  openai.ChatCompletionMessage // tool_call 为带 tool_calls 的 assistant 消息，tool_result 为 tool 消息
+	ToolName string                       // tool_result 对应的工具名
+	IsError  bool                         // 工具执行失败
+}
+
+// 工具事件类型
+const (
+	ToolEventCall   = "tool_call"
+	ToolEventResult = "tool_result"
+)
+
+// ToolRegistry 工具注册表
+type ToolRegistry struct {
+	mu    sync.RWMutex
+	tools map[string]*Tool
+	order []string
+}
+
+var GlobalToolRegistry = NewToolRegistry()
+
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{
+		tools: make(map[string]*Tool),
+	}
+}
+
+// Register 注册工具，同名工具会被覆盖
+func (r *ToolRegistry) Register(tool Tool) error {
+	if tool.Name == "" {
+		return errors.New("工具名称不能为空")
+	}
+	if tool.Handler == nil {
+		return fmt.Errorf("工具 %s 缺少执行函数", tool.Name)
+	}
+	if tool.Parameters == nil {
+		tool.Parameters = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.tools[tool.Name]; !exists {
+		r.order = append(r.order, tool.Name)
+	}
+	r.tools[tool.Name] = &tool
+	return nil
+}
+
+// Get 根据名称获取工具
+func (r *ToolRegistry) Get(name string) (*Tool, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tool, ok := r.tools[name]
+	return tool, ok
+}
+
+// Definitions 返回发送给模型的工具定义（按注册顺序）
+func (r *ToolRegistry) Definitions() []openai.Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	definitions := make([]openai.Tool, 0, len(r.order))
+	for _, name := range r.order {
+		tool := r.tools[name]
+		definitions = append(definitions, openai.Tool{
+			Type: openai.ToolTypeFunction,
+			Function: &openai.FunctionDefinition{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  tool.Parameters,
+			},
+		})
+	}
+	return definitions
+}
+
+// Execute 执行一次工具调用，工具不存在或参数非法时返回错误
+func (r *ToolRegistry) Execute(ctx context.Context, tc ToolContext, call openai.ToolCall) (string, error) {
+	tool, ok := r.Get(call.Function.Name)
+	if !ok {
+		return "", fmt.Errorf("未知的工具: %s", call.Function.Name)
+	}
+
+	args := json.RawMessage(call.Function.Arguments)
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	if !json.Valid(args) {
+		return "", fmt.Errorf("工具 %s 的参数不是合法的 JSON", call.Function.Name)
+	}
+
+	return tool.Handler(ctx, tc, args)
+}
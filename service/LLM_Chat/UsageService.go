@@ -0,0 +1,232 @@
+package LLM_Chat
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"os"
+	"platfrom/database"
+	"strings"
+	"time"
+)
+
+// 用量记录在两处：每条模型回复上保存本次的 token 和费用，UsageDaily 按天累加，统计接口只查汇总表
+
+// usageDateLayout UsageDaily.Date 的格式
+const usageDateLayout = "2006-01-02"
+
+type UsageServiceInterface interface {
+	Cost(model string, usage TokenUsage) float64
+	Currency() string
+	GetUsageSummary(userID uint, from, to time.Time) (*UsageSummary, error)
+	GetSessionUsage(userID uint, sessionID string) (*UsageTotals, error)
+	RootGetUsageReport(from, to time.Time) ([]UserUsage, error)
+}
+
+var GlobalUsageService UsageServiceInterface
+
+// UsageTotals 汇总的请求数、token 数和费用
+type UsageTotals struct {
+	Requests         int64   `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	TotalTokens      int64   `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+type DailyUsage struct {
+	Date string `json:"date"`
+	UsageTotals
+}
+
+type ModelUsage struct {
+	Model string `json:"model"`
+	UsageTotals
+}
+
+type APIUsage struct {
+	APIID uint `json:"api_id"`
+	UsageTotals
+}
+
+type UserUsage struct {
+	UserID uint `json:"user_id"`
+	UsageTotals
+}
+
+// UsageSummary 用户在日期范围内的用量：总计、按天、按模型、按 API 配置
+type UsageSummary struct {
+	From     string       `json:"from"`
+	To       string       `json:"to"`
+	Currency string       `json:"currency"`
+	Total    UsageTotals  `json:"total"`
+	Daily    []DailyUsage `json:"daily"`
+	ByModel  []ModelUsage `json:"by_model"`
+	ByAPI    []APIUsage   `json:"by_api"`
+}
+
+// usageTotalsColumns 汇总查询的列，与 UsageTotals 的字段对应
+const usageTotalsColumns = "COALESCE(SUM(requests), 0) AS requests, " +
+	"COALESCE(SUM(prompt_tokens), 0) AS prompt_tokens, " +
+	"COALESCE(SUM(completion_tokens), 0) AS completion_tokens, " +
+	"COALESCE(SUM(prompt_tokens + completion_tokens), 0) AS total_tokens, " +
+	"COALESCE(SUM(cost), 0) AS cost"
+
+type UsageService struct {
+	db      *gorm.DB
+	pricing database.PricingConfig
+}
+
+func NewUsageService(db *gorm.DB, pricing database.PricingConfig) (UsageServiceInterface, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接不能为空")
+	}
+
+	// 模型名匹配不区分大小写
+	models := make(map[string]database.ModelPrice, len(pricing.Models))
+	for name, price := range pricing.Models {
+		models[strings.ToLower(name)] = price
+	}
+	pricing.Models = models
+
+	service := &UsageService{db: db, pricing: pricing}
+	GlobalUsageService = service
+	return service, nil
+}
+
+// LoadPricingConfig 读取 style.yaml 中的模型价格表
+func LoadPricingConfig(configPath string) (database.PricingConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return database.PricingConfig{}, err
+	}
+
+	var config database.StyleConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return database.PricingConfig{}, err
+	}
+	return config.Pricing, nil
+}
+
+// Cost 按价格表计算费用，价格表中没有的模型费用为 0。
+// 先精确匹配，再使用最长的前缀匹配（如 gpt-4o 匹配 gpt-4o-2024-08-06）
+func (s *UsageService) Cost(model string, usage TokenUsage) float64 {
+	model = strings.ToLower(model)
+	price, ok := s.pricing.Models[model]
+	if !ok {
+		matched := ""
+		for name, p := range s.pricing.Models {
+			if strings.HasPrefix(model, name) && len(name) > len(matched) {
+				matched, price = name, p
+			}
+		}
+		if matched == "" {
+			return 0
+		}
+	}
+	return (float64(usage.PromptTokens)*price.Prompt + float64(usage.CompletionTokens)*price.Completion) / 1e6
+}
+
+func (s *UsageService) Currency() string {
+	return s.pricing.Currency
+}
+
+// usageCost 全局用量服务未初始化时费用记为 0
+func usageCost(model string, usage TokenUsage) float64 {
+	if GlobalUsageService == nil {
+		return 0
+	}
+	return GlobalUsageService.Cost(model, usage)
+}
+
+// recordUsage 把一次请求的用量累加到当天的汇总记录，调用方可传入事务
+func recordUsage(tx *gorm.DB, userID uint, sessionID string, apiID uint, model string, usage TokenUsage, cost float64) error {
+	if usage.Total() == 0 {
+		return nil
+	}
+	row := &database.UsageDaily{
+		Date:             time.Now().Format(usageDateLayout),
+		UserID:           userID,
+		SessionID:        sessionID,
+		APIID:            apiID,
+		Model:            model,
+		Requests:         1,
+		PromptTokens:     int64(usage.PromptTokens),
+		CompletionTokens: int64(usage.CompletionTokens),
+		Cost:             cost,
+	}
+	err := tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "date"}, {Name: "user_id"}, {Name: "session_id"}, {Name: "api_id"}, {Name: "model"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"requests":          gorm.Expr("requests + ?", 1),
+			"prompt_tokens":     gorm.Expr("prompt_tokens + ?", usage.PromptTokens),
+			"completion_tokens": gorm.Expr("completion_tokens + ?", usage.CompletionTokens),
+			"cost":              gorm.Expr("cost + ?", cost),
+			"updated_at":        time.Now(),
+		}),
+	}).Create(row).Error
+	if err != nil {
+		return fmt.Errorf("记录用量失败: %w", err)
+	}
+	return nil
+}
+
+// usageRange 日期范围内的汇总记录（包含首尾两天）
+func (s *UsageService) usageRange(from, to time.Time) *gorm.DB {
+	return s.db.Model(&database.UsageDaily{}).
+		Where("date >= ? AND date <= ?", from.Format(usageDateLayout), to.Format(usageDateLayout))
+}
+
+// GetUsageSummary 获取用户在日期范围内的用量
+func (s *UsageService) GetUsageSummary(userID uint, from, to time.Time) (*UsageSummary, error) {
+	summary := &UsageSummary{
+		From:     from.Format(usageDateLayout),
+		To:       to.Format(usageDateLayout),
+		Currency: s.pricing.Currency,
+		Daily:    []DailyUsage{},
+		ByModel:  []ModelUsage{},
+		ByAPI:    []APIUsage{},
+	}
+
+	if err := s.usageRange(from, to).Where("user_id = ?", userID).
+		Select(usageTotalsColumns).Scan(&summary.Total).Error; err != nil {
+		return nil, fmt.Errorf("查询用量失败: %w", err)
+	}
+	if err := s.usageRange(from, to).Where("user_id = ?", userID).
+		Select("date, " + usageTotalsColumns).Group("date").Order("date").Scan(&summary.Daily).Error; err != nil {
+		return nil, fmt.Errorf("查询用量失败: %w", err)
+	}
+	if err := s.usageRange(from, to).Where("user_id = ?", userID).
+		Select("model, " + usageTotalsColumns).Group("model").Order("model").Scan(&summary.ByModel).Error; err != nil {
+		return nil, fmt.Errorf("查询用量失败: %w", err)
+	}
+	if err := s.usageRange(from, to).Where("user_id = ?", userID).
+		Select("api_id, " + usageTotalsColumns).Group("api_id").Order("api_id").Scan(&summary.ByAPI).Error; err != nil {
+		return nil, fmt.Errorf("查询用量失败: %w", err)
+	}
+	return summary, nil
+}
+
+// GetSessionUsage 获取会话的累计用量
+func (s *UsageService) GetSessionUsage(userID uint, sessionID string) (*UsageTotals, error) {
+	var totals UsageTotals
+	if err := s.db.Model(&database.UsageDaily{}).
+		Where("user_id = ? AND session_id = ?", userID, sessionID).
+		Select(usageTotalsColumns).Scan(&totals).Error; err != nil {
+		return nil, fmt.Errorf("查询用量失败: %w", err)
+	}
+	return &totals, nil
+}
+
+// RootGetUsageReport 管理员查看日期范围内各用户的用量，按费用和 token 数从高到低排列
+func (s *UsageService) RootGetUsageReport(from, to time.Time) ([]UserUsage, error) {
+	report := []UserUsage{}
+	if err := s.usageRange(from, to).
+		Select("user_id, " + usageTotalsColumns).Group("user_id").
+		Order("cost DESC, total_tokens DESC, user_id").Scan(&report).Error; err != nil {
+		return nil, fmt.Errorf("查询用量失败: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,254 @@
+package Mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"log"
+	"math"
+	"os"
+	"platfrom/database"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMailWorkers     = 2
+	defaultMailQueueSize   = 100
+	defaultMailMaxAttempts = 5
+	defaultMailRetryDelay  = 5 * time.Second
+	defaultMailLanguage    = "zh"
+	defaultMailAppName     = "Platform"
+	mailSendTimeout        = time.Minute
+)
+
+var (
+	ErrMailQueueFull      = errors.New("邮件发送队列已满，请稍后再试")
+	ErrMailServiceStopped = errors.New("邮件服务已停止")
+	ErrMailTemplate       = errors.New("没有对应的邮件模板")
+)
+
+// GlobalMailService 全局 MailService 实例，为 nil 时由 Default 退回到控制台输出
+var GlobalMailService MailServiceInterface
+
+// LocalRecipient 开发用的控制台、文件 Mailer 在用户没有邮箱时使用的占位收件地址
+const LocalRecipient = "dev@localhost"
+
+var (
+	consoleOnce    sync.Once
+	consoleService MailServiceInterface
+)
+
+// Default 返回 GlobalMailService；未初始化时返回输出到标准输出的邮件服务（首次使用时创建，不修改 GlobalMailService）
+func Default() MailServiceInterface {
+	if GlobalMailService != nil {
+		return GlobalMailService
+	}
+	consoleOnce.Do(func() {
+		consoleService, _ = newMailService(NewConsoleMailer(nil), database.MailConfig{})
+	})
+	return consoleService
+}
+
+// MailServiceInterface 异步发送邮件：放入队列后立即返回，由后台 worker 发送，临时错误按指数退避重试
+type MailServiceInterface interface {
+	// Send 把邮件放入发送队列
+	Send(msg *Message) error
+	// SendCode 按验证码类型和语言渲染模板后放入发送队列，language 可以是 Accept-Language 的值
+	SendCode(to, codeType, language string, data CodeData) error
+	// RequiresAddress 发送是否需要用户的邮箱：开发用的控制台、文件 Mailer 不需要，其余（SMTP 等）需要
+	RequiresAddress() bool
+	// Shutdown 停止接收新邮件，等待队列中的邮件发送完（重试的等待会被跳过）
+	Shutdown()
+}
+
+type mailService struct {
+	mailer    Mailer
+	config    database.MailConfig
+	templates map[string]map[string]compiledTemplate
+
+	queue   chan *Message
+	stop    chan struct{}
+	mu      sync.RWMutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// LoadMailConfig 从 style.yaml 读取 mail 配置
+func LoadMailConfig(configPath string) (database.MailConfig, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		return database.MailConfig{}, err
+	}
+
+	var config database.StyleConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return database.MailConfig{}, err
+	}
+	return config.Mail, nil
+}
+
+// NewMailService 创建邮件服务并启动 worker，同时设置为 GlobalMailService
+func NewMailService(mailer Mailer, config database.MailConfig) (MailServiceInterface, error) {
+	service, err := newMailService(mailer, config)
+	if err != nil {
+		return nil, err
+	}
+	GlobalMailService = service
+	return service, nil
+}
+
+func newMailService(mailer Mailer, config database.MailConfig) (*mailService, error) {
+	if mailer == nil {
+		return nil, errors.New("Mailer 不能为空")
+	}
+	if config.Workers <= 0 {
+		config.Workers = defaultMailWorkers
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultMailQueueSize
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultMailMaxAttempts
+	}
+	if config.RetryDelay <= 0 {
+		config.RetryDelay = defaultMailRetryDelay
+	}
+	if config.AppName == "" {
+		config.AppName = defaultMailAppName
+	}
+	config.DefaultLanguage = normalizeLanguage(config.DefaultLanguage)
+	if config.DefaultLanguage == "" {
+		config.DefaultLanguage = defaultMailLanguage
+	}
+
+	templates, err := compileTemplates(config.Templates)
+	if err != nil {
+		return nil, err
+	}
+
+	service := &mailService{
+		mailer:    mailer,
+		config:    config,
+		templates: templates,
+		queue:     make(chan *Message, config.QueueSize),
+		stop:      make(chan struct{}),
+	}
+	for i := 0; i < config.Workers; i++ {
+		service.wg.Add(1)
+		go service.worker()
+	}
+	return service, nil
+}
+
+func (s *mailService) Send(msg *Message) error {
+	if _, err := parseAddress(msg.To); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.stopped {
+		return ErrMailServiceStopped
+	}
+	select {
+	case s.queue <- msg:
+		return nil
+	default:
+		return ErrMailQueueFull
+	}
+}
+
+func (s *mailService) SendCode(to, codeType, language string, data CodeData) error {
+	tmpl, err := s.selectTemplate(codeType, language)
+	if err != nil {
+		return err
+	}
+	subject, body, err := tmpl.render(templateData{
+		AppName:          s.config.AppName,
+		Username:         data.Username,
+		Code:             data.Code,
+		ExpiresInMinutes: int(math.Ceil(time.Until(data.ExpiresAt).Minutes())),
+	})
+	if err != nil {
+		return fmt.Errorf("渲染邮件模板失败: %w", err)
+	}
+	return s.Send(&Message{To: to, Subject: subject, Body: body})
+}
+
+// selectTemplate 没有请求的语言时依次使用默认语言和按名称排序的第一个语言
+func (s *mailService) selectTemplate(codeType, language string) (compiledTemplate, error) {
+	languages := s.templates[codeType]
+	if len(languages) == 0 {
+		return compiledTemplate{}, fmt.Errorf("%w: %s", ErrMailTemplate, codeType)
+	}
+	if tmpl, ok := languages[normalizeLanguage(language)]; ok {
+		return tmpl, nil
+	}
+	if tmpl, ok := languages[s.config.DefaultLanguage]; ok {
+		return tmpl, nil
+	}
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return languages[names[0]], nil
+}
+
+func (s *mailService) RequiresAddress() bool {
+	_, local := s.mailer.(localMailer)
+	return !local
+}
+
+func (s *mailService) Shutdown() {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	s.stopped = true
+	close(s.stop)
+	close(s.queue)
+	s.mu.Unlock()
+
+	s.wg.Wait()
+}
+
+func (s *mailService) worker() {
+	defer s.wg.Done()
+	for msg := range s.queue {
+		s.deliver(msg)
+	}
+}
+
+// deliver 发送一封邮件，临时错误时等待 RetryDelay、2*RetryDelay... 后重试。
+// 日志中只记录收件人和主题，不记录正文（含验证码）
+func (s *mailService) deliver(msg *Message) {
+	delay := s.config.RetryDelay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		err := s.mailer.Send(ctx, msg)
+		cancel()
+		if err == nil {
+			return
+		}
+
+		if IsPermanent(err) || attempt >= s.config.MaxAttempts {
+			log.Printf("邮件发送失败，已放弃 (to: %s, subject: %s, 尝试 %d 次): %v", msg.To, msg.Subject, attempt, err)
+			return
+		}
+		log.Printf("邮件发送失败，%s 后重试 (to: %s, 第 %d 次): %v", delay, msg.To, attempt, err)
+
+		select {
+		case <-time.After(delay):
+		case <-s.stop:
+			// 停止时不再等待，立即做最后一次尝试
+			delay = 0
+			attempt = s.config.MaxAttempts - 1
+		}
+		delay *= 2
+	}
+}
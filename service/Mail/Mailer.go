@@ -0,0 +1,157 @@
+package Mail
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidAddress 收件人或发件人地址无效，重试也不会成功
+var ErrInvalidAddress = errors.New("邮件地址无效")
+
+// Message 一封纯文本邮件
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer 邮件发送后端。Send 同步发送一封邮件，重试由 MailService 负责
+type Mailer interface {
+	Send(ctx context.Context, msg *Message) error
+}
+
+// localMailer 开发环境使用、不实际投递的 Mailer（控制台、文件）。
+// 用户没有邮箱时验证码可以退回到控制台输出，其余 Mailer 必须有收件地址
+type localMailer interface {
+	local()
+}
+
+// IsPermanent 是否为重试也不会成功的错误（地址无效、SMTP 5xx）
+func IsPermanent(err error) bool {
+	if errors.Is(err, ErrInvalidAddress) {
+		return true
+	}
+	var smtpErr *textproto.Error
+	return errors.As(err, &smtpErr) && smtpErr.Code >= 500
+}
+
+// parseAddress 校验地址并返回不含显示名的邮箱
+func parseAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidAddress, address)
+	}
+	return parsed.Address, nil
+}
+
+// buildMessage 生成 RFC 5322 格式的邮件，主题按 RFC 2047 编码，正文 base64 编码
+func buildMessage(from string, msg *Message) ([]byte, error) {
+	if _, err := parseAddress(from); err != nil {
+		return nil, err
+	}
+	if _, err := parseAddress(msg.To); err != nil {
+		return nil, err
+	}
+	if strings.ContainsAny(msg.Subject, "\r\n") {
+		return nil, errors.New("邮件主题不能包含换行")
+	}
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	domain := "localhost"
+	if at := strings.LastIndex(from, "@"); at >= 0 {
+		domain = strings.Trim(from[at+1:], "> ")
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "Message-ID: <%s@%s>\r\n", hex.EncodeToString(id), domain)
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(msg.Body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+	return buf.Bytes(), nil
+}
+
+// ConsoleMailer 开发环境使用：把邮件的收件人、主题和正文写到输出（默认标准输出），不实际发送
+type ConsoleMailer struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewConsoleMailer 创建输出到 w 的 Mailer，w 为 nil 时输出到标准输出
+func NewConsoleMailer(w io.Writer) Mailer {
+	if w == nil {
+		w = os.Stdout
+	}
+	return &ConsoleMailer{out: w}
+}
+
+func (m *ConsoleMailer) local() {}
+
+func (m *ConsoleMailer) Send(ctx context.Context, msg *Message) error {
+	if _, err := parseAddress(msg.To); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := fmt.Fprintf(m.out, "==== 邮件 ====\nTo: %s\nSubject: %s\n\n%s\n==============\n", msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// FileMailer 开发环境使用：每封邮件保存为目录中的一个 .eml 文件，可用邮件客户端打开
+type FileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer 创建写入 dir 的 Mailer
+func NewFileMailer(dir, from string) (Mailer, error) {
+	if dir == "" {
+		return nil, errors.New("邮件目录不能为空")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建邮件目录失败: %w", err)
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+func (m *FileMailer) local() {}
+
+func (m *FileMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := buildMessage(m.from, msg)
+	if err != nil {
+		return err
+	}
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405"), hex.EncodeToString(suffix))
+	return os.WriteFile(filepath.Join(m.dir, name), data, 0644)
+}
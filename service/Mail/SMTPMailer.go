@@ -0,0 +1,137 @@
+package Mail
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTP 的加密方式
+const (
+	SMTPStartTLS = "starttls" // 明文连接后升级（通常为 587 端口），默认
+	SMTPTLS      = "tls"      // 直接建立 TLS 连接（通常为 465 端口）
+	SMTPNone     = "none"     // 不加密，只用于本机或内网的中继
+)
+
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPConfig SMTP 服务器配置
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string // 为空时不认证
+	Password string
+	From     string // 发件人，如 "Platform <no-reply@example.com>"
+	Security string // starttls / tls / none
+	Timeout  time.Duration
+}
+
+// SMTPMailer 通过 SMTP 服务器发送邮件，每封邮件使用一个新连接
+type SMTPMailer struct {
+	config SMTPConfig
+}
+
+// NewSMTPMailer 创建 SMTP Mailer
+func NewSMTPMailer(config SMTPConfig) (Mailer, error) {
+	if config.Host == "" || config.Port == "" {
+		return nil, errors.New("SMTP 服务器地址不能为空")
+	}
+	if _, err := parseAddress(config.From); err != nil {
+		return nil, fmt.Errorf("发件人地址无效: %w", err)
+	}
+	switch config.Security {
+	case "":
+		config.Security = SMTPStartTLS
+	case SMTPStartTLS, SMTPTLS, SMTPNone:
+	default:
+		return nil, fmt.Errorf("不支持的 SMTP 加密方式: %s", config.Security)
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultSMTPTimeout
+	}
+	return &SMTPMailer{config: config}, nil
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, msg *Message) error {
+	data, err := buildMessage(m.config.From, msg)
+	if err != nil {
+		return err
+	}
+	from, _ := parseAddress(m.config.From)
+	to, _ := parseAddress(msg.To)
+
+	client, err := m.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if m.config.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.config.Username, m.config.Password, m.config.Host)); err != nil {
+			return fmt.Errorf("SMTP 认证失败: %w", err)
+		}
+	}
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// dial 建立连接并按配置完成 TLS，整个会话受 ctx 的截止时间和 Timeout 限制
+func (m *SMTPMailer) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(m.config.Host, m.config.Port)
+	dialer := &net.Dialer{Timeout: m.config.Timeout}
+	tlsConfig := &tls.Config{ServerName: m.config.Host}
+
+	var conn net.Conn
+	var err error
+	if m.config.Security == SMTPTLS {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("连接 SMTP 服务器失败: %w", err)
+	}
+
+	deadline := time.Now().Add(m.config.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.config.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("连接 SMTP 服务器失败: %w", err)
+	}
+
+	if m.config.Security == SMTPStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, errors.New("SMTP 服务器不支持 STARTTLS")
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("SMTP STARTTLS 失败: %w", err)
+		}
+	}
+	return client, nil
+}
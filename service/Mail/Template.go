@@ -0,0 +1,116 @@
+package Mail
+
+import (
+	"bytes"
+	"fmt"
+	"platfrom/database"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// CodeData 验证码邮件的内容
+type CodeData struct {
+	Username  string
+	Code      string
+	ExpiresAt time.Time
+}
+
+// templateData 模板中可用的字段
+type templateData struct {
+	AppName          string
+	Username         string
+	Code             string
+	ExpiresInMinutes int
+}
+
+// builtinTemplates 内置的验证码邮件模板：验证码类型 -> 语言 -> 模板。
+// 新的验证码类型在这里或 style.yaml 的 mail.templates 中添加
+var builtinTemplates = map[string]map[string]database.MailTemplate{
+	"password_reset": {
+		"zh": {
+			Subject: "【{{.AppName}}】重置密码验证码",
+			Body: `{{.Username}}，你好：
+
+你正在重置 {{.AppName}} 的登录密码，验证码为：
+
+    {{.Code}}
+
+验证码 {{.ExpiresInMinutes}} 分钟内有效，只能使用一次。如果这不是你本人的操作，请忽略本邮件，你的密码不会被修改。
+`,
+		},
+		"en": {
+			Subject: "[{{.AppName}}] Your password reset code",
+			Body: `Hi {{.Username}},
+
+We received a request to reset your {{.AppName}} password. Your verification code is:
+
+    {{.Code}}
+
+The code expires in {{.ExpiresInMinutes}} minutes and can only be used once. If you did not request this, you can ignore this email and your password will stay the same.
+`,
+		},
+	},
+}
+
+type compiledTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+// compileTemplates 合并内置模板和配置中的模板并编译
+func compileTemplates(overrides map[string]map[string]database.MailTemplate) (map[string]map[string]compiledTemplate, error) {
+	merged := make(map[string]map[string]database.MailTemplate)
+	for _, source := range []map[string]map[string]database.MailTemplate{builtinTemplates, overrides} {
+		for codeType, languages := range source {
+			if merged[codeType] == nil {
+				merged[codeType] = make(map[string]database.MailTemplate)
+			}
+			for language, tmpl := range languages {
+				merged[codeType][normalizeLanguage(language)] = tmpl
+			}
+		}
+	}
+
+	compiled := make(map[string]map[string]compiledTemplate)
+	for codeType, languages := range merged {
+		compiled[codeType] = make(map[string]compiledTemplate)
+		for language, tmpl := range languages {
+			name := codeType + "." + language
+			subject, err := template.New(name + ".subject").Option("missingkey=error").Parse(tmpl.Subject)
+			if err != nil {
+				return nil, fmt.Errorf("解析邮件模板 %s 失败: %w", name, err)
+			}
+			body, err := template.New(name + ".body").Option("missingkey=error").Parse(tmpl.Body)
+			if err != nil {
+				return nil, fmt.Errorf("解析邮件模板 %s 失败: %w", name, err)
+			}
+			compiled[codeType][language] = compiledTemplate{subject: subject, body: body}
+		}
+	}
+	return compiled, nil
+}
+
+// normalizeLanguage 取语言标签的主标签，如 "en-US,en;q=0.9" -> "en"、"zh_CN" -> "zh"
+func normalizeLanguage(language string) string {
+	language = strings.TrimSpace(language)
+	if i := strings.IndexAny(language, ",;"); i >= 0 {
+		language = language[:i]
+	}
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(language))
+}
+
+// render 渲染邮件主题和正文
+func (t compiledTemplate) render(data templateData) (string, string, error) {
+	var subject, body bytes.Buffer
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return "", "", err
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(subject.String()), body.String(), nil
+}
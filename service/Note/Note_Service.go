@@ -3,6 +3,7 @@ package Note
 import (
 	"errors"
 	"gorm.io/gorm"
+	"log"
 	"platfrom/database"
 	"strings"
 )
@@ -25,6 +26,15 @@ type NoteServiceInterface interface {
 
 var GlobalNoteService NoteServiceInterface
 
+// NoteIndexerInterface 笔记变更时同步检索索引（由检索服务实现）
+type NoteIndexerInterface interface {
+	IndexNote(note *database.Note) error
+	RemoveNote(userID uint, noteID uint) error
+}
+
+// GlobalNoteIndexer 为空时不建立索引
+var GlobalNoteIndexer NoteIndexerInterface
+
 type NoteService struct {
 	db *gorm.DB
 }
@@ -42,7 +52,11 @@ func (s *NoteService) CreateNote(note *database.Note) error {
 	if note.Title == "" {
 		return errors.New("标题不能为空")
 	}
-	return s.db.Create(note).Error
+	if err := s.db.Create(note).Error; err != nil {
+		return err
+	}
+	indexNote(note)
+	return nil
 }
 
 // UpdateNote 更新笔记
@@ -65,6 +79,10 @@ func (s *NoteService) UpdateNote(UserID uint, id uint, note *database.Note) erro
 		return errors.New("笔记不存在或无权限修改")
 	}
 
+	// 更新可能只包含部分字段，重新读取完整笔记后再建索引
+	if updated, err := s.GetNoteByID(UserID, id); err == nil {
+		indexNote(updated)
+	}
 	return nil
 }
 
@@ -79,7 +97,11 @@ func (s *NoteService) DeleteNote(UserID uint, id uint) error {
 		}
 		return err
 	}
-	return s.db.Delete(&note).Error
+	if err := s.db.Delete(&note).Error; err != nil {
+		return err
+	}
+	removeNoteIndex(note.UserID, note.ID)
+	return nil
 }
 
 // GetNoteByID 根据ID获取笔记
@@ -190,6 +212,27 @@ func (s *NoteService) RootDeleteNote(noteID uint) error {
 	if err := database.DB.Delete(&note).Error; err != nil {
 		return err
 	}
+	removeNoteIndex(note.UserID, note.ID)
 
 	return nil
 }
+
+// indexNote 更新笔记的检索索引，失败只记录日志，不影响笔记本身的保存
+func indexNote(note *database.Note) {
+	if GlobalNoteIndexer == nil {
+		return
+	}
+	if err := GlobalNoteIndexer.IndexNote(note); err != nil {
+		log.Printf("笔记索引失败 (note: %d): %v", note.ID, err)
+	}
+}
+
+// removeNoteIndex 删除笔记的检索索引
+func removeNoteIndex(userID uint, noteID uint) {
+	if GlobalNoteIndexer == nil {
+		return
+	}
+	if err := GlobalNoteIndexer.RemoveNote(userID, noteID); err != nil {
+		log.Printf("删除笔记索引失败 (note: %d): %v", noteID, err)
+	}
+}
@@ -0,0 +1,67 @@
+package RAG
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	defaultChunkSize    = 500 // 每个切块的最大字数
+	defaultChunkOverlap = 50  // 相邻切块重叠的字数，避免句子被切断后丢失上下文
+)
+
+// SplitText 按段落切分文本，段落过长时按字数切分并保留重叠
+func SplitText(text string, chunkSize, overlap int) []string {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	if overlap < 0 || overlap >= chunkSize {
+		overlap = 0
+	}
+
+	var chunks []string
+	var current strings.Builder
+
+	flush := func() {
+		if chunk := strings.TrimSpace(current.String()); chunk != "" {
+			chunks = append(chunks, chunk)
+		}
+		current.Reset()
+	}
+
+	for _, paragraph := range strings.Split(text, "\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		// 当前切块放不下这个段落时先结束当前切块
+		if current.Len() > 0 && utf8.RuneCountInString(current.String())+utf8.RuneCountInString(paragraph)+1 > chunkSize {
+			flush()
+		}
+
+		runes := []rune(paragraph)
+		if len(runes) <= chunkSize {
+			if current.Len() > 0 {
+				current.WriteString("\n")
+			}
+			current.WriteString(paragraph)
+			continue
+		}
+
+		// 单个段落超长，按固定窗口切分
+		for start := 0; start < len(runes); start += chunkSize - overlap {
+			end := start + chunkSize
+			if end > len(runes) {
+				end = len(runes)
+			}
+			current.WriteString(string(runes[start:end]))
+			flush()
+			if end == len(runes) {
+				break
+			}
+		}
+	}
+	flush()
+	return chunks
+}
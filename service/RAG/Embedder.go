@@ -0,0 +1,160 @@
+package RAG
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// EmbedderInterface 文本向量化接口，检索时的查询和建索引时的切块必须使用同一个实现
+type EmbedderInterface interface {
+	// Embed 批量计算向量，返回值与 texts 一一对应
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+	// ModelName 向量模型标识，模型变化后旧向量不再参与检索
+	ModelName() string
+}
+
+// ========== OpenAI 兼容接口 ==========
+
+// OpenAIEmbedder 调用 OpenAI 兼容的 /embeddings 接口
+type OpenAIEmbedder struct {
+	Client *openai.Client
+	Model  string
+}
+
+func NewOpenAIEmbedder(apiKey, baseURL, model string) EmbedderInterface {
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = strings.TrimRight(baseURL, "/")
+	}
+	return &OpenAIEmbedder{
+		Client: openai.NewClientWithConfig(config),
+		Model:  model,
+	}
+}
+
+func (e *OpenAIEmbedder) ModelName() string {
+	return e.Model
+}
+
+func (e *OpenAIEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) == 0 {
+		return nil, nil
+	}
+	resp, err := e.Client.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: texts,
+		Model: openai.EmbeddingModel(e.Model),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Embedding error: %w", err)
+	}
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("Embedding error: 返回 %d 个向量，期望 %d 个", len(resp.Data), len(texts))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, item := range resp.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, errors.New("Embedding error: 向量序号越界")
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
+
+// ========== 本地哈希向量 ==========
+
+const defaultHashDimensions = 512
+
+// HashEmbedder 基于特征哈希的本地向量：英文按单词、中文按单字和相邻双字计特征
+// 不依赖外部服务，效果弱于语义向量模型，适合未配置向量接口时兜底
+type HashEmbedder struct {
+	Dimensions int
+}
+
+func NewHashEmbedder(dimensions int) EmbedderInterface {
+	if dimensions <= 0 {
+		dimensions = defaultHashDimensions
+	}
+	return &HashEmbedder{Dimensions: dimensions}
+}
+
+func (e *HashEmbedder) ModelName() string {
+	return fmt.Sprintf("local-hash-%d", e.Dimensions)
+}
+
+func (e *HashEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, e.Dimensions)
+		for _, feature := range hashFeatures(text) {
+			h := fnv.New32a()
+			_, _ = h.Write([]byte(feature))
+			sum := h.Sum32()
+			// 用哈希的最高位决定符号，减少碰撞带来的偏差
+			if sum&(1<<31) != 0 {
+				vector[sum%uint32(e.Dimensions)] -= 1
+			} else {
+				vector[sum%uint32(e.Dimensions)] += 1
+			}
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// hashFeatures 提取文本特征：小写英文单词/数字，中文单字及相邻双字
+func hashFeatures(text string) []string {
+	var features []string
+	var word []rune
+	var prevHan rune
+
+	flushWord := func() {
+		if len(word) > 0 {
+			features = append(features, string(word))
+			word = word[:0]
+		}
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.Is(unicode.Han, r):
+			flushWord()
+			features = append(features, string(r))
+			if prevHan != 0 {
+				features = append(features, string([]rune{prevHan, r}))
+			}
+			prevHan = r
+			continue
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word = append(word, r)
+		default:
+			flushWord()
+		}
+		prevHan = 0
+	}
+	flushWord()
+	return features
+}
+
+// normalize 归一化为单位向量，之后余弦相似度即为点积
+func normalize(vector []float32) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += float64(v) * float64(v)
+	}
+	if norm == 0 {
+		return vector
+	}
+	norm = math.Sqrt(norm)
+	result := make([]float32, len(vector))
+	for i, v := range vector {
+		result[i] = float32(float64(v) / norm)
+	}
+	return result
+}
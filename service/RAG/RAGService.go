@@ -0,0 +1,392 @@
+package RAG
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"math"
+	"platfrom/database"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTopK      = 5
+	maxTopK          = 20
+	indexTimeout     = 30 * time.Second
+	fileIndexTimeout = 10 * time.Minute // 大文件切块多，向量化耗时较长
+	embedBatchSize   = 32               // 单次向量化请求的最大切块数
+)
+
+type RAGServiceInterface interface {
+	// IndexNote 重建笔记的切块和向量（笔记创建或更新后调用）
+	IndexNote(note *database.Note) error
+	// RemoveNote 删除笔记的切块（笔记删除后调用）
+	RemoveNote(userID uint, noteID uint) error
+	// Search 检索用户笔记中与查询最相关的切块
+	Search(ctx context.Context, userID uint, query string, topK int) ([]SearchResult, error)
+	// SyncAll 为尚未按当前向量模型建立索引的笔记补建索引
+	SyncAll(ctx context.Context) (int, error)
+
+	// IndexFile 重建上传文件的切块和向量，content 为提取出的文本
+	IndexFile(file *database.UploadedFile, content string) error
+	// RemoveFile 删除上传文件的切块
+	RemoveFile(fileID uint) error
+	// SearchFiles 在指定文件中检索与查询最相关的切块
+	SearchFiles(ctx context.Context, fileIDs []uint, query string, topK int) ([]FileSearchResult, error)
+}
+
+// SearchResult 检索结果
+type SearchResult struct {
+	NoteID     uint    `json:"note_id"`
+	Title      string  `json:"title"`
+	ChunkIndex int     `json:"chunk_index"`
+	Content    string  `json:"content"`
+	Score      float64 `json:"score"`
+}
+
+// FileSearchResult 文件检索结果
+type FileSearchResult struct {
+	FileID     uint    `json:"file_id"`
+	FileName   string  `json:"file_name"`
+	ChunkIndex int     `json:"chunk_index"`
+	Content    string  `json:"-"`
+	Score      float64 `json:"score"`
+}
+
+var GlobalRAGService RAGServiceInterface
+
+type RAGService struct {
+	db       *gorm.DB
+	embedder EmbedderInterface
+}
+
+func NewRAGService(db *gorm.DB, embedder EmbedderInterface) (RAGServiceInterface, error) {
+	if db == nil {
+		return nil, errors.New("数据库连接不能为空")
+	}
+	if embedder == nil {
+		return nil, errors.New("向量模型不能为空")
+	}
+	service := &RAGService{
+		db:       db,
+		embedder: embedder,
+	}
+	GlobalRAGService = service
+	return service, nil
+}
+
+// IndexNote 重建笔记的切块和向量，标题参与向量计算以便按标题检索
+func (s *RAGService) IndexNote(note *database.Note) error {
+	if note == nil || note.ID == 0 {
+		return errors.New("笔记不存在")
+	}
+
+	texts := SplitText(note.Content, defaultChunkSize, defaultChunkOverlap)
+	if len(texts) == 0 && note.Title != "" {
+		texts = []string{note.Title}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), indexTimeout)
+	defer cancel()
+	vectors, err := s.embedChunks(ctx, note.Title, texts)
+	if err != nil {
+		return err
+	}
+
+	chunks := make([]database.NoteChunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = database.NoteChunk{
+			UserID:         note.UserID,
+			NoteID:         note.ID,
+			ChunkIndex:     i,
+			Title:          note.Title,
+			Content:        text,
+			Embedding:      vectors[i],
+			EmbeddingModel: s.embedder.ModelName(),
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("note_id = ?", note.ID).Delete(&database.NoteChunk{}).Error; err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+		return tx.Create(&chunks).Error
+	})
+}
+
+// RemoveNote 删除笔记的切块
+func (s *RAGService) RemoveNote(userID uint, noteID uint) error {
+	query := s.db.Where("note_id = ?", noteID)
+	if userID > 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	return query.Delete(&database.NoteChunk{}).Error
+}
+
+// Search 在用户自己的笔记切块中按余弦相似度检索
+func (s *RAGService) Search(ctx context.Context, userID uint, query string, topK int) ([]SearchResult, error) {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil, nil
+	}
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+	if topK > maxTopK {
+		topK = maxTopK
+	}
+
+	vectors, err := s.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	queryVector := normalize(vectors[0])
+
+	var chunks []database.NoteChunk
+	if err := s.db.Where("user_id = ? AND embedding_model = ?", userID, s.embedder.ModelName()).
+		Find(&chunks).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(chunks))
+	for _, chunk := range chunks {
+		score := dot(queryVector, decodeVector(chunk.Embedding))
+		if score <= 0 {
+			continue
+		}
+		results = append(results, SearchResult{
+			NoteID:     chunk.NoteID,
+			Title:      chunk.Title,
+			ChunkIndex: chunk.ChunkIndex,
+			Content:    chunk.Content,
+			Score:      score,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	return results, nil
+}
+
+// SyncAll 为没有当前模型向量的笔记补建索引，返回处理的笔记数
+// 用于首次启用检索或更换向量模型后的迁移
+func (s *RAGService) SyncAll(ctx context.Context) (int, error) {
+	var notes []database.Note
+	indexed := s.db.Model(&database.NoteChunk{}).
+		Select("note_id").
+		Where("embedding_model = ?", s.embedder.ModelName())
+	if err := s.db.Where("id NOT IN (?)", indexed).Find(&notes).Error; err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range notes {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		if err := s.IndexNote(&notes[i]); err != nil {
+			log.Printf("笔记索引失败 (note: %d): %v", notes[i].ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+// IndexFile 重建上传文件的切块和向量，文件名参与向量计算
+func (s *RAGService) IndexFile(file *database.UploadedFile, content string) error {
+	if file == nil || file.ID == 0 {
+		return errors.New("文件不存在")
+	}
+
+	texts := SplitText(content, defaultChunkSize, defaultChunkOverlap)
+	ctx, cancel := context.WithTimeout(context.Background(), fileIndexTimeout)
+	defer cancel()
+	vectors, err := s.embedChunks(ctx, file.FileName, texts)
+	if err != nil {
+		return err
+	}
+
+	chunks := make([]database.FileChunk, len(texts))
+	for i, text := range texts {
+		chunks[i] = database.FileChunk{
+			FileID:         file.ID,
+			SessionID:      file.SessionID,
+			FileName:       file.FileName,
+			ChunkIndex:     i,
+			Content:        text,
+			Embedding:      vectors[i],
+			EmbeddingModel: s.embedder.ModelName(),
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("file_id = ?", file.ID).Delete(&database.FileChunk{}).Error; err != nil {
+			return err
+		}
+		if len(chunks) == 0 {
+			return nil
+		}
+		// 大文件切块较多，分批插入避免超出 SQL 参数上限
+		return tx.CreateInBatches(&chunks, 100).Error
+	})
+}
+
+// RemoveFile 删除上传文件的切块
+func (s *RAGService) RemoveFile(fileID uint) error {
+	return s.db.Where("file_id = ?", fileID).Delete(&database.FileChunk{}).Error
+}
+
+// SearchFiles 在指定文件的切块中按相似度排序取前 topK 个
+// 与笔记检索不同，这里不过滤低分切块：用户主动附加了文件，问题与内容字面无关时（如"总结一下"）也应返回内容
+func (s *RAGService) SearchFiles(ctx context.Context, fileIDs []uint, query string, topK int) ([]FileSearchResult, error) {
+	if len(fileIDs) == 0 {
+		return nil, nil
+	}
+	if topK <= 0 {
+		topK = defaultTopK
+	}
+	if topK > maxTopK {
+		topK = maxTopK
+	}
+
+	vectors, err := s.embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	queryVector := normalize(vectors[0])
+
+	var chunks []database.FileChunk
+	if err := s.db.Where("file_id IN ? AND embedding_model = ?", fileIDs, s.embedder.ModelName()).
+		Order("file_id, chunk_index").
+		Find(&chunks).Error; err != nil {
+		return nil, err
+	}
+
+	results := make([]FileSearchResult, 0, len(chunks))
+	for _, chunk := range chunks {
+		results = append(results, FileSearchResult{
+			FileID:     chunk.FileID,
+			FileName:   chunk.FileName,
+			ChunkIndex: chunk.ChunkIndex,
+			Content:    chunk.Content,
+			Score:      dot(queryVector, decodeVector(chunk.Embedding)),
+		})
+	}
+
+	// 按相关度取前 topK，再按文件内的顺序排列，便于模型理解上下文
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Score > results[j].Score
+	})
+	if len(results) > topK {
+		results = results[:topK]
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].FileID != results[j].FileID {
+			return results[i].FileID < results[j].FileID
+		}
+		return results[i].ChunkIndex < results[j].ChunkIndex
+	})
+	return results, nil
+}
+
+// embedChunks 计算切块向量（标题或文件名参与计算），返回编码后的归一化向量
+func (s *RAGService) embedChunks(ctx context.Context, title string, texts []string) ([][]byte, error) {
+	inputs := make([]string, len(texts))
+	for i, text := range texts {
+		inputs[i] = title + "\n" + text
+	}
+	vectors, err := s.embed(ctx, inputs)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded := make([][]byte, len(vectors))
+	for i, vector := range vectors {
+		encoded[i] = encodeVector(normalize(vector))
+	}
+	return encoded, nil
+}
+
+// embed 分批调用向量模型
+func (s *RAGService) embed(ctx context.Context, texts []string) ([][]float32, error) {
+	vectors := make([][]float32, 0, len(texts))
+	for start := 0; start < len(texts); start += embedBatchSize {
+		end := start + embedBatchSize
+		if end > len(texts) {
+			end = len(texts)
+		}
+		batch, err := s.embedder.Embed(ctx, texts[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("向量化失败: %w", err)
+		}
+		if len(batch) != end-start {
+			return nil, fmt.Errorf("向量化失败: 返回 %d 个向量，期望 %d 个", len(batch), end-start)
+		}
+		vectors = append(vectors, batch...)
+	}
+	return vectors, nil
+}
+
+// FormatFileChunks 把文件检索结果格式化为附在用户消息后的文件片段
+func FormatFileChunks(results []FileSearchResult) string {
+	var parts []string
+	for _, result := range results {
+		parts = append(parts, fmt.Sprintf("【文件：%s 片段%d】\n%s\n", result.FileName, result.ChunkIndex+1, result.Content))
+	}
+	return strings.Join(parts, "\n")
+}
+
+// FormatReferences 把检索结果格式化为注入上下文的参考资料，要求模型按笔记ID引用
+func FormatReferences(results []SearchResult) string {
+	if len(results) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteString("以下是从用户笔记中检索到的参考资料。回答时如果用到了某条资料，请在相应位置按资料标注的 [笔记<ID>:<标题>] 格式注明出处；资料与问题无关时忽略即可。\n")
+	for i, result := range results {
+		builder.WriteString(fmt.Sprintf("\n[%d] [笔记%d:%s]\n%s\n", i+1, result.NoteID, result.Title, result.Content))
+	}
+	return builder.String()
+}
+
+func encodeVector(vector []float32) []byte {
+	data := make([]byte, 4*len(vector))
+	for i, v := range vector {
+		binary.LittleEndian.PutUint32(data[4*i:], math.Float32bits(v))
+	}
+	return data
+}
+
+func decodeVector(data []byte) []float32 {
+	vector := make([]float32, len(data)/4)
+	for i := range vector {
+		vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(data[4*i:]))
+	}
+	return vector
+}
+
+// dot 点积，维度不一致时按较短的计算
+func dot(a, b []float32) float64 {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	var sum float64
+	for i := 0; i < n; i++ {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
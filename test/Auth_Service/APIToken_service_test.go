@@ -0,0 +1,53 @@
+package Auth_Service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"platfrom/database"
+	"platfrom/service/Auth"
+)
+
+// TestAPIToken 测试 API 令牌的创建、校验和删除
+func TestAPIToken(t *testing.T) {
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&database.APIToken{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	service, err := Auth.NewAPITokenService(db)
+	if err != nil {
+		t.Fatalf("创建令牌服务失败: %v", err)
+	}
+
+	record, token, err := service.CreateToken(1, "脚本")
+	if err != nil {
+		t.Fatalf("创建令牌失败: %v", err)
+	}
+	if !strings.HasPrefix(token, record.Prefix) || strings.Contains(record.TokenHash, token) {
+		t.Errorf("只应保存令牌的哈希和前缀: %+v", record)
+	}
+
+	got, err := service.Authenticate(token)
+	if err != nil || got.ID != record.ID || got.UserID != 1 || got.LastUsedAt == nil {
+		t.Fatalf("校验令牌失败: %+v, %v", got, err)
+	}
+	for _, invalid := range []string{"", "sk-plat-0000", token + "x"} {
+		if _, err := service.Authenticate(invalid); !errors.Is(err, Auth.ErrInvalidAPIToken) {
+			t.Errorf("无效令牌应返回 ErrInvalidAPIToken: %q, %v", invalid, err)
+		}
+	}
+
+	if err := service.DeleteToken(2, record.ID); err == nil {
+		t.Error("不能删除其他用户的令牌")
+	}
+	if err := service.DeleteToken(1, record.ID); err != nil {
+		t.Fatalf("删除令牌失败: %v", err)
+	}
+	if _, err := service.Authenticate(token); !errors.Is(err, Auth.ErrInvalidAPIToken) {
+		t.Errorf("删除后令牌应失效: %v", err)
+	}
+	if tokens, _ := service.ListTokens(1); len(tokens) != 0 {
+		t.Errorf("删除后列表应为空: %d", len(tokens))
+	}
+}
@@ -0,0 +1,281 @@
+package Auth_Service
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"platfrom/Config"
+	"platfrom/database"
+	"platfrom/service/Auth"
+
+	"gorm.io/gorm"
+)
+
+// setupTokenService 创建不使用 Redis 的令牌服务和一个测试用户
+func setupTokenService(t *testing.T) (Auth.TokenService, Auth.UserService, *database.User, *gorm.DB) {
+	previousKey, previousService := Config.Cfg.SecretKey, Auth.GlobalTokenService
+	Config.Cfg.SecretKey = "test-secret"
+	t.Cleanup(func() {
+		Config.Cfg.SecretKey = previousKey
+		Auth.GlobalTokenService = previousService
+	})
+
+	db := setupTestDB(t)
+	if err := db.AutoMigrate(&database.AuthSession{}, &database.RefreshToken{}, &database.RevokedToken{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	userService, err := Auth.NewUserService(db)
+	if err != nil {
+		t.Fatalf("创建用户服务失败: %v", err)
+	}
+	user, err := userService.CreateUser(database.RegisterRequest{Username: "alice", Password: "password123"})
+	if err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	tokenService, err := Auth.NewTokenService(db, nil)
+	if err != nil {
+		t.Fatalf("创建令牌服务失败: %v", err)
+	}
+	return tokenService, userService, user, db
+}
+
+// TestTokenService 测试令牌签发、刷新轮换、重复使用检测和吊销
+func TestTokenService(t *testing.T) {
+	service, _, user, db := setupTokenService(t)
+
+	t.Run("签发和校验", func(t *testing.T) {
+		pair, err := service.IssueTokens(user, "test-agent", "127.0.0.1")
+		if err != nil {
+			t.Fatalf("签发令牌失败: %v", err)
+		}
+		claims, err := service.Authenticate(pair.AccessToken)
+		if err != nil || claims.UserID != user.ID || claims.SessionID != pair.SessionID || claims.ID == "" {
+			t.Fatalf("访问令牌校验失败: %+v, %v", claims, err)
+		}
+		var record database.RefreshToken
+		db.Where("session_id = ?", pair.SessionID).First(&record)
+		if record.TokenHash == "" || record.TokenHash == pair.RefreshToken {
+			t.Errorf("刷新令牌只应保存哈希: %+v", record)
+		}
+		if _, err := service.Refresh("invalid", "", ""); !errors.Is(err, Auth.ErrInvalidRefreshToken) {
+			t.Errorf("无效的刷新令牌应返回 ErrInvalidRefreshToken: %v", err)
+		}
+	})
+
+	t.Run("刷新轮换并吊销上一个访问令牌", func(t *testing.T) {
+		pair, _ := service.IssueTokens(user, "", "")
+		next, err := service.Refresh(pair.RefreshToken, "", "")
+		if err != nil {
+			t.Fatalf("刷新失败: %v", err)
+		}
+		if next.RefreshToken == "" || next.RefreshToken == pair.RefreshToken || next.SessionID != pair.SessionID {
+			t.Fatalf("刷新应轮换刷新令牌并沿用会话: %+v", next)
+		}
+		if _, err := service.Authenticate(pair.AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+			t.Errorf("刷新后上一个访问令牌应被吊销: %v", err)
+		}
+		if _, err := service.Authenticate(next.AccessToken); err != nil {
+			t.Errorf("新的访问令牌应有效: %v", err)
+		}
+
+		// 宽限期内再次使用旧的刷新令牌（并发请求），只签发访问令牌
+		again, err := service.Refresh(pair.RefreshToken, "", "")
+		if err != nil || again.RefreshToken != "" || again.AccessToken == "" {
+			t.Fatalf("宽限期内应只签发访问令牌: %+v, %v", again, err)
+		}
+		if _, err := service.Authenticate(next.AccessToken); err != nil {
+			t.Errorf("宽限期内刷新不应吊销已签发的访问令牌: %v", err)
+		}
+		if _, err := service.Authenticate(again.AccessToken); err != nil {
+			t.Errorf("宽限期内签发的访问令牌应有效: %v", err)
+		}
+	})
+
+	t.Run("刷新期间会话被吊销", func(t *testing.T) {
+		pair, _ := service.IssueTokens(user, "", "")
+
+		sqlDB, err := db.DB()
+		if err != nil {
+			t.Fatalf("获取数据库连接失败: %v", err)
+		}
+		// 内存数据库每个连接各自独立，回调中的吊销需要和刷新共用一个连接
+		sqlDB.SetMaxOpenConns(1)
+
+		// 在刷新读取会话之后、提交轮换之前吊销会话，模拟并发的注销
+		const callback = "test:revoke_during_refresh"
+		revoked := false
+		err = db.Callback().Query().After("gorm:query").Register(callback, func(tx *gorm.DB) {
+			if revoked || tx.Statement.Table != "auth_sessions" {
+				return
+			}
+			revoked = true
+			tx.Session(&gorm.Session{NewDB: true}).Model(&database.AuthSession{}).
+				Where("id = ?", pair.SessionID).Update("revoked_at", time.Now())
+		})
+		if err != nil {
+			t.Fatalf("注册回调失败: %v", err)
+		}
+		_, err = service.Refresh(pair.RefreshToken, "", "")
+		db.Callback().Query().Remove(callback)
+
+		if !errors.Is(err, Auth.ErrInvalidRefreshToken) {
+			t.Fatalf("会话在刷新期间被吊销应返回 ErrInvalidRefreshToken: %v", err)
+		}
+		var session database.AuthSession
+		db.Where("id = ?", pair.SessionID).First(&session)
+		if session.RevokedAt == nil {
+			t.Errorf("刷新不应恢复已吊销的会话")
+		}
+		var count int64
+		db.Model(&database.RefreshToken{}).Where("session_id = ?", pair.SessionID).Count(&count)
+		if count != 1 {
+			t.Errorf("刷新失败时不应留下新的刷新令牌: %d", count)
+		}
+	})
+
+	t.Run("并发刷新", func(t *testing.T) {
+		sqlDB, err := db.DB()
+		if err != nil {
+			t.Fatalf("获取数据库连接失败: %v", err)
+		}
+		// 内存数据库每个连接各自独立，并发请求需要共用一个连接
+		sqlDB.SetMaxOpenConns(1)
+
+		pair, _ := service.IssueTokens(user, "", "")
+		const workers = 8
+		pairs := make([]*Auth.TokenPair, workers)
+		errs := make([]error, workers)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				pairs[i], errs[i] = service.Refresh(pair.RefreshToken, "", "")
+			}(i)
+		}
+		wg.Wait()
+
+		rotated := 0
+		for i := 0; i < workers; i++ {
+			if errs[i] != nil {
+				t.Fatalf("并发刷新失败: %v", errs[i])
+			}
+			if pairs[i].RefreshToken != "" {
+				rotated++
+			}
+		}
+		if rotated != 1 {
+			t.Errorf("并发刷新应只轮换一次刷新令牌: %d", rotated)
+		}
+		for i := 0; i < workers; i++ {
+			if _, err := service.Authenticate(pairs[i].AccessToken); err != nil {
+				t.Errorf("并发刷新签发的访问令牌都应有效: %v", err)
+			}
+		}
+		if _, err := service.Authenticate(pair.AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+			t.Errorf("刷新前的访问令牌应被吊销: %v", err)
+		}
+
+		// 吊销会话后所有并发签发的访问令牌一并失效
+		if err := service.RevokeSession(user.ID, pair.SessionID); err != nil {
+			t.Fatalf("注销设备失败: %v", err)
+		}
+		for i := 0; i < workers; i++ {
+			if _, err := service.Authenticate(pairs[i].AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+				t.Errorf("会话被吊销后访问令牌应失效: %v", err)
+			}
+		}
+	})
+
+	t.Run("宽限期后重复使用吊销整个会话", func(t *testing.T) {
+		pair, _ := service.IssueTokens(user, "", "")
+		next, err := service.Refresh(pair.RefreshToken, "", "")
+		if err != nil {
+			t.Fatalf("刷新失败: %v", err)
+		}
+		db.Model(&database.RefreshToken{}).Where("used_at IS NOT NULL AND session_id = ?", pair.SessionID).
+			Update("used_at", time.Now().Add(-time.Minute))
+
+		if _, err := service.Refresh(pair.RefreshToken, "", ""); !errors.Is(err, Auth.ErrRefreshTokenReused) {
+			t.Fatalf("宽限期后重复使用应返回 ErrRefreshTokenReused: %v", err)
+		}
+		if _, err := service.Authenticate(next.AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+			t.Errorf("会话被吊销后访问令牌应失效: %v", err)
+		}
+		if _, err := service.Refresh(next.RefreshToken, "", ""); !errors.Is(err, Auth.ErrInvalidRefreshToken) {
+			t.Errorf("会话被吊销后刷新令牌应失效: %v", err)
+		}
+	})
+
+	t.Run("注销", func(t *testing.T) {
+		pair, _ := service.IssueTokens(user, "", "")
+		if err := service.Logout(pair.AccessToken, ""); err != nil {
+			t.Fatalf("注销失败: %v", err)
+		}
+		if _, err := service.Authenticate(pair.AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+			t.Errorf("注销后访问令牌应失效: %v", err)
+		}
+		if _, err := service.Refresh(pair.RefreshToken, "", ""); !errors.Is(err, Auth.ErrInvalidRefreshToken) {
+			t.Errorf("注销后刷新令牌应失效: %v", err)
+		}
+
+		// 只有刷新令牌（访问令牌已过期）时也能注销
+		other, _ := service.IssueTokens(user, "", "")
+		if err := service.Logout("", other.RefreshToken); err != nil {
+			t.Fatalf("按刷新令牌注销失败: %v", err)
+		}
+		if _, err := service.Authenticate(other.AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+			t.Errorf("注销后访问令牌应失效: %v", err)
+		}
+		if err := service.Logout("invalid", "invalid"); err != nil {
+			t.Errorf("无效令牌注销应忽略: %v", err)
+		}
+	})
+}
+
+// TestAuthSessions 测试登录设备的列表、注销，以及修改密码后吊销所有令牌
+func TestAuthSessions(t *testing.T) {
+	service, userService, user, _ := setupTokenService(t)
+
+	phone, _ := service.IssueTokens(user, "phone", "10.0.0.1")
+	laptop, _ := service.IssueTokens(user, "laptop", "10.0.0.2")
+	tablet, _ := service.IssueTokens(user, "tablet", "10.0.0.3")
+
+	sessions, err := service.ListSessions(user.ID)
+	if err != nil || len(sessions) != 3 {
+		t.Fatalf("应有 3 个登录设备: %d, %v", len(sessions), err)
+	}
+
+	if err := service.RevokeSession(user.ID+1, phone.SessionID); !errors.Is(err, Auth.ErrAuthSessionNotFound) {
+		t.Errorf("不能注销其他用户的设备: %v", err)
+	}
+	if err := service.RevokeSession(user.ID, phone.SessionID); err != nil {
+		t.Fatalf("注销设备失败: %v", err)
+	}
+	if _, err := service.Authenticate(phone.AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+		t.Errorf("注销设备后访问令牌应失效: %v", err)
+	}
+
+	count, err := service.RevokeUserSessions(user.ID, laptop.SessionID)
+	if err != nil || count != 1 {
+		t.Fatalf("应注销除当前设备外的 1 个设备: %d, %v", count, err)
+	}
+	if _, err := service.Authenticate(tablet.AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+		t.Errorf("其他设备的访问令牌应失效: %v", err)
+	}
+	if sessions, _ := service.ListSessions(user.ID); len(sessions) != 1 || sessions[0].ID != laptop.SessionID {
+		t.Errorf("应只剩当前设备: %+v", sessions)
+	}
+
+	if err := userService.UpdatePassword(user.ID, "password123", "newpassword456"); err != nil {
+		t.Fatalf("修改密码失败: %v", err)
+	}
+	if _, err := service.Authenticate(laptop.AccessToken); !errors.Is(err, Auth.ErrTokenRevoked) {
+		t.Errorf("修改密码后所有访问令牌应失效: %v", err)
+	}
+	if _, err := service.Refresh(laptop.RefreshToken, "", ""); !errors.Is(err, Auth.ErrInvalidRefreshToken) {
+		t.Errorf("修改密码后所有刷新令牌应失效: %v", err)
+	}
+}
@@ -1,10 +1,15 @@
 package Auth_Service
 
 import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"platfrom/service/Auth"
+	"platfrom/service/Mail"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
@@ -262,7 +267,7 @@ func TestSendVerificationCode(t *testing.T) {
 	}
 
 	t.Run("成功发送验证码", func(t *testing.T) {
-		code, err := service.SendVerificationCode("testuser_all", "password_reset")
+		code, err := service.SendVerificationCode("testuser_all", "password_reset", "")
 		if err != nil {
 			t.Errorf("SendVerificationCode() 意外返回错误: %v", err)
 			return
@@ -294,7 +299,7 @@ func TestVerifyCode(t *testing.T) {
 	}
 
 	// 发送验证码
-	codeRecord, err := service.SendVerificationCode("testuser_all", "password_reset")
+	codeRecord, err := service.SendVerificationCode("testuser_all", "password_reset", "")
 	if err != nil {
 		t.Fatalf("发送验证码失败: %v", err)
 	}
@@ -364,7 +369,7 @@ func TestResetPassword(t *testing.T) {
 	}
 
 	// 发送验证码
-	codeRecord, err := service.SendVerificationCode("testuser_all", "password_reset")
+	codeRecord, err := service.SendVerificationCode("testuser_all", "password_reset", "")
 	if err != nil {
 		t.Fatalf("发送验证码失败: %v", err)
 	}
@@ -678,3 +683,83 @@ func TestRootAddUser(t *testing.T) {
 		})
 	}
 }
+
+// recordingMailer 记录发送的邮件
+type recordingMailer struct {
+	mu       sync.Mutex
+	messages []*Mail.Message
+}
+
+func (m *recordingMailer) Send(ctx context.Context, msg *Mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+func (m *recordingMailer) sent() []*Mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Mail.Message(nil), m.messages...)
+}
+
+// TestSendVerificationCodeMail 测试配置邮件服务后验证码发送到用户邮箱
+func TestSendVerificationCodeMail(t *testing.T) {
+	service, cleanup := setupUserService(t)
+	defer cleanup()
+
+	mailer := &recordingMailer{}
+	previous := Mail.GlobalMailService
+	mailService, err := Mail.NewMailService(mailer, database.MailConfig{})
+	if err != nil {
+		t.Fatalf("创建邮件服务失败: %v", err)
+	}
+	t.Cleanup(func() {
+		mailService.Shutdown()
+		Mail.GlobalMailService = previous
+	})
+
+	if _, err := service.CreateUser(database.RegisterRequest{Username: "with_email", Password: "password123", Email: "alice@example.com"}); err != nil {
+		t.Fatalf("创建测试用户失败: %v", err)
+	}
+	if _, err := service.CreateUser(database.RegisterRequest{Username: "no_email", Password: "password123"}); err != nil {
+		t.Fatalf("创建测试用户失败: %v", err)
+	}
+
+	code, err := service.SendVerificationCode("with_email", "password_reset", "en")
+	if err != nil {
+		t.Fatalf("发送验证码失败: %v", err)
+	}
+	mailService.Shutdown()
+	sent := mailer.sent()
+	if len(sent) != 1 || sent[0].To != "alice@example.com" || !strings.Contains(sent[0].Body, code.Code) ||
+		!strings.Contains(sent[0].Subject, "password reset") {
+		t.Fatalf("验证码邮件错误: %+v", sent)
+	}
+
+	if _, err := service.SendVerificationCode("no_email", "password_reset", ""); err == nil {
+		t.Error("用户没有邮箱时应返回错误")
+	}
+
+	// 开发用的控制台 Mailer 不要求邮箱，没有邮箱的用户仍能拿到验证码
+	var out bytes.Buffer
+	consoleService, err := Mail.NewMailService(Mail.NewConsoleMailer(&out), database.MailConfig{})
+	if err != nil {
+		t.Fatalf("创建邮件服务失败: %v", err)
+	}
+	t.Cleanup(consoleService.Shutdown)
+	if consoleService.RequiresAddress() || !mailService.RequiresAddress() {
+		t.Error("只有实际投递的 Mailer 需要邮箱")
+	}
+	code, err = service.SendVerificationCode("no_email", "password_reset", "")
+	if err != nil {
+		t.Fatalf("控制台 Mailer 下没有邮箱也应能发送验证码: %v", err)
+	}
+	if valid, _ := service.VerifyCode("no_email", code.Code, "password_reset"); !valid {
+		t.Error("验证码应有效")
+	}
+	consoleService.Shutdown()
+	if !strings.Contains(out.String(), "To: "+Mail.LocalRecipient) || !strings.Contains(out.String(), code.Code) {
+		t.Errorf("验证码应通过控制台 Mailer 发给占位地址: %s", out.String())
+	}
+}
@@ -0,0 +1,145 @@
+package LLM_Chat_Service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testMasterKey 生成测试用的 32 字节主密钥
+func testMasterKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+// useAPIKeyCipher 设置全局加解密器（默认只有主密钥 v1），测试结束后恢复
+func useAPIKeyCipher(t *testing.T, keys ...string) *LLM_Chat.APIKeyCipher {
+	t.Helper()
+	if len(keys) == 0 {
+		keys = []string{"v1"}
+	}
+	masterKeys := make(map[string][]byte)
+	for i, id := range keys {
+		masterKeys[id] = testMasterKey(byte(i + 1))
+	}
+	// 最后一个是当前主密钥
+	c, err := LLM_Chat.NewAPIKeyCipher(masterKeys, keys[len(keys)-1])
+	if err != nil {
+		t.Fatalf("创建加解密器失败: %v", err)
+	}
+	previous := LLM_Chat.GlobalAPIKeyCipher
+	LLM_Chat.GlobalAPIKeyCipher = c
+	t.Cleanup(func() { LLM_Chat.GlobalAPIKeyCipher = previous })
+	return c
+}
+
+// TestAPIKeyEncryption 测试密钥加密保存、旧明文记录兼容、主密钥轮换和接口返回脱敏密钥
+func TestAPIKeyEncryption(t *testing.T) {
+	t.Run("加解密", func(t *testing.T) {
+		c := useAPIKeyCipher(t)
+		ciphertext, dataKey, keyID, err := c.Encrypt("sk-secret")
+		if err != nil || keyID != "v1" || strings.Contains(ciphertext, "sk-secret") {
+			t.Fatalf("加密结果错误: %s, %s, %v", ciphertext, keyID, err)
+		}
+		if plaintext, err := c.Decrypt(ciphertext, dataKey, keyID); err != nil || plaintext != "sk-secret" {
+			t.Errorf("解密结果错误: %s, %v", plaintext, err)
+		}
+		if _, err := c.Decrypt(ciphertext, dataKey, "v9"); !errors.Is(err, LLM_Chat.ErrUnknownMasterKey) {
+			t.Errorf("未知主密钥应返回 ErrUnknownMasterKey: %v", err)
+		}
+
+		keys, err := LLM_Chat.ParseMasterKeys("v1:" + base64.StdEncoding.EncodeToString(testMasterKey(1)) + ", v2:" + base64.StdEncoding.EncodeToString(testMasterKey(2)))
+		if err != nil || len(keys) != 2 || !bytes.Equal(keys["v2"], testMasterKey(2)) {
+			t.Errorf("解析主密钥错误: %v, %v", keys, err)
+		}
+		if _, err := LLM_Chat.NewAPIKeyCipher(map[string][]byte{"v1": []byte("short")}, "v1"); err == nil {
+			t.Error("主密钥长度错误时应返回错误")
+		}
+	})
+
+	db := setupTestDB(t)
+	useAPIKeyCipher(t)
+	service, err := LLM_Chat.NewUserAPIService(db)
+	if err != nil {
+		t.Fatalf("创建用户API服务失败: %v", err)
+	}
+	created, err := service.CreateAPI(1, &database.UserAPI{APIName: "encrypted", APIKey: "sk-encrypted-1234", ModelName: "gpt-4"})
+	if err != nil || created.APIKey != "sk-encrypted-1234" {
+		t.Fatalf("创建API配置失败: %+v, %v", created, err)
+	}
+	// 加密前保存的旧记录
+	legacy := &database.UserAPI{UserID: 1, APIName: "legacy", APIKey: "sk-legacy-5678", ModelName: "gpt-3.5"}
+	if err := db.Create(legacy).Error; err != nil {
+		t.Fatalf("创建旧记录失败: %v", err)
+	}
+
+	t.Run("数据库中保存密文", func(t *testing.T) {
+		var row database.UserAPI
+		db.First(&row, created.ID)
+		if row.KeyID != "v1" || row.DataKey == "" || strings.Contains(row.APIKey, "sk-encrypted") {
+			t.Errorf("密钥应加密保存: %+v", row)
+		}
+		apis, err := service.GetUserAPIs(1)
+		if err != nil || len(apis) != 2 {
+			t.Fatalf("获取API配置失败: %v", err)
+		}
+		for _, api := range apis {
+			if !strings.HasPrefix(api.APIKey, "sk-") {
+				t.Errorf("读取时应返回明文: %+v", api)
+			}
+		}
+	})
+
+	t.Run("轮换主密钥", func(t *testing.T) {
+		useAPIKeyCipher(t, "v1", "v2")
+		rotating, _ := LLM_Chat.NewUserAPIService(db)
+		count, err := rotating.RotateAPIKeys()
+		if err != nil || count != 2 {
+			t.Fatalf("轮换结果错误: %d, %v", count, err)
+		}
+		if count, _ := rotating.RotateAPIKeys(); count != 0 {
+			t.Errorf("已使用当前主密钥的记录不应重复处理: %d", count)
+		}
+
+		// 移除旧主密钥后仍能解密
+		c, _ := LLM_Chat.NewAPIKeyCipher(map[string][]byte{"v2": testMasterKey(2)}, "v2")
+		LLM_Chat.GlobalAPIKeyCipher = c
+		rotated, _ := LLM_Chat.NewUserAPIService(db)
+		for id, want := range map[uint]string{created.ID: "sk-encrypted-1234", legacy.ID: "sk-legacy-5678"} {
+			api, err := rotated.GetAPIByID(id)
+			if err != nil || api.APIKey != want || api.KeyID != "v2" {
+				t.Errorf("轮换后读取错误: %+v, %v", api, err)
+			}
+		}
+	})
+
+	t.Run("接口只返回脱敏密钥", func(t *testing.T) {
+		for key, want := range map[string]string{
+			"":                               "",
+			"sk-encrypted-1234":              "****",
+			"sk-proj-abcdefghijklmnopqrabcd": "****abcd",
+		} {
+			if got := LLM_Chat.MaskAPIKey(key); got != want {
+				t.Errorf("MaskAPIKey(%q) = %q, 期望 %q", key, got, want)
+			}
+		}
+
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.GET("/apis", func(c *gin.Context) { c.Set("user_id", uint(1)) }, LLM_Chat_Route.GetUserAPIs)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/apis", nil))
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "sk-encrypted-1234") || !strings.Contains(w.Body.String(), `"api_key":"****"`) {
+			t.Errorf("列表应返回脱敏密钥: %d, %s", w.Code, w.Body.String())
+		}
+	})
+}
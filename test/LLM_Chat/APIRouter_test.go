@@ -0,0 +1,310 @@
+package LLM_Chat_Service
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+)
+
+// routingServer OpenAI 兼容服务，按请求次数决定返回错误状态码还是固定回复
+type routingServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	hits   int
+	bodies []string
+}
+
+// newRoutingServer fail 返回第 n 次请求（从 1 开始）的错误状态码和 Retry-After，状态码为 0 时正常回复
+func newRoutingServer(t *testing.T, reply string, delay time.Duration, fail func(n int) (int, string)) *routingServer {
+	s := &routingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.mu.Lock()
+		s.hits++
+		n := s.hits
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+
+		time.Sleep(delay)
+		if fail != nil {
+			if status, retryAfter := fail(n); status != 0 {
+				if retryAfter != "" {
+					w.Header().Set("Retry-After", retryAfter)
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				fmt.Fprintf(w, `{"error":{"message":"HTTP %d","type":"test"}}`, status)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, reply)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *routingServer) hitCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.hits
+}
+
+func (s *routingServer) lastBody() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bodies[len(s.bodies)-1]
+}
+
+// always 每次请求都返回 status
+func always(status int) func(int) (int, string) {
+	return func(int) (int, string) { return status, "" }
+}
+
+// TestModelRouting 测试多个 API 配置间的回退、重试、熔断和负载均衡
+func TestModelRouting(t *testing.T) {
+	manager, chatService, apiService := setupSessionManager(t)
+	const userID = uint(1)
+
+	createAPI := func(name, model, baseURL string) *database.UserAPI {
+		api, err := apiService.CreateAPI(userID, &database.UserAPI{APIName: name, APIKey: "sk-" + name, ModelName: model, BaseURL: baseURL})
+		if err != nil {
+			t.Fatalf("创建API配置失败: %v", err)
+		}
+		return api
+	}
+	send := func(sessionID, model, message string) (string, LLM_Chat.ServedBy, error) {
+		session, err := manager.GetOrCreateSession(userID, sessionID, model, "", "default")
+		if err != nil {
+			t.Fatalf("创建会话失败: %v", err)
+		}
+		if err := manager.SaveMessage(sessionID, "user", message, userID); err != nil {
+			t.Fatalf("保存用户消息失败: %v", err)
+		}
+		reply, err := session.SendMessage(message, manager.NewSendOptions(sessionID, userID, nil, false, nil))
+		if err != nil {
+			return "", LLM_Chat.ServedBy{}, err
+		}
+		servedBy := session.LastServedBy()
+		if err := manager.SaveReply(sessionID, reply, servedBy, session.LastUsage(), userID); err != nil {
+			t.Fatalf("保存回复失败: %v", err)
+		}
+		return reply, servedBy, nil
+	}
+
+	t.Run("同名配置失败时回退并记录实际配置", func(t *testing.T) {
+		failing := newRoutingServer(t, "", 0, always(http.StatusInternalServerError))
+		backup := newRoutingServer(t, "来自备用配置", 0, nil)
+		createAPI("primary", "fallback-model", failing.URL)
+		secondary := createAPI("secondary", "fallback-model", backup.URL)
+
+		reply, servedBy, err := send("route_fallback", "fallback-model", "第一个问题")
+		if err != nil || reply != "来自备用配置" {
+			t.Fatalf("应切换到备用配置: %q, %v", reply, err)
+		}
+		if servedBy.APIID != secondary.ID {
+			t.Errorf("实际配置应为 %d: %+v", secondary.ID, servedBy)
+		}
+		// 5xx 在同一配置上重试后才切换
+		if failing.hitCount() != 3 {
+			t.Errorf("首选配置应请求 3 次: %d", failing.hitCount())
+		}
+
+		messages, _, _, err := chatService.GetChatMessages("route_fallback", userID, 0, 10)
+		if err != nil || len(messages) != 2 {
+			t.Fatalf("获取消息失败: %d, %v", len(messages), err)
+		}
+		if reply := messages[1]; reply.APIID != secondary.ID || reply.ServedModel != "fallback-model" {
+			t.Errorf("回复应记录实际配置: %+v", reply)
+		}
+
+		// 一次请求的重试只计一次失败，不会熔断：第二次请求仍先尝试首选配置；切换后历史仍完整发送
+		if failing.hitCount() != 3 {
+			t.Errorf("首选配置应重试 2 次: %d", failing.hitCount())
+		}
+		if _, servedBy, err := send("route_fallback", "fallback-model", "第二个问题"); err != nil || servedBy.APIID != secondary.ID {
+			t.Fatalf("第二次请求失败: %+v, %v", servedBy, err)
+		}
+		if failing.hitCount() != 6 {
+			t.Errorf("一次请求失败后不应熔断: %d", failing.hitCount())
+		}
+		if body := backup.lastBody(); !strings.Contains(body, "第一个问题") || !strings.Contains(body, "来自备用配置") {
+			t.Errorf("请求应包含之前的对话: %s", body)
+		}
+
+		// 连续 3 次请求失败后熔断，不再请求首选配置
+		if _, _, err := send("route_fallback", "fallback-model", "第三个问题"); err != nil {
+			t.Fatalf("第三次请求失败: %v", err)
+		}
+		if _, servedBy, err := send("route_fallback", "fallback-model", "第四个问题"); err != nil || servedBy.APIID != secondary.ID {
+			t.Fatalf("第四次请求失败: %+v, %v", servedBy, err)
+		}
+		if failing.hitCount() != 9 {
+			t.Errorf("熔断后不应请求首选配置: %d", failing.hitCount())
+		}
+	})
+
+	t.Run("429 按 Retry-After 等待后重试", func(t *testing.T) {
+		limited := newRoutingServer(t, "限流后成功", 0, func(n int) (int, string) {
+			if n == 1 {
+				return http.StatusTooManyRequests, "1"
+			}
+			return 0, ""
+		})
+		api := createAPI("limited", "limited-model", limited.URL)
+
+		start := time.Now()
+		reply, servedBy, err := send("route_retry_after", "limited-model", "你好")
+		if err != nil || reply != "限流后成功" || servedBy.APIID != api.ID {
+			t.Fatalf("重试后应成功: %q, %+v, %v", reply, servedBy, err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Errorf("应等待 Retry-After 指定的时间: %v", elapsed)
+		}
+	})
+
+	t.Run("请求错误不切换配置", func(t *testing.T) {
+		invalid := newRoutingServer(t, "", 0, always(http.StatusBadRequest))
+		backup := newRoutingServer(t, "不应使用", 0, nil)
+		createAPI("invalid", "invalid-model", invalid.URL)
+		createAPI("invalid-backup", "invalid-model", backup.URL)
+
+		if _, _, err := send("route_bad_request", "invalid-model", "你好"); err == nil {
+			t.Fatal("400 应直接返回错误")
+		}
+		if invalid.hitCount() != 1 || backup.hitCount() != 0 {
+			t.Errorf("400 不应重试或切换: %d, %d", invalid.hitCount(), backup.hitCount())
+		}
+	})
+
+	t.Run("所有配置熔断", func(t *testing.T) {
+		down := newRoutingServer(t, "", 0, always(http.StatusServiceUnavailable))
+		createAPI("down", "down-model", down.URL)
+
+		// 每次请求重试用完计一次失败，连续 3 次请求失败后熔断
+		for i := 0; i < 3; i++ {
+			_, _, err := send("route_down", "down-model", "你好")
+			if err == nil {
+				t.Fatal("配置不可用时应返回错误")
+			}
+			if errors.Is(err, LLM_Chat.ErrAllAPIsUnavailable) {
+				t.Fatalf("第 %d 次请求前不应熔断", i+1)
+			}
+		}
+		if down.hitCount() != 9 {
+			t.Errorf("熔断前每次请求应重试 2 次: %d", down.hitCount())
+		}
+		if _, _, err := send("route_down", "down-model", "再试一次"); !errors.Is(err, LLM_Chat.ErrAllAPIsUnavailable) {
+			t.Errorf("熔断期间应返回 ErrAllAPIsUnavailable: %v", err)
+		}
+		if down.hitCount() != 9 {
+			t.Errorf("熔断期间不应请求上游: %d", down.hitCount())
+		}
+	})
+
+	t.Run("轮询", func(t *testing.T) {
+		first := newRoutingServer(t, "第一个", 0, nil)
+		second := newRoutingServer(t, "第二个", 0, nil)
+		a := createAPI("rr-a", "rr-a-model", first.URL)
+		b := createAPI("rr-b", "rr-b-model", second.URL)
+		if _, err := apiService.CreateModelRoute(userID, &database.ModelRoute{Name: "balanced", Policy: database.RoutePolicyRoundRobin, APIIDs: []uint{a.ID, b.ID}}); err != nil {
+			t.Fatalf("创建模型路由失败: %v", err)
+		}
+
+		var served []uint
+		for i := 0; i < 4; i++ {
+			_, servedBy, err := send("route_round_robin", "balanced", fmt.Sprintf("问题%d", i))
+			if err != nil {
+				t.Fatalf("请求失败: %v", err)
+			}
+			served = append(served, servedBy.APIID)
+		}
+		if want := []uint{a.ID, b.ID, a.ID, b.ID}; fmt.Sprint(served) != fmt.Sprint(want) {
+			t.Errorf("应轮流使用: %v, 期望 %v", served, want)
+		}
+		if first.hitCount() != 2 || second.hitCount() != 2 {
+			t.Errorf("请求应平均分配: %d, %d", first.hitCount(), second.hitCount())
+		}
+	})
+
+	t.Run("最低延迟", func(t *testing.T) {
+		slow := newRoutingServer(t, "慢", 100*time.Millisecond, nil)
+		fast := newRoutingServer(t, "快", 0, nil)
+		s := createAPI("slow", "slow-model", slow.URL)
+		f := createAPI("fast", "fast-model", fast.URL)
+		if _, err := apiService.CreateModelRoute(userID, &database.ModelRoute{Name: "quickest", Policy: database.RoutePolicyLeastLatency, APIIDs: []uint{s.ID, f.ID}}); err != nil {
+			t.Fatalf("创建模型路由失败: %v", err)
+		}
+
+		// 没有延迟数据的配置优先采样，之后固定使用较快的配置
+		for i := 0; i < 4; i++ {
+			if _, _, err := send("route_latency", "quickest", fmt.Sprintf("问题%d", i)); err != nil {
+				t.Fatalf("请求失败: %v", err)
+			}
+		}
+		if slow.hitCount() != 1 || fast.hitCount() != 3 {
+			t.Errorf("应优先使用延迟低的配置: 慢 %d, 快 %d", slow.hitCount(), fast.hitCount())
+		}
+	})
+}
+
+// TestModelRouteValidation 测试模型路由的校验和用户隔离
+func TestModelRouteValidation(t *testing.T) {
+	_, _, apiService := setupSessionManager(t)
+
+	mine, _ := apiService.CreateAPI(1, &database.UserAPI{APIName: "mine", APIKey: "sk-mine", ModelName: "gpt-4"})
+	theirs, _ := apiService.CreateAPI(2, &database.UserAPI{APIName: "theirs", APIKey: "sk-theirs", ModelName: "gpt-4"})
+
+	invalid := map[string]*database.ModelRoute{
+		"名称为空":     {Policy: database.RoutePolicyFallback, APIIDs: []uint{mine.ID}},
+		"不支持的策略":   {Name: "r", Policy: "random", APIIDs: []uint{mine.ID}},
+		"没有配置":     {Name: "r"},
+		"重复的配置":    {Name: "r", APIIDs: []uint{mine.ID, mine.ID}},
+		"其他用户的配置":  {Name: "r", APIIDs: []uint{mine.ID, theirs.ID}},
+		"不存在的配置ID": {Name: "r", APIIDs: []uint{9999}},
+	}
+	for name, route := range invalid {
+		if _, err := apiService.CreateModelRoute(1, route); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+
+	route, err := apiService.CreateModelRoute(1, &database.ModelRoute{Name: "smart", APIIDs: []uint{mine.ID}})
+	if err != nil || route.Policy != database.RoutePolicyFallback {
+		t.Fatalf("创建模型路由失败: %+v, %v", route, err)
+	}
+	if _, err := apiService.CreateModelRoute(1, &database.ModelRoute{Name: "smart", APIIDs: []uint{mine.ID}}); err == nil {
+		t.Error("同名路由应返回错误")
+	}
+
+	resolved, err := apiService.ResolveModelRoute(1, "smart")
+	if err != nil || len(resolved.APIs) != 1 || resolved.Primary().APIKey != "sk-mine" {
+		t.Fatalf("解析路由失败: %+v, %v", resolved, err)
+	}
+	if _, err := apiService.ResolveModelRoute(2, "smart"); err == nil {
+		t.Error("其他用户的路由不应被解析")
+	}
+
+	if _, err := apiService.UpdateModelRoute(2, route.ID, &database.ModelRoute{Name: "stolen", APIIDs: []uint{theirs.ID}}); !errors.Is(err, LLM_Chat.ErrModelRouteNotFound) {
+		t.Errorf("修改其他用户的路由应返回 ErrModelRouteNotFound: %v", err)
+	}
+	if err := apiService.DeleteModelRoute(2, route.ID); !errors.Is(err, LLM_Chat.ErrModelRouteNotFound) {
+		t.Errorf("删除其他用户的路由应返回 ErrModelRouteNotFound: %v", err)
+	}
+	updated, err := apiService.UpdateModelRoute(1, route.ID, &database.ModelRoute{Name: "smart", Policy: database.RoutePolicyRoundRobin, APIIDs: []uint{mine.ID}})
+	if err != nil || updated.Policy != database.RoutePolicyRoundRobin {
+		t.Errorf("修改模型路由失败: %+v, %v", updated, err)
+	}
+	if err := apiService.DeleteModelRoute(1, route.ID); err != nil {
+		t.Errorf("删除模型路由失败: %v", err)
+	}
+}
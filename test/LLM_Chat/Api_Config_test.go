@@ -1,11 +1,19 @@
 package LLM_Chat_Service
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
 	"platfrom/database"
 	"platfrom/service/LLM_Chat"
 
+	"github.com/gin-gonic/gin"
+
 	"github.com/glebarez/sqlite"
 	"gorm.io/gorm"
 )
@@ -18,7 +26,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 	}
 
 	// 自动迁移所有表
-	err = db.AutoMigrate(&database.UserAPI{})
+	err = db.AutoMigrate(&database.UserAPI{}, &database.ModelRoute{})
 	if err != nil {
 		t.Fatalf("数据库迁移失败: %v", err)
 	}
@@ -29,6 +37,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 // setupUserAPIService 创建用户API服务实例
 func setupUserAPIService(t *testing.T) (LLM_Chat.UserAPIServiceInterface, func()) {
 	db := setupTestDB(t)
+	useAPIKeyCipher(t)
 	service, err := LLM_Chat.NewUserAPIService(db)
 	if err != nil {
 		t.Fatalf("创建用户API服务失败: %v", err)
@@ -641,21 +650,94 @@ func TestGetFirstAvailableAPI(t *testing.T) {
 	}
 }
 
-// TestAPIConnection 测试API连接
+// TestAPIConnection 测试连通性检查：按提供商请求模型列表，记录状态、延迟和错误，并影响 GetFirstAvailableAPI
 func TestAPIConnection(t *testing.T) {
 	service, cleanup := setupUserAPIService(t)
 	defer cleanup()
 
-	t.Run("测试API连接（简单实现）", func(t *testing.T) {
-		success, err := service.TestAPIConnection()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/v1/models" && r.Header.Get("Authorization") == "Bearer sk-good":
+			fmt.Fprint(w, `{"data":[{"id":"gpt-4"}]}`)
+		case r.URL.Path == "/v1/models" && r.Header.Get("x-api-key") == "sk-ant" && r.Header.Get("anthropic-version") != "":
+			fmt.Fprint(w, `{"data":[]}`)
+		case r.URL.Path == "/api/tags":
+			fmt.Fprint(w, `{"models":[]}`)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "invalid api key")
+		}
+	}))
+	defer upstream.Close()
+
+	bad, _ := service.CreateAPI(1, &database.UserAPI{APIName: "bad", APIKey: "sk-bad", ModelName: "m1", BaseURL: upstream.URL + "/v1"})
+	good, _ := service.CreateAPI(1, &database.UserAPI{APIName: "good", APIKey: "sk-good", ModelName: "m2", BaseURL: upstream.URL + "/v1"})
+	anthropic, _ := service.CreateAPI(1, &database.UserAPI{APIName: "claude", APIKey: "sk-ant", ModelName: "m3", BaseURL: upstream.URL, Provider: database.ProviderAnthropic})
+	ollama, _ := service.CreateAPI(1, &database.UserAPI{APIName: "local", ModelName: "m4", BaseURL: upstream.URL, Provider: database.ProviderOllama})
+
+	t.Run("检查结果", func(t *testing.T) {
+		for _, api := range []*database.UserAPI{good, anthropic, ollama} {
+			checked, err := service.TestAPIConnection(context.Background(), api.ID)
+			if err != nil || checked.HealthStatus != database.APIHealthy || checked.HealthCheckedAt == nil || checked.HealthError != "" {
+				t.Errorf("%s 应检查通过: %+v, %v", api.APIName, checked, err)
+			}
+		}
 
-		if err != nil {
-			t.Errorf("TestAPIConnection() 意外返回错误: %v", err)
-			return
+		checked, err := service.TestAPIConnection(context.Background(), bad.ID)
+		if err != nil || checked.HealthStatus != database.APIUnhealthy || !strings.Contains(checked.HealthError, "invalid api key") {
+			t.Errorf("错误的密钥应检查失败: %+v, %v", checked, err)
+		}
+		stored, _ := service.GetAPIByID(bad.ID)
+		if stored.HealthStatus != database.APIUnhealthy || stored.HealthError == "" {
+			t.Errorf("检查结果应保存: %+v", stored)
+		}
+
+		if _, err := service.TestAPIConnection(context.Background(), 999); err == nil {
+			t.Error("不存在的配置应返回错误")
+		}
+	})
+
+	t.Run("优先返回可用的配置", func(t *testing.T) {
+		first, err := service.GetFirstAvailableAPI(1)
+		if err != nil || first.ID != good.ID {
+			t.Errorf("应跳过检查失败的配置: %+v, %v", first, err)
+		}
+	})
+
+	t.Run("修改密钥后重置检查结果", func(t *testing.T) {
+		if err := service.UpdateAPI(bad.ID, map[string]interface{}{"api_key": "sk-good"}); err != nil {
+			t.Fatalf("更新失败: %v", err)
+		}
+		stored, _ := service.GetAPIByID(bad.ID)
+		if stored.HealthStatus != "" {
+			t.Errorf("检查结果应被重置: %+v", stored)
+		}
+
+		count, err := service.CheckAllAPIs(context.Background())
+		if err != nil || count != 4 {
+			t.Errorf("应检查全部配置: %d, %v", count, err)
+		}
+		if stored, _ := service.GetAPIByID(bad.ID); stored.HealthStatus != database.APIHealthy {
+			t.Errorf("新密钥应检查通过: %+v", stored)
+		}
+	})
+
+	t.Run("接口", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.POST("/apis/:id/test", func(c *gin.Context) { c.Set("user_id", uint(1)) }, LLM_Chat_Route.TestUserAPI)
+		router.POST("/other/apis/:id/test", func(c *gin.Context) { c.Set("user_id", uint(2)) }, LLM_Chat_Route.TestUserAPI)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/apis/%d/test", good.ID), nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"healthy":true`) || strings.Contains(w.Body.String(), "sk-good") {
+			t.Errorf("检查接口返回错误: %d, %s", w.Code, w.Body.String())
 		}
 
-		if !success {
-			t.Error("TestAPIConnection() 应该返回 true")
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/other/apis/%d/test", good.ID), nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("不能检查其他用户的配置: %d", w.Code)
 		}
 	})
 }
@@ -0,0 +1,273 @@
+package LLM_Chat_Service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/gin-gonic/gin"
+)
+
+// pathContents 返回会话当前分支上各消息的内容
+func pathContents(t *testing.T, service LLM_Chat.ChatServiceInterface, sessionID string, userID uint) []string {
+	messages, _, _, err := service.GetChatMessages(sessionID, userID, 0, 100)
+	if err != nil {
+		t.Fatalf("获取消息失败: %v", err)
+	}
+	var contents []string
+	for _, msg := range messages {
+		contents = append(contents, msg.Content)
+	}
+	return contents
+}
+
+// lastMessageID 返回当前分支最后一条消息的ID
+func lastMessageID(t *testing.T, service LLM_Chat.ChatServiceInterface, sessionID string) uint {
+	messages, _, _, _ := service.GetChatMessages(sessionID, 1, 0, 100)
+	if len(messages) == 0 {
+		t.Fatal("当前分支没有消息")
+	}
+	return messages[len(messages)-1].ID
+}
+
+// TestMessageBranching 测试编辑、重新生成产生兄弟分支，以及分支切换
+func TestMessageBranching(t *testing.T) {
+	service, cleanup := setupChatService(t)
+	defer cleanup()
+
+	const sessionID = "session_branch"
+	_, _ = service.CreateChatSession(sessionID, "gpt-4", 1)
+	_ = service.SaveChatMessage(sessionID, "user", "问题1", 1)
+	_ = service.SaveChatMessage(sessionID, "assistant", "回答1", 1)
+	_ = service.SaveChatMessage(sessionID, "user", "问题2", 1)
+	question2 := lastMessageID(t, service, sessionID)
+	_ = service.SaveChatMessage(sessionID, "assistant", "回答2", 1)
+	answer2 := lastMessageID(t, service, sessionID)
+
+	// 编辑问题2：新分支只包含编辑后的问题，原分支保留
+	edited, err := service.EditUserMessage(sessionID, 1, question2, "问题2（修改）")
+	if err != nil {
+		t.Fatalf("编辑消息失败: %v", err)
+	}
+	if got := fmt.Sprint(pathContents(t, service, sessionID, 1)); got != "[问题1 回答1 问题2（修改）]" {
+		t.Errorf("编辑后应切换到新分支: %s", got)
+	}
+	_ = service.SaveChatMessage(sessionID, "assistant", "回答2（新）", 1)
+
+	siblings, err := service.GetSiblings(sessionID, 1, question2)
+	if err != nil || len(siblings) != 2 || siblings[0].ID != question2 || siblings[1].ID != edited.ID {
+		t.Fatalf("编辑后的消息应与原消息互为兄弟: %+v, %v", siblings, err)
+	}
+
+	// 切回原分支，沿最新的子消息走到叶子
+	leaf, err := service.SwitchBranch(sessionID, 1, question2)
+	if err != nil || leaf != answer2 {
+		t.Fatalf("切换分支失败: leaf=%d, err=%v", leaf, err)
+	}
+	if got := fmt.Sprint(pathContents(t, service, sessionID, 1)); got != "[问题1 回答1 问题2 回答2]" {
+		t.Errorf("应切换回原分支: %s", got)
+	}
+
+	// 重新生成回答2：新回答与原回答互为兄弟
+	question, err := service.BranchForRegenerate(sessionID, 1, answer2)
+	if err != nil || question.ID != question2 {
+		t.Fatalf("准备重新生成失败: %+v, %v", question, err)
+	}
+	_ = service.SaveChatMessage(sessionID, "assistant", "回答2（重新生成）", 1)
+	if siblings, _ := service.GetSiblings(sessionID, 1, answer2); len(siblings) != 2 {
+		t.Errorf("重新生成的回答应与原回答互为兄弟: %d", len(siblings))
+	}
+	history, _ := service.GetRecentChatMessages(sessionID, 1, 10)
+	if len(history) != 4 || history[3].Content != "回答2（重新生成）" {
+		t.Errorf("发送给模型的历史应只包含当前分支: %+v", history)
+	}
+
+	// 其他分支上的摘要不适用于当前分支
+	editedPath, _, _, _ := service.GetChatMessages(sessionID, 1, 0, 100)
+	_, _ = service.SwitchBranch(sessionID, 1, edited.ID)
+	editedLeaf := lastMessageID(t, service, sessionID)
+	_ = service.SaveSummary(sessionID, 1, "修改后分支的摘要", editedLeaf)
+	if summary, _ := service.GetLatestSummary(sessionID, 1); summary == nil {
+		t.Error("当前分支的摘要应生效")
+	}
+	_, _ = service.SwitchBranch(sessionID, 1, editedPath[3].ID)
+	if summary, _ := service.GetLatestSummary(sessionID, 1); summary != nil {
+		t.Errorf("其他分支的摘要不应生效: %+v", summary)
+	}
+
+	// 错误情况
+	if _, err := service.EditUserMessage(sessionID, 1, answer2, "x"); err == nil {
+		t.Error("不应允许编辑模型回复")
+	}
+	if _, err := service.BranchForRegenerate(sessionID, 1, question2); err == nil {
+		t.Error("不应允许重新生成用户消息")
+	}
+	if _, err := service.EditUserMessage(sessionID, 2, question2, "x"); !errors.Is(err, LLM_Chat.ErrSessionNotFound) {
+		t.Errorf("其他用户不能编辑: %v", err)
+	}
+	if _, err := service.SwitchBranch(sessionID, 1, 9999); !errors.Is(err, LLM_Chat.ErrMessageNotFound) {
+		t.Errorf("不存在的消息应返回 ErrMessageNotFound: %v", err)
+	}
+}
+
+// TestBranchRoutes 测试编辑和重新生成接口按新分支重建历史后请求模型
+func TestBranchRoutes(t *testing.T) {
+	server := newStreamLLMServer(t, "新的", "回答")
+	manager, chatService, apiService := setupSessionManager(t)
+
+	const sessionID = "session_branch_route"
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: server.URL}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+	_, _ = chatService.CreateChatSession(sessionID, "gpt-4", 1)
+	_ = chatService.SaveChatMessage(sessionID, "user", "问题1", 1)
+	_ = chatService.SaveChatMessage(sessionID, "assistant", "回答1", 1)
+	_ = chatService.SaveChatMessage(sessionID, "user", "问题2", 1)
+	question2 := lastMessageID(t, chatService, sessionID)
+	_ = chatService.SaveChatMessage(sessionID, "assistant", "回答2", 1)
+	answer2 := lastMessageID(t, chatService, sessionID)
+
+	// 内存中已有按原分支加载的会话，编辑后必须重建
+	if _, err := manager.GetOrCreateSession(1, sessionID, "gpt-4", "", ""); err != nil {
+		t.Fatalf("加载会话失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chat := router.Group("/api/chat", func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	chat.POST("/sessions/:session_id/messages/:message_id/edit", LLM_Chat_Route.EditMessage)
+	chat.POST("/sessions/:session_id/messages/:message_id/regenerate", LLM_Chat_Route.RegenerateMessage)
+	chat.GET("/sessions/:session_id/messages/:message_id/siblings", LLM_Chat_Route.GetMessageSiblings)
+	chat.PUT("/sessions/:session_id/branch", LLM_Chat_Route.SwitchBranch)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	sentContents := func() []string {
+		var contents []string
+		for _, msg := range server.lastRequest().Messages {
+			if msg.Role != "system" {
+				contents = append(contents, msg.Content)
+			}
+		}
+		return contents
+	}
+
+	w := do(http.MethodPost, fmt.Sprintf("/api/chat/sessions/%s/messages/%d/edit", sessionID, question2), `{"message":"问题2（修改）"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("编辑消息失败: %d, %s", w.Code, w.Body.String())
+	}
+	if got := fmt.Sprint(sentContents()); got != "[问题1 回答1 问题2（修改）]" {
+		t.Errorf("发送给模型的应是新分支的历史: %s", got)
+	}
+	if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题1 回答1 问题2（修改） 新的回答]" {
+		t.Errorf("回复应保存在新分支上: %s", got)
+	}
+
+	w = do(http.MethodGet, fmt.Sprintf("/api/chat/sessions/%s/messages/%d/siblings", sessionID, question2), "")
+	var siblings struct {
+		Data  []LLM_Chat_Route.MessageWithID `json:"data"`
+		Index int                            `json:"index"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &siblings)
+	if w.Code != http.StatusOK || len(siblings.Data) != 2 || siblings.Index != 0 {
+		t.Errorf("兄弟消息列表错误: %d, %s", w.Code, w.Body.String())
+	}
+
+	// 切回原分支后重新生成回答2
+	w = do(http.MethodPut, fmt.Sprintf("/api/chat/sessions/%s/branch", sessionID), fmt.Sprintf(`{"message_id":%d}`, question2))
+	if w.Code != http.StatusOK {
+		t.Fatalf("切换分支失败: %d, %s", w.Code, w.Body.String())
+	}
+	w = do(http.MethodPost, fmt.Sprintf("/api/chat/sessions/%s/messages/%d/regenerate", sessionID, answer2), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("重新生成失败: %d, %s", w.Code, w.Body.String())
+	}
+	if got := fmt.Sprint(sentContents()); got != "[问题1 回答1 问题2]" {
+		t.Errorf("重新生成时不应包含原回答: %s", got)
+	}
+	if siblings, _ := chatService.GetSiblings(sessionID, 1, answer2); len(siblings) != 2 {
+		t.Errorf("重新生成的回答应与原回答互为兄弟: %d", len(siblings))
+	}
+
+	if w := do(http.MethodPost, fmt.Sprintf("/api/chat/sessions/%s/messages/%d/edit", sessionID, answer2), `{"message":"x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("编辑模型回复应返回 400: %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/api/chat/sessions/other/messages/1/regenerate", ""); w.Code != http.StatusNotFound {
+		t.Errorf("不存在的会话应返回 404: %d", w.Code)
+	}
+}
+
+// TestBranchRoutesDuringGeneration 测试生成进行时不能移动当前分支，以及重新生成失败时保留原回复
+func TestBranchRoutesDuringGeneration(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `{"error":{"message":"bad request"}}`)
+	}))
+	t.Cleanup(failing.Close)
+	_, chatService, apiService := setupSessionManager(t)
+
+	const sessionID = "session_branch_busy"
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: failing.URL}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+	_, _ = chatService.CreateChatSession(sessionID, "gpt-4", 1)
+	_ = chatService.SaveChatMessage(sessionID, "user", "问题", 1)
+	question := lastMessageID(t, chatService, sessionID)
+	_ = chatService.SaveChatMessage(sessionID, "assistant", "回答", 1)
+	answer := lastMessageID(t, chatService, sessionID)
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chat := router.Group("/api/chat", func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	chat.POST("/sessions/:session_id/messages/:message_id/edit", LLM_Chat_Route.EditMessage)
+	chat.POST("/sessions/:session_id/messages/:message_id/regenerate", LLM_Chat_Route.RegenerateMessage)
+	chat.PUT("/sessions/:session_id/branch", LLM_Chat_Route.SwitchBranch)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	_, generation, err := LLM_Chat.GlobalGenerationRegistry.Start(context.Background(), sessionID, 1)
+	if err != nil {
+		t.Fatalf("登记生成失败: %v", err)
+	}
+	requests := map[string][3]string{
+		"编辑":   {http.MethodPost, fmt.Sprintf("/api/chat/sessions/%s/messages/%d/edit", sessionID, question), `{"message":"改"}`},
+		"重新生成": {http.MethodPost, fmt.Sprintf("/api/chat/sessions/%s/messages/%d/regenerate", sessionID, answer), ""},
+		"切换分支": {http.MethodPut, fmt.Sprintf("/api/chat/sessions/%s/branch", sessionID), fmt.Sprintf(`{"message_id":%d}`, question)},
+	}
+	for name, request := range requests {
+		if w := do(request[0], request[1], request[2]); w.Code != http.StatusConflict {
+			t.Errorf("%s: 生成进行时应返回 409: %d, %s", name, w.Code, w.Body.String())
+		}
+	}
+	LLM_Chat.GlobalGenerationRegistry.Finish(generation)
+	if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 回答]" {
+		t.Fatalf("生成进行时当前分支不应被移动: %s", got)
+	}
+
+	// 上游返回错误：重新生成失败后仍显示原回复
+	w := do(http.MethodPost, fmt.Sprintf("/api/chat/sessions/%s/messages/%d/regenerate", sessionID, answer), "")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("上游错误时重新生成应失败: %d, %s", w.Code, w.Body.String())
+	}
+	if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 回答]" {
+		t.Errorf("重新生成失败时应恢复原回复: %s", got)
+	}
+}
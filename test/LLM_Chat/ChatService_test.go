@@ -1,6 +1,7 @@
 package LLM_Chat_Service
 
 import (
+	"errors"
 	"testing"
 	"time"
 
@@ -8,6 +9,7 @@ import (
 	"platfrom/service/LLM_Chat"
 
 	"github.com/glebarez/sqlite"
+	"github.com/sashabaranov/go-openai"
 	"gorm.io/gorm"
 )
 
@@ -53,25 +55,21 @@ func TestRootGetAllSessions(t *testing.T) {
 		sessionID string
 		modelName string
 		userID    uint
-		title     string
 	}{
 		{
 			sessionID: "session_001",
 			modelName: "gpt-3.5-turbo",
 			userID:    1,
-			title:     "测试会话1",
 		},
 		{
 			sessionID: "session_002",
 			modelName: "gpt-4",
 			userID:    2,
-			title:     "测试会话2",
 		},
 		{
 			sessionID: "session_003",
 			modelName: "claude-2",
 			userID:    3,
-			title:     "测试会话3",
 		},
 	}
 
@@ -81,11 +79,6 @@ func TestRootGetAllSessions(t *testing.T) {
 		if err != nil {
 			t.Fatalf("创建测试会话失败: %v", err)
 		}
-		// 更新标题
-		err = service.UpdateSessionTitle(ts.sessionID, ts.title)
-		if err != nil {
-			t.Fatalf("更新会话标题失败: %v", err)
-		}
 		// 保存一些消息
 		err = service.SaveChatMessage(ts.sessionID, "user", "你好，我是用户", ts.userID)
 		if err != nil {
@@ -289,3 +282,142 @@ func TestRootDeleteSession(t *testing.T) {
 		})
 	}
 }
+
+// TestUpdateSessionGenerationParams 测试会话级生成参数的持久化
+func TestUpdateSessionGenerationParams(t *testing.T) {
+	service, cleanup := setupChatService(t)
+	defer cleanup()
+
+	if _, err := service.CreateChatSession("session_params", "gpt-4", 1); err != nil {
+		t.Fatalf("创建测试会话失败: %v", err)
+	}
+
+	temperature := float32(0.3)
+	seed := 7
+	params := database.GenerationParams{
+		Temperature: &temperature,
+		Stop:        []string{"###", "END"},
+		Seed:        &seed,
+	}
+
+	if _, err := service.UpdateSessionGenerationParams("session_params", 1, params); err != nil {
+		t.Fatalf("UpdateSessionGenerationParams() 意外返回错误: %v", err)
+	}
+
+	session, err := service.GetChatSession("session_params", 1)
+	if err != nil {
+		t.Fatalf("获取会话失败: %v", err)
+	}
+	got := session.GenerationParams
+	if got.Temperature == nil || *got.Temperature != temperature {
+		t.Errorf("temperature 未持久化: 得到 %v", got.Temperature)
+	}
+	if len(got.Stop) != 2 || got.Stop[1] != "END" {
+		t.Errorf("stop 未持久化: 得到 %v", got.Stop)
+	}
+	if got.TopP != nil {
+		t.Errorf("未设置的 top_p 应为 nil: 得到 %v", *got.TopP)
+	}
+
+	// 整体替换：清空参数
+	if _, err := service.UpdateSessionGenerationParams("session_params", 1, database.GenerationParams{}); err != nil {
+		t.Fatalf("清空生成参数失败: %v", err)
+	}
+	session, _ = service.GetChatSession("session_params", 1)
+	if session.GenerationParams.Temperature != nil || len(session.GenerationParams.Stop) != 0 {
+		t.Errorf("生成参数应被清空: 得到 %+v", session.GenerationParams)
+	}
+
+	// 其他用户不能修改
+	if _, err := service.UpdateSessionGenerationParams("session_params", 2, params); err == nil {
+		t.Error("其他用户修改会话参数应返回错误")
+	}
+}
+
+// TestUpdateSessionGenerationParamsKeepsOtherColumns 测试更新生成参数不覆盖同时进行的生成写入的字段
+func TestUpdateSessionGenerationParamsKeepsOtherColumns(t *testing.T) {
+	db := setupChatTestDB(t)
+	// 内存数据库每个连接各自独立，回调中的更新需要和服务共用一个连接
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	service, err := LLM_Chat.NewChatService(db)
+	if err != nil {
+		t.Fatalf("创建聊天服务失败: %v", err)
+	}
+	if _, err := service.CreateChatSession("session_overlap", "gpt-4", 1); err != nil {
+		t.Fatalf("创建测试会话失败: %v", err)
+	}
+
+	// 在读取会话之后、写入参数之前，模拟生成保存回复并移动当前分支
+	const callback = "test:append_during_update"
+	appended := false
+	err = db.Callback().Query().After("gorm:query").Register(callback, func(tx *gorm.DB) {
+		if appended || tx.Statement.Table != "chat_sessions" {
+			return
+		}
+		appended = true
+		tx.Session(&gorm.Session{NewDB: true}).Model(&database.ChatSession{}).
+			Where("session_id = ?", "session_overlap").
+			Updates(map[string]interface{}{"message_count": 2, "active_leaf_id": 42, "title": "新标题"})
+	})
+	if err != nil {
+		t.Fatalf("注册回调失败: %v", err)
+	}
+	temperature := float32(0.5)
+	updated, err := service.UpdateSessionGenerationParams("session_overlap", 1, database.GenerationParams{Temperature: &temperature})
+	db.Callback().Query().Remove(callback)
+	if err != nil {
+		t.Fatalf("UpdateSessionGenerationParams() 意外返回错误: %v", err)
+	}
+
+	session, _ := service.GetChatSession("session_overlap", 1)
+	if session.MessageCount != 2 || session.ActiveLeafID != 42 || session.Title != "新标题" {
+		t.Errorf("更新生成参数不应覆盖其他字段: %+v", session)
+	}
+	if session.GenerationParams.Temperature == nil || *session.GenerationParams.Temperature != temperature {
+		t.Errorf("temperature 未持久化: %+v", session.GenerationParams)
+	}
+	if updated.ActiveLeafID != 42 {
+		t.Errorf("应返回更新后的会话: %+v", updated)
+	}
+}
+
+// TestSessionContextOwnerCheck 测试读写会话上下文、摘要的方法只对会话所有者可用
+func TestSessionContextOwnerCheck(t *testing.T) {
+	service, cleanup := setupChatService(t)
+	defer cleanup()
+
+	if _, err := service.CreateChatSession("session_owner", "gpt-4", 1); err != nil {
+		t.Fatalf("创建测试会话失败: %v", err)
+	}
+	_ = service.SaveChatMessage("session_owner", "user", "你好", 1)
+
+	calls := map[string]func(userID uint) error{
+		"SaveToolMessage": func(userID uint) error {
+			return service.SaveToolMessage("session_owner", userID, openai.ChatCompletionMessage{Role: "tool", ToolCallID: "call_1", Content: "[]"})
+		},
+		"GetRecentChatMessages": func(userID uint) error {
+			_, err := service.GetRecentChatMessages("session_owner", userID, 10)
+			return err
+		},
+		"GetLatestSummary": func(userID uint) error {
+			_, err := service.GetLatestSummary("session_owner", userID)
+			return err
+		},
+		"GetMessagesAfter": func(userID uint) error {
+			_, err := service.GetMessagesAfter("session_owner", userID, 0)
+			return err
+		},
+		"SaveSummary": func(userID uint) error {
+			return service.SaveSummary("session_owner", userID, "摘要", 1)
+		},
+	}
+	for name, call := range calls {
+		if err := call(2); !errors.Is(err, LLM_Chat.ErrSessionNotFound) {
+			t.Errorf("%s: 其他用户应返回 ErrSessionNotFound: %v", name, err)
+		}
+		if err := call(1); err != nil {
+			t.Errorf("%s: 会话所有者调用失败: %v", name, err)
+		}
+	}
+}
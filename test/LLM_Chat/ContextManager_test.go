@@ -0,0 +1,305 @@
+package LLM_Chat_Service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// TestHeuristicTokenizer 测试启发式分词器的估算
+func TestHeuristicTokenizer(t *testing.T) {
+	tokenizer := &LLM_Chat.HeuristicTokenizer{}
+
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"你好世界", 4},
+		{"hello world!", 3},
+		{"你好 abcd", 4},
+	}
+	for _, tt := range tests {
+		if got := tokenizer.CountTokens(tt.text); got != tt.want {
+			t.Errorf("CountTokens(%q) = %d, 期望 %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestNewTokenizer 测试按模型系列选择分词器
+func TestNewTokenizer(t *testing.T) {
+	// 随机的 base64 内容：启发式按 4 字符 1 token 估算会明显偏少
+	base64Text := "iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAYAAAAfFcSJAAAADUlEQVR42mNk+M9QDwADhgGAWjR9awAAAABJRU5ErkJggg=="
+	heuristic := (&LLM_Chat.HeuristicTokenizer{}).CountTokens(base64Text)
+
+	tests := []struct {
+		model    string
+		wantType interface{}
+	}{
+		{"gpt-4o-mini", &LLM_Chat.BPETokenizer{}},
+		{"gpt-4", &LLM_Chat.BPETokenizer{}},
+		{"claude-3-5-sonnet-latest", LLM_Chat.MaxTokenizer{}},
+		{"deepseek/deepseek-chat", LLM_Chat.MaxTokenizer{}},
+		{"unknown-model", &LLM_Chat.HeuristicTokenizer{}},
+	}
+	for _, tt := range tests {
+		tokenizer := LLM_Chat.NewTokenizer(tt.model)
+		if fmt.Sprintf("%T", tokenizer) != fmt.Sprintf("%T", tt.wantType) {
+			t.Errorf("NewTokenizer(%q) = %T, 期望 %T", tt.model, tokenizer, tt.wantType)
+			continue
+		}
+		if _, isHeuristic := tokenizer.(*LLM_Chat.HeuristicTokenizer); !isHeuristic {
+			if got := tokenizer.CountTokens(base64Text); got <= heuristic {
+				t.Errorf("%s: base64 内容应按词表计数: %d <= 启发式 %d", tt.model, got, heuristic)
+			}
+		}
+	}
+
+	gpt4o, gpt4 := LLM_Chat.NewTokenizer("gpt-4o"), LLM_Chat.NewTokenizer("gpt-4")
+	if got := gpt4o.CountTokens("hello world"); got != 2 {
+		t.Errorf("o200k: CountTokens(hello world) = %d, 期望 2", got)
+	}
+	if got := gpt4.CountTokens("<|endoftext|>"); got == 0 {
+		t.Error("特殊标记应按普通文本计数")
+	}
+
+	// 超长文本分段计数，只会在分段边界多算少量 token
+	long := strings.Repeat("func main() { fmt.Println(\"hello\") }\n", 500) + strings.Repeat("长", 5000)
+	whole, parts := gpt4.CountTokens(long), 0
+	for _, line := range strings.SplitAfter(long, "\n") {
+		parts += gpt4.CountTokens(line)
+	}
+	if whole < parts-10 || whole > parts+10 {
+		t.Errorf("分段计数偏差过大: %d, 逐行 %d", whole, parts)
+	}
+}
+
+// TestModelContextLength 测试模型上下文长度查询
+func TestModelContextLength(t *testing.T) {
+	tests := []struct {
+		model string
+		want  int
+	}{
+		{"gpt-4o-mini", 128000},
+		{"gpt-4", 8192},
+		{"claude-3-5-sonnet-latest", 200000},
+		{"deepseek/deepseek-chat", 65536},
+		{"llama3.1:8b", 131072},
+		{"unknown-model", 8192},
+	}
+	for _, tt := range tests {
+		if got := LLM_Chat.ModelContextLength(tt.model); got != tt.want {
+			t.Errorf("ModelContextLength(%q) = %d, 期望 %d", tt.model, got, tt.want)
+		}
+	}
+}
+
+// buildHistory 构造 n 轮对话，每条消息约 tokensPerMsg 个 token
+func buildHistory(rounds, tokensPerMsg int) []openai.ChatCompletionMessage {
+	var history []openai.ChatCompletionMessage
+	for i := 0; i < rounds; i++ {
+		history = append(history,
+			openai.ChatCompletionMessage{Role: "user", Content: strings.Repeat("问", tokensPerMsg)},
+			openai.ChatCompletionMessage{Role: "assistant", Content: strings.Repeat("答", tokensPerMsg)},
+		)
+	}
+	return append(history, openai.ChatCompletionMessage{Role: "user", Content: "最新的问题"})
+}
+
+// TestBuildContext 测试按 token 预算构造上下文
+func TestBuildContext(t *testing.T) {
+	t.Run("短对话全部保留", func(t *testing.T) {
+		cm := LLM_Chat.NewContextManager("gpt-4o", 0)
+		history := buildHistory(30, 10)
+
+		result := cm.BuildContext("系统提示", history, 0)
+		if len(result) != len(history)+1 {
+			t.Errorf("短对话不应被截断: 得到 %d 条, 期望 %d 条", len(result), len(history)+1)
+		}
+		if result[0].Role != "system" || result[0].Content != "系统提示" {
+			t.Errorf("第一条应为系统提示词: 得到 %+v", result[0])
+		}
+	})
+
+	t.Run("超出预算时保留最近的历史", func(t *testing.T) {
+		// 预算 2000，回复预留 500，每条历史约 104 token
+		cm := LLM_Chat.NewContextManager("gpt-4o", 2000)
+		history := buildHistory(50, 100)
+
+		result := cm.BuildContext("系统提示", history, 500)
+		total := 0
+		for _, msg := range result {
+			total += cm.CountMessageTokens(msg)
+		}
+		if total > 1500 {
+			t.Errorf("上下文超出预算: %d > 1500", total)
+		}
+		if result[0].Role != "system" {
+			t.Error("系统提示词必须保留")
+		}
+		if last := result[len(result)-1]; last.Content != "最新的问题" {
+			t.Errorf("最后一条用户消息必须保留: 得到 %s", last.Content)
+		}
+		if result[1].Role != "user" {
+			t.Errorf("历史不应从 assistant 回复开始: 得到 %s", result[1].Role)
+		}
+		if len(result) < 10 {
+			t.Errorf("应尽量填满预算: 只保留了 %d 条", len(result))
+		}
+	})
+
+	t.Run("单条超长消息被截断", func(t *testing.T) {
+		cm := LLM_Chat.NewContextManager("gpt-4", 0)
+		history := []openai.ChatCompletionMessage{
+			{Role: "user", Content: strings.Repeat("长", 20000)},
+		}
+
+		result := cm.BuildContext("系统提示", history, 1000)
+		if len(result) != 2 {
+			t.Fatalf("应包含系统提示词和用户消息: 得到 %d 条", len(result))
+		}
+		total := cm.CountMessageTokens(result[0]) + cm.CountMessageTokens(result[1])
+		if total > 8192-1000 {
+			t.Errorf("截断后仍超出预算: %d", total)
+		}
+		if history[0].Content != strings.Repeat("长", 20000) {
+			t.Error("截断不应修改原始历史")
+		}
+	})
+}
+
+// TestBuildContextKeepsToolCalls 测试工具调用循环中的上下文：本轮的工具调用和工具结果不被拆开
+func TestBuildContextKeepsToolCalls(t *testing.T) {
+	toolCall := func(id string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: "assistant", ToolCalls: []openai.ToolCall{{
+			ID: id, Type: openai.ToolTypeFunction,
+			Function: openai.FunctionCall{Name: "read_file", Arguments: `{"file_id":1}`},
+		}}}
+	}
+	toolResult := func(id string, tokens int) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: "tool", ToolCallID: id, Content: strings.Repeat("文", tokens)}
+	}
+
+	history := buildHistory(20, 100)
+	history = append(history,
+		toolCall("call_1"), toolResult("call_1", 300),
+		toolCall("call_2"), toolResult("call_2", 3000), toolResult("call_2", 100),
+	)
+
+	// 预算 2000，回复预留 500
+	cm := LLM_Chat.NewContextManager("gpt-4o", 2000)
+	result := cm.BuildContext("系统提示", history, 500)
+
+	total := 0
+	for _, msg := range result {
+		total += cm.CountMessageTokens(msg)
+	}
+	if total > 1500 {
+		t.Errorf("上下文超出预算: %d > 1500", total)
+	}
+
+	// 从最后一条用户消息开始的本轮消息全部保留
+	turn := history[len(history)-6:]
+	if len(result) < len(turn)+1 {
+		t.Fatalf("本轮消息应全部保留: 得到 %d 条", len(result))
+	}
+	got := result[len(result)-len(turn):]
+	for i := range turn {
+		if got[i].Role != turn[i].Role || got[i].ToolCallID != turn[i].ToolCallID || len(got[i].ToolCalls) != len(turn[i].ToolCalls) {
+			t.Fatalf("本轮第 %d 条消息不匹配: 得到 %+v", i, got[i])
+		}
+	}
+	if got[0].Content != "最新的问题" || got[2].Content != turn[2].Content || got[5].Content != turn[5].Content {
+		t.Error("较短的消息不应被截断")
+	}
+	if got[4].Content == turn[4].Content || got[4].Content == "" {
+		t.Error("超长的工具结果应被截断而不是丢弃")
+	}
+	if history[len(history)-2].Content != strings.Repeat("文", 3000) {
+		t.Error("截断不应修改原始历史")
+	}
+
+	// 更早的历史中，工具调用和它的结果不会被拆开
+	for i, msg := range result {
+		if msg.Role == "tool" && (i == 0 || (result[i-1].Role != "tool" && len(result[i-1].ToolCalls) == 0)) {
+			t.Errorf("第 %d 条工具结果前没有对应的工具调用", i)
+		}
+	}
+}
+
+// TestBuildContextDropsToolBlocksWhole 测试裁剪更早的历史时，工具调用块整体保留或丢弃
+func TestBuildContextDropsToolBlocksWhole(t *testing.T) {
+	history := []openai.ChatCompletionMessage{
+		{Role: "user", Content: strings.Repeat("问", 100)},
+		{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "call_1", Type: openai.ToolTypeFunction, Function: openai.FunctionCall{Name: "search_notes", Arguments: "{}"}}}},
+		{Role: "tool", ToolCallID: "call_1", Content: strings.Repeat("大", 1600)},
+		{Role: "tool", ToolCallID: "call_1", Content: "小"},
+		{Role: "assistant", Content: "答"},
+		{Role: "user", Content: "最新的问题"},
+	}
+
+	// 预算 2000，回复预留 500：工具结果块放不下，不能只保留其中较短的那条
+	cm := LLM_Chat.NewContextManager("gpt-4o", 2000)
+	result := cm.BuildContext("系统提示", history, 500)
+	for _, msg := range result {
+		if msg.Role == "tool" {
+			t.Fatalf("放不下的工具调用块应整体丢弃: %+v", result)
+		}
+	}
+	if last := result[len(result)-1]; last.Content != "最新的问题" {
+		t.Errorf("最后一条用户消息必须保留: 得到 %s", last.Content)
+	}
+}
+
+// TestSessionSendsSystemPromptAndBudgetedHistory 测试会话发送系统提示词并按预算裁剪历史
+func TestSessionSendsSystemPromptAndBudgetedHistory(t *testing.T) {
+	var got openai.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	history := buildHistory(40, 100)
+	history = history[:len(history)-1]
+
+	creator := &LLM_Chat.DefaultSessionCreator{}
+	session, err := creator.CreateSessionFromHistory(LLM_Chat.ProviderConfig{
+		Provider:      database.ProviderOpenAI,
+		APIKey:        "sk-test",
+		BaseURL:       server.URL,
+		ModelName:     "gpt-4o",
+		ContextBudget: 3000,
+	}, "你是一个测试助手", history)
+	if err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+
+	if _, err := session.SendMessage("你好", LLM_Chat.SendOptions{}); err != nil {
+		t.Fatalf("SendMessage() 意外返回错误: %v", err)
+	}
+
+	if len(got.Messages) == 0 || got.Messages[0].Role != "system" || got.Messages[0].Content != "你是一个测试助手" {
+		t.Fatalf("第一条消息应为系统提示词: 得到 %+v", got.Messages)
+	}
+	if len(got.Messages) >= len(history)+2 {
+		t.Errorf("历史应按预算裁剪: 发送了 %d 条", len(got.Messages))
+	}
+	if last := got.Messages[len(got.Messages)-1]; last.Content != "你好" {
+		t.Errorf("最后一条应为本轮用户消息: 得到 %s", last.Content)
+	}
+
+	// 完整历史仍保留在内存中，换用更大预算的模型时可以继续使用
+	if n := len(session.GetMessages()); n != len(history)+2 {
+		t.Errorf("内存中的历史不应被裁剪: 得到 %d 条", n)
+	}
+}
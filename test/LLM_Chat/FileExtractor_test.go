@@ -0,0 +1,159 @@
+package LLM_Chat_Service
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"platfrom/service/LLM_Chat"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// buildZip 按顺序写入文件构造 OOXML 压缩包
+func buildZip(t *testing.T, entries [][2]string) []byte {
+	var buf bytes.Buffer
+	writer := zip.NewWriter(&buf)
+	for _, entry := range entries {
+		w, err := writer.Create(entry[0])
+		if err != nil {
+			t.Fatalf("创建压缩包失败: %v", err)
+		}
+		_, _ = w.Write([]byte(entry[1]))
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("创建压缩包失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// buildPDF 构造只有一页文本的最小 PDF
+func buildPDF(text string) []byte {
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 712 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+const contentTypesXML = `<?xml version="1.0" encoding="UTF-8"?><Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"></Types>`
+
+// TestExtractorRegistry 测试按内容识别类型并提取文本
+func TestExtractorRegistry(t *testing.T) {
+	registry := LLM_Chat.NewExtractorRegistry()
+
+	docx := buildZip(t, [][2]string{
+		{"[Content_Types].xml", contentTypesXML},
+		{"word/document.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>第一段</w:t></w:r><w:r><w:t>继续</w:t></w:r></w:p>
+<w:p><w:r><w:t>第二段</w:t></w:r></w:p>
+</w:body></w:document>`},
+	})
+
+	xlsx := buildZip(t, [][2]string{
+		{"[Content_Types].xml", contentTypesXML},
+		{"xl/workbook.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="成绩" sheetId="1" r:id="rId1"/></sheets></workbook>`},
+		{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+		{"xl/sharedStrings.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><si><t>姓名</t></si><si><t>分数</t></si><si><r><t>张</t></r><r><t>三</t></r></si></sst>`},
+		{"xl/worksheets/sheet1.xml", `<?xml version="1.0" encoding="UTF-8"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+<row r="2"><c r="A2" t="s"><v>2</v></c><c r="B2"><v>95</v></c></row>
+</sheetData></worksheet>`},
+	})
+
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(strings.Repeat("这是一段使用国标编码保存的中文文本。", 5)))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		mimeType string
+		contains []string
+		excludes []string
+	}{
+		{"纯文本", []byte("hello world\n第二行"), "text/plain", []string{"hello world", "第二行"}, nil},
+		{"GBK 文本", gbk, "text/plain", []string{"国标编码"}, nil},
+		{"JSON 按父类型提取", []byte(`{"name": "测试"}`), "application/json", []string{`"name"`}, nil},
+		{"CSV", []byte("name,score\nalice,90\nbob,85\n"), "text/csv", []string{"name | score", "bob | 85"}, nil},
+		{"HTML", []byte(`<!DOCTYPE html><html><head><title>标题</title><style>p{color:red}</style></head><body><h1>正文标题</h1><p>段落内容</p><script>alert(1)</script></body></html>`),
+			"text/html", []string{"正文标题\n段落内容"}, []string{"alert", "color:red"}},
+		{"DOCX", docx, "application/vnd.openxmlformats-officedocument.wordprocessingml.document", []string{"第一段继续\n第二段"}, nil},
+		{"XLSX", xlsx, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", []string{"【工作表：成绩】", "姓名 | 分数", "张三 | 95"}, nil},
+		{"PDF", buildPDF("Hello PDF"), "application/pdf", []string{"Hello PDF"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, mimeType, err := registry.Extract(tt.data)
+			if err != nil {
+				t.Fatalf("Extract() 意外返回错误: %v", err)
+			}
+			if mimeType != tt.mimeType {
+				t.Errorf("类型识别错误: 期望 %s, 得到 %s", tt.mimeType, mimeType)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(text, want) {
+					t.Errorf("提取结果应包含 %q: %q", want, text)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(text, unwanted) {
+					t.Errorf("提取结果不应包含 %q: %q", unwanted, text)
+				}
+			}
+		})
+	}
+}
+
+// TestExtractorRegistryUnsupported 测试不支持的类型和自定义提取器
+func TestExtractorRegistryUnsupported(t *testing.T) {
+	registry := LLM_Chat.NewExtractorRegistry()
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x00\x01\x00\x00\x00\x01\x08\x06\x00\x00\x00")
+
+	// 扩展名伪装无效，按内容识别
+	_, mimeType, err := registry.Extract(png)
+	if !errors.Is(err, LLM_Chat.ErrUnsupportedFileType) {
+		t.Fatalf("图片应返回不支持的类型: %v", err)
+	}
+	if mimeType != "image/png" {
+		t.Errorf("类型识别错误: %s", mimeType)
+	}
+
+	registry.Register("image/png", func(data []byte, params map[string]string) (string, error) {
+		return "图片描述", nil
+	})
+	if text, _, err := registry.Extract(png); err != nil || text != "图片描述" {
+		t.Errorf("自定义提取器未生效: %s, %v", text, err)
+	}
+
+	if _, _, err := registry.Extract([]byte("   \n  ")); err == nil {
+		t.Error("没有文本内容时应返回错误")
+	}
+}
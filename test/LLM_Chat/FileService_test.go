@@ -0,0 +1,451 @@
+package LLM_Chat_Service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"gorm.io/gorm"
+)
+
+// newTestBlobStore 创建使用临时目录的本地存储
+func newTestBlobStore(t *testing.T) LLM_Chat.BlobStoreInterface {
+	store, err := LLM_Chat.NewLocalBlobStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("创建文件存储失败: %v", err)
+	}
+	return store
+}
+
+// testUploadConfig 使用 style.yaml 中的上传策略，上传目录改为临时目录
+func testUploadConfig(t *testing.T) database.FileUploadConfig {
+	config, err := LLM_Chat.LoadFileUploadConfig("../../style.yaml")
+	if err != nil {
+		t.Fatalf("读取上传配置失败: %v", err)
+	}
+	config.UploadDir = filepath.Join(t.TempDir(), "uploads")
+	return config
+}
+
+// setupFileService 创建使用临时上传目录的文件服务
+func setupFileService(t *testing.T, config database.FileUploadConfig, store LLM_Chat.BlobStoreInterface) (LLM_Chat.FileServiceInterface, *gorm.DB, string) {
+	db := setupChatTestDB(t)
+	if err := db.AutoMigrate(&database.UploadedFile{}, &database.Blob{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	// 测试文件上传到用户 1 的会话 s1、s2
+	for _, sessionID := range []string{"s1", "s2"} {
+		db.Create(&database.ChatSession{SessionID: sessionID, UserID: 1, ModelName: "gpt-4"})
+	}
+	config.UploadDir = filepath.Join(t.TempDir(), "uploads")
+	service, err := LLM_Chat.NewFileService(db, config, store)
+	if err != nil {
+		t.Fatalf("创建文件服务失败: %v", err)
+	}
+	return service, db, config.UploadDir
+}
+
+// TestLoadFileUploadConfig 测试从 style.yaml 读取上传配置，缺少必填项时返回错误
+func TestLoadFileUploadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "style.yaml")
+	content := `personas:
+  - name: "默认助手"
+    content: "你好"
+file_upload:
+  upload_dir: "./data/uploads"
+  max_file_size: 2048
+  allowed_extensions:
+    - ".TXT"
+    - "md"
+`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("写入配置失败: %v", err)
+	}
+
+	config, err := LLM_Chat.LoadFileUploadConfig(path)
+	if err != nil {
+		t.Fatalf("读取配置失败: %v", err)
+	}
+	if config.UploadDir != "./data/uploads" || config.MaxFileSize != 2048 {
+		t.Fatalf("配置解析错误: %+v", config)
+	}
+	if strings.Join(config.AllowedExtensions, ",") != ".txt,.md" {
+		t.Errorf("扩展名应统一为带点的小写形式: %v", config.AllowedExtensions)
+	}
+
+	for name, broken := range map[string]database.FileUploadConfig{
+		"缺少上传目录":  {MaxFileSize: 1024, AllowedExtensions: []string{".txt"}},
+		"缺少大小上限":  {UploadDir: "./uploads", AllowedExtensions: []string{".txt"}},
+		"大小上限为负数": {UploadDir: "./uploads", MaxFileSize: -1, AllowedExtensions: []string{".txt"}},
+		"缺少扩展名":   {UploadDir: "./uploads", MaxFileSize: 1024, AllowedExtensions: []string{" "}},
+	} {
+		if _, err := LLM_Chat.NewFileService(setupChatTestDB(t), broken, newTestBlobStore(t)); err == nil {
+			t.Errorf("%s时应返回错误", name)
+		}
+	}
+	if err := os.WriteFile(path, []byte("file_upload:\n  max_file_size: 2048\n"), 0644); err != nil {
+		t.Fatalf("写入配置失败: %v", err)
+	}
+	if _, err := LLM_Chat.LoadFileUploadConfig(path); err == nil {
+		t.Error("配置不完整时读取应返回错误")
+	}
+	if _, err := LLM_Chat.NewFileService(setupChatTestDB(t), config, nil); err == nil {
+		t.Error("没有文件存储时应返回错误")
+	}
+}
+
+// TestStoreUpload 测试上传文件按配置校验并写入配置的目录
+func TestStoreUpload(t *testing.T) {
+	service, _, uploadDir := setupFileService(t, database.FileUploadConfig{
+		MaxFileSize:       1024,
+		AllowedExtensions: []string{".txt", ".png"},
+	}, newTestBlobStore(t))
+
+	t.Run("正常上传", func(t *testing.T) {
+		file, err := service.StoreUpload("s1", "note.TXT", strings.NewReader("hello world"), 1)
+		if err != nil {
+			t.Fatalf("StoreUpload() 意外返回错误: %v", err)
+		}
+		if file.ID == 0 || file.FileSize != 11 || file.Content != "hello world" || file.FileType != "text/plain" || file.IsProcessed {
+			t.Errorf("文件记录错误: %+v", file)
+		}
+		if file.BlobKey != LLM_Chat.BlobKey([]byte("hello world")) {
+			t.Errorf("文件应按内容的 sha256 保存: %s", file.BlobKey)
+		}
+		if data, err := service.ReadFile(file); err != nil || string(data) != "hello world" {
+			t.Errorf("读取文件内容错误: %q, %v", data, err)
+		}
+	})
+
+	t.Run("图片只校验不提取文本", func(t *testing.T) {
+		file, err := service.StoreUpload("s1", "cat.png", bytes.NewReader(buildPNG(t, 16, 16)), 1)
+		if err != nil {
+			t.Fatalf("StoreUpload() 意外返回错误: %v", err)
+		}
+		if file.FileType != "image/png" || file.Content != "" || !file.IsProcessed {
+			t.Errorf("图片记录错误: %+v", file)
+		}
+	})
+
+	before, _ := os.ReadDir(uploadDir)
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		wantErr error
+	}{
+		{"扩展名不允许", "run.sh", "echo hi", LLM_Chat.ErrExtensionNotAllowed},
+		{"超过大小限制", "big.txt", strings.Repeat("a", 2048), LLM_Chat.ErrFileTooLarge},
+		{"内容无法识别", "fake.png", "\x00\x01\x02\x03", LLM_Chat.ErrInvalidFile},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.StoreUpload("s1", tt.file, strings.NewReader(tt.content), 1)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("期望错误 %v, 得到 %v", tt.wantErr, err)
+			}
+		})
+	}
+	after, _ := os.ReadDir(uploadDir)
+	if len(after) != len(before) {
+		t.Errorf("上传过程中的临时文件应被清理: %d -> %d", len(before), len(after))
+	}
+
+	if err := service.ValidateUpload("a.txt", 4096); !errors.Is(err, LLM_Chat.ErrFileTooLarge) {
+		t.Errorf("声明的大小超限时应直接拒绝: %v", err)
+	}
+}
+
+// TestBlobReferenceCounting 测试相同内容只保存一份，删除最后一个引用时回收内容
+func TestBlobReferenceCounting(t *testing.T) {
+	store := newTestBlobStore(t)
+	service, db, _ := setupFileService(t, testUploadConfig(t), store)
+	ctx := context.Background()
+
+	first, err := service.StoreUpload("s1", "a.txt", strings.NewReader("相同的内容"), 1)
+	if err != nil {
+		t.Fatalf("StoreUpload() 意外返回错误: %v", err)
+	}
+	second, err := service.StoreUpload("s2", "b.txt", strings.NewReader("相同的内容"), 1)
+	if err != nil {
+		t.Fatalf("StoreUpload() 意外返回错误: %v", err)
+	}
+	if first.BlobKey != second.BlobKey {
+		t.Fatal("相同内容应使用同一个键")
+	}
+
+	blobs, _ := store.List(ctx)
+	var blob database.Blob
+	db.First(&blob, "hash = ?", first.BlobKey)
+	if len(blobs) != 1 || blob.RefCount != 2 {
+		t.Fatalf("相同内容应只保存一份并记录 2 个引用: blobs=%d, refs=%d", len(blobs), blob.RefCount)
+	}
+
+	if err := service.DeleteFile(first.ID, 1); err != nil {
+		t.Fatalf("删除文件失败: %v", err)
+	}
+	if exists, _ := store.Exists(ctx, first.BlobKey); !exists {
+		t.Fatal("仍有引用时不应删除内容")
+	}
+	if data, err := service.ReadFile(second); err != nil || string(data) != "相同的内容" {
+		t.Errorf("另一个引用应仍可读取: %v", err)
+	}
+
+	if err := service.DeleteFile(second.ID, 1); err != nil {
+		t.Fatalf("删除文件失败: %v", err)
+	}
+	if exists, _ := store.Exists(ctx, first.BlobKey); exists {
+		t.Error("最后一个引用删除后应回收内容")
+	}
+	var count int64
+	db.Model(&database.Blob{}).Count(&count)
+	if count != 0 {
+		t.Errorf("引用记录应被删除: 剩余 %d", count)
+	}
+}
+
+// TestDeleteSessionReleasesFiles 测试删除会话时释放其中文件对内容的引用，内容随之可以回收
+func TestDeleteSessionReleasesFiles(t *testing.T) {
+	store := newTestBlobStore(t)
+	service, db, _ := setupFileService(t, testUploadConfig(t), store)
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	chatService, _ := LLM_Chat.NewChatService(db)
+	ctx := context.Background()
+
+	first, _ := service.StoreUpload("s1", "a.txt", strings.NewReader("会话文件"), 1)
+	_, _ = service.StoreUpload("s1", "b.txt", strings.NewReader("只在 s1 中"), 1)
+	if _, err := service.StoreUpload("s2", "c.txt", strings.NewReader("会话文件"), 1); err != nil {
+		t.Fatalf("StoreUpload() 意外返回错误: %v", err)
+	}
+
+	if err := chatService.DeleteChatSession("s1", 2); !errors.Is(err, LLM_Chat.ErrSessionNotFound) {
+		t.Fatalf("其他用户不能删除会话: %v", err)
+	}
+	if err := chatService.DeleteChatSession("s1", 1); err != nil {
+		t.Fatalf("删除会话失败: %v", err)
+	}
+	var files int64
+	db.Model(&database.UploadedFile{}).Where("session_id = ?", "s1").Count(&files)
+	var blob database.Blob
+	db.First(&blob, "hash = ?", first.BlobKey)
+	if files != 0 || blob.RefCount != 1 {
+		t.Fatalf("删除会话应删除其文件并释放引用: files=%d, refs=%d", files, blob.RefCount)
+	}
+	if blobs, _ := store.List(ctx); len(blobs) != 1 {
+		t.Errorf("只被 s1 引用的内容应被回收: %d", len(blobs))
+	}
+
+	if err := chatService.RootDeleteSession("s2"); err != nil {
+		t.Fatalf("管理员删除会话失败: %v", err)
+	}
+	if blobs, _ := store.List(ctx); len(blobs) != 0 {
+		t.Errorf("最后一个引用随会话删除后应回收内容: %d", len(blobs))
+	}
+	if removed, err := service.ReconcileBlobs(ctx); err != nil || removed != 0 {
+		t.Errorf("不应遗留需要清理的内容: %d, %v", removed, err)
+	}
+}
+
+// TestReconcileBlobs 测试按文件表清理孤立内容并修正引用计数
+func TestReconcileBlobs(t *testing.T) {
+	store := newTestBlobStore(t)
+	service, db, _ := setupFileService(t, testUploadConfig(t), store)
+	ctx := context.Background()
+
+	kept, _ := service.StoreUpload("s1", "kept.txt", strings.NewReader("保留的文件"), 1)
+	db.Model(&database.Blob{}).Where("hash = ?", kept.BlobKey).Update("ref_count", 5)
+
+	// 没有任何文件引用的内容（如删除时存储暂时不可用）
+	orphan := []byte("孤立的内容")
+	orphanKey := LLM_Chat.BlobKey(orphan)
+	if err := store.Put(ctx, orphanKey, bytes.NewReader(orphan), int64(len(orphan))); err != nil {
+		t.Fatalf("写入内容失败: %v", err)
+	}
+
+	if removed, err := service.ReconcileBlobs(ctx); err != nil || removed != 0 {
+		t.Fatalf("新写入的内容不应被清理: removed=%d, err=%v", removed, err)
+	}
+	var blob database.Blob
+	db.First(&blob, "hash = ?", kept.BlobKey)
+	if blob.RefCount != 1 {
+		t.Errorf("引用计数应按文件表修正: %d", blob.RefCount)
+	}
+
+	// 模拟内容写入已超过保护期
+	blobs, _ := store.List(ctx)
+	root := filepath.Dir(filepath.Dir(filepath.Dir(findBlobPath(t, store, orphanKey))))
+	old := time.Now().Add(-2 * time.Hour)
+	for _, b := range blobs {
+		_ = os.Chtimes(filepath.Join(root, b.Key[:2], b.Key[2:4], b.Key), old, old)
+	}
+
+	if removed, err := service.ReconcileBlobs(ctx); err != nil || removed != 1 {
+		t.Fatalf("应清理 1 个孤立内容: removed=%d, err=%v", removed, err)
+	}
+	if exists, _ := store.Exists(ctx, orphanKey); exists {
+		t.Error("孤立内容应被删除")
+	}
+	if exists, _ := store.Exists(ctx, kept.BlobKey); !exists {
+		t.Error("仍被引用的内容不应被删除")
+	}
+}
+
+// findBlobPath 在本地存储目录中查找内容对应的文件
+func findBlobPath(t *testing.T, store LLM_Chat.BlobStoreInterface, key string) string {
+	reader, err := store.Open(context.Background(), key)
+	if err != nil {
+		t.Fatalf("打开内容失败: %v", err)
+	}
+	defer reader.Close()
+	file, ok := reader.(*os.File)
+	if !ok {
+		t.Fatal("本地存储应返回文件")
+	}
+	return file.Name()
+}
+
+// fakeS3 内存中的 S3 兼容服务，校验签名头和内容哈希
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=minio/") || r.Header.Get("x-amz-date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/bucket")
+	if path == "" || path == "/" {
+		var result struct {
+			XMLName  xml.Name `xml:"ListBucketResult"`
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+		}
+		keys := make([]string, 0, len(f.objects))
+		for key := range f.objects {
+			if strings.HasPrefix(key, r.URL.Query().Get("prefix")) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			result.Contents = append(result.Contents, struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}{key, int64(len(f.objects[key])), time.Now().Add(-2 * time.Hour)})
+		}
+		_ = xml.NewEncoder(w).Encode(result)
+		return
+	}
+
+	key := strings.TrimPrefix(path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(r.Body)
+		sum := sha256.Sum256(data)
+		if hex.EncodeToString(sum[:]) != r.Header.Get("x-amz-content-sha256") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.objects[key] = data
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(data)
+		}
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// TestS3BlobStore 测试 S3 兼容存储的读写、去重和清理
+func TestS3BlobStore(t *testing.T) {
+	backend := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(backend)
+	defer server.Close()
+
+	store, err := LLM_Chat.NewS3BlobStore(LLM_Chat.S3Config{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "minio",
+		SecretKey: "minio123",
+		Prefix:    "uploads/",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatalf("创建 S3 存储失败: %v", err)
+	}
+	ctx := context.Background()
+
+	data := []byte("保存在 S3 中的内容")
+	key := LLM_Chat.BlobKey(data)
+	if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data))); err != nil {
+		t.Fatalf("Put() 意外返回错误: %v", err)
+	}
+	if _, ok := backend.objects["uploads/"+key]; !ok {
+		t.Fatalf("对象应保存在前缀下: %v", backend.objects)
+	}
+	if err := store.Put(ctx, LLM_Chat.BlobKey([]byte("其他")), bytes.NewReader(data), int64(len(data))); err == nil {
+		t.Error("内容与键不一致时应返回错误")
+	}
+
+	reader, err := store.Open(ctx, key)
+	if err != nil {
+		t.Fatalf("Open() 意外返回错误: %v", err)
+	}
+	got, _ := io.ReadAll(reader)
+	reader.Close()
+	if !bytes.Equal(got, data) {
+		t.Errorf("读取内容错误: %q", got)
+	}
+	if _, err := store.Open(ctx, LLM_Chat.BlobKey([]byte("不存在"))); !errors.Is(err, LLM_Chat.ErrBlobNotFound) {
+		t.Errorf("不存在的内容应返回 ErrBlobNotFound: %v", err)
+	}
+
+	// 通过文件服务使用 S3 存储：删除最后一个引用后对象被删除，孤立对象被清理
+	service, _, _ := setupFileService(t, testUploadConfig(t), store)
+	file, err := service.StoreUpload("s1", "a.txt", strings.NewReader("通过文件服务上传"), 1)
+	if err != nil {
+		t.Fatalf("StoreUpload() 意外返回错误: %v", err)
+	}
+	if removed, err := service.ReconcileBlobs(ctx); err != nil || removed != 1 {
+		t.Fatalf("应只清理没有文件引用的对象: removed=%d, err=%v", removed, err)
+	}
+	if err := service.DeleteFile(file.ID, 1); err != nil {
+		t.Fatalf("删除文件失败: %v", err)
+	}
+	blobs, err := store.List(ctx)
+	if err != nil || len(blobs) != 0 {
+		t.Errorf("所有对象都应被删除: %+v, %v", blobs, err)
+	}
+}
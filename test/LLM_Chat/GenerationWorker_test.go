@@ -0,0 +1,338 @@
+package LLM_Chat_Service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// startWorkerPool 启动使用进程内队列的 worker 池，测试结束时停止
+func startWorkerPool(t *testing.T, config database.GenerationConfig) *LLM_Chat.GenerationWorkerPool {
+	pool := LLM_Chat.InitGenerationWorkerPool(config, LLM_Chat.NewJobQueue(nil, config.QueueSize))
+	t.Cleanup(pool.Shutdown)
+	return pool
+}
+
+// stalledQueue 只接收不交付的队列，任务一直处于排队状态
+type stalledQueue struct{}
+
+func (stalledQueue) Push(ctx context.Context, job *LLM_Chat.GenerationJob) error { return nil }
+
+func (stalledQueue) Pop(ctx context.Context) (*LLM_Chat.GenerationJob, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+// waitGenerationDone 等待会话的生成结束并释放登记
+func waitGenerationDone(t *testing.T, sessionID string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, running := LLM_Chat.GlobalGenerationRegistry.Get(sessionID); !running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待生成结束超时: %s", sessionID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestGenerationSurvivesDisconnect 测试客户端断开后生成继续进行，完整回复仍被保存
+func TestGenerationSurvivesDisconnect(t *testing.T) {
+	server := newStreamLLMServer(t)
+	_, chatService, apiService := setupSessionManager(t)
+
+	const sessionID = "session_disconnect"
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: server.URL}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chat := router.Group("/api/chat", func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	chat.POST("/message/stream", LLM_Chat_Route.SendMessageStream)
+
+	server.set(true, "一")
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		req := httptest.NewRequest(http.MethodPost, "/api/chat/message/stream", strings.NewReader(`{"session_id":"session_disconnect","model_name":"gpt-4","message":"问题"}`)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if generation, ok := LLM_Chat.GlobalGenerationRegistry.Get(sessionID); ok && generation.Content() == "一" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("等待流式生成超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 客户端断开后请求立即结束，生成继续
+	cancel()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端断开后请求应结束")
+	}
+	if _, running := LLM_Chat.GlobalGenerationRegistry.Get(sessionID); !running {
+		t.Fatal("客户端断开不应中止生成")
+	}
+
+	server.release("二", "三")
+	waitGenerationDone(t, sessionID)
+	if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 一二三]" {
+		t.Errorf("没有客户端接收时也应保存完整回复: %s", got)
+	}
+}
+
+// waitGenerationContent 等待会话正在进行的生成推送到 content
+func waitGenerationContent(t *testing.T, sessionID, content string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if generation, ok := LLM_Chat.GlobalGenerationRegistry.Get(sessionID); ok && generation.Content() == content {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("等待生成超时: %s", sessionID)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSyncEndpointsUseWorker 测试同步的发送和重新生成接口也由 worker 生成：可以被停止，客户端断开后继续生成
+func TestSyncEndpointsUseWorker(t *testing.T) {
+	server := newStreamLLMServer(t)
+	_, chatService, apiService := setupSessionManager(t)
+
+	const sessionID = "session_sync"
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: server.URL}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chat := router.Group("/api/chat", func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	chat.POST("/message", LLM_Chat_Route.SendMessage)
+	chat.POST("/sessions/:session_id/messages/:message_id/regenerate", LLM_Chat_Route.RegenerateMessage)
+	chat.POST("/sessions/:session_id/stop", LLM_Chat_Route.StopGeneration)
+	do := func(ctx context.Context, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// 同步发送可以被 /stop 停止，返回并保存已生成的部分
+	server.set(true, "部分")
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- do(context.Background(), "/api/chat/message", `{"session_id":"session_sync","model_name":"gpt-4","message":"问题"}`)
+	}()
+	waitGenerationContent(t, sessionID, "部分")
+	if w := do(context.Background(), "/api/chat/sessions/session_sync/stop", ""); w.Code != http.StatusOK {
+		t.Fatalf("停止同步生成失败: %d, %s", w.Code, w.Body.String())
+	}
+	w := <-done
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"stopped":true`) || !strings.Contains(w.Body.String(), `"response":"部分"`) {
+		t.Fatalf("同步接口应返回已停止的部分回复: %d, %s", w.Code, w.Body.String())
+	}
+	waitGenerationDone(t, sessionID)
+	reply := lastMessageID(t, chatService, sessionID)
+
+	// 客户端断开后重新生成继续进行并保存
+	server.set(true, "新")
+	ctx, cancel := context.WithCancel(context.Background())
+	handled := make(chan struct{})
+	go func() {
+		defer close(handled)
+		do(ctx, fmt.Sprintf("/api/chat/sessions/%s/messages/%d/regenerate", sessionID, reply), "")
+	}()
+	waitGenerationContent(t, sessionID, "新")
+	cancel()
+	select {
+	case <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("客户端断开后请求应结束")
+	}
+	server.release("回答")
+	waitGenerationDone(t, sessionID)
+	if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 新回答]" {
+		t.Errorf("客户端断开后仍应保存重新生成的回复: %s", got)
+	}
+}
+
+// TestGenerationConcurrencyLimit 测试按用户限制同时进行的生成数，以及单个用户的上限覆盖
+func TestGenerationConcurrencyLimit(t *testing.T) {
+	LLM_Chat.NewCacheService(nil, false)
+	pool := LLM_Chat.InitGenerationWorkerPool(database.GenerationConfig{
+		Workers:              1,
+		MaxConcurrentPerUser: 1,
+		UserLimits:           map[uint]int{2: 2},
+	}, stalledQueue{})
+	t.Cleanup(pool.Shutdown)
+
+	submit := func(sessionID string, userID uint) error {
+		return pool.Submit(LLM_Chat.NewGenerationJob(LLM_Chat.JobSend, sessionID, userID))
+	}
+	if err := submit("limit_a", 1); err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	if err := submit("limit_a", 2); !errors.Is(err, LLM_Chat.ErrGenerationInProgress) {
+		t.Errorf("同一会话重复提交应返回 ErrGenerationInProgress: %v", err)
+	}
+	if err := submit("limit_b", 1); !errors.Is(err, LLM_Chat.ErrTooManyGenerations) {
+		t.Errorf("超过用户上限应返回 ErrTooManyGenerations: %v", err)
+	}
+	if err := submit("limit_c", 2); err != nil {
+		t.Errorf("单独配置的用户上限应生效: %v", err)
+	}
+	if err := submit("limit_d", 2); err != nil {
+		t.Errorf("单独配置的用户上限应生效: %v", err)
+	}
+
+	// 任务的结束事件写入后释放登记，用户可以再次提交
+	for _, sessionID := range []string{"limit_a", "limit_c", "limit_d"} {
+		if _, err := LLM_Chat.GlobalCacheService.AppendStreamEvent(sessionID, LLM_Chat.StreamEvent{Data: "{}", Done: true}); err != nil {
+			t.Fatalf("追加事件失败: %v", err)
+		}
+		waitGenerationDone(t, sessionID)
+	}
+	if err := submit("limit_b", 1); err != nil {
+		t.Errorf("生成结束后应可再次提交: %v", err)
+	}
+	_, _ = LLM_Chat.GlobalCacheService.AppendStreamEvent("limit_b", LLM_Chat.StreamEvent{Data: "{}", Done: true})
+	waitGenerationDone(t, "limit_b")
+}
+
+// TestMemoryJobQueue 测试进程内队列按顺序交付任务，已满时返回 ErrQueueFull
+func TestMemoryJobQueue(t *testing.T) {
+	queue := LLM_Chat.NewJobQueue(nil, 1)
+	ctx := context.Background()
+
+	job := LLM_Chat.NewGenerationJob(LLM_Chat.JobContinue, "s1", 1)
+	if err := queue.Push(ctx, job); err != nil {
+		t.Fatalf("入队失败: %v", err)
+	}
+	if err := queue.Push(ctx, LLM_Chat.NewGenerationJob(LLM_Chat.JobSend, "s2", 1)); !errors.Is(err, LLM_Chat.ErrQueueFull) {
+		t.Errorf("队列已满时应返回 ErrQueueFull: %v", err)
+	}
+
+	got, err := queue.Pop(ctx)
+	if err != nil || got.ID != job.ID {
+		t.Fatalf("出队失败: %+v, %v", got, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := queue.Pop(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("队列为空时应等待到 ctx 结束: %v", err)
+	}
+}
+
+// useRedisCache 把全局缓存服务换成使用 miniredis 的实例，模拟多个实例共享 Redis
+func useRedisCache(t *testing.T) *redis.Client {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	LLM_Chat.NewCacheService(client, true)
+	t.Cleanup(func() {
+		LLM_Chat.NewCacheService(nil, false)
+		_ = client.Close()
+	})
+	return client
+}
+
+// TestStopAcrossInstances 测试使用 Redis 时，其他实例收到的停止请求也能停止本实例执行的生成
+func TestStopAcrossInstances(t *testing.T) {
+	server := newStreamLLMServer(t)
+	_, chatService, apiService := setupSessionManager(t)
+	useRedisCache(t)
+
+	const sessionID = "session_remote_stop"
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: server.URL}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chat := router.Group("/api/chat", func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	chat.POST("/message/stream", LLM_Chat_Route.SendMessageStream)
+	post := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/chat/message/stream", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	server.set(true, "部分", "回答")
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- post(`{"session_id":"session_remote_stop","model_name":"gpt-4","message":"问题"}`)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if generation, ok := LLM_Chat.GlobalGenerationRegistry.Get(sessionID); ok && generation.Content() == "部分回答" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("等待流式生成超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 另一个实例没有本地登记，按 Redis 中的登记判断归属
+	other := LLM_Chat.NewGenerationRegistry()
+	if !other.Running(sessionID) {
+		t.Error("其他实例应能看到正在进行的生成")
+	}
+	if _, err := other.Stop(sessionID, 2); !errors.Is(err, LLM_Chat.ErrNoGeneration) {
+		t.Errorf("其他用户不能停止生成: %v", err)
+	}
+	content, err := other.Stop(sessionID, 1)
+	if err != nil || content != "部分回答" {
+		t.Fatalf("其他实例停止生成失败: %q, %v", content, err)
+	}
+
+	stream := <-done
+	if !strings.Contains(stream.Body.String(), `"stopped":true`) {
+		t.Errorf("流应以 stopped 事件结束: %s", stream.Body.String())
+	}
+	if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 部分回答]" {
+		t.Errorf("应保存已生成的部分: %s", got)
+	}
+	waitGenerationDone(t, sessionID)
+	if other.Running(sessionID) {
+		t.Error("生成结束后应释放 Redis 中的登记")
+	}
+
+	// 其他实例正在为会话生成时，本实例拒绝新的生成
+	if claimed, err := LLM_Chat.GlobalCacheService.ClaimGeneration(sessionID, 1, "job_other", time.Minute); err != nil || !claimed {
+		t.Fatalf("登记生成失败: %v, %v", claimed, err)
+	}
+	if w := post(`{"session_id":"session_remote_stop","model_name":"gpt-4","message":"问题"}`); w.Code != http.StatusConflict {
+		t.Errorf("其他实例正在生成时应返回 409: %d", w.Code)
+	}
+}
@@ -0,0 +1,240 @@
+package LLM_Chat_Service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+)
+
+// streamLLMServer 以 SSE 流式返回固定片段的 OpenAI 兼容服务；hold 为 true 时推送完片段后挂起，
+// 直到请求被取消或 release 给出剩余片段
+type streamLLMServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []openai.ChatCompletionRequest
+	chunks   []string
+	hold     bool
+	tail     chan []string
+}
+
+func newStreamLLMServer(t *testing.T, chunks ...string) *streamLLMServer {
+	m := &streamLLMServer{chunks: chunks, tail: make(chan []string, 1)}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body openai.ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.mu.Lock()
+		m.requests = append(m.requests, body)
+		chunks, hold := m.chunks, m.hold
+		m.mu.Unlock()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		writeChunks := func(chunks []string) {
+			for _, chunk := range chunks {
+				data, _ := json.Marshal(map[string]interface{}{
+					"choices": []map[string]interface{}{{"index": 0, "delta": map[string]string{"content": chunk}}},
+				})
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				w.(http.Flusher).Flush()
+			}
+		}
+		writeChunks(chunks)
+		if hold {
+			select {
+			case tail := <-m.tail:
+				writeChunks(tail)
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`+"\n\ndata: [DONE]\n\n")
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *streamLLMServer) set(hold bool, chunks ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hold, m.chunks = hold, chunks
+}
+
+// release 让挂起的请求推送剩余片段后正常结束
+func (m *streamLLMServer) release(chunks ...string) {
+	m.tail <- chunks
+}
+
+func (m *streamLLMServer) lastRequest() openai.ChatCompletionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests[len(m.requests)-1]
+}
+
+// TestGenerationRegistry 测试同一会话只允许一个生成，以及停止时取消 context
+func TestGenerationRegistry(t *testing.T) {
+	registry := LLM_Chat.NewGenerationRegistry()
+
+	ctx, generation, err := registry.Start(context.Background(), "s1", 1)
+	if err != nil {
+		t.Fatalf("登记生成失败: %v", err)
+	}
+	if _, _, err := registry.Start(context.Background(), "s1", 1); !errors.Is(err, LLM_Chat.ErrGenerationInProgress) {
+		t.Errorf("同一会话重复登记应返回 ErrGenerationInProgress: %v", err)
+	}
+	if _, err := registry.Stop("s1", 2); !errors.Is(err, LLM_Chat.ErrNoGeneration) {
+		t.Errorf("其他用户不能停止生成: %v", err)
+	}
+
+	generation.Append("部分")
+	go func() {
+		<-ctx.Done()
+		registry.Finish(generation)
+	}()
+	content, err := registry.Stop("s1", 1)
+	if err != nil || content != "部分" || !generation.Stopped() {
+		t.Fatalf("停止生成失败: %q, %v", content, err)
+	}
+	if _, exists := registry.Get("s1"); exists {
+		t.Error("结束后应移除登记")
+	}
+	if _, err := registry.Stop("s1", 1); !errors.Is(err, LLM_Chat.ErrNoGeneration) {
+		t.Errorf("没有生成时应返回 ErrNoGeneration: %v", err)
+	}
+}
+
+// TestStopRegenerateContinue 测试停止流式生成后保存已生成部分，以及重新生成和续写最后一条回复
+func TestStopRegenerateContinue(t *testing.T) {
+	server := newStreamLLMServer(t)
+	_, chatService, apiService := setupSessionManager(t)
+
+	const sessionID = "session_stream"
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: server.URL}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chat := router.Group("/api/chat", func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	chat.POST("/message/stream", LLM_Chat_Route.SendMessageStream)
+	chat.POST("/sessions/:session_id/stop", LLM_Chat_Route.StopGeneration)
+	chat.POST("/sessions/:session_id/regenerate", LLM_Chat_Route.RegenerateStream)
+	chat.POST("/sessions/:session_id/continue", LLM_Chat_Route.ContinueStream)
+	do := func(path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("停止后保存已生成的部分", func(t *testing.T) {
+		server.set(true, "部分", "回答")
+		done := make(chan *httptest.ResponseRecorder)
+		go func() {
+			done <- do("/api/chat/message/stream", `{"session_id":"session_stream","model_name":"gpt-4","message":"问题"}`)
+		}()
+
+		// 等待两个片段都已推送
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if generation, ok := LLM_Chat.GlobalGenerationRegistry.Get(sessionID); ok && generation.Content() == "部分回答" {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("等待流式生成超时")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		w := do("/api/chat/sessions/session_stream/stop", "")
+		var stopped struct {
+			Content string `json:"content"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &stopped)
+		if w.Code != http.StatusOK || stopped.Content != "部分回答" {
+			t.Fatalf("停止生成失败: %d, %s", w.Code, w.Body.String())
+		}
+
+		stream := <-done
+		if !strings.Contains(stream.Body.String(), `"stopped":true`) {
+			t.Errorf("流应以 stopped 事件结束: %s", stream.Body.String())
+		}
+		if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 部分回答]" {
+			t.Errorf("应保存已生成的部分: %s", got)
+		}
+		if w := do("/api/chat/sessions/session_stream/stop", ""); w.Code != http.StatusNotFound {
+			t.Errorf("没有正在进行的生成时应返回 404: %d", w.Code)
+		}
+	})
+
+	t.Run("续写最后一条回复", func(t *testing.T) {
+		server.set(false, "，续写")
+		w := do("/api/chat/sessions/session_stream/continue", "")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "续写") {
+			t.Fatalf("续写失败: %d, %s", w.Code, w.Body.String())
+		}
+		messages := server.lastRequest().Messages
+		if last := messages[len(messages)-1]; last.Role != "user" || messages[len(messages)-2].Content != "部分回答" {
+			t.Errorf("续写请求应包含被截断的回复和续写指令: %+v", messages)
+		}
+		if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 部分回答，续写]" {
+			t.Errorf("续写内容应拼接到原回复: %s", got)
+		}
+	})
+
+	t.Run("重新生成最后一条回复", func(t *testing.T) {
+		server.set(false, "新的", "回答")
+		w := do("/api/chat/sessions/session_stream/regenerate", "")
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"done":true`) {
+			t.Fatalf("重新生成失败: %d, %s", w.Code, w.Body.String())
+		}
+		var sent []string
+		for _, msg := range server.lastRequest().Messages {
+			if msg.Role != "system" {
+				sent = append(sent, msg.Content)
+			}
+		}
+		if fmt.Sprint(sent) != "[问题]" {
+			t.Errorf("重新生成时不应包含原回复: %v", sent)
+		}
+		if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 新的回答]" {
+			t.Errorf("新回复应替换当前分支的最后一条回复: %s", got)
+		}
+		last, _ := chatService.GetLastMessage(sessionID, 1)
+		if siblings, _ := chatService.GetSiblings(sessionID, 1, last.ID); len(siblings) != 2 {
+			t.Errorf("原回复应保留为兄弟分支: %d", len(siblings))
+		}
+	})
+
+	t.Run("同一会话不能同时生成", func(t *testing.T) {
+		_, generation, err := LLM_Chat.GlobalGenerationRegistry.Start(context.Background(), sessionID, 1)
+		if err != nil {
+			t.Fatalf("登记生成失败: %v", err)
+		}
+		defer LLM_Chat.GlobalGenerationRegistry.Finish(generation)
+
+		if w := do("/api/chat/sessions/session_stream/regenerate", ""); w.Code != http.StatusConflict {
+			t.Errorf("应返回 409: %d", w.Code)
+		}
+		if w := do("/api/chat/message/stream", `{"session_id":"session_stream","model_name":"gpt-4","message":"问题"}`); w.Code != http.StatusConflict {
+			t.Errorf("应返回 409: %d", w.Code)
+		}
+	})
+
+	if w := do("/api/chat/sessions/other/continue", ""); w.Code != http.StatusNotFound {
+		t.Errorf("不存在的会话应返回 404: %d", w.Code)
+	}
+}
@@ -0,0 +1,208 @@
+package LLM_Chat_Service
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// buildPNG 生成指定尺寸的 PNG 图片
+func buildPNG(t *testing.T, width, height int) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("生成图片失败: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestValidateImage 测试图片类型、尺寸校验
+func TestValidateImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"正常 PNG", buildPNG(t, 64, 32), ""},
+		{"尺寸过小", buildPNG(t, 8, 8), "不能小于"},
+		{"尺寸过大", buildPNG(t, 9000, 16), "不能超过"},
+		{"非图片", []byte("hello world"), "不支持的文件类型"},
+		{"损坏的 PNG", buildPNG(t, 64, 64)[:30], "图片解析失败"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mimeType, err := LLM_Chat.ValidateImage(tt.data)
+			if tt.wantErr == "" {
+				if err != nil || mimeType != "image/png" {
+					t.Errorf("ValidateImage() = %s, %v", mimeType, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("期望错误包含 %q, 得到 %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestImagePartsPersistence 测试带图片的消息保存后重新加载仍能还原为多部分内容
+func TestImagePartsPersistence(t *testing.T) {
+	db := setupChatTestDB(t)
+	if err := db.AutoMigrate(&database.UploadedFile{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	service, _ := LLM_Chat.NewChatService(db)
+	_, _ = LLM_Chat.NewFileService(db, testUploadConfig(t), newTestBlobStore(t))
+
+	data := buildPNG(t, 32, 32)
+	path := filepath.Join(t.TempDir(), "cat.png")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("写入图片失败: %v", err)
+	}
+	file := &database.UploadedFile{SessionID: "session_image", FileName: "cat.png", FilePath: path, FileSize: int64(len(data)), FileType: "image/png", IsProcessed: true}
+	if err := db.Create(file).Error; err != nil {
+		t.Fatalf("保存文件失败: %v", err)
+	}
+	missing := &database.UploadedFile{SessionID: "session_image", FileName: "gone.png", FilePath: filepath.Join(t.TempDir(), "gone.png"), FileSize: 1, FileType: "image/png", IsProcessed: true}
+	_ = db.Create(missing).Error
+
+	_, _ = service.CreateChatSession("session_image", "gpt-4o", 1)
+	parts := []database.MessagePart{
+		{Type: database.MessagePartText, Text: "图里是什么"},
+		{Type: database.MessagePartImage, FileID: file.ID, MimeType: "image/png"},
+		{Type: database.MessagePartImage, FileID: missing.ID, MimeType: "image/png"},
+	}
+	if err := service.SaveChatMessageWithParts("session_image", "user", "图里是什么", parts, 1); err != nil {
+		t.Fatalf("保存消息失败: %v", err)
+	}
+	_ = service.SaveChatMessage("session_image", "assistant", "一只猫", 1)
+
+	messages, err := service.GetRecentChatMessages("session_image", 1, 10)
+	if err != nil || len(messages) != 2 {
+		t.Fatalf("获取消息失败: %d, %v", len(messages), err)
+	}
+
+	user := messages[0]
+	if user.Content != "" || len(user.MultiContent) != 3 {
+		t.Fatalf("带图片的消息应还原为多部分内容: %+v", user)
+	}
+	if user.MultiContent[0].Text != "图里是什么" {
+		t.Errorf("文本部分错误: %+v", user.MultiContent[0])
+	}
+	want := "data:image/png;base64," + base64.StdEncoding.EncodeToString(data)
+	if image := user.MultiContent[1]; image.Type != openai.ChatMessagePartTypeImageURL || image.ImageURL == nil || image.ImageURL.URL != want {
+		t.Errorf("图片应还原为 data URL: %+v", image)
+	}
+	if placeholder := user.MultiContent[2]; placeholder.Type != openai.ChatMessagePartTypeText || placeholder.Text != "[图片已失效]" {
+		t.Errorf("读取失败的图片应以文字占位: %+v", placeholder)
+	}
+	if messages[1].Content != "一只猫" || len(messages[1].MultiContent) != 0 {
+		t.Errorf("普通消息不应变为多部分内容: %+v", messages[1])
+	}
+
+	if text := LLM_Chat.MessageText(LLM_Chat.ToChatCompletionMessage(database.ChatMessage{Role: "user", Parts: parts})); text != "图里是什么\n[图片]\n[图片]" {
+		t.Errorf("摘要等场景应以文字代替图片: %q", text)
+	}
+}
+
+// TestImageWireFormat 测试各提供商发送图片的请求格式
+func TestImageWireFormat(t *testing.T) {
+	dataURL := "data:image/png;base64," + base64.StdEncoding.EncodeToString(buildPNG(t, 16, 16))
+	_, encoded, _ := LLM_Chat.ParseDataURL(dataURL)
+	images := []openai.ChatMessagePart{LLM_Chat.NewImagePart(dataURL)}
+
+	t.Run("OpenAI", func(t *testing.T) {
+		var body map[string]interface{}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"猫"},"finish_reason":"stop"}]}`)
+		}))
+		defer server.Close()
+
+		session := LLM_Chat.NewAdvancedChatSession(LLM_Chat.NewOpenAIProvider("sk-test", server.URL), "gpt-4o", "", 0)
+		if _, err := session.SendMessage("这是什么", LLM_Chat.SendOptions{Images: images}); err != nil {
+			t.Fatalf("SendMessage() 意外返回错误: %v", err)
+		}
+
+		messages := body["messages"].([]interface{})
+		content, ok := messages[len(messages)-1].(map[string]interface{})["content"].([]interface{})
+		if !ok || len(content) != 2 {
+			t.Fatalf("用户消息应为多部分内容: %+v", messages)
+		}
+		part := content[1].(map[string]interface{})
+		if part["type"] != "image_url" || part["image_url"].(map[string]interface{})["url"] != dataURL {
+			t.Errorf("图片应以 image_url 发送: %+v", part)
+		}
+	})
+
+	t.Run("Anthropic", func(t *testing.T) {
+		var body struct {
+			Messages []struct {
+				Content []map[string]interface{} `json:"content"`
+			} `json:"messages"`
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"content":[{"type":"text","text":"猫"}],"stop_reason":"end_turn"}`)
+		}))
+		defer server.Close()
+
+		session := LLM_Chat.NewAdvancedChatSession(LLM_Chat.NewAnthropicProvider("sk-ant-test", server.URL), "claude-test", "", 0)
+		if _, err := session.SendMessage("这是什么", LLM_Chat.SendOptions{Images: images}); err != nil {
+			t.Fatalf("SendMessage() 意外返回错误: %v", err)
+		}
+
+		blocks := body.Messages[0].Content
+		if len(blocks) != 2 || blocks[0]["text"] != "这是什么" || blocks[1]["type"] != "image" {
+			t.Fatalf("图片应转为 image 块: %+v", blocks)
+		}
+		source := blocks[1]["source"].(map[string]interface{})
+		if source["type"] != "base64" || source["media_type"] != "image/png" || source["data"] != encoded {
+			t.Errorf("图片来源错误: %+v", source)
+		}
+	})
+
+	t.Run("Ollama", func(t *testing.T) {
+		var body struct {
+			Messages []struct {
+				Content string   `json:"content"`
+				Images  []string `json:"images"`
+			} `json:"messages"`
+		}
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"message":{"role":"assistant","content":"猫"},"done":true}`)
+		}))
+		defer server.Close()
+
+		provider := LLM_Chat.NewOllamaProvider(server.URL)
+		if _, err := provider.CreateChatCompletion(context.Background(), LLM_Chat.ProviderRequest{
+			Model:    "llava",
+			Messages: []openai.ChatCompletionMessage{{Role: "user", MultiContent: append([]openai.ChatMessagePart{{Type: openai.ChatMessagePartTypeText, Text: "这是什么"}}, images...)}},
+		}); err != nil {
+			t.Fatalf("CreateChatCompletion() 意外返回错误: %v", err)
+		}
+
+		if len(body.Messages) != 1 || body.Messages[0].Content != "这是什么" || len(body.Messages[0].Images) != 1 || body.Messages[0].Images[0] != encoded {
+			t.Errorf("图片应以 base64 放入 images 字段: %+v", body.Messages)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package LLM_Chat_Service
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupOwnershipRouter 创建带测试认证的路由：请求头 X-User-ID 作为当前用户
+// 用户 1 拥有会话 s1（一条消息、一个文件），用户 1、2 都配置了 gpt-4
+func setupOwnershipRouter(t *testing.T) (*gin.Engine, *database.UploadedFile) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&database.UserAPI{}, &database.ModelRoute{}, &database.ChatSession{}, &database.ChatMessage{}, &database.UploadedFile{}, &database.Blob{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+
+	chatService, _ := LLM_Chat.NewChatService(db)
+	useAPIKeyCipher(t)
+	apiService, _ := LLM_Chat.NewUserAPIService(db)
+	personaManager, _ := LLM_Chat.NewPersonaManager(&LLM_Chat.PersonaConfigs{
+		Personas: []LLM_Chat.PersonaConfig{{Name: "default", Content: "你是一个测试助手"}},
+	})
+	fileService, _ := LLM_Chat.NewFileService(db, testUploadConfig(t), newTestBlobStore(t))
+	LLM_Chat.InitSessionManager(chatService, LLM_Chat.NewCacheService(nil, false), apiService, personaManager)
+	startWorkerPool(t, database.GenerationConfig{})
+
+	for _, userID := range []uint{1, 2} {
+		if _, err := apiService.CreateAPI(userID, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: "http://127.0.0.1:1"}); err != nil {
+			t.Fatalf("创建API配置失败: %v", err)
+		}
+	}
+	if _, err := chatService.CreateChatSession("s1", "gpt-4", 1); err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+	_ = chatService.SaveChatMessage("s1", "user", "用户 1 的消息", 1)
+	file, err := fileService.StoreUpload("s1", "secret.txt", bytes.NewReader([]byte("用户 1 的文件")), 1)
+	if err != nil {
+		t.Fatalf("上传文件失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	api := router.Group("/api", func(c *gin.Context) {
+		userID, _ := strconv.Atoi(c.GetHeader("X-User-ID"))
+		c.Set("user_id", uint(userID))
+	})
+	chat := api.Group("/chat")
+	{
+		chat.POST("/message", LLM_Chat_Route.SendMessage)
+		chat.POST("/message/stream", LLM_Chat_Route.SendMessageStream)
+		chat.GET("/sessions/:session_id/messages", LLM_Chat_Route.GetSessionMessages)
+		chat.DELETE("/sessions/:session_id", LLM_Chat_Route.DeleteSession)
+		chat.GET("/sessions/:session_id/settings", LLM_Chat_Route.GetSessionSettings)
+		chat.PUT("/sessions/:session_id/settings", LLM_Chat_Route.UpdateSessionSettings)
+		chat.GET("/recover", LLM_Chat_Route.RecoverStreamResponse)
+	}
+	files := api.Group("/files")
+	{
+		files.POST("/upload", LLM_Chat_Route.UploadFile())
+		files.GET("/session/:session_id", LLM_Chat_Route.GetSessionFiles())
+		files.DELETE("/:file_id", LLM_Chat_Route.DeleteFile())
+	}
+	return router, file
+}
+
+// ownershipRequest 以指定用户身份发送请求
+func ownershipRequest(router *gin.Engine, userID uint, method, path, contentType string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	req.Header.Set("X-User-ID", strconv.Itoa(int(userID)))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// uploadForm 构造上传文件的表单
+func uploadForm(t *testing.T, sessionID string) ([]byte, string) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+	_ = writer.WriteField("session_id", sessionID)
+	part, err := writer.CreateFormFile("file", "upload.txt")
+	if err != nil {
+		t.Fatalf("构造表单失败: %v", err)
+	}
+	_, _ = part.Write([]byte("上传的内容"))
+	_ = writer.Close()
+	return buf.Bytes(), writer.FormDataContentType()
+}
+
+// TestOwnershipEnforcement 测试其他用户访问会话和文件时一律返回 404，且数据不受影响
+func TestOwnershipEnforcement(t *testing.T) {
+	router, file := setupOwnershipRouter(t)
+	const owner, other = uint(1), uint(2)
+
+	// 用户 1 的会话已加载到内存，其他用户不能借此发送消息
+	if _, err := LLM_Chat.GetSessionManager().GetOrCreateSession(owner, "s1", "gpt-4", "", ""); err != nil {
+		t.Fatalf("加载会话失败: %v", err)
+	}
+	// 用户 2 自己的会话，用于引用用户 1 的文件
+	if _, err := LLM_Chat.GetSessionManager().GetOrCreateSession(other, "s2", "gpt-4", "", ""); err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+
+	uploadBody, uploadType := uploadForm(t, "s1")
+	messageBody := func(sessionID string, fileIDs ...uint) []byte {
+		data, _ := json.Marshal(map[string]interface{}{"session_id": sessionID, "model_name": "gpt-4", "message": "你好", "file_ids": fileIDs})
+		return data
+	}
+	fileID := strconv.Itoa(int(file.ID))
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		body        []byte
+	}{
+		{"GetSessionMessages", http.MethodGet, "/api/chat/sessions/s1/messages", "", nil},
+		{"DeleteSession", http.MethodDelete, "/api/chat/sessions/s1", "", nil},
+		{"GetSessionSettings", http.MethodGet, "/api/chat/sessions/s1/settings", "", nil},
+		{"UpdateSessionSettings", http.MethodPut, "/api/chat/sessions/s1/settings", "application/json", []byte(`{"temperature":0.5}`)},
+		{"RecoverStreamResponse", http.MethodGet, "/api/chat/recover?session_id=s1", "", nil},
+		{"SendMessage", http.MethodPost, "/api/chat/message", "application/json", messageBody("s1")},
+		{"SendMessageStream", http.MethodPost, "/api/chat/message/stream", "application/json", messageBody("s1")},
+		{"SendMessage 引用其他用户的文件", http.MethodPost, "/api/chat/message", "application/json", messageBody("s2", file.ID)},
+		{"UploadFile", http.MethodPost, "/api/files/upload", uploadType, uploadBody},
+		{"GetSessionFiles", http.MethodGet, "/api/files/session/s1", "", nil},
+		{"DeleteFile", http.MethodDelete, "/api/files/" + fileID, "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := ownershipRequest(router, other, tt.method, tt.path, tt.contentType, tt.body)
+			if w.Code != http.StatusNotFound {
+				t.Errorf("其他用户访问应返回 404, 得到 %d: %s", w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// 用户 1 的数据不受影响
+	w := ownershipRequest(router, owner, http.MethodGet, "/api/chat/sessions/s1/messages", "", nil)
+	var messages struct {
+		Data []LLM_Chat_Route.MessageWithID `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &messages)
+	if w.Code != http.StatusOK || len(messages.Data) != 1 {
+		t.Errorf("会话消息不应被其他用户删除: %d, %s", w.Code, w.Body.String())
+	}
+
+	w = ownershipRequest(router, owner, http.MethodGet, "/api/files/session/s1", "", nil)
+	var files struct {
+		Data []database.UploadedFile `json:"data"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &files)
+	if w.Code != http.StatusOK || len(files.Data) != 1 {
+		t.Errorf("会话文件不应被其他用户删除或新增: %d, %s", w.Code, w.Body.String())
+	}
+
+	// 会话所有者可以正常上传和删除
+	uploadBody, uploadType = uploadForm(t, "s1")
+	if w := ownershipRequest(router, owner, http.MethodPost, "/api/files/upload", uploadType, uploadBody); w.Code != http.StatusOK {
+		t.Errorf("所有者上传文件失败: %d, %s", w.Code, w.Body.String())
+	}
+	if w := ownershipRequest(router, owner, http.MethodDelete, "/api/files/"+fileID, "", nil); w.Code != http.StatusOK {
+		t.Errorf("所有者删除文件失败: %d, %s", w.Code, w.Body.String())
+	}
+	if w := ownershipRequest(router, owner, http.MethodDelete, "/api/chat/sessions/s1", "", nil); w.Code != http.StatusOK {
+		t.Errorf("所有者删除会话失败: %d, %s", w.Code, w.Body.String())
+	}
+	if w := ownershipRequest(router, owner, http.MethodGet, "/api/chat/sessions/s1/messages", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("删除后的会话应返回 404: %d", w.Code)
+	}
+}
@@ -0,0 +1,413 @@
+package LLM_Chat_Service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// testMessages 测试用的对话消息
+var testMessages = []openai.ChatCompletionMessage{
+	{Role: "system", Content: "你是一个测试助手"},
+	{Role: "user", Content: "你好"},
+}
+
+// collectChunks 收集流式输出的所有分片
+func collectChunks(t *testing.T, provider LLM_Chat.LLMProviderInterface, req LLM_Chat.ProviderRequest) ([]string, *LLM_Chat.ProviderResponse) {
+	var chunks []string
+	resp, err := provider.CreateChatCompletionStream(context.Background(), req, func(chunk string) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletionStream() 意外返回错误: %v", err)
+	}
+	return chunks, resp
+}
+
+// TestOpenAIProvider 测试 OpenAI 兼容接口
+func TestOpenAIProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("请求路径错误: %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer sk-test" {
+			t.Errorf("Authorization 头错误: %s", r.Header.Get("Authorization"))
+		}
+
+		var body openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("解析请求失败: %v", err)
+		}
+		if body.Model != "test-model" {
+			t.Errorf("模型名称没有传递到请求中: 得到 %s", body.Model)
+		}
+
+		if body.Stream {
+			if body.StreamOptions == nil || !body.StreamOptions.IncludeUsage {
+				t.Error("流式请求应开启 include_usage")
+			}
+			w.Header().Set("Content-Type", "text/event-stream")
+			for _, chunk := range []string{"你", "好"} {
+				fmt.Fprintf(w, "data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":%q}}]}\n\n", chunk)
+			}
+			fmt.Fprint(w, "data: {\"choices\":[{\"index\":0,\"delta\":{},\"finish_reason\":\"stop\"}]}\n\n")
+			fmt.Fprint(w, "data: {\"choices\":[],\"usage\":{\"prompt_tokens\":12,\"completion_tokens\":2,\"total_tokens\":14}}\n\n")
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"你好"},"finish_reason":"stop"}],"usage":{"prompt_tokens":12,"completion_tokens":2,"total_tokens":14}}`)
+	}))
+	defer server.Close()
+
+	provider, err := LLM_Chat.NewLLMProvider(database.ProviderOpenAI, "sk-test", server.URL+"/v1")
+	if err != nil {
+		t.Fatalf("创建提供商失败: %v", err)
+	}
+	req := LLM_Chat.ProviderRequest{Model: "test-model", Messages: testMessages}
+
+	t.Run("同步请求", func(t *testing.T) {
+		resp, err := provider.CreateChatCompletion(context.Background(), req)
+		if err != nil {
+			t.Fatalf("CreateChatCompletion() 意外返回错误: %v", err)
+		}
+		if resp.Content != "你好" {
+			t.Errorf("回复内容错误: 得到 %s", resp.Content)
+		}
+		if resp.Usage != (LLM_Chat.TokenUsage{PromptTokens: 12, CompletionTokens: 2}) {
+			t.Errorf("用量错误: 得到 %+v", resp.Usage)
+		}
+	})
+
+	t.Run("流式请求", func(t *testing.T) {
+		chunks, resp := collectChunks(t, provider, req)
+		if len(chunks) != 2 || resp.Content != "你好" {
+			t.Errorf("流式回复错误: chunks=%v, content=%s", chunks, resp.Content)
+		}
+		if resp.FinishReason != "stop" {
+			t.Errorf("结束原因错误: 得到 %s", resp.FinishReason)
+		}
+		if resp.Usage != (LLM_Chat.TokenUsage{PromptTokens: 12, CompletionTokens: 2}) {
+			t.Errorf("流式用量错误: 得到 %+v", resp.Usage)
+		}
+	})
+}
+
+// TestAnthropicProvider 测试 Anthropic Messages API
+func TestAnthropicProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/messages" {
+			t.Errorf("请求路径错误: %s", r.URL.Path)
+		}
+		if r.Header.Get("x-api-key") != "sk-ant-test" {
+			t.Errorf("x-api-key 头错误: %s", r.Header.Get("x-api-key"))
+		}
+		if r.Header.Get("anthropic-version") == "" {
+			t.Error("缺少 anthropic-version 头")
+		}
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("解析请求失败: %v", err)
+		}
+		if body["model"] != "claude-test" {
+			t.Errorf("模型名称错误: 得到 %v", body["model"])
+		}
+		if body["system"] != "你是一个测试助手" {
+			t.Errorf("system 消息应放在顶层字段: 得到 %v", body["system"])
+		}
+		if messages := body["messages"].([]interface{}); len(messages) != 1 {
+			t.Errorf("messages 不应包含 system 消息: 得到 %d 条", len(messages))
+		}
+
+		if body["stream"] == true {
+			w.Header().Set("Content-Type", "text/event-stream")
+			fmt.Fprint(w, "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":12,\"output_tokens\":1}}}\n\n")
+			for _, chunk := range []string{"你", "好"} {
+				fmt.Fprintf(w, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":%q}}\n\n", chunk)
+			}
+			fmt.Fprint(w, "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":2}}\n\n")
+			fmt.Fprint(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"content":[{"type":"text","text":"你好"}],"stop_reason":"end_turn","usage":{"input_tokens":12,"output_tokens":2}}`)
+	}))
+	defer server.Close()
+
+	provider, err := LLM_Chat.NewLLMProvider(database.ProviderAnthropic, "sk-ant-test", server.URL)
+	if err != nil {
+		t.Fatalf("创建提供商失败: %v", err)
+	}
+	req := LLM_Chat.ProviderRequest{Model: "claude-test", Messages: testMessages}
+
+	t.Run("同步请求", func(t *testing.T) {
+		resp, err := provider.CreateChatCompletion(context.Background(), req)
+		if err != nil {
+			t.Fatalf("CreateChatCompletion() 意外返回错误: %v", err)
+		}
+		if resp.Content != "你好" || resp.FinishReason != "end_turn" || resp.Usage != (LLM_Chat.TokenUsage{PromptTokens: 12, CompletionTokens: 2}) {
+			t.Errorf("回复错误: content=%s, finish=%s, usage=%+v", resp.Content, resp.FinishReason, resp.Usage)
+		}
+	})
+
+	t.Run("流式请求", func(t *testing.T) {
+		chunks, resp := collectChunks(t, provider, req)
+		if len(chunks) != 2 || resp.Content != "你好" {
+			t.Errorf("流式回复错误: chunks=%v, content=%s", chunks, resp.Content)
+		}
+		if resp.Usage != (LLM_Chat.TokenUsage{PromptTokens: 12, CompletionTokens: 2}) {
+			t.Errorf("流式用量错误: 得到 %+v", resp.Usage)
+		}
+	})
+}
+
+// TestAnthropicProviderError 测试 Anthropic 错误响应
+func TestAnthropicProviderError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`)
+	}))
+	defer server.Close()
+
+	provider, _ := LLM_Chat.NewLLMProvider(database.ProviderAnthropic, "bad-key", server.URL)
+	_, err := provider.CreateChatCompletion(context.Background(), LLM_Chat.ProviderRequest{Model: "claude-test", Messages: testMessages})
+	if err == nil {
+		t.Fatal("期望返回错误，但没有")
+	}
+
+	providerErr, ok := err.(*LLM_Chat.ProviderError)
+	if !ok {
+		t.Fatalf("错误类型应为 ProviderError: 得到 %T", err)
+	}
+	if providerErr.StatusCode != http.StatusUnauthorized || !strings.Contains(providerErr.Message, "invalid x-api-key") {
+		t.Errorf("错误信息不正确: %v", providerErr)
+	}
+}
+
+// TestOllamaProvider 测试 Ollama /api/chat 接口
+func TestOllamaProvider(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/chat" {
+			t.Errorf("请求路径错误: %s", r.URL.Path)
+		}
+
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatalf("解析请求失败: %v", err)
+		}
+		if body["model"] != "llama3" {
+			t.Errorf("模型名称错误: 得到 %v", body["model"])
+		}
+
+		if body["stream"] == true {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"你"},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":"好"},"done":false}`)
+			fmt.Fprintln(w, `{"message":{"role":"assistant","content":""},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":2}`)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"message":{"role":"assistant","content":"你好"},"done":true,"done_reason":"stop","prompt_eval_count":12,"eval_count":2}`)
+	}))
+	defer server.Close()
+
+	provider, err := LLM_Chat.NewLLMProvider(database.ProviderOllama, "", server.URL)
+	if err != nil {
+		t.Fatalf("创建提供商失败: %v", err)
+	}
+	req := LLM_Chat.ProviderRequest{Model: "llama3", Messages: testMessages}
+
+	t.Run("同步请求", func(t *testing.T) {
+		resp, err := provider.CreateChatCompletion(context.Background(), req)
+		if err != nil {
+			t.Fatalf("CreateChatCompletion() 意外返回错误: %v", err)
+		}
+		if resp.Content != "你好" {
+			t.Errorf("回复内容错误: 得到 %s", resp.Content)
+		}
+	})
+
+	t.Run("流式请求", func(t *testing.T) {
+		chunks, resp := collectChunks(t, provider, req)
+		if len(chunks) != 2 || resp.Content != "你好" || resp.FinishReason != "stop" {
+			t.Errorf("流式回复错误: chunks=%v, content=%s, finish=%s", chunks, resp.Content, resp.FinishReason)
+		}
+		if resp.Usage != (LLM_Chat.TokenUsage{PromptTokens: 12, CompletionTokens: 2}) {
+			t.Errorf("流式用量错误: 得到 %+v", resp.Usage)
+		}
+	})
+}
+
+// TestNewLLMProvider 测试提供商选择
+func TestNewLLMProvider(t *testing.T) {
+	tests := []struct {
+		name     string
+		provider string
+		wantName string
+		wantErr  bool
+	}{
+		{name: "空值默认为 OpenAI", provider: "", wantName: database.ProviderOpenAI},
+		{name: "Anthropic", provider: "anthropic", wantName: database.ProviderAnthropic},
+		{name: "Ollama（大小写不敏感）", provider: "Ollama", wantName: database.ProviderOllama},
+		{name: "不支持的提供商", provider: "unknown", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := LLM_Chat.NewLLMProvider(tt.provider, "key", "")
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("NewLLMProvider() 期望返回错误，但没有")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewLLMProvider() 意外返回错误: %v", err)
+			}
+			if provider.Name() != tt.wantName {
+				t.Errorf("提供商错误: 得到 %s, 期望 %s", provider.Name(), tt.wantName)
+			}
+		})
+	}
+}
+
+// TestSessionCreatorUsesConfiguredModel 测试会话使用 API 配置中的模型名称，而不是写死的模型
+func TestSessionCreatorUsesConfiguredModel(t *testing.T) {
+	var gotModel string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body openai.ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		gotModel = body.Model
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	creator := &LLM_Chat.DefaultSessionCreator{}
+	session, err := creator.CreateSession(LLM_Chat.ProviderConfig{
+		Provider:  database.ProviderOpenAI,
+		APIKey:    "sk-test",
+		BaseURL:   server.URL,
+		ModelName: "qwen-max",
+	}, "你是一个测试助手")
+	if err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+
+	if _, err := session.SendMessage("你好", LLM_Chat.SendOptions{}); err != nil {
+		t.Fatalf("SendMessage() 意外返回错误: %v", err)
+	}
+	if gotModel != "qwen-max" {
+		t.Errorf("请求中的模型名称错误: 得到 %s, 期望 qwen-max", gotModel)
+	}
+}
+
+// TestGenerationParamsForwarded 测试生成参数被转发到各提供商的请求中
+func TestGenerationParamsForwarded(t *testing.T) {
+	temperature := float32(0)
+	topP := float32(0.9)
+	maxTokens := 256
+	seed := 42
+	params := database.GenerationParams{
+		Temperature: &temperature,
+		TopP:        &topP,
+		MaxTokens:   &maxTokens,
+		Stop:        []string{"END"},
+		Seed:        &seed,
+	}
+
+	var got map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&got)
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/messages":
+			fmt.Fprint(w, `{"content":[{"type":"text","text":"ok"}],"stop_reason":"end_turn"}`)
+		case "/api/chat":
+			fmt.Fprint(w, `{"message":{"role":"assistant","content":"ok"},"done":true}`)
+		default:
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"ok"},"finish_reason":"stop"}]}`)
+		}
+	}))
+	defer server.Close()
+
+	req := LLM_Chat.ProviderRequest{Model: "m", Messages: testMessages, Params: params}
+
+	t.Run("OpenAI", func(t *testing.T) {
+		provider, _ := LLM_Chat.NewLLMProvider(database.ProviderOpenAI, "k", server.URL)
+		if _, err := provider.CreateChatCompletion(context.Background(), req); err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		if _, ok := got["temperature"]; !ok {
+			t.Error("temperature=0 应该被显式发送")
+		}
+		if got["max_tokens"] != float64(256) || got["seed"] != float64(42) {
+			t.Errorf("max_tokens/seed 错误: %v / %v", got["max_tokens"], got["seed"])
+		}
+	})
+
+	t.Run("Anthropic", func(t *testing.T) {
+		provider, _ := LLM_Chat.NewLLMProvider(database.ProviderAnthropic, "k", server.URL)
+		if _, err := provider.CreateChatCompletion(context.Background(), req); err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		if got["max_tokens"] != float64(256) || got["temperature"] != float64(0) {
+			t.Errorf("max_tokens/temperature 错误: %v / %v", got["max_tokens"], got["temperature"])
+		}
+		if stop, ok := got["stop_sequences"].([]interface{}); !ok || len(stop) != 1 {
+			t.Errorf("stop_sequences 错误: %v", got["stop_sequences"])
+		}
+	})
+
+	t.Run("Ollama", func(t *testing.T) {
+		provider, _ := LLM_Chat.NewLLMProvider(database.ProviderOllama, "", server.URL)
+		if _, err := provider.CreateChatCompletion(context.Background(), req); err != nil {
+			t.Fatalf("请求失败: %v", err)
+		}
+		options, ok := got["options"].(map[string]interface{})
+		if !ok {
+			t.Fatalf("缺少 options 字段: %v", got)
+		}
+		if options["num_predict"] != float64(256) || options["seed"] != float64(42) {
+			t.Errorf("num_predict/seed 错误: %v / %v", options["num_predict"], options["seed"])
+		}
+	})
+}
+
+// TestMergeGenerationParams 测试会话参数与单条消息参数的合并
+func TestMergeGenerationParams(t *testing.T) {
+	sessionTemp := float32(0.7)
+	messageTemp := float32(0.2)
+	maxTokens := 100
+
+	base := database.GenerationParams{Temperature: &sessionTemp, MaxTokens: &maxTokens, Stop: []string{"A"}}
+	merged := LLM_Chat.MergeGenerationParams(base, &database.GenerationParams{Temperature: &messageTemp})
+
+	if *merged.Temperature != messageTemp {
+		t.Errorf("temperature 应被单条消息覆盖: 得到 %v", *merged.Temperature)
+	}
+	if merged.MaxTokens == nil || *merged.MaxTokens != maxTokens {
+		t.Error("未覆盖的 max_tokens 应保留会话值")
+	}
+	if len(merged.Stop) != 1 || merged.Stop[0] != "A" {
+		t.Errorf("未覆盖的 stop 应保留会话值: 得到 %v", merged.Stop)
+	}
+	if *base.Temperature != sessionTemp {
+		t.Error("合并不应修改原始参数")
+	}
+}
@@ -0,0 +1,173 @@
+package LLM_Chat_Service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	AuthRoute "platfrom/Route/Auth"
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/Auth"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// newUpstreamServer 模拟上游 OpenAI 兼容接口：校验密钥，按 stream 返回 JSON 或 SSE。
+// 和 OpenAI 一样，流式响应只在 stream_options.include_usage 为 true 时返回 usage
+func newUpstreamServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" || r.Header.Get("Authorization") != "Bearer sk-upstream" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"message":"bad key"}}`)
+			return
+		}
+		var body struct {
+			Model         string `json:"model"`
+			Stream        bool   `json:"stream"`
+			StreamOptions struct {
+				IncludeUsage bool `json:"include_usage"`
+			} `json:"stream_options"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+
+		if !body.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"model":%q,"choices":[{"index":0,"message":{"role":"assistant","content":"你好"}}],"usage":{"prompt_tokens":3,"completion_tokens":2,"total_tokens":5}}`, body.Model)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"choices":[{"index":0,"delta":{"content":"你"}}]}`,
+			`{"choices":[{"index":0,"delta":{"content":"好"}}]}`,
+		}
+		if body.StreamOptions.IncludeUsage {
+			chunks = append(chunks, `{"choices":[],"usage":{"prompt_tokens":4,"completion_tokens":2,"total_tokens":6}}`)
+		}
+		for _, chunk := range chunks {
+			fmt.Fprintf(w, "data: %s\n\n", chunk)
+			w.(http.Flusher).Flush()
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestOpenAIProxy 测试 /v1 接口的令牌认证、按模型转发（含流式）、模型列表和用量记录
+func TestOpenAIProxy(t *testing.T) {
+	upstream := newUpstreamServer(t)
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	if err := db.AutoMigrate(&database.UserAPI{}, &database.APIToken{}, &database.ProxyUsageLog{}, &database.UsageDaily{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+
+	useAPIKeyCipher(t)
+	apiService, _ := LLM_Chat.NewUserAPIService(db)
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "up", APIKey: "sk-upstream", ModelName: "gpt-4", BaseURL: upstream.URL + "/v1"}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+	if _, err := LLM_Chat.NewProxyService(db, apiService); err != nil {
+		t.Fatalf("创建网关服务失败: %v", err)
+	}
+	tokenService, _ := Auth.NewAPITokenService(db)
+	record, token, err := tokenService.CreateToken(1, "test")
+	if err != nil {
+		t.Fatalf("创建令牌失败: %v", err)
+	}
+	_, otherToken, _ := tokenService.CreateToken(2, "other")
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	v1 := router.Group("/v1", AuthRoute.APITokenMiddleware())
+	v1.POST("/chat/completions", LLM_Chat_Route.ProxyRateLimitMiddleware(), LLM_Chat_Route.ProxyChatCompletions)
+	v1.GET("/models", LLM_Chat_Route.ProxyListModels)
+	do := func(method, path, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do(http.MethodGet, "/v1/models", "", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("缺少令牌应返回 401: %d", w.Code)
+	}
+	if w := do(http.MethodGet, "/v1/models", "sk-plat-bad", ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("无效令牌应返回 401: %d", w.Code)
+	}
+
+	w := do(http.MethodGet, "/v1/models", token, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"id":"gpt-4"`) {
+		t.Errorf("模型列表错误: %d, %s", w.Code, w.Body.String())
+	}
+
+	w = do(http.MethodPost, "/v1/chat/completions", token, `{"model":"gpt-4","messages":[{"role":"user","content":"hi"}]}`)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "你好") {
+		t.Fatalf("非流式转发失败: %d, %s", w.Code, w.Body.String())
+	}
+
+	// 客户端没有要求用量：网关仍向上游要求用量以便记账，但不转发只含用量的事件
+	w = do(http.MethodPost, "/v1/chat/completions", token, `{"model":"gpt-4","stream":true,"messages":[{"role":"user","content":"hi"}]}`)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "text/event-stream" || strings.Count(w.Body.String(), "data: ") != 3 || strings.Contains(w.Body.String(), "usage") {
+		t.Fatalf("流式转发失败: %d, %s", w.Code, w.Body.String())
+	}
+
+	// 客户端自己要求用量时原样转发
+	w = do(http.MethodPost, "/v1/chat/completions", token, `{"model":"gpt-4","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"hi"}]}`)
+	if w.Code != http.StatusOK || strings.Count(w.Body.String(), "data: ") != 4 || !strings.Contains(w.Body.String(), `"total_tokens":6`) {
+		t.Fatalf("流式转发失败: %d, %s", w.Code, w.Body.String())
+	}
+
+	// 其他用户没有配置该模型
+	if w := do(http.MethodPost, "/v1/chat/completions", otherToken, `{"model":"gpt-4","messages":[]}`); w.Code != http.StatusNotFound {
+		t.Errorf("未配置的模型应返回 404: %d", w.Code)
+	}
+	if w := do(http.MethodPost, "/v1/chat/completions", token, `not json`); w.Code != http.StatusBadRequest {
+		t.Errorf("非法请求应返回 400: %d", w.Code)
+	}
+
+	var logs []database.ProxyUsageLog
+	db.Order("id").Find(&logs)
+	if len(logs) != 3 {
+		t.Fatalf("应记录三次调用: %+v", logs)
+	}
+	if logs[0].TotalTokens != 5 || logs[0].Stream || logs[0].TokenID != record.ID || logs[0].StatusCode != http.StatusOK {
+		t.Errorf("非流式用量错误: %+v", logs[0])
+	}
+	for _, streamLog := range logs[1:] {
+		if streamLog.PromptTokens != 4 || streamLog.TotalTokens != 6 || !streamLog.Stream {
+			t.Errorf("流式用量错误: %+v", streamLog)
+		}
+	}
+	var daily database.UsageDaily
+	db.Where("user_id = ?", 1).First(&daily)
+	if daily.PromptTokens+daily.CompletionTokens != 17 || daily.Requests != 3 {
+		t.Errorf("流式调用的用量应计入每日汇总: %+v", daily)
+	}
+
+	// 上游错误原样返回
+	if err := apiService.UpdateAPI(1, map[string]interface{}{"api_key": "sk-wrong"}); err != nil {
+		t.Fatalf("更新API配置失败: %v", err)
+	}
+	w = do(http.MethodPost, "/v1/chat/completions", token, `{"model":"gpt-4","messages":[]}`)
+	body, _ := io.ReadAll(w.Body)
+	if w.Code != http.StatusUnauthorized || !strings.Contains(string(body), "bad key") {
+		t.Errorf("上游错误应原样返回: %d, %s", w.Code, body)
+	}
+}
@@ -0,0 +1,273 @@
+package LLM_Chat_Service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupRateLimiter 创建使用进程内计数的限流服务，用户 1 为访客、2 为普通用户、3 为管理员
+func setupRateLimiter(t *testing.T, config database.RateLimitConfig) (LLM_Chat.RateLimiterInterface, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	if err := db.AutoMigrate(&database.User{}, &database.UserRateLimit{}, &database.UsageDaily{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	for i, role := range []database.Role{database.RoleGuest, database.RoleUser, database.RoleAdmin} {
+		user := database.User{ID: uint(i + 1), Username: string(role), PasswordHash: "x", Role: role}
+		if err := db.Create(&user).Error; err != nil {
+			t.Fatalf("创建用户失败: %v", err)
+		}
+	}
+
+	previous := LLM_Chat.GlobalRateLimiter
+	limiter, err := LLM_Chat.NewRateLimiter(db, nil, config)
+	if err != nil {
+		t.Fatalf("创建限流服务失败: %v", err)
+	}
+	t.Cleanup(func() { LLM_Chat.GlobalRateLimiter = previous })
+	return limiter, db
+}
+
+// rateLimitScope 返回错误对应的限制种类，不是限流错误时返回空字符串
+func rateLimitScope(err error) string {
+	var limited *LLM_Chat.RateLimitError
+	if errors.As(err, &limited) {
+		return limited.Scope
+	}
+	return ""
+}
+
+func intPtr(v int) *int { return &v }
+
+// TestRateLimiter 测试按角色的每分钟请求数、每日 token 配额、并发数和管理员覆盖
+func TestRateLimiter(t *testing.T) {
+	limiter, db := setupRateLimiter(t, database.RateLimitConfig{Roles: map[database.Role]database.RateLimits{
+		database.RoleGuest: {RequestsPerMinute: 2, ConcurrentStreams: 1, DailyTokens: 100},
+		database.RoleUser:  {RequestsPerMinute: 3, ConcurrentStreams: 2},
+	}})
+
+	t.Run("每分钟请求数按角色限制", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if _, err := limiter.AllowRequest(1); err != nil {
+				t.Fatalf("第 %d 次请求不应被限制: %v", i+1, err)
+			}
+		}
+		status, err := limiter.AllowRequest(1)
+		if rateLimitScope(err) != LLM_Chat.RateLimitRequests || !errors.Is(err, LLM_Chat.ErrRateLimited) {
+			t.Fatalf("访客第 3 次请求应被限制: %v", err)
+		}
+		if status == nil || status.Requests != 3 || status.Role != database.RoleGuest {
+			t.Errorf("被限制时也应返回状态: %+v", status)
+		}
+
+		// 管理员未在配置中列出，使用默认值（不限制）
+		for i := 0; i < 10; i++ {
+			if _, err := limiter.AllowRequest(3); err != nil {
+				t.Fatalf("管理员不应被限制: %v", err)
+			}
+		}
+	})
+
+	t.Run("每日 token 配额", func(t *testing.T) {
+		db.Create(&database.UsageDaily{Date: time.Now().Format("2006-01-02"), UserID: 1, Requests: 1, PromptTokens: 80, CompletionTokens: 20})
+		status, err := limiter.Status(1)
+		if err != nil || status.TokensUsed != 100 {
+			t.Fatalf("今日用量错误: %+v, %v", status, err)
+		}
+		if _, err := limiter.AllowRequest(1); rateLimitScope(err) != LLM_Chat.RateLimitTokens {
+			t.Errorf("用完配额后应被限制: %v", err)
+		}
+		// 普通用户没有 token 配额
+		db.Create(&database.UsageDaily{Date: time.Now().Format("2006-01-02"), UserID: 2, Requests: 1, PromptTokens: 1 << 30})
+		if _, err := limiter.AllowRequest(2); err != nil {
+			t.Errorf("未设置配额时不应限制: %v", err)
+		}
+	})
+
+	t.Run("并发数", func(t *testing.T) {
+		release, err := limiter.AcquireStream(1)
+		if err != nil {
+			t.Fatalf("占用并发名额失败: %v", err)
+		}
+		if _, err := limiter.AcquireStream(1); rateLimitScope(err) != LLM_Chat.RateLimitStreams {
+			t.Fatalf("超过并发数应被限制: %v", err)
+		}
+		if status, _ := limiter.Status(1); status.Streams != 1 {
+			t.Errorf("被拒绝的请求不应占用名额: %d", status.Streams)
+		}
+		release()
+		release() // 重复释放不应多减
+		again, err := limiter.AcquireStream(1)
+		if err != nil {
+			t.Fatalf("释放后应可再次占用: %v", err)
+		}
+		if status, _ := limiter.Status(1); status.Streams != 1 {
+			t.Errorf("重复释放后计数错误: %d", status.Streams)
+		}
+		again()
+	})
+
+	t.Run("管理员覆盖", func(t *testing.T) {
+		if err := limiter.SetUserOverride(&database.UserRateLimit{UserID: 1, RequestsPerMinute: intPtr(0), ConcurrentStreams: intPtr(3)}); err != nil {
+			t.Fatalf("设置覆盖失败: %v", err)
+		}
+		status, _ := limiter.Status(1)
+		if status.Limits.RequestsPerMinute != 0 || status.Limits.ConcurrentStreams != 3 || status.Limits.DailyTokens != 100 {
+			t.Errorf("覆盖的字段应生效，其余使用角色的限制: %+v", status.Limits)
+		}
+
+		if err := limiter.SetUserOverride(&database.UserRateLimit{UserID: 1, ConcurrentStreams: intPtr(2)}); err != nil {
+			t.Fatalf("更新覆盖失败: %v", err)
+		}
+		if status, _ := limiter.Status(1); status.Limits.RequestsPerMinute != 2 || status.Limits.ConcurrentStreams != 2 {
+			t.Errorf("覆盖应整体替换: %+v", status.Limits)
+		}
+
+		if err := limiter.DeleteUserOverride(1); err != nil {
+			t.Fatalf("删除覆盖失败: %v", err)
+		}
+		if override, err := limiter.GetUserOverride(1); err != nil || override != nil {
+			t.Errorf("删除后不应有覆盖: %+v, %v", override, err)
+		}
+
+		if err := limiter.SetUserOverride(&database.UserRateLimit{UserID: 99, RequestsPerMinute: intPtr(1)}); !errors.Is(err, LLM_Chat.ErrRateLimitUserNotFound) {
+			t.Errorf("不存在的用户应返回 ErrRateLimitUserNotFound: %v", err)
+		}
+		if err := limiter.SetUserOverride(&database.UserRateLimit{UserID: 1, RequestsPerMinute: intPtr(-1)}); err == nil {
+			t.Error("负数的限制应被拒绝")
+		}
+	})
+
+	t.Run("提交生成任务占用并发名额", func(t *testing.T) {
+		LLM_Chat.NewCacheService(nil, false)
+		pool := LLM_Chat.InitGenerationWorkerPool(database.GenerationConfig{Workers: 1, MaxConcurrentPerUser: 5}, stalledQueue{})
+		t.Cleanup(pool.Shutdown)
+
+		if err := pool.Submit(LLM_Chat.NewGenerationJob(LLM_Chat.JobSend, "rate_a", 1)); err != nil {
+			t.Fatalf("提交任务失败: %v", err)
+		}
+		if err := pool.Submit(LLM_Chat.NewGenerationJob(LLM_Chat.JobSend, "rate_b", 1)); rateLimitScope(err) != LLM_Chat.RateLimitStreams {
+			t.Fatalf("超过角色的并发数应被限制: %v", err)
+		}
+
+		_, _ = LLM_Chat.GlobalCacheService.AppendStreamEvent("rate_a", LLM_Chat.StreamEvent{Data: "{}", Done: true})
+		waitGenerationDone(t, "rate_a")
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			if status, _ := limiter.Status(1); status.Streams == 0 {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatal("生成结束后应释放并发名额")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// TestRateLimitMiddleware 测试限流中间件的 429 响应和响应头
+func TestRateLimitMiddleware(t *testing.T) {
+	setupRateLimiter(t, database.RateLimitConfig{Roles: map[database.Role]database.RateLimits{
+		database.RoleGuest: {RequestsPerMinute: 1, DailyTokens: 1000},
+	}})
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	router.POST("/chat/message", LLM_Chat_Route.RateLimitMiddleware(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/chat/message", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("第一次请求应通过: %d", w.Code)
+	}
+	if w.Header().Get("X-RateLimit-Limit-Requests") != "1" || w.Header().Get("X-RateLimit-Remaining-Requests") != "0" ||
+		w.Header().Get("X-RateLimit-Remaining-Tokens") != "1000" {
+		t.Errorf("响应头错误: %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/chat/message", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("超出限制应返回 429 和 Retry-After: %d, %v", w.Code, w.Header())
+	}
+	var body struct {
+		Limit      string `json:"limit"`
+		RetryAfter int64  `json:"retry_after"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Limit != LLM_Chat.RateLimitRequests || body.RetryAfter < 1 || body.RetryAfter > 60 {
+		t.Errorf("响应体错误: %s", w.Body.String())
+	}
+}
+
+// TestProxyRateLimitMiddleware 测试 /v1 网关按令牌所属用户限流和限制并发，并按 OpenAI 的格式返回 429
+func TestProxyRateLimitMiddleware(t *testing.T) {
+	setupRateLimiter(t, database.RateLimitConfig{Roles: map[database.Role]database.RateLimits{
+		database.RoleGuest: {RequestsPerMinute: 2, ConcurrentStreams: 1},
+	}})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	router.POST("/v1/chat/completions", LLM_Chat_Route.ProxyRateLimitMiddleware(), func(c *gin.Context) {
+		if c.Query("block") != "" {
+			close(started)
+			<-release
+		}
+		c.JSON(http.StatusOK, gin.H{"ok": true})
+	})
+	do := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, nil))
+		return w
+	}
+	var body struct {
+		Error struct {
+			Type string `json:"type"`
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+
+	// 第一个请求进行中时占用唯一的并发名额
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- do("/v1/chat/completions?block=1") }()
+	<-started
+	w := do("/v1/chat/completions")
+	close(release)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("并发超出限制应返回 429: %d, %s", w.Code, w.Body.String())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Type != "rate_limit_error" || body.Error.Code != LLM_Chat.RateLimitStreams {
+		t.Errorf("并发限制的响应体错误: %s", w.Body.String())
+	}
+	if first := <-done; first.Code != http.StatusOK {
+		t.Fatalf("第一个请求应通过: %d", first.Code)
+	}
+
+	// 两次请求已计入每分钟请求数
+	w = do("/v1/chat/completions")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("X-RateLimit-Limit-Requests") != "2" {
+		t.Fatalf("超出每分钟请求数应返回 429: %d, %v", w.Code, w.Header())
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || body.Error.Code != LLM_Chat.RateLimitRequests {
+		t.Errorf("请求数限制的响应体错误: %s", w.Body.String())
+	}
+}
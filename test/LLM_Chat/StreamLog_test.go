@@ -0,0 +1,186 @@
+package LLM_Chat_Service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestMemoryStreamLog 测试 Redis 不可用时的事件日志：按事件ID续读、阻塞等待新事件、容量上限和清空
+func TestMemoryStreamLog(t *testing.T) {
+	cache := LLM_Chat.NewCacheService(nil, false)
+	ctx := context.Background()
+
+	var ids []string
+	for _, data := range []string{"a", "b", "c"} {
+		id, err := cache.AppendStreamEvent("s1", LLM_Chat.StreamEvent{Data: data})
+		if err != nil {
+			t.Fatalf("追加事件失败: %v", err)
+		}
+		ids = append(ids, id)
+	}
+
+	events, err := cache.ReadStreamEvents(ctx, "s1", ids[0], 0)
+	if err != nil || len(events) != 2 || events[0].Data != "b" || events[1].ID != ids[2] {
+		t.Fatalf("应返回之后的事件: %+v, %v", events, err)
+	}
+	if events, _ := cache.ReadStreamEvents(ctx, "s1", "", 0); len(events) != 3 {
+		t.Errorf("没有事件ID时应从头读取: %d", len(events))
+	}
+	if _, err := cache.ReadStreamEvents(ctx, "s1", "abc", 0); !errors.Is(err, LLM_Chat.ErrInvalidEventID) {
+		t.Errorf("非法事件ID应返回 ErrInvalidEventID: %v", err)
+	}
+
+	// 没有新事件时阻塞，新事件到达后立即返回
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = cache.AppendStreamEvent("s1", LLM_Chat.StreamEvent{Data: "d", Done: true})
+	}()
+	start := time.Now()
+	events, err = cache.ReadStreamEvents(ctx, "s1", ids[2], 5*time.Second)
+	if err != nil || len(events) != 1 || !events[0].Done || time.Since(start) > 2*time.Second {
+		t.Fatalf("应等待并返回新事件: %+v, %v", events, err)
+	}
+	if events, _ := cache.ReadStreamEvents(ctx, "s1", events[0].ID, 20*time.Millisecond); len(events) != 0 {
+		t.Errorf("等待超时应返回空: %+v", events)
+	}
+
+	// 超出容量后只保留最近的事件
+	for i := 0; i < 5000; i++ {
+		_, _ = cache.AppendStreamEvent("s2", LLM_Chat.StreamEvent{Data: fmt.Sprint(i)})
+	}
+	events, _ = cache.ReadStreamEvents(ctx, "s2", "", 0)
+	if len(events) != 4096 || events[0].Data != "904" || events[len(events)-1].Data != "4999" {
+		t.Errorf("环形缓冲应保留最近 4096 条: %d, %s..%s", len(events), events[0].Data, events[len(events)-1].Data)
+	}
+
+	if err := cache.ResetStreamEvents("s1"); err != nil {
+		t.Fatalf("清空事件失败: %v", err)
+	}
+	if events, _ := cache.ReadStreamEvents(ctx, "s1", "", 0); len(events) != 0 {
+		t.Errorf("清空后不应有事件: %d", len(events))
+	}
+}
+
+// sseEvents 解析 SSE 响应体中的事件，返回每个事件的 id 和 data
+func sseEvents(body string) (ids, data []string) {
+	for _, block := range strings.Split(body, "\n\n") {
+		var id, payload string
+		for _, line := range strings.Split(block, "\n") {
+			if strings.HasPrefix(line, "id: ") {
+				id = strings.TrimPrefix(line, "id: ")
+			} else if strings.HasPrefix(line, "data: ") {
+				payload = strings.TrimPrefix(line, "data: ")
+			}
+		}
+		if payload != "" {
+			ids = append(ids, id)
+			data = append(data, payload)
+		}
+	}
+	return ids, data
+}
+
+// TestResumeStream 测试流式事件带ID，断线后按 Last-Event-ID 补发并接上仍在进行的生成
+func TestResumeStream(t *testing.T) {
+	server := newStreamLLMServer(t)
+	_, chatService, apiService := setupSessionManager(t)
+
+	const sessionID = "session_resume"
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: server.URL}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chat := router.Group("/api/chat", func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	chat.POST("/message/stream", LLM_Chat_Route.SendMessageStream)
+	chat.GET("/recover", LLM_Chat_Route.RecoverStreamResponse)
+	recoverStream := func(lastEventID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/chat/recover?session_id="+sessionID, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := recoverStream(""); w.Code != http.StatusNotFound {
+		t.Errorf("没有缓存的响应时应返回 404: %d", w.Code)
+	}
+
+	server.set(true, "一", "二")
+	original := make(chan *httptest.ResponseRecorder)
+	go func() {
+		req := httptest.NewRequest(http.MethodPost, "/api/chat/message/stream", strings.NewReader(`{"session_id":"session_resume","model_name":"gpt-4","message":"问题"}`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		original <- w
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if generation, ok := LLM_Chat.GlobalGenerationRegistry.Get(sessionID); ok && generation.Content() == "一二" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("等待流式生成超时")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	logged, _ := LLM_Chat.GlobalCacheService.ReadStreamEvents(context.Background(), sessionID, "", 0)
+	if len(logged) != 2 {
+		t.Fatalf("应记录两个事件: %+v", logged)
+	}
+
+	// 客户端在收到第一个片段后断开，重连时补发第二个片段并继续接收
+	resumed := make(chan *httptest.ResponseRecorder)
+	go func() { resumed <- recoverStream(logged[0].ID) }()
+	time.Sleep(50 * time.Millisecond)
+	server.release("三")
+
+	w := <-resumed
+	ids, data := sseEvents(w.Body.String())
+	if w.Code != http.StatusOK || len(data) != 3 || ids[0] != logged[1].ID {
+		t.Fatalf("重连应从第二个片段开始: %d, %s", w.Code, w.Body.String())
+	}
+	if !strings.Contains(data[0], `"二"`) || !strings.Contains(data[1], `"三"`) || !strings.Contains(data[2], `"done":true`) {
+		t.Errorf("补发内容错误: %v", data)
+	}
+
+	ids, data = sseEvents((<-original).Body.String())
+	for i, id := range ids {
+		if id == "" {
+			t.Errorf("每个事件都应带有ID: %s", data[i])
+		}
+	}
+	if len(data) != 4 {
+		t.Errorf("原始连接应收到全部事件: %v", data)
+	}
+
+	// 生成结束后仍可完整补发
+	if _, data := sseEvents(recoverStream("").Body.String()); len(data) != 4 {
+		t.Errorf("结束后应能补发全部事件: %v", data)
+	}
+	if w := recoverStream("bad-id"); w.Code != http.StatusBadRequest {
+		t.Errorf("非法的 Last-Event-ID 应返回 400: %d", w.Code)
+	}
+
+	// 保存的回复只包含一份内容
+	if got := fmt.Sprint(pathContents(t, chatService, sessionID, 1)); got != "[问题 一二三]" {
+		t.Errorf("保存的回复错误: %s", got)
+	}
+}
@@ -0,0 +1,212 @@
+package LLM_Chat_Service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/glebarez/sqlite"
+	"github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+)
+
+// mockLLMServer 记录收到的请求并返回固定回复的 OpenAI 兼容服务
+type mockLLMServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []openai.ChatCompletionRequest
+}
+
+func newMockLLMServer(t *testing.T, reply string) *mockLLMServer {
+	m := &mockLLMServer{}
+	m.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("解析请求失败: %v", err)
+		}
+		m.mu.Lock()
+		m.requests = append(m.requests, body)
+		m.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":%q},"finish_reason":"stop"}]}`, reply)
+	}))
+	t.Cleanup(m.Close)
+	return m
+}
+
+func (m *mockLLMServer) lastRequest() openai.ChatCompletionRequest {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.requests[len(m.requests)-1]
+}
+
+// setupSessionManager 初始化会话管理器及其依赖（SQLite 内存数据库、无 Redis）
+func setupSessionManager(t *testing.T) (*LLM_Chat.SessionManager, LLM_Chat.ChatServiceInterface, LLM_Chat.UserAPIServiceInterface) {
+	manager, chatService, apiService, _ := setupSessionManagerWithDB(t)
+	return manager, chatService, apiService
+}
+
+// setupSessionManagerWithDB 同 setupSessionManager，额外返回数据库供需要直接查询的测试使用
+func setupSessionManagerWithDB(t *testing.T) (*LLM_Chat.SessionManager, LLM_Chat.ChatServiceInterface, LLM_Chat.UserAPIServiceInterface, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	// 内存数据库每个连接相互独立，限制为单连接
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	if err := db.AutoMigrate(&database.UserAPI{}, &database.ModelRoute{}, &database.ChatSession{}, &database.ChatMessage{}, &database.UsageDaily{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+
+	chatService, _ := LLM_Chat.NewChatService(db)
+	useAPIKeyCipher(t)
+	apiService, _ := LLM_Chat.NewUserAPIService(db)
+	personaManager, err := LLM_Chat.NewPersonaManager(&LLM_Chat.PersonaConfigs{
+		Personas: []LLM_Chat.PersonaConfig{{Name: "default", Content: "你是一个测试助手"}},
+	})
+	if err != nil {
+		t.Fatalf("创建人格管理器失败: %v", err)
+	}
+
+	LLM_Chat.InitSessionManager(chatService, LLM_Chat.NewCacheService(nil, false), apiService, personaManager)
+	startWorkerPool(t, database.GenerationConfig{})
+	return LLM_Chat.GetSessionManager(), chatService, apiService, db
+}
+
+// TestSummarizeIfNeeded 测试滚动摘要的生成、存储和注入
+func TestSummarizeIfNeeded(t *testing.T) {
+	server := newMockLLMServer(t, "这是对话摘要")
+	manager, chatService, apiService := setupSessionManager(t)
+
+	const userID, sessionID = uint(1), "session_summary"
+	if _, err := apiService.CreateAPI(userID, &database.UserAPI{
+		APIName:       "test",
+		APIKey:        "sk-test",
+		ModelName:     "gpt-4",
+		BaseURL:       server.URL,
+		ContextBudget: 1000,
+	}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+	if _, err := chatService.CreateChatSession(sessionID, "gpt-4", userID); err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+
+	// 20 条消息，每条约 100 token，超过预算 1000 的触发阈值
+	for i := 0; i < 10; i++ {
+		_ = chatService.SaveChatMessage(sessionID, "user", fmt.Sprintf("问题%d%s", i, strings.Repeat("问", 96)), userID)
+		_ = chatService.SaveChatMessage(sessionID, "assistant", fmt.Sprintf("回答%d%s", i, strings.Repeat("是", 96)), userID)
+	}
+
+	t.Run("未开启时不生成摘要", func(t *testing.T) {
+		done, err := manager.SummarizeIfNeeded(context.Background(), userID, sessionID)
+		if err != nil || done {
+			t.Fatalf("未开启摘要时不应生成: done=%v, err=%v", done, err)
+		}
+	})
+
+	if err := chatService.UpdateSessionSummaryEnabled(sessionID, userID, true); err != nil {
+		t.Fatalf("开启摘要失败: %v", err)
+	}
+
+	t.Run("超过阈值时生成摘要", func(t *testing.T) {
+		done, err := manager.SummarizeIfNeeded(context.Background(), userID, sessionID)
+		if err != nil || !done {
+			t.Fatalf("应生成摘要: done=%v, err=%v", done, err)
+		}
+
+		summary, err := chatService.GetLatestSummary(sessionID, userID)
+		if err != nil || summary == nil {
+			t.Fatalf("摘要未保存: %v", err)
+		}
+		if summary.Content != "这是对话摘要" || summary.SummarizedUntilID == 0 {
+			t.Errorf("摘要内容错误: %+v", summary)
+		}
+
+		req := server.lastRequest()
+		if !strings.Contains(req.Messages[1].Content, "问题0") {
+			t.Error("摘要请求应包含最早的对话")
+		}
+		if strings.Contains(req.Messages[1].Content, "问题9") {
+			t.Error("最近的对话应保留原文，不参与摘要")
+		}
+	})
+
+	t.Run("消息列表仍返回完整历史", func(t *testing.T) {
+		messages, _, _, err := chatService.GetChatMessages(sessionID, userID, 0, 100)
+		if err != nil {
+			t.Fatalf("获取消息失败: %v", err)
+		}
+		if len(messages) != 20 {
+			t.Errorf("应返回全部 20 条原始消息: 得到 %d 条", len(messages))
+		}
+		for _, msg := range messages {
+			if msg.Role == database.MessageRoleSummary {
+				t.Error("消息列表不应包含摘要")
+			}
+		}
+
+		recent, _ := chatService.GetRecentChatMessages(sessionID, userID, 100)
+		if len(recent) == 0 || len(recent) >= 20 {
+			t.Errorf("恢复会话时应只加载摘要之后的消息: 得到 %d 条", len(recent))
+		}
+		if recent[0].Role != "user" {
+			t.Errorf("摘要之后的历史应从用户消息开始: 得到 %s", recent[0].Role)
+		}
+	})
+
+	t.Run("摘要注入到系统提示词之后", func(t *testing.T) {
+		session, err := manager.GetOrCreateSession(userID, sessionID, "gpt-4", "", "")
+		if err != nil {
+			t.Fatalf("获取会话失败: %v", err)
+		}
+		if _, err := session.SendMessage("继续", LLM_Chat.SendOptions{}); err != nil {
+			t.Fatalf("发送消息失败: %v", err)
+		}
+
+		req := server.lastRequest()
+		if req.Messages[0].Content != "你是一个测试助手" {
+			t.Errorf("第一条应为人格提示词: 得到 %s", req.Messages[0].Content)
+		}
+		if req.Messages[1].Role != "system" || !strings.Contains(req.Messages[1].Content, "这是对话摘要") {
+			t.Errorf("第二条应为摘要: 得到 %+v", req.Messages[1])
+		}
+	})
+
+	t.Run("增量更新摘要", func(t *testing.T) {
+		previous, _ := chatService.GetLatestSummary(sessionID, userID)
+		for i := 10; i < 20; i++ {
+			_ = chatService.SaveChatMessage(sessionID, "user", fmt.Sprintf("问题%d%s", i, strings.Repeat("问", 96)), userID)
+			_ = chatService.SaveChatMessage(sessionID, "assistant", fmt.Sprintf("回答%d%s", i, strings.Repeat("是", 96)), userID)
+		}
+
+		done, err := manager.SummarizeIfNeeded(context.Background(), userID, sessionID)
+		if err != nil || !done {
+			t.Fatalf("应再次生成摘要: done=%v, err=%v", done, err)
+		}
+
+		req := server.lastRequest()
+		if !strings.Contains(req.Messages[1].Content, "这是对话摘要") {
+			t.Error("增量摘要应基于之前的摘要")
+		}
+		if strings.Contains(req.Messages[1].Content, "问题0") {
+			t.Error("已摘要的消息不应再次发送")
+		}
+
+		latest, _ := chatService.GetLatestSummary(sessionID, userID)
+		if latest.SummarizedUntilID <= previous.SummarizedUntilID {
+			t.Errorf("摘要覆盖范围应向后推进: %d -> %d", previous.SummarizedUntilID, latest.SummarizedUntilID)
+		}
+	})
+}
@@ -0,0 +1,360 @@
+package LLM_Chat_Service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+	"platfrom/service/Note"
+
+	"github.com/glebarez/sqlite"
+	"github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+)
+
+// newEchoRegistry 创建只包含 echo 工具的注册表
+func newEchoRegistry(t *testing.T) *LLM_Chat.ToolRegistry {
+	registry := LLM_Chat.NewToolRegistry()
+	err := registry.Register(LLM_Chat.Tool{
+		Name:        "echo",
+		Description: "原样返回输入",
+		Parameters: map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"text": map[string]interface{}{"type": "string"}},
+		},
+		Handler: func(ctx context.Context, tc LLM_Chat.ToolContext, args json.RawMessage) (string, error) {
+			var params struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			if params.Text == "fail" {
+				return "", errors.New("故意失败")
+			}
+			return fmt.Sprintf("user=%d session=%s text=%s", tc.UserID, tc.SessionID, params.Text), nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("注册工具失败: %v", err)
+	}
+	return registry
+}
+
+// TestToolRegistry 测试工具注册与执行
+func TestToolRegistry(t *testing.T) {
+	registry := newEchoRegistry(t)
+
+	if err := registry.Register(LLM_Chat.Tool{Name: "no_handler"}); err == nil {
+		t.Error("缺少执行函数的工具应注册失败")
+	}
+
+	definitions := registry.Definitions()
+	if len(definitions) != 1 || definitions[0].Function.Name != "echo" {
+		t.Fatalf("工具定义错误: %+v", definitions)
+	}
+
+	tc := LLM_Chat.ToolContext{UserID: 1, SessionID: "s1"}
+	result, err := registry.Execute(context.Background(), tc, openai.ToolCall{
+		Function: openai.FunctionCall{Name: "echo", Arguments: `{"text":"hi"}`},
+	})
+	if err != nil || result != "user=1 session=s1 text=hi" {
+		t.Errorf("执行结果错误: %s, %v", result, err)
+	}
+
+	if _, err := registry.Execute(context.Background(), tc, openai.ToolCall{Function: openai.FunctionCall{Name: "missing"}}); err == nil {
+		t.Error("未知工具应返回错误")
+	}
+	if _, err := registry.Execute(context.Background(), tc, openai.ToolCall{Function: openai.FunctionCall{Name: "echo", Arguments: "{bad"}}); err == nil {
+		t.Error("非法参数应返回错误")
+	}
+}
+
+// toolCallServer 第一次请求返回工具调用，之后返回最终回答
+func toolCallServer(t *testing.T, requests *[]openai.ChatCompletionRequest) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body openai.ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		*requests = append(*requests, body)
+		round := len(*requests)
+
+		if body.Stream {
+			w.Header().Set("Content-Type", "text/event-stream")
+			if round == 1 {
+				// 工具调用参数分两片返回
+				fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"echo","arguments":"{\"text\":"}}]}}]}`+"\n\n")
+				fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"hi\"}"}}]}}]}`+"\n\n")
+				fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}`+"\n\n")
+			} else {
+				fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"content":"完成"}}]}`+"\n\n")
+				fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`+"\n\n")
+			}
+			fmt.Fprint(w, "data: [DONE]\n\n")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if round == 1 {
+			fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"","tool_calls":[{"id":"call_1","type":"function","function":{"name":"echo","arguments":"{\"text\":\"hi\"}"}},{"id":"call_2","type":"function","function":{"name":"echo","arguments":"{\"text\":\"fail\"}"}}]},"finish_reason":"tool_calls"}]}`)
+			return
+		}
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"完成"},"finish_reason":"stop"}]}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// TestSessionToolLoop 测试会话执行工具调用并循环直到得到最终回答
+func TestSessionToolLoop(t *testing.T) {
+	registry := newEchoRegistry(t)
+
+	t.Run("同步请求", func(t *testing.T) {
+		var requests []openai.ChatCompletionRequest
+		server := toolCallServer(t, &requests)
+		session := LLM_Chat.NewAdvancedChatSession(LLM_Chat.NewOpenAIProvider("sk-test", server.URL), "gpt-4o", "", 0)
+		session.SetSessionID("s1")
+
+		var events []LLM_Chat.ToolEvent
+		response, err := session.SendMessage("你好", LLM_Chat.SendOptions{
+			UserID: 7,
+			Tools:  registry,
+			OnToolEvent: func(event LLM_Chat.ToolEvent) error {
+				events = append(events, event)
+				return nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("SendMessage() 意外返回错误: %v", err)
+		}
+		if response != "完成" {
+			t.Errorf("最终回答错误: %s", response)
+		}
+
+		if len(requests) != 2 || len(requests[0].Tools) != 1 {
+			t.Fatalf("应请求两次且携带工具定义: 得到 %d 次", len(requests))
+		}
+		second := requests[1].Messages
+		if len(second) != 4 || len(second[1].ToolCalls) != 2 || second[2].Role != "tool" || second[2].ToolCallID != "call_1" {
+			t.Fatalf("第二次请求应包含工具调用和结果: %+v", second)
+		}
+		if second[2].Content != "user=7 session=s1 text=hi" {
+			t.Errorf("工具结果错误: %s", second[2].Content)
+		}
+		if !strings.Contains(second[3].Content, "故意失败") {
+			t.Errorf("工具失败信息应返回给模型: %s", second[3].Content)
+		}
+
+		if len(events) != 3 || events[0].Type != LLM_Chat.ToolEventCall || events[1].Type != LLM_Chat.ToolEventResult || !events[2].IsError {
+			t.Errorf("工具事件错误: %+v", events)
+		}
+		if n := len(session.GetMessages()); n != 5 {
+			t.Errorf("历史应包含用户消息、工具调用、两条结果和最终回答: 得到 %d 条", n)
+		}
+	})
+
+	t.Run("流式请求", func(t *testing.T) {
+		var requests []openai.ChatCompletionRequest
+		server := toolCallServer(t, &requests)
+		session := LLM_Chat.NewAdvancedChatSession(LLM_Chat.NewOpenAIProvider("sk-test", server.URL), "gpt-4o", "", 0)
+
+		var chunks []string
+		response, err := session.SendMessageStream(context.Background(), "你好", LLM_Chat.SendOptions{Tools: registry}, func(chunk string) error {
+			chunks = append(chunks, chunk)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("SendMessageStream() 意外返回错误: %v", err)
+		}
+		if response != "完成" || strings.Join(chunks, "") != "完成" {
+			t.Errorf("流式回答错误: %s, %v", response, chunks)
+		}
+		if got := requests[1].Messages[1].ToolCalls[0].Function.Arguments; got != `{"text":"hi"}` {
+			t.Errorf("流式工具参数应被拼接: %s", got)
+		}
+	})
+
+	t.Run("未启用工具时不发送工具定义", func(t *testing.T) {
+		var requests []openai.ChatCompletionRequest
+		server := toolCallServer(t, &requests)
+		requests = append(requests, openai.ChatCompletionRequest{}) // 跳过第一轮的工具调用响应
+		session := LLM_Chat.NewAdvancedChatSession(LLM_Chat.NewOpenAIProvider("sk-test", server.URL), "gpt-4o", "", 0)
+
+		if _, err := session.SendMessage("你好", LLM_Chat.SendOptions{}); err != nil {
+			t.Fatalf("SendMessage() 意外返回错误: %v", err)
+		}
+		if len(requests[1].Tools) != 0 {
+			t.Error("未启用工具时不应发送工具定义")
+		}
+	})
+}
+
+// TestAnthropicToolCalling 测试 Anthropic 的 tool_use / tool_result 转换
+func TestAnthropicToolCalling(t *testing.T) {
+	var body struct {
+		Messages []struct {
+			Role    string                   `json:"role"`
+			Content []map[string]interface{} `json:"content"`
+		} `json:"messages"`
+		Tools []map[string]interface{} `json:"tools"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"content":[{"type":"text","text":"我来查一下"},{"type":"tool_use","id":"toolu_1","name":"echo","input":{"text":"hi"}}],"stop_reason":"tool_use"}`)
+	}))
+	defer server.Close()
+
+	provider := LLM_Chat.NewAnthropicProvider("sk-ant-test", server.URL)
+	resp, err := provider.CreateChatCompletion(context.Background(), LLM_Chat.ProviderRequest{
+		Model: "claude-test",
+		Messages: []openai.ChatCompletionMessage{
+			{Role: "user", Content: "你好"},
+			{Role: "assistant", ToolCalls: []openai.ToolCall{{ID: "toolu_0", Type: "function", Function: openai.FunctionCall{Name: "echo", Arguments: `{"text":"a"}`}}}},
+			{Role: "tool", ToolCallID: "toolu_0", Content: "a"},
+		},
+		Tools: newEchoRegistry(t).Definitions(),
+	})
+	if err != nil {
+		t.Fatalf("CreateChatCompletion() 意外返回错误: %v", err)
+	}
+
+	if len(body.Tools) != 1 || body.Tools[0]["name"] != "echo" || body.Tools[0]["input_schema"] == nil {
+		t.Errorf("工具定义转换错误: %+v", body.Tools)
+	}
+	if len(body.Messages) != 3 {
+		t.Fatalf("消息数错误: %+v", body.Messages)
+	}
+	if block := body.Messages[1].Content[0]; block["type"] != "tool_use" || block["id"] != "toolu_0" {
+		t.Errorf("assistant 工具调用应转为 tool_use: %+v", block)
+	}
+	if block := body.Messages[2].Content[0]; body.Messages[2].Role != "user" || block["type"] != "tool_result" || block["tool_use_id"] != "toolu_0" {
+		t.Errorf("tool 消息应转为 user 角色的 tool_result: %+v", body.Messages[2])
+	}
+
+	if resp.Content != "我来查一下" || len(resp.ToolCalls) != 1 {
+		t.Fatalf("响应解析错误: %+v", resp)
+	}
+	if call := resp.ToolCalls[0]; call.ID != "toolu_1" || call.Function.Name != "echo" || call.Function.Arguments != `{"text":"hi"}` {
+		t.Errorf("tool_use 解析错误: %+v", call)
+	}
+}
+
+// stubNoteService 只实现搜索的笔记服务
+type stubNoteService struct {
+	Note.NoteServiceInterface
+	notes []database.Note
+}
+
+func (s *stubNoteService) SearchNotes(userID uint, keyword string) ([]database.Note, error) {
+	var result []database.Note
+	for _, note := range s.notes {
+		if note.UserID == userID && strings.Contains(note.Content, keyword) {
+			result = append(result, note)
+		}
+	}
+	return result, nil
+}
+
+// TestBuiltinTools 测试内置的搜索笔记和读取文件工具
+func TestBuiltinTools(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	if err := db.AutoMigrate(&database.ChatSession{}, &database.UploadedFile{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	db.Create(&database.ChatSession{SessionID: "s1", UserID: 1, ModelName: "gpt-4"})
+	fileService, _ := LLM_Chat.NewFileService(db, testUploadConfig(t), newTestBlobStore(t))
+	file := &database.UploadedFile{SessionID: "s1", FileName: "readme.md", FilePath: "unused", FileSize: 5, FileType: "text/markdown", Content: "文件内容"}
+	if err := fileService.SaveFile(file); err != nil {
+		t.Fatalf("保存文件失败: %v", err)
+	}
+
+	noteService := &stubNoteService{notes: []database.Note{
+		{UserID: 1, Title: "Go 笔记", Content: "goroutine 和 channel"},
+		{UserID: 2, Title: "别人的笔记", Content: "goroutine"},
+	}}
+
+	registry := LLM_Chat.NewToolRegistry()
+	if err := LLM_Chat.RegisterBuiltinTools(registry, noteService, fileService); err != nil {
+		t.Fatalf("注册内置工具失败: %v", err)
+	}
+	tc := LLM_Chat.ToolContext{UserID: 1, SessionID: "s1"}
+	call := func(tc LLM_Chat.ToolContext, name, args string) (string, error) {
+		return registry.Execute(context.Background(), tc, openai.ToolCall{Function: openai.FunctionCall{Name: name, Arguments: args}})
+	}
+
+	result, err := call(tc, "search_notes", `{"query":"goroutine"}`)
+	if err != nil || !strings.Contains(result, "Go 笔记") || strings.Contains(result, "别人的笔记") {
+		t.Errorf("search_notes 只应返回当前用户的笔记: %s, %v", result, err)
+	}
+
+	result, err = call(tc, "read_uploaded_file", `{}`)
+	if err != nil || !strings.Contains(result, fmt.Sprintf("file_id=%d", file.ID)) {
+		t.Errorf("不传 file_id 时应列出文件: %s, %v", result, err)
+	}
+
+	result, err = call(tc, "read_uploaded_file", fmt.Sprintf(`{"file_id":%d}`, file.ID))
+	if err != nil || !strings.Contains(result, "文件内容") {
+		t.Errorf("read_uploaded_file 结果错误: %s, %v", result, err)
+	}
+
+	otherSession := LLM_Chat.ToolContext{UserID: 1, SessionID: "s2"}
+	if _, err := call(otherSession, "read_uploaded_file", fmt.Sprintf(`{"file_id":%d}`, file.ID)); err == nil {
+		t.Error("不应读取其他会话的文件")
+	}
+
+	otherUser := LLM_Chat.ToolContext{UserID: 2, SessionID: "s1"}
+	if _, err := call(otherUser, "read_uploaded_file", fmt.Sprintf(`{"file_id":%d}`, file.ID)); err == nil {
+		t.Error("不应读取其他用户的文件")
+	}
+	if _, err := call(otherUser, "read_uploaded_file", `{}`); err == nil {
+		t.Error("不应列出其他用户会话的文件")
+	}
+}
+
+// TestToolMessagePersistence 测试工具消息的保存与恢复
+func TestToolMessagePersistence(t *testing.T) {
+	service, cleanup := setupChatService(t)
+	defer cleanup()
+
+	if _, err := service.CreateChatSession("session_tools", "gpt-4", 1); err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+	_ = service.SaveChatMessage("session_tools", "user", "查一下笔记", 1)
+	toolCalls := []openai.ToolCall{{ID: "call_1", Type: "function", Function: openai.FunctionCall{Name: "search_notes", Arguments: `{"query":"go"}`}}}
+	if err := service.SaveToolMessage("session_tools", 1, openai.ChatCompletionMessage{Role: "assistant", ToolCalls: toolCalls}); err != nil {
+		t.Fatalf("保存工具调用失败: %v", err)
+	}
+	if err := service.SaveToolMessage("session_tools", 1, openai.ChatCompletionMessage{Role: "tool", ToolCallID: "call_1", Content: "[]"}); err != nil {
+		t.Fatalf("保存工具结果失败: %v", err)
+	}
+	_ = service.SaveChatMessage("session_tools", "assistant", "没有找到", 1)
+
+	messages, err := service.GetRecentChatMessages("session_tools", 1, 10)
+	if err != nil {
+		t.Fatalf("获取消息失败: %v", err)
+	}
+	if len(messages) != 4 {
+		t.Fatalf("消息数错误: 得到 %d 条", len(messages))
+	}
+	if len(messages[1].ToolCalls) != 1 || messages[1].ToolCalls[0].Function.Name != "search_notes" {
+		t.Errorf("工具调用未恢复: %+v", messages[1])
+	}
+	if messages[2].Role != "tool" || messages[2].ToolCallID != "call_1" {
+		t.Errorf("工具结果未恢复: %+v", messages[2])
+	}
+
+	session, _ := service.GetChatSession("session_tools", 1)
+	if session.MessageCount != 2 {
+		t.Errorf("工具消息不应计入消息数: 得到 %d", session.MessageCount)
+	}
+}
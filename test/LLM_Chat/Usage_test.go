@@ -0,0 +1,195 @@
+package LLM_Chat_Service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newUsageServer 返回固定回复和用量（输入 1000、输出 500 token）的 OpenAI 兼容服务
+func newUsageServer(t *testing.T) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"回复"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1000,"completion_tokens":500,"total_tokens":1500}}`)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// useUsageService 设置全局用量服务，测试结束后恢复
+func useUsageService(t *testing.T, service LLM_Chat.UsageServiceInterface) {
+	t.Helper()
+	previous := LLM_Chat.GlobalUsageService
+	LLM_Chat.GlobalUsageService = service
+	t.Cleanup(func() { LLM_Chat.GlobalUsageService = previous })
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+// TestUsageCost 测试价格表匹配
+func TestUsageCost(t *testing.T) {
+	service, err := LLM_Chat.NewUsageService(setupTestDB(t), database.PricingConfig{
+		Currency: "USD",
+		Models: map[string]database.ModelPrice{
+			"gpt-4o":      {Prompt: 2.5, Completion: 10},
+			"gpt-4o-mini": {Prompt: 0.15, Completion: 0.6},
+		},
+	})
+	if err != nil {
+		t.Fatalf("创建用量服务失败: %v", err)
+	}
+	useUsageService(t, service)
+
+	usage := LLM_Chat.TokenUsage{PromptTokens: 1000000, CompletionTokens: 1000000}
+	cases := map[string]float64{
+		"gpt-4o":            12.5,
+		"GPT-4o":            12.5,
+		"gpt-4o-2024-08-06": 12.5, // 前缀匹配
+		"gpt-4o-mini-2024":  0.75, // 使用最长的前缀
+		"unknown-model":     0,
+	}
+	for model, want := range cases {
+		if got := service.Cost(model, usage); !almostEqual(got, want) {
+			t.Errorf("%s 的费用错误: 得到 %v, 期望 %v", model, got, want)
+		}
+	}
+}
+
+// TestUsageAccounting 测试回复的用量保存、每日汇总和统计接口
+func TestUsageAccounting(t *testing.T) {
+	manager, chatService, apiService, db := setupSessionManagerWithDB(t)
+	server := newUsageServer(t)
+	service, _ := LLM_Chat.NewUsageService(db, database.PricingConfig{
+		Currency: "USD",
+		Models:   map[string]database.ModelPrice{"gpt-4o": {Prompt: 2, Completion: 10}},
+	})
+	useUsageService(t, service)
+
+	const userID, sessionID = uint(1), "session_usage"
+	api, err := apiService.CreateAPI(userID, &database.UserAPI{APIName: "usage", APIKey: "sk-usage", ModelName: "gpt-4o", BaseURL: server.URL})
+	if err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+
+	session, err := manager.GetOrCreateSession(userID, sessionID, "gpt-4o", "", "default")
+	if err != nil {
+		t.Fatalf("创建会话失败: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		_ = manager.SaveMessage(sessionID, "user", "你好", userID)
+		reply, err := session.SendMessage("你好", manager.NewSendOptions(sessionID, userID, nil, false, nil))
+		if err != nil {
+			t.Fatalf("发送消息失败: %v", err)
+		}
+		if err := manager.SaveReply(sessionID, reply, session.LastServedBy(), session.LastUsage(), userID); err != nil {
+			t.Fatalf("保存回复失败: %v", err)
+		}
+	}
+
+	// 每次回复：1000 × 2 / 1e6 + 500 × 10 / 1e6 = 0.007
+	const replyCost = 0.007
+	t.Run("回复上保存用量", func(t *testing.T) {
+		messages, _, _, err := chatService.GetChatMessages(sessionID, userID, 0, 10)
+		if err != nil || len(messages) != 4 {
+			t.Fatalf("获取消息失败: %d, %v", len(messages), err)
+		}
+		reply := messages[1]
+		if reply.PromptTokens != 1000 || reply.CompletionTokens != 500 || !almostEqual(reply.Cost, replyCost) || reply.APIID != api.ID {
+			t.Errorf("回复的用量错误: %+v", reply)
+		}
+		if messages[0].PromptTokens != 0 {
+			t.Errorf("用户消息不应有用量: %+v", messages[0])
+		}
+	})
+
+	t.Run("按天汇总", func(t *testing.T) {
+		today := time.Now()
+		summary, err := service.GetUsageSummary(userID, today, today)
+		if err != nil {
+			t.Fatalf("查询用量失败: %v", err)
+		}
+		if summary.Total.Requests != 2 || summary.Total.TotalTokens != 3000 || !almostEqual(summary.Total.Cost, 2*replyCost) {
+			t.Errorf("总计错误: %+v", summary.Total)
+		}
+		if len(summary.Daily) != 1 || len(summary.ByModel) != 1 || summary.ByModel[0].Model != "gpt-4o" {
+			t.Errorf("分组错误: %+v", summary)
+		}
+		if len(summary.ByAPI) != 1 || summary.ByAPI[0].APIID != api.ID || summary.Currency != "USD" {
+			t.Errorf("按 API 配置分组错误: %+v", summary.ByAPI)
+		}
+
+		// 其他用户和其他日期没有用量
+		if other, _ := service.GetUsageSummary(2, today, today); other.Total.Requests != 0 {
+			t.Errorf("其他用户不应有用量: %+v", other.Total)
+		}
+		yesterday := today.AddDate(0, 0, -1)
+		if earlier, _ := service.GetUsageSummary(userID, yesterday, yesterday); earlier.Total.Requests != 0 || len(earlier.Daily) != 0 {
+			t.Errorf("日期范围外不应有用量: %+v", earlier)
+		}
+	})
+
+	t.Run("续写累加用量", func(t *testing.T) {
+		last, _ := chatService.GetLastMessage(sessionID, userID)
+		if err := chatService.AppendToMessage(sessionID, userID, last.ID, "续写", session.LastServedBy(), LLM_Chat.TokenUsage{PromptTokens: 100, CompletionTokens: 50}); err != nil {
+			t.Fatalf("续写失败: %v", err)
+		}
+		updated, _ := chatService.GetLastMessage(sessionID, userID)
+		if updated.PromptTokens != 1100 || updated.CompletionTokens != 550 {
+			t.Errorf("续写的用量应累加到原回复: %+v", updated)
+		}
+		totals, err := service.GetSessionUsage(userID, sessionID)
+		if err != nil || totals.Requests != 3 || totals.TotalTokens != 3150 {
+			t.Errorf("会话用量错误: %+v, %v", totals, err)
+		}
+	})
+
+	t.Run("管理员报表", func(t *testing.T) {
+		report, err := service.RootGetUsageReport(time.Now(), time.Now())
+		if err != nil || len(report) != 1 || report[0].UserID != userID || report[0].Requests != 3 {
+			t.Errorf("报表错误: %+v, %v", report, err)
+		}
+	})
+
+	t.Run("接口", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(func(c *gin.Context) { c.Set("user_id", userID) })
+		router.GET("/usage", LLM_Chat_Route.GetUsage)
+		router.GET("/usage/sessions/:session_id", LLM_Chat_Route.GetSessionUsage)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/usage", nil))
+		var body struct {
+			Data LLM_Chat.UsageSummary `json:"data"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &body) != nil || body.Data.Total.Requests != 3 {
+			t.Errorf("默认统计最近 30 天: %d, %s", w.Code, w.Body.String())
+		}
+
+		for _, query := range []string{"?from=2026-13-01", "?from=2026-02-01&to=2026-01-01", "?from=2024-01-01&to=2026-01-01"} {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/usage"+query, nil))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%s 应返回 400: %d", query, w.Code)
+			}
+		}
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/usage/sessions/not_mine", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("不存在的会话应返回 404: %d", w.Code)
+		}
+	})
+}
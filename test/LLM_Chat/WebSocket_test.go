@@ -0,0 +1,204 @@
+package LLM_Chat_Service
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	LLM_Chat_Route "platfrom/Route/LLM_Chat"
+	"platfrom/database"
+	"platfrom/service/RAG"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// wsFrame 服务端推送的 WebSocket 消息
+type wsFrame struct {
+	SessionID string `json:"session_id"`
+	ID        string `json:"id"`
+	Event     string `json:"event"`
+	Data      struct {
+		Content string `json:"content"`
+		Done    bool   `json:"done"`
+		Stopped bool   `json:"stopped"`
+		Error   string `json:"error"`
+		Status  int    `json:"status"`
+		Typing  bool   `json:"typing"`
+	} `json:"data"`
+}
+
+// blockingEmbedder 直到 release 关闭才返回向量，模拟耗时的检索
+type blockingEmbedder struct {
+	release chan struct{}
+}
+
+func (e *blockingEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	select {
+	case <-e.release:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	vectors := make([][]float32, len(texts))
+	for i := range texts {
+		vectors[i] = []float32{1, 0}
+	}
+	return vectors, nil
+}
+
+func (e *blockingEmbedder) ModelName() string { return "blocking" }
+
+// dialChatWS 连接测试服务器的 /api/chat/ws
+func dialChatWS(t *testing.T, server *httptest.Server) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/api/chat/ws", nil)
+	if err != nil {
+		t.Fatalf("连接 WebSocket 失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readFrames 读取消息直到 stop 返回 true
+func readFrames(t *testing.T, conn *websocket.Conn, stop func(frame wsFrame) bool) []wsFrame {
+	t.Helper()
+	var frames []wsFrame
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		var frame wsFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			t.Fatalf("读取消息失败: %v (已收到 %+v)", err, frames)
+		}
+		frames = append(frames, frame)
+		if stop(frame) {
+			return frames
+		}
+	}
+}
+
+// TestChatWebSocket 测试在一个连接上同时生成多个会话的回复，以及停止、输入状态转发和错误消息
+func TestChatWebSocket(t *testing.T) {
+	llm := newStreamLLMServer(t, "你", "好")
+	_, chatService, apiService, db := setupSessionManagerWithDB(t)
+	if _, err := apiService.CreateAPI(1, &database.UserAPI{APIName: "test", APIKey: "sk-test", ModelName: "gpt-4", BaseURL: llm.URL}); err != nil {
+		t.Fatalf("创建API配置失败: %v", err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	chat := router.Group("/api/chat", func(c *gin.Context) { c.Set("user_id", uint(1)) })
+	chat.GET("/ws", LLM_Chat_Route.ChatWebSocket)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	conn := dialChatWS(t, server)
+	send := func(conn *websocket.Conn, message string) {
+		t.Helper()
+		if err := conn.WriteMessage(websocket.TextMessage, []byte(message)); err != nil {
+			t.Fatalf("发送消息失败: %v", err)
+		}
+	}
+
+	t.Run("多个会话共用一个连接", func(t *testing.T) {
+		send(conn, `{"type":"send","session_id":"ws_a","model_name":"gpt-4","message":"问题A"}`)
+		send(conn, `{"type":"send","session_id":"ws_b","model_name":"gpt-4","message":"问题B"}`)
+
+		contents := map[string]string{}
+		done := map[string]bool{}
+		readFrames(t, conn, func(frame wsFrame) bool {
+			if frame.Data.Error != "" {
+				t.Fatalf("生成失败: %+v", frame)
+			}
+			if frame.ID == "" {
+				t.Errorf("事件应带有ID: %+v", frame)
+			}
+			contents[frame.SessionID] += frame.Data.Content
+			if frame.Data.Done {
+				done[frame.SessionID] = true
+			}
+			return done["ws_a"] && done["ws_b"]
+		})
+		if contents["ws_a"] != "你好" || contents["ws_b"] != "你好" {
+			t.Errorf("每个会话应收到自己的回复: %v", contents)
+		}
+		if got := fmt.Sprint(pathContents(t, chatService, "ws_b", 1)); got != "[问题B 你好]" {
+			t.Errorf("回复应被保存: %s", got)
+		}
+	})
+
+	t.Run("停止生成", func(t *testing.T) {
+		llm.set(true, "部分")
+		send(conn, `{"type":"send","session_id":"ws_a","model_name":"gpt-4","message":"再问"}`)
+		readFrames(t, conn, func(frame wsFrame) bool { return frame.Data.Content == "部分" })
+
+		send(conn, `{"type":"stop","session_id":"ws_a"}`)
+		frames := readFrames(t, conn, func(frame wsFrame) bool { return frame.Data.Done })
+		if last := frames[len(frames)-1]; !last.Data.Stopped || last.SessionID != "ws_a" {
+			t.Errorf("应以 stopped 事件结束: %+v", last)
+		}
+		if got := fmt.Sprint(pathContents(t, chatService, "ws_a", 1)); got != "[问题A 你好 再问 部分]" {
+			t.Errorf("应保存已生成的部分: %s", got)
+		}
+	})
+
+	t.Run("输入状态转发给同一用户的其他连接", func(t *testing.T) {
+		other := dialChatWS(t, server)
+		// 等待第二个连接登记
+		time.Sleep(50 * time.Millisecond)
+		send(conn, `{"type":"typing","session_id":"ws_a","typing":true}`)
+		frames := readFrames(t, other, func(frame wsFrame) bool { return true })
+		if frames[0].Event != "typing" || !frames[0].Data.Typing || frames[0].SessionID != "ws_a" {
+			t.Errorf("应收到输入状态: %+v", frames[0])
+		}
+	})
+
+	t.Run("检索笔记时不阻塞其他消息", func(t *testing.T) {
+		if err := db.AutoMigrate(&database.NoteChunk{}); err != nil {
+			t.Fatalf("数据库迁移失败: %v", err)
+		}
+		embedder := &blockingEmbedder{release: make(chan struct{})}
+		previous := RAG.GlobalRAGService
+		if _, err := RAG.NewRAGService(db, embedder); err != nil {
+			t.Fatalf("创建检索服务失败: %v", err)
+		}
+		t.Cleanup(func() { RAG.GlobalRAGService = previous })
+
+		llm.set(false, "好")
+		send(conn, `{"type":"send","session_id":"ws_b","model_name":"gpt-4","message":"查笔记","use_notes":true}`)
+		// 检索阻塞期间，同一连接上的其他消息仍被处理
+		send(conn, `{"type":"unknown","session_id":"ws_a"}`)
+		frame := readFrames(t, conn, func(wsFrame) bool { return true })[0]
+		if frame.Event != "error" || frame.SessionID != "ws_a" {
+			t.Fatalf("检索期间应处理其他消息: %+v", frame)
+		}
+
+		close(embedder.release)
+		frames := readFrames(t, conn, func(frame wsFrame) bool { return frame.SessionID == "ws_b" && frame.Data.Done })
+		if last := frames[len(frames)-1]; last.Data.Error != "" {
+			t.Errorf("检索完成后应正常生成: %+v", last)
+		}
+	})
+
+	t.Run("错误消息", func(t *testing.T) {
+		send(conn, `{"type":"unknown","session_id":"ws_a"}`)
+		frame := readFrames(t, conn, func(wsFrame) bool { return true })[0]
+		if frame.Event != "error" || frame.Data.Status != 400 {
+			t.Errorf("不支持的类型应返回 400: %+v", frame)
+		}
+
+		send(conn, `{"type":"regenerate","session_id":"missing"}`)
+		frame = readFrames(t, conn, func(wsFrame) bool { return true })[0]
+		if frame.Event != "error" || frame.Data.Status != 404 {
+			t.Errorf("不存在的会话应返回 404: %+v", frame)
+		}
+
+		send(conn, `{"type":"send","session_id":"ws_a"}`)
+		frame = readFrames(t, conn, func(wsFrame) bool { return true })[0]
+		if frame.Event != "error" || frame.Data.Status != 400 {
+			t.Errorf("缺少参数应返回 400: %+v", frame)
+		}
+	})
+}
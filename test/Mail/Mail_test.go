@@ -0,0 +1,356 @@
+package Mail_Service
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"platfrom/database"
+	"platfrom/service/Mail"
+)
+
+// smtpStub 进程内的最小 SMTP 服务器，记录收到的邮件；failures 次 DATA 之前返回 451（临时错误）
+type smtpStub struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []string
+	failures int
+	reject   bool // RCPT 返回 550（永久错误）
+	attempts int
+}
+
+func newSMTPStub(t *testing.T) *smtpStub {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("启动 SMTP 服务失败: %v", err)
+	}
+	stub := &smtpStub{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go stub.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return stub
+}
+
+func (s *smtpStub) port() string {
+	return strings.TrimPrefix(s.listener.Addr().String(), "127.0.0.1:")
+}
+
+func (s *smtpStub) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = io.WriteString(conn, line+"\r\n") }
+
+	reply("220 stub ESMTP")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 stub")
+		case strings.HasPrefix(command, "MAIL FROM"):
+			s.mu.Lock()
+			s.attempts++
+			s.mu.Unlock()
+			reply("250 OK")
+		case strings.HasPrefix(command, "RCPT TO"):
+			s.mu.Lock()
+			reject := s.reject
+			s.mu.Unlock()
+			if reject {
+				reply("550 no such user")
+				continue
+			}
+			reply("250 OK")
+		case command == "DATA":
+			s.mu.Lock()
+			fail := s.failures > 0
+			if fail {
+				s.failures--
+			}
+			s.mu.Unlock()
+			if fail {
+				reply("451 try again later")
+				continue
+			}
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.mu.Unlock()
+			reply("250 queued")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *smtpStub) setReject() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reject = true
+}
+
+func (s *smtpStub) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func (s *smtpStub) attemptCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts
+}
+
+func newStubMailer(t *testing.T, stub *smtpStub) Mail.Mailer {
+	mailer, err := Mail.NewSMTPMailer(Mail.SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     stub.port(),
+		From:     "Platform <no-reply@example.com>",
+		Security: Mail.SMTPNone,
+		Timeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("创建 SMTP Mailer 失败: %v", err)
+	}
+	return mailer
+}
+
+// newMailService 创建测试用的邮件服务，结束时停止并恢复全局实例
+func newMailService(t *testing.T, mailer Mail.Mailer, config database.MailConfig) Mail.MailServiceInterface {
+	previous := Mail.GlobalMailService
+	service, err := Mail.NewMailService(mailer, config)
+	if err != nil {
+		t.Fatalf("创建邮件服务失败: %v", err)
+	}
+	t.Cleanup(func() {
+		service.Shutdown()
+		Mail.GlobalMailService = previous
+	})
+	return service
+}
+
+// parseMail 解析邮件，返回解码后的主题和正文
+func parseMail(t *testing.T, raw string) (*mail.Message, string, string) {
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("解析邮件失败: %v", err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatalf("解码主题失败: %v", err)
+	}
+	body, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, msg.Body))
+	if err != nil {
+		t.Fatalf("解码正文失败: %v", err)
+	}
+	return msg, subject, string(body)
+}
+
+// waitFor 等待条件成立
+func waitFor(t *testing.T, condition func() bool, message string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestSMTPMailer 测试通过 SMTP 发送邮件以及邮件的格式
+func TestSMTPMailer(t *testing.T) {
+	stub := newSMTPStub(t)
+	mailer := newStubMailer(t, stub)
+
+	err := mailer.Send(context.Background(), &Mail.Message{To: "alice@example.com", Subject: "重置密码", Body: "验证码：123456\n"})
+	if err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	messages := stub.received()
+	if len(messages) != 1 {
+		t.Fatalf("应收到 1 封邮件: %d", len(messages))
+	}
+	msg, subject, body := parseMail(t, messages[0])
+	if msg.Header.Get("To") != "alice@example.com" || msg.Header.Get("From") != "Platform <no-reply@example.com>" || msg.Header.Get("Message-Id") == "" {
+		t.Errorf("邮件头错误: %v", msg.Header)
+	}
+	if subject != "重置密码" || body != "验证码：123456\n" {
+		t.Errorf("主题或正文错误: %q, %q", subject, body)
+	}
+
+	if err := mailer.Send(context.Background(), &Mail.Message{To: "not-an-address", Subject: "x"}); !Mail.IsPermanent(err) {
+		t.Errorf("无效地址应为永久错误: %v", err)
+	}
+	if err := mailer.Send(context.Background(), &Mail.Message{To: "a@example.com", Subject: "x\r\nBcc: b@example.com"}); err == nil {
+		t.Error("主题包含换行应被拒绝")
+	}
+
+	stub.setReject()
+	if err := mailer.Send(context.Background(), &Mail.Message{To: "bob@example.com", Subject: "x"}); !Mail.IsPermanent(err) {
+		t.Errorf("SMTP 5xx 应为永久错误: %v", err)
+	}
+
+	// 默认使用 STARTTLS
+	starttls, err := Mail.NewSMTPMailer(Mail.SMTPConfig{Host: "127.0.0.1", Port: stub.port(), From: "no-reply@example.com"})
+	if err != nil {
+		t.Fatalf("创建 SMTP Mailer 失败: %v", err)
+	}
+	if err := starttls.Send(context.Background(), &Mail.Message{To: "alice@example.com", Subject: "x"}); err == nil {
+		t.Error("服务器不支持 STARTTLS 时不应以明文发送")
+	}
+}
+
+// TestMailService 测试验证码模板、异步发送和重试
+func TestMailService(t *testing.T) {
+	t.Run("按语言渲染模板", func(t *testing.T) {
+		stub := newSMTPStub(t)
+		service := newMailService(t, newStubMailer(t, stub), database.MailConfig{AppName: "测试平台"})
+		data := Mail.CodeData{Username: "alice", Code: "654321", ExpiresAt: time.Now().Add(5 * time.Minute)}
+
+		for _, language := range []string{"", "en-US,en;q=0.9", "fr"} {
+			if err := service.SendCode("alice@example.com", "password_reset", language, data); err != nil {
+				t.Fatalf("发送验证码失败 (%q): %v", language, err)
+			}
+		}
+		waitFor(t, func() bool { return len(stub.received()) == 3 }, "应收到 3 封邮件")
+
+		subjects := make(map[string]string)
+		for _, raw := range stub.received() {
+			_, subject, body := parseMail(t, raw)
+			if !strings.Contains(body, "654321") || !strings.Contains(body, "alice") || !strings.Contains(body, "5") {
+				t.Errorf("正文应包含用户名、验证码和有效期: %q", body)
+			}
+			subjects[subject] = body
+		}
+		if _, ok := subjects["【测试平台】重置密码验证码"]; !ok {
+			t.Errorf("默认和未知语言应使用中文模板: %v", subjects)
+		}
+		if _, ok := subjects["[测试平台] Your password reset code"]; !ok {
+			t.Errorf("应按 Accept-Language 使用英文模板: %v", subjects)
+		}
+
+		if err := service.SendCode("alice@example.com", "register", "", data); !errors.Is(err, Mail.ErrMailTemplate) {
+			t.Errorf("没有模板的验证码类型应返回 ErrMailTemplate: %v", err)
+		}
+	})
+
+	t.Run("配置中的模板", func(t *testing.T) {
+		stub := newSMTPStub(t)
+		service := newMailService(t, newStubMailer(t, stub), database.MailConfig{
+			DefaultLanguage: "en",
+			Templates: map[string]map[string]database.MailTemplate{
+				"register": {"ja": {Subject: "{{.AppName}} 登録コード", Body: "コード: {{.Code}}"}},
+			},
+		})
+		if err := service.SendCode("bob@example.com", "register", "zh-CN", Mail.CodeData{Code: "111222", ExpiresAt: time.Now().Add(time.Minute)}); err != nil {
+			t.Fatalf("发送失败: %v", err)
+		}
+		waitFor(t, func() bool { return len(stub.received()) == 1 }, "应收到 1 封邮件")
+		_, subject, body := parseMail(t, stub.received()[0])
+		if subject != "Platform 登録コード" || body != "コード: 111222" {
+			t.Errorf("应使用配置中唯一的语言: %q, %q", subject, body)
+		}
+
+		if _, err := Mail.NewMailService(Mail.NewConsoleMailer(io.Discard), database.MailConfig{
+			Templates: map[string]map[string]database.MailTemplate{"x": {"zh": {Subject: "{{.Code"}}},
+		}); err == nil {
+			t.Error("模板语法错误应在创建时返回")
+		}
+	})
+
+	t.Run("临时错误重试", func(t *testing.T) {
+		stub := newSMTPStub(t)
+		stub.failures = 2
+		service := newMailService(t, newStubMailer(t, stub), database.MailConfig{MaxAttempts: 3, RetryDelay: 10 * time.Millisecond})
+
+		if err := service.Send(&Mail.Message{To: "alice@example.com", Subject: "retry", Body: "x"}); err != nil {
+			t.Fatalf("放入队列失败: %v", err)
+		}
+		waitFor(t, func() bool { return len(stub.received()) == 1 }, "重试后应发送成功")
+		if stub.attemptCount() != 3 {
+			t.Errorf("应尝试 3 次: %d", stub.attemptCount())
+		}
+	})
+
+	t.Run("永久错误不重试", func(t *testing.T) {
+		stub := newSMTPStub(t)
+		stub.setReject()
+		service := newMailService(t, newStubMailer(t, stub), database.MailConfig{MaxAttempts: 5, RetryDelay: 10 * time.Millisecond})
+
+		if err := service.Send(&Mail.Message{To: "ghost@example.com", Subject: "x"}); err != nil {
+			t.Fatalf("放入队列失败: %v", err)
+		}
+		service.Shutdown()
+		if stub.attemptCount() != 1 {
+			t.Errorf("永久错误不应重试: %d", stub.attemptCount())
+		}
+		if err := service.Send(&Mail.Message{To: "ghost@example.com", Subject: "x"}); !errors.Is(err, Mail.ErrMailServiceStopped) {
+			t.Errorf("停止后应拒绝发送: %v", err)
+		}
+	})
+}
+
+// TestFileMailer 测试开发用的文件 Mailer
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	mailer, err := Mail.NewFileMailer(dir, "no-reply@example.com")
+	if err != nil {
+		t.Fatalf("创建文件 Mailer 失败: %v", err)
+	}
+	if err := mailer.Send(context.Background(), &Mail.Message{To: "alice@example.com", Subject: "hello", Body: "world"}); err != nil {
+		t.Fatalf("写入邮件失败: %v", err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("应生成 1 个 .eml 文件: %v", files)
+	}
+	raw, _ := os.ReadFile(files[0])
+	if _, subject, body := parseMail(t, string(raw)); subject != "hello" || body != "world" {
+		t.Errorf("邮件内容错误: %q, %q", subject, body)
+	}
+
+	var out strings.Builder
+	if err := Mail.NewConsoleMailer(&out).Send(context.Background(), &Mail.Message{To: "alice@example.com", Subject: "hi", Body: "code 123"}); err != nil {
+		t.Fatalf("输出邮件失败: %v", err)
+	}
+	if !strings.Contains(out.String(), "code 123") {
+		t.Errorf("控制台应输出正文: %q", out.String())
+	}
+}
@@ -0,0 +1,311 @@
+package RAG_Service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"platfrom/database"
+	"platfrom/service/LLM_Chat"
+	"platfrom/service/Note"
+	"platfrom/service/RAG"
+
+	"github.com/glebarez/sqlite"
+	"github.com/sashabaranov/go-openai"
+	"gorm.io/gorm"
+)
+
+// fakeEmbedder 按固定词表计数的确定性向量
+type fakeEmbedder struct {
+	calls int
+}
+
+var fakeVocabulary = []string{"golang", "python", "猫", "狗", "咖啡"}
+
+func (e *fakeEmbedder) ModelName() string {
+	return "fake"
+}
+
+func (e *fakeEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		vector := make([]float32, len(fakeVocabulary))
+		for j, word := range fakeVocabulary {
+			vector[j] = float32(strings.Count(strings.ToLower(text), word))
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// setupRAG 创建笔记服务和检索服务，笔记变更会同步索引
+func setupRAG(t *testing.T) (Note.NoteServiceInterface, RAG.RAGServiceInterface, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	if err := db.AutoMigrate(&database.Note{}, &database.NoteChunk{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+
+	originalDB, originalIndexer := database.DB, Note.GlobalNoteIndexer
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = originalDB
+		Note.GlobalNoteIndexer = originalIndexer
+	})
+
+	noteService := Note.NewNoteService()
+	ragService, err := RAG.NewRAGService(db, &fakeEmbedder{})
+	if err != nil {
+		t.Fatalf("创建检索服务失败: %v", err)
+	}
+	Note.GlobalNoteIndexer = ragService
+	return noteService, ragService, db
+}
+
+// TestSplitText 测试笔记切块
+func TestSplitText(t *testing.T) {
+	if chunks := RAG.SplitText("第一段\n\n第二段", 100, 10); len(chunks) != 1 || chunks[0] != "第一段\n第二段" {
+		t.Errorf("短段落应合并为一个切块: %q", chunks)
+	}
+
+	chunks := RAG.SplitText(strings.Repeat("字", 250), 100, 20)
+	if len(chunks) != 3 {
+		t.Fatalf("超长段落应按窗口切分: 得到 %d 块", len(chunks))
+	}
+	for _, chunk := range chunks {
+		if n := len([]rune(chunk)); n > 100 {
+			t.Errorf("切块超过最大字数: %d", n)
+		}
+	}
+
+	if chunks := RAG.SplitText("  \n\n ", 100, 10); len(chunks) != 0 {
+		t.Errorf("空白文本不应产生切块: %q", chunks)
+	}
+}
+
+// TestNoteIndexing 测试笔记增删改时索引同步，以及按用户隔离的检索
+func TestNoteIndexing(t *testing.T) {
+	noteService, ragService, db := setupRAG(t)
+	ctx := context.Background()
+
+	goNote := &database.Note{UserID: 1, Title: "Go 笔记", Content: "golang 的并发模型"}
+	catNote := &database.Note{UserID: 1, Title: "宠物", Content: "家里的猫喜欢晒太阳"}
+	otherNote := &database.Note{UserID: 2, Title: "别人的 Go 笔记", Content: "golang golang"}
+	for _, note := range []*database.Note{goNote, catNote, otherNote} {
+		if err := noteService.CreateNote(note); err != nil {
+			t.Fatalf("创建笔记失败: %v", err)
+		}
+	}
+
+	results, err := ragService.Search(ctx, 1, "golang 怎么写", 5)
+	if err != nil {
+		t.Fatalf("检索失败: %v", err)
+	}
+	if len(results) != 1 || results[0].NoteID != goNote.ID || results[0].Title != "Go 笔记" {
+		t.Fatalf("应只检索到当前用户的相关笔记: %+v", results)
+	}
+
+	t.Run("更新笔记后重建索引", func(t *testing.T) {
+		if err := noteService.UpdateNote(1, goNote.ID, &database.Note{Title: "Python 笔记", Content: "python 的生成器"}); err != nil {
+			t.Fatalf("更新笔记失败: %v", err)
+		}
+		if results, _ := ragService.Search(ctx, 1, "golang", 5); len(results) != 0 {
+			t.Errorf("旧内容不应再被检索到: %+v", results)
+		}
+		results, _ := ragService.Search(ctx, 1, "python", 5)
+		if len(results) != 1 || results[0].Title != "Python 笔记" {
+			t.Errorf("应检索到更新后的内容: %+v", results)
+		}
+	})
+
+	t.Run("删除笔记后移除索引", func(t *testing.T) {
+		if err := noteService.DeleteNote(1, catNote.ID); err != nil {
+			t.Fatalf("删除笔记失败: %v", err)
+		}
+		var count int64
+		db.Model(&database.NoteChunk{}).Where("note_id = ?", catNote.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("删除笔记后切块应被移除: 剩余 %d", count)
+		}
+	})
+}
+
+// TestSyncAll 测试为已有笔记补建索引
+func TestSyncAll(t *testing.T) {
+	noteService, ragService, _ := setupRAG(t)
+
+	// 模拟启用检索前创建的笔记
+	Note.GlobalNoteIndexer = nil
+	_ = noteService.CreateNote(&database.Note{UserID: 1, Title: "咖啡", Content: "手冲咖啡的水温"})
+	_ = noteService.CreateNote(&database.Note{UserID: 1, Title: "狗", Content: "狗粮的选择"})
+
+	count, err := ragService.SyncAll(context.Background())
+	if err != nil || count != 2 {
+		t.Fatalf("应补建 2 篇笔记的索引: count=%d, err=%v", count, err)
+	}
+	if count, _ := ragService.SyncAll(context.Background()); count != 0 {
+		t.Errorf("已建立索引的笔记不应重复处理: count=%d", count)
+	}
+
+	results, _ := ragService.Search(context.Background(), 1, "咖啡", 5)
+	if len(results) != 1 || results[0].Title != "咖啡" {
+		t.Errorf("补建索引后应能检索到: %+v", results)
+	}
+}
+
+// TestHashEmbedder 测试本地哈希向量的相似度排序
+func TestHashEmbedder(t *testing.T) {
+	db, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	_ = db.AutoMigrate(&database.NoteChunk{})
+	ragService, _ := RAG.NewRAGService(db, RAG.NewHashEmbedder(0))
+
+	_ = ragService.IndexNote(&database.Note{Model: gorm.Model{ID: 1}, UserID: 1, Title: "数据库索引", Content: "B+ 树索引可以加速范围查询"})
+	_ = ragService.IndexNote(&database.Note{Model: gorm.Model{ID: 2}, UserID: 1, Title: "旅行计划", Content: "五月去云南看风景"})
+
+	results, err := ragService.Search(context.Background(), 1, "怎么给数据库加索引", 2)
+	if err != nil || len(results) == 0 {
+		t.Fatalf("检索失败: %v", err)
+	}
+	if results[0].NoteID != 1 {
+		t.Errorf("最相关的应为数据库笔记: %+v", results)
+	}
+}
+
+// TestReferencesInjected 测试参考资料作为系统消息注入且带引用标记
+func TestReferencesInjected(t *testing.T) {
+	references := RAG.FormatReferences([]RAG.SearchResult{{NoteID: 42, Title: "Go 笔记", Content: "golang 的并发模型"}})
+	if !strings.Contains(references, "[笔记42:Go 笔记]") {
+		t.Fatalf("参考资料应包含笔记ID和标题: %s", references)
+	}
+	if RAG.FormatReferences(nil) != "" {
+		t.Error("没有检索结果时不应注入参考资料")
+	}
+
+	var requests []openai.ChatCompletionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body openai.ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		requests = append(requests, body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"choices":[{"index":0,"message":{"role":"assistant","content":"好的"},"finish_reason":"stop"}]}`)
+	}))
+	defer server.Close()
+
+	session := LLM_Chat.NewAdvancedChatSession(LLM_Chat.NewOpenAIProvider("sk-test", server.URL), "gpt-4o", "你是一个测试助手", 0)
+	if _, err := session.SendMessage("并发怎么写", LLM_Chat.SendOptions{References: references}); err != nil {
+		t.Fatalf("SendMessage() 意外返回错误: %v", err)
+	}
+	if _, err := session.SendMessage("继续", LLM_Chat.SendOptions{}); err != nil {
+		t.Fatalf("SendMessage() 意外返回错误: %v", err)
+	}
+
+	first := requests[0].Messages
+	if len(first) != 3 || first[1].Role != "system" || first[1].Content != references {
+		t.Fatalf("参考资料应在系统提示词之后注入: %+v", first)
+	}
+	for _, msg := range requests[1].Messages {
+		if strings.Contains(msg.Content, "[笔记42:Go 笔记]") {
+			t.Error("参考资料不应写入会话历史")
+		}
+	}
+}
+
+// TestFileIndexing 测试上传文件切块索引、按问题检索片段和删除
+func TestFileIndexing(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("无法创建测试数据库: %v", err)
+	}
+	if err := db.AutoMigrate(&database.ChatSession{}, &database.UploadedFile{}, &database.FileChunk{}); err != nil {
+		t.Fatalf("数据库迁移失败: %v", err)
+	}
+	db.Create(&database.ChatSession{SessionID: "s1", UserID: 1, ModelName: "gpt-4"})
+	store, _ := LLM_Chat.NewLocalBlobStore(t.TempDir())
+	uploadConfig, err := LLM_Chat.LoadFileUploadConfig("../../style.yaml")
+	if err != nil {
+		t.Fatalf("读取上传配置失败: %v", err)
+	}
+	uploadConfig.UploadDir = t.TempDir()
+	fileService, err := LLM_Chat.NewFileService(db, uploadConfig, store)
+	if err != nil {
+		t.Fatalf("创建文件服务失败: %v", err)
+	}
+	embedder := &fakeEmbedder{}
+	ragService, _ := RAG.NewRAGService(db, embedder)
+
+	originalIndexer := LLM_Chat.GlobalFileIndexer
+	LLM_Chat.GlobalFileIndexer = ragService
+	defer func() { LLM_Chat.GlobalFileIndexer = originalIndexer }()
+
+	// 200 段无关内容中夹着一段关于咖啡的内容
+	var paragraphs []string
+	for i := 0; i < 200; i++ {
+		paragraphs = append(paragraphs, fmt.Sprintf("第%d段%s", i, strings.Repeat("填充", 100)))
+	}
+	paragraphs[150] = "手冲咖啡的水温建议在 90 度左右"
+	file := &database.UploadedFile{
+		SessionID: "s1",
+		FileName:  "big.txt",
+		FilePath:  "unused",
+		FileSize:  1,
+		FileType:  ".txt",
+		Content:   strings.Join(paragraphs, "\n"),
+	}
+	if err := fileService.SaveFile(file); err != nil {
+		t.Fatalf("保存文件失败: %v", err)
+	}
+
+	if err := fileService.IndexFile(file); err != nil {
+		t.Fatalf("文件索引失败: %v", err)
+	}
+	stored, _ := fileService.GetFileByID(file.ID, 1)
+	if !stored.IsProcessed {
+		t.Error("索引完成后应标记为已处理")
+	}
+
+	var count int64
+	db.Model(&database.FileChunk{}).Where("file_id = ?", file.ID).Count(&count)
+	if count < 100 {
+		t.Fatalf("大文件应被切成多块: 得到 %d 块", count)
+	}
+
+	results, err := ragService.SearchFiles(context.Background(), []uint{file.ID}, "咖啡怎么冲", 3)
+	if err != nil {
+		t.Fatalf("检索失败: %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("应只返回 topK 个片段: 得到 %d 个", len(results))
+	}
+	found := false
+	for i, result := range results {
+		if strings.Contains(result.Content, "手冲咖啡") {
+			found = true
+		}
+		if i > 0 && result.ChunkIndex < results[i-1].ChunkIndex {
+			t.Error("片段应按文件内顺序排列")
+		}
+	}
+	if !found {
+		t.Errorf("应检索到与问题相关的片段: %+v", results)
+	}
+
+	section := RAG.FormatFileChunks(results)
+	if !strings.Contains(section, "【文件：big.txt 片段") || len([]rune(section)) > 3*600 {
+		t.Errorf("附加到消息的只应是少量片段: 长度 %d", len([]rune(section)))
+	}
+
+	if err := fileService.DeleteFile(file.ID, 1); err != nil {
+		t.Fatalf("删除文件失败: %v", err)
+	}
+	db.Model(&database.FileChunk{}).Where("file_id = ?", file.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("删除文件后切块应被移除: 剩余 %d", count)
+	}
+}